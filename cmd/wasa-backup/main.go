@@ -0,0 +1,175 @@
+/*
+Wasa-backup writes a consistent snapshot of the SQLite database and of the photos files to a single tar.gz archive,
+and restores it.
+
+The snapshot is taken with the SQLite online backup API, holding the writes just long enough to link the photos files
+aside: it's safe to take it while the web server is running, and the rows of the archive never point to missing files.
+The archive holds the manifest of its files with their SHA-256 checksums, the restore verifies every one of them, and
+the integrity of the database, before swapping the data in. The replaced database and photos directory are kept, with
+the ".pre-restore" suffix, until the next restore.
+
+Usage:
+
+	wasa-backup [flags]
+	wasa-backup --restore --archive wasa-backup-20230101T100000Z.tar.gz
+
+The archive is written to --archive, or to wasa-backup-<date>.tar.gz in the working directory. The database and the
+photos directory are configured like in `webapi`, with the same flags and environment variables (e.g. DB_PATH and
+CFG_PHOTOS_DIRECTORY). The restore must run while the web server is stopped. PostgreSQL isn't supported, back it up
+with pg_dump.
+
+Return values (exit codes):
+
+	0
+		The archive has been written, or restored
+
+	1
+		The backup or the restore failed
+
+	2
+		The archive is not valid, e.g. a checksum doesn't match: nothing has been restored
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ardanlabs/conf"
+	"github.com/lucaronca/wasa-homework/service/backup"
+	"github.com/lucaronca/wasa-homework/service/database"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Assets is embedded in the configuration, so that the photos directory has the same flag as in webapi
+type Assets struct {
+	PhotosDirectory string `conf:"default:/static/photos"`
+}
+
+// Configuration is parsed from the flags and the environment variables, like the webapi one
+type Configuration struct {
+	DB struct {
+		Filename    string        `conf:"default:/wasa-photo.db"`
+		BusyTimeout time.Duration `conf:"default:5s"`
+	}
+	Assets
+	// Archive is the path of the archive to write, or to restore
+	Archive string
+	// Restore replaces the database and the photos with the content of the archive
+	Restore bool
+}
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		if errors.Is(err, backup.ErrInvalidArchive) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var cfg Configuration
+	if err := conf.Parse(os.Args[1:], "CFG", &cfg); err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			usage, err := conf.Usage("CFG", &cfg)
+			if err != nil {
+				return fmt.Errorf("generating config usage: %w", err)
+			}
+			fmt.Println(usage) //nolint:forbidigo
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = filepath.Join(pwd, "/data")
+	}
+	databasePath := filepath.Join(dbPath, cfg.DB.Filename)
+	photosDirectory := filepath.Join(pwd, cfg.PhotosDirectory)
+
+	if cfg.Restore {
+		if cfg.Archive == "" {
+			return errors.New("the archive to restore is required")
+		}
+		return restore(cfg.Archive, databasePath, photosDirectory)
+	}
+
+	archive := cfg.Archive
+	if archive == "" {
+		archive = filepath.Join(pwd, backup.FileName(globaltime.Now()))
+	}
+	return create(cfg, archive, databasePath, photosDirectory)
+}
+
+// create writes the archive of the database at databasePath and of the photos
+func create(cfg Configuration, archive string, databasePath string, photosDirectory string) error {
+	// The database has to exist already, opening it would create an empty one
+	if _, err := os.Stat(databasePath); err != nil {
+		return fmt.Errorf("opening the database: %w", err)
+	}
+	dbconn, readconn, err := database.OpenSQLite(databasePath, database.SQLiteConfig{
+		JournalMode:        "WAL",
+		BusyTimeout:        cfg.DB.BusyTimeout,
+		MaxReadConnections: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("opening SQLite: %w", err)
+	}
+	defer func() {
+		_ = readconn.Close()
+		_ = dbconn.Close()
+	}()
+	db, err := database.NewWithPools(dbconn, readconn, database.SQLite)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+
+	// An interrupted backup leaves no archive behind
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manifest, err := backup.WriteFile(ctx, archive, db, photosDirectory)
+	if err != nil {
+		return fmt.Errorf("writing the archive: %w", err)
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "%s written, with the database and %d photos\n", archive, len(manifest.Photos))
+	return nil
+}
+
+// restore replaces the database at databasePath and the photos with the content of the archive
+func restore(archive string, databasePath string, photosDirectory string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	manifest, err := backup.Restore(f, databasePath, photosDirectory)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", archive, err)
+	}
+	_, _ = fmt.Fprintf(
+		os.Stdout,
+		"%s restored, taken at %s with %d photos\n",
+		archive,
+		manifest.CreatedAt.Format(time.RFC3339),
+		len(manifest.Photos),
+	)
+	return nil
+}
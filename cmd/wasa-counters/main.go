@@ -0,0 +1,143 @@
+/*
+Wasa-counters checks the counters of likes, comments, followers, followings and photos stored in the database against
+the rows they count, and lists the ones that drifted. With -repair it also recomputes them.
+
+The repositories keep the counters up to date along with the rows they count, so a drift means that the database was
+changed by hand or by a bug: it's safe to run the check, and the repair, while the web server is running.
+
+Usage:
+
+	wasa-counters [flags]
+
+The database is configured like in `webapi`, with the same flags and environment variables (e.g. CFG_DB_DRIVER,
+CFG_DB_URL and DB_PATH), and it's migrated to the latest schema version before the check.
+
+Return values (exit codes):
+
+	0
+		The counters are correct, or they have been repaired
+
+	1
+		The check or the repair failed
+
+	2
+		Some counters drifted, and they haven't been repaired
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ardanlabs/conf"
+	_ "github.com/lib/pq"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// errDrift is returned when some counters drifted and they haven't been repaired
+var errDrift = errors.New("some counters drifted")
+
+// Configuration is parsed from the flags and the environment variables, like the webapi one
+type Configuration struct {
+	DB struct {
+		Driver   string `conf:"default:sqlite3"`
+		Filename string `conf:"default:/wasa-photo.db"`
+		URL      string `conf:"mask"`
+		// Repair recomputes the counters that drifted
+		Repair bool
+	}
+}
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		if errors.Is(err, errDrift) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var cfg Configuration
+	if err := conf.Parse(os.Args[1:], "CFG", &cfg); err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			usage, err := conf.Usage("CFG", &cfg)
+			if err != nil {
+				return fmt.Errorf("generating config usage: %w", err)
+			}
+			fmt.Println(usage) //nolint:forbidigo
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	dialect, err := database.ParseDialect(cfg.DB.Driver)
+	if err != nil {
+		return err
+	}
+	var dbconn, readconn *sql.DB
+	if dialect == database.SQLite {
+		dbPath := os.Getenv("DB_PATH")
+		if dbPath == "" {
+			pwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			dbPath = filepath.Join(pwd, "/data")
+		}
+		dbconn, readconn, err = database.OpenSQLite(filepath.Join(dbPath, cfg.DB.Filename), database.SQLiteConfig{
+			JournalMode:        "WAL",
+			BusyTimeout:        5 * time.Second,
+			MaxReadConnections: 1,
+		})
+	} else {
+		dbconn, err = sql.Open(string(dialect), cfg.DB.URL)
+		readconn = dbconn
+	}
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", dialect, err)
+	}
+	defer func() {
+		if readconn != dbconn {
+			_ = readconn.Close()
+		}
+		_ = dbconn.Close()
+	}()
+
+	db, err := database.NewWithPools(dbconn, readconn, dialect)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	countersRepository, _ := repositories.NewCountersRepository(db)
+
+	ctx := context.Background()
+	drifts, err := countersRepository.GetCounterDrifts(ctx)
+	if err != nil {
+		return fmt.Errorf("checking the counters: %w", err)
+	}
+	for _, drift := range *drifts {
+		_, _ = fmt.Fprintf(os.Stdout, "%s %d: %s is %d, should be %d\n", drift.Table, drift.Id, drift.Column, drift.Stored, drift.Actual)
+	}
+	if len(*drifts) == 0 {
+		_, _ = fmt.Fprintln(os.Stdout, "the counters are correct")
+		return nil
+	}
+	if !cfg.DB.Repair {
+		return fmt.Errorf("%w: %d, run with --db-repair to recompute them", errDrift, len(*drifts))
+	}
+
+	repaired, err := countersRepository.RepairCounters(ctx)
+	if err != nil {
+		return fmt.Errorf("repairing the counters: %w", err)
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "%d counters repaired\n", repaired)
+	return nil
+}
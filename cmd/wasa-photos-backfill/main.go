@@ -0,0 +1,173 @@
+/*
+Wasa-photos-backfill stores the dimensions, the file size, the MIME type and the BlurHash of the photos uploaded before
+the web server computed them at upload time, reading their files from the photos directory.
+
+It only describes the photos that haven't been described yet, so it's safe to run it again, and to run it while the
+web server is running.
+
+Usage:
+
+	wasa-photos-backfill [flags]
+
+The database and the photos directory are configured like in `webapi`, with the same flags and environment variables
+(e.g. CFG_DB_DRIVER, CFG_DB_URL, DB_PATH and CFG_PHOTOS_DIRECTORY), and the database is migrated to the latest schema
+version before the backfill.
+
+Return values (exit codes):
+
+	0
+		Every photo has been described
+
+	1
+		The backfill failed
+
+	2
+		Some photos have been skipped, since their file can't be read or decoded, or it has too many pixels
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/ardanlabs/conf"
+	_ "github.com/lib/pq"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+	"github.com/lucaronca/wasa-homework/service/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// errSkipped is returned when some photos have been skipped
+var errSkipped = errors.New("some photos have been skipped")
+
+// Assets is embedded in the configuration, so that the photos directory has the same flag as in webapi
+type Assets struct {
+	PhotosDirectory string `conf:"default:/static/photos"`
+}
+
+// Configuration is parsed from the flags and the environment variables, like the webapi one
+type Configuration struct {
+	DB struct {
+		Driver   string `conf:"default:sqlite3"`
+		Filename string `conf:"default:/wasa-photo.db"`
+		URL      string `conf:"mask"`
+	}
+	Assets
+	// Photos has the same flags as in webapi, the photos with more than MaxPixels pixels are skipped
+	Photos struct {
+		MaxPixels int `conf:"default:25000000"`
+	}
+}
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		if errors.Is(err, errSkipped) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var cfg Configuration
+	if err := conf.Parse(os.Args[1:], "CFG", &cfg); err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			usage, err := conf.Usage("CFG", &cfg)
+			if err != nil {
+				return fmt.Errorf("generating config usage: %w", err)
+			}
+			fmt.Println(usage) //nolint:forbidigo
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	dialect, err := database.ParseDialect(cfg.DB.Driver)
+	if err != nil {
+		return err
+	}
+	var dbconn, readconn *sql.DB
+	if dialect == database.SQLite {
+		dbPath := os.Getenv("DB_PATH")
+		if dbPath == "" {
+			dbPath = filepath.Join(pwd, "/data")
+		}
+		dbconn, readconn, err = database.OpenSQLite(filepath.Join(dbPath, cfg.DB.Filename), database.SQLiteConfig{
+			JournalMode:        "WAL",
+			BusyTimeout:        5 * time.Second,
+			MaxReadConnections: 1,
+		})
+	} else {
+		dbconn, err = sql.Open(string(dialect), cfg.DB.URL)
+		readconn = dbconn
+	}
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", dialect, err)
+	}
+	defer func() {
+		if readconn != dbconn {
+			_ = readconn.Close()
+		}
+		_ = dbconn.Close()
+	}()
+
+	db, err := database.NewWithPools(dbconn, readconn, dialect)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	repos, _ := repositories.NewRepositories(db)
+	unitOfWork, _ := repositories.NewUnitOfWork(db)
+	// Only the photos directory is used by the backfill, the uploads and the trash aren't handled here
+	photosService := services.NewPhotosService(
+		filepath.Join(pwd, cfg.PhotosDirectory),
+		"",
+		0,
+		nil,
+		false,
+		cfg.Photos.MaxPixels,
+		1,
+		unitOfWork,
+		repos.Users,
+		repos.Bans,
+		repos.Photos,
+		repos.Likes,
+		repos.Comments,
+		repos.Follows,
+	)
+
+	// The photos described until an interruption are kept, the next run goes on with the others
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	described, skipped, err := photosService.BackfillPhotoImages(ctx)
+	_, _ = fmt.Fprintf(os.Stdout, "%d photos described\n", described)
+	ids := make([]int, 0, len(skipped))
+	for id := range skipped {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		_, _ = fmt.Fprintf(os.Stdout, "photo %d skipped: %v\n", id, skipped[id])
+	}
+	if err != nil {
+		return fmt.Errorf("describing the photos: %w", err)
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("%w: %d", errSkipped, len(skipped))
+	}
+	return nil
+}
@@ -30,14 +30,18 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/ardanlabs/conf"
+	_ "github.com/lib/pq"
 	"github.com/lucaronca/wasa-homework/service/api"
 	"github.com/lucaronca/wasa-homework/service/api/controllers"
 	"github.com/lucaronca/wasa-homework/service/api/repositories"
@@ -64,26 +68,48 @@ and configuration, this function defines the necessary api repositories, service
 and makes sure that the router is configured with the HTTP endpoints and handlers defined in the controllers.
 Finally it returns an http.Handler ready to be invoked by an http.Server.
 */
-func newHandler(router api.Router, db database.AppDatabase, assetsCfg Assets) http.Handler {
+func newHandler(
+	router api.Router,
+	db database.AppDatabase,
+	cfg WebAPIConfiguration,
+	assetsCfg Assets,
+	backupsDirectory string,
+) (http.Handler, error) {
 	// Liveness checker
 	livenessChecker := api.NewLivenessChecker(db.Ping)
 
 	// Instantiate repositories
 	authRepository, _ := repositories.NewAuthRepository(db)
+	twoFactorRepository, _ := repositories.NewTwoFactorRepository(db)
 	usersRepository, _ := repositories.NewUsersRepository(db)
 	bansRepository, _ := repositories.NewBansRepository(db)
 	followsRepository, _ := repositories.NewFollowsRepository(db)
 	photosRepository, _ := repositories.NewPhotosRepository(db)
 	likesRepository, _ := repositories.NewLikesRepository(db)
 	commentsRepository, _ := repositories.NewCommentsRepository(db)
+	unitOfWork, _ := repositories.NewUnitOfWork(db)
 
 	// Instantiate services
-	authService := services.NewAuthService(authRepository, usersRepository)
-	bansService := services.NewBansService(usersRepository, bansRepository, followsRepository)
+	authService := services.NewAuthService(
+		[]byte(cfg.Auth.TokenHashKey),
+		cfg.Auth.AccessTokenTTL,
+		cfg.Auth.RefreshTokenTTL,
+		unitOfWork,
+		authRepository,
+		usersRepository,
+		twoFactorRepository,
+	)
+	bansService := services.NewBansService(unitOfWork, usersRepository, bansRepository, followsRepository)
 	followsService := services.NewFollowsService(usersRepository, bansRepository, followsRepository)
 	photosService := services.NewPhotosService(
 		assetsCfg.PhotosDirectory,
 		assetsCfg.PhotosUrlPath,
+		cfg.Trash.Retention,
+		cfg.Photos.VariantWidths,
+		cfg.Photos.KeepTakenAt,
+		cfg.Photos.MaxPixels,
+		cfg.Photos.MaxProcessing,
+		unitOfWork,
 		usersRepository,
 		bansRepository,
 		photosRepository,
@@ -115,37 +141,88 @@ func newHandler(router api.Router, db database.AppDatabase, assetsCfg Assets) ht
 
 	// Instantiate controllers
 	loginController := controllers.NewLoginController(authService)
+	tokensController := controllers.NewTokensController(authService)
+	twoFactorController := controllers.NewTwoFactorController(authService)
 	bansController := controllers.NewBansController(bansService)
 	followsController := controllers.NewFollowsController(followsService)
-	photosController := controllers.NewPhotosController(photosService)
+	photosController := controllers.NewPhotosController(photosService, cfg.Photos.MaxUploadSize, cfg.Web.UploadTimeout)
 	usersController := controllers.NewUsersController(usersService)
 	likesController := controllers.NewLikesController(likesService)
 	commentsController := controllers.NewCommentsController(commentsService)
 
+	controllersList := []controllers.Controller{
+		loginController,
+		tokensController,
+		twoFactorController,
+		followsController,
+		bansController,
+		photosController,
+		usersController,
+		likesController,
+		commentsController,
+	}
+
+	// Login through an external OpenID Connect provider, if configured
+	if cfg.OIDC.IssuerURL != "" {
+		oidcService := services.NewOIDCService(
+			services.OIDCConfig{
+				IssuerURL:    cfg.OIDC.IssuerURL,
+				ClientID:     cfg.OIDC.ClientID,
+				ClientSecret: cfg.OIDC.ClientSecret,
+				RedirectURL:  cfg.OIDC.RedirectURL,
+				Scopes:       cfg.OIDC.Scopes,
+			},
+			authService,
+		)
+		controllersList = append(controllersList, controllers.NewOIDCController(
+			oidcService,
+			cfg.OIDC.PostLoginRedirectURL,
+			strings.HasPrefix(cfg.OIDC.RedirectURL, "https://"),
+		))
+	}
+
+	// Online backups of the SQLite database and of the photos, if there are administrators to take them
+	if cfg.DB.Driver == string(database.SQLite) && len(cfg.Admin.UserIds) > 0 {
+		backupService := services.NewBackupService(db, assetsCfg.PhotosDirectory, backupsDirectory, cfg.Admin.UserIds)
+		controllersList = append(controllersList, controllers.NewBackupController(backupService, cfg.Web.BackupTimeout))
+	}
+
+	// Instantiate background tasks
+	sessionsSweeper := api.BackgroundTask{
+		Name:     "sessions-sweeper",
+		Interval: cfg.Auth.SessionsSweepInterval,
+		Run: func(ctx context.Context) error {
+			_, err := authService.RemoveExpiredSessions(ctx)
+			return err
+		},
+	}
+	photosPurger := api.BackgroundTask{
+		Name:     "photos-purger",
+		Interval: cfg.Trash.PurgeInterval,
+		Run: func(ctx context.Context) error {
+			_, err := photosService.PurgeTrash(ctx)
+			return err
+		},
+	}
+
 	// Handler Configuration
 	handlerCfg := api.HandlerConfig{
 		Photos: api.HandlerConfigPhotos{
 			PhotosDirectory: assetsCfg.PhotosDirectory,
 			PhotosUrlPath:   assetsCfg.PhotosUrlPath,
 		},
+		Requests: api.HandlerConfigRequests{
+			Timeout: cfg.Web.RequestTimeout,
+		},
 		Deps: api.HandlerConfigDependencies{
 			LivenessChecker:     livenessChecker,
 			TokenAuthMiddleware: tokenAuthMiddleware,
+			BackgroundTasks:     []api.BackgroundTask{sessionsSweeper, photosPurger},
 		},
 	}
 
 	// Instantiate routes
-	handler := router.Handler(
-		handlerCfg,
-		loginController,
-		followsController,
-		bansController,
-		photosController,
-		usersController,
-		likesController,
-		commentsController,
-	)
-	return handler
+	return router.Handler(handlerCfg, controllersList...)
 }
 
 // run executes the program. The body of this function should perform the following steps:
@@ -185,28 +262,57 @@ func run() error {
 		panic(err)
 	}
 
-	dbPath := os.Getenv("DB_PATH")
-
-	if dbPath == "" {
-		dbPath = filepath.Join(pwd, "/data")
+	dialect, err := database.ParseDialect(cfg.DB.Driver)
+	if err != nil {
+		return err
 	}
+	// Reads go through readconn, writes through dbconn: they're the same pool, except for SQLite
+	var dbconn, readconn *sql.DB
+	if dialect == database.SQLite {
+		dbPath := os.Getenv("DB_PATH")
 
-	dbconn, err := sql.Open(
-		"sqlite3",
-		fmt.Sprintf(
-			"file:%s?_foreign_keys=on",
-			filepath.Join(dbPath, cfg.DB.Filename),
-		),
-	)
+		if dbPath == "" {
+			dbPath = filepath.Join(pwd, "/data")
+		}
+
+		dbconn, readconn, err = database.OpenSQLite(filepath.Join(dbPath, cfg.DB.Filename), sqliteConfig(cfg))
+	} else {
+		dbconn, err = sql.Open(string(dialect), cfg.DB.URL)
+		readconn = dbconn
+	}
 	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite: %w", err)
+		logger.WithError(err).Errorf("error opening %s DB", dialect)
+		return fmt.Errorf("opening %s: %w", dialect, err)
 	}
 	defer func() {
 		logger.Debug("database stopping")
+		if readconn != dbconn {
+			_ = readconn.Close()
+		}
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+
+	pendingMigrations, err := database.PendingMigrations(dbconn, dialect)
+	if err != nil {
+		logger.WithError(err).Error("error reading the database schema version")
+		return fmt.Errorf("reading the database schema version: %w", err)
+	}
+	if cfg.DB.MigrateDryRun {
+		printPendingMigrations(os.Stdout, pendingMigrations)
+		return nil
+	}
+	for _, migration := range pendingMigrations {
+		logger.Infof("applying database migration %04d_%s", migration.Version, migration.Name)
+	}
+	// The migration hashing the tokens stored in plaintext needs the key, that NewWithPools doesn't have
+	_, err = database.Migrate(dbconn, dialect, database.MigrationConfig{TokenHash: func(token string) string {
+		return services.HashToken([]byte(cfg.Auth.TokenHashKey), token)
+	}})
+	if err != nil {
+		logger.WithError(err).Error("error migrating the database")
+		return fmt.Errorf("migrating the database: %w", err)
+	}
+	db, err := database.NewWithPools(dbconn, readconn, dialect)
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
@@ -238,11 +344,17 @@ func run() error {
 		PhotosUrlPath:   cfg.Assets.PhotosUrlPath,
 	}
 
-	handler := newHandler(
+	handler, err := newHandler(
 		apirouter,
 		db,
+		cfg,
 		assetsCfg,
+		filepath.Join(pwd, cfg.Admin.BackupsDirectory),
 	)
+	if err != nil {
+		logger.WithError(err).Error("error creating the API handler")
+		return fmt.Errorf("creating the API handler: %w", err)
+	}
 
 	handler, err = registerWebUI(handler)
 	if err != nil {
@@ -253,6 +365,10 @@ func run() error {
 	// Apply CORS policy
 	handler = applyCORSHandler(handler)
 
+	// The contexts of the requests derive from requestsCtx, canceling it aborts the queries still running for them
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	// Create the API server
 	apiserver := http.Server{
 		Addr:              cfg.Web.APIHost,
@@ -260,6 +376,11 @@ func run() error {
 		ReadTimeout:       cfg.Web.ReadTimeout,
 		ReadHeaderTimeout: cfg.Web.ReadTimeout,
 		WriteTimeout:      cfg.Web.WriteTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
+		// The routes with their own timeout change the deadlines of the connection
+		ConnContext: routes.ConnContext,
 	}
 
 	// Start the service listening for requests in a separate goroutine
@@ -292,6 +413,7 @@ func run() error {
 		err = apiserver.Shutdown(ctx)
 		if err != nil {
 			logger.WithError(err).Warning("error during graceful shutdown of HTTP server")
+			cancelRequests()
 			err = apiserver.Close()
 		}
 
@@ -306,3 +428,14 @@ func run() error {
 
 	return nil
 }
+
+// printPendingMigrations writes the migrations New would apply, for the dry run
+func printPendingMigrations(w io.Writer, migrations []database.Migration) {
+	if len(migrations) == 0 {
+		_, _ = fmt.Fprintln(w, "the database schema is up to date")
+		return
+	}
+	for _, migration := range migrations {
+		_, _ = fmt.Fprintf(w, "-- %04d_%s\n%s\n", migration.Version, migration.Name, strings.TrimSpace(migration.SQL))
+	}
+}
@@ -5,29 +5,39 @@ import (
 
 	"github.com/lucaronca/wasa-homework/service/api/controllers"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
+	"github.com/lucaronca/wasa-homework/service/photofiles"
 )
 
 /*
 Handler returns an instance of http.Handler that handle APIs registered by the controllers.
 You need to pass:
 - An `HandlerConfig` struct used to configure where photos assets will be saved,
-to specify the path which will be used to serve the photos, and the needed Handler dependecies
+to specify the path which will be used to serve the photos, the deadline of the requests, and the needed Handler dependecies, including the
+background tasks to run until the router is closed
 - 1..n controllers to handle the API endpoints.
+
+It fails if a background task can't be started.
 */
 func (rt *_router) Handler(
 	cfg HandlerConfig,
 	controllers ...controllers.Controller,
-) http.Handler {
+) (http.Handler, error) {
 	tokenAuthMiddleware := cfg.Deps.TokenAuthMiddleware
-	reqCtxMiddleware := routes.NewReqCtxMiddleware(&rt.baseLogger)
+	reqCtxMiddleware := routes.NewReqCtxMiddleware(&rt.baseLogger, cfg.Requests.Timeout)
 
 	// Register routes defined in the controllers
 	for _, controller := range controllers {
 		for _, route := range controller.Routes() {
 			handler := route.HandlerFunc
-			// If AuthRequired, wrap the handler with token authentication middleware
+			// If AuthRequired, wrap the handler with token authentication middleware enforcing the route scopes
 			if route.AuthRequired {
-				handler = tokenAuthMiddleware(route.HandlerFunc)
+				handler = tokenAuthMiddleware(route.Scopes)(route.HandlerFunc)
+			}
+			// The routes with their own timeout get it as the deadline of the request context and of the connection
+			if route.Timeout > 0 {
+				handle := routes.NewReqCtxMiddleware(&rt.baseLogger, route.Timeout)(handler)
+				rt.router.Handle(route.Method, route.Path, routes.NewConnDeadlineMiddleware(route.Timeout)(handle))
+				continue
 			}
 			rt.router.Handle(route.Method, route.Path, reqCtxMiddleware(handler))
 		}
@@ -37,8 +47,13 @@ func (rt *_router) Handler(
 	liveness := cfg.Deps.LivenessChecker
 	rt.router.GET("/liveness", liveness)
 
-	// Static files
-	rt.router.ServeFiles(cfg.Photos.PhotosUrlPath+"/*filepath", http.Dir(cfg.Photos.PhotosDirectory))
+	// Background tasks, stopped by Close
+	if err := rt.startBackgroundTasks(cfg.Deps.BackgroundTasks...); err != nil {
+		return nil, err
+	}
+
+	// Static files, the ones of the photos in the trash and of the uploads being processed aren't served
+	rt.router.ServeFiles(cfg.Photos.PhotosUrlPath+"/*filepath", photofiles.FileSystem(cfg.Photos.PhotosDirectory))
 
-	return rt.router
+	return rt.router, nil
 }
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/controllers"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/routes"
+	"github.com/sirupsen/logrus"
+)
+
+// slowBackupService takes a backup in duration, it fails if the context is done before
+type slowBackupService struct {
+	duration time.Duration
+}
+
+func (s slowBackupService) CreateBackup(ctx context.Context, userId int) (*models.Backup, error) {
+	select {
+	case <-time.After(s.duration):
+		return &models.Backup{Name: "wasa-backup.tar.gz"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestHandler_BackupLongerThanRequestTimeout(t *testing.T) {
+	r, err := New(RouterConfig{Logger: logrus.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := r.(*_router)
+	t.Cleanup(func() { _ = rt.Close() })
+
+	// The authenticated user is an administrator
+	authenticated := func([]models.Scope) routes.Middleware {
+		return func(fn routes.Handler) routes.Handler {
+			return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+				ctx.User.Id = 1
+				fn(w, r, ps, ctx)
+			}
+		}
+	}
+	const timeout = 100 * time.Millisecond
+	handler, err := rt.Handler(HandlerConfig{
+		Photos:   HandlerConfigPhotos{PhotosDirectory: t.TempDir(), PhotosUrlPath: "/assets/photos"},
+		Requests: HandlerConfigRequests{Timeout: timeout},
+		Deps:     HandlerConfigDependencies{LivenessChecker: NewLivenessChecker(), TokenAuthMiddleware: authenticated},
+	}, controllers.NewBackupController(slowBackupService{3 * timeout}, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ReadTimeout = timeout
+	server.Config.WriteTimeout = timeout
+	server.Config.ConnContext = routes.ConnContext
+	server.Start()
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/admin/backups", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	var backup models.Backup
+	if http.StatusCreated != res.StatusCode {
+		t.Fatal("expected", http.StatusCreated, "got:", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&backup); err != nil || backup.Name != "wasa-backup.tar.gz" {
+		t.Error("expected the backup to be answered got:", backup, err)
+	}
+}
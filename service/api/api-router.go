@@ -14,10 +14,14 @@ Example:
 		logger.WithError(err).Error("error creating the API server instance")
 		return fmt.Errorf("error creating the API server instance: %w", err)
 	}
-	handler := apirouter.Handler(
+	handler, err := apirouter.Handler(
 		handlerCfg,
 		controllers...,
 	)
+	if err != nil {
+		logger.WithError(err).Error("error creating the API handler")
+		return fmt.Errorf("error creating the API handler: %w", err)
+	}
 
 	// Create the API server
 	apiserver := http.Server{
@@ -36,8 +40,10 @@ See the `main.go` file inside the `cmd/webapi` for a full usage example.
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"sync"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/lucaronca/wasa-homework/service/api/controllers"
@@ -50,7 +56,7 @@ type Router interface {
 	Handler(
 		cfg HandlerConfig,
 		controllers ...controllers.Controller,
-	) http.Handler
+	) (http.Handler, error)
 
 	// Close terminates any resource used in the package
 	Close() error
@@ -69,9 +75,12 @@ func New(cfg RouterConfig) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
+	backgroundCtx, stopBackgroundTasks := context.WithCancel(context.Background())
 	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
+		router:              router,
+		baseLogger:          cfg.Logger,
+		backgroundCtx:       backgroundCtx,
+		stopBackgroundTasks: stopBackgroundTasks,
 	}, nil
 }
 
@@ -81,4 +90,10 @@ type _router struct {
 	// baseLogger is a logger for non-requests contexts, like goroutines or background tasks not started by a request.
 	// Use context logger if available (e.g., in requests) instead of this logger.
 	baseLogger logrus.FieldLogger
+
+	// backgroundCtx is the context of the background tasks, stopBackgroundTasks cancels it to ask them to stop, and
+	// backgroundTasks waits for them
+	backgroundCtx       context.Context
+	stopBackgroundTasks context.CancelFunc
+	backgroundTasks     sync.WaitGroup
 }
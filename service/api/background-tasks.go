@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BackgroundTask is a job that the router runs periodically, outside of any request, until it's closed
+type BackgroundTask struct {
+	// Name identifies the task in the logs
+	Name string
+
+	// Interval between two runs of the task
+	Interval time.Duration
+
+	// Run executes the task once, ctx is canceled when the router is closed
+	Run func(ctx context.Context) error
+}
+
+// startBackgroundTasks runs every task in its own goroutine. None is started if one of them has no positive interval.
+func (rt *_router) startBackgroundTasks(tasks ...BackgroundTask) error {
+	for _, task := range tasks {
+		if task.Interval <= 0 {
+			return fmt.Errorf("the interval of the background task %s should be positive, got %s", task.Name, task.Interval)
+		}
+	}
+
+	for _, task := range tasks {
+		rt.backgroundTasks.Add(1)
+		go func(task BackgroundTask) {
+			defer rt.backgroundTasks.Done()
+
+			logger := rt.baseLogger.WithFields(logrus.Fields{"task": task.Name})
+			ticker := time.NewTicker(task.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-rt.backgroundCtx.Done():
+					return
+				case <-ticker.C:
+					if err := task.Run(rt.backgroundCtx); err != nil {
+						logger.WithError(err).Error("background task failed")
+					}
+				}
+			}
+		}(task)
+	}
+	return nil
+}
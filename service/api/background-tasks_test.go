@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStartBackgroundTasks_NotPositiveInterval(t *testing.T) {
+	r, err := New(RouterConfig{Logger: logrus.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := r.(*_router)
+	t.Cleanup(func() { _ = rt.Close() })
+
+	run := make(chan struct{}, 1)
+	valid := BackgroundTask{Name: "valid", Interval: time.Millisecond, Run: func(context.Context) error {
+		select {
+		case run <- struct{}{}:
+		default:
+		}
+		return nil
+	}}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		notValid := BackgroundTask{Name: "not-valid", Interval: interval, Run: valid.Run}
+		if err := rt.startBackgroundTasks(valid, notValid); err == nil {
+			t.Error("expected an error for the interval", interval)
+		}
+	}
+	// None of the tasks is started
+	select {
+	case <-run:
+		t.Error("expected the valid task not to be started")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"time"
+
 	"github.com/julienschmidt/httprouter"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/sirupsen/logrus"
@@ -18,12 +20,19 @@ type HandlerConfigPhotos struct {
 	PhotosUrlPath   string
 }
 
+type HandlerConfigRequests struct {
+	// Timeout is the deadline set on the context of every request, no deadline is set if it's zero
+	Timeout time.Duration
+}
+
 type HandlerConfigDependencies struct {
 	LivenessChecker     httprouter.Handle
-	TokenAuthMiddleware routes.Middleware
+	TokenAuthMiddleware routes.ScopedMiddleware
+	BackgroundTasks     []BackgroundTask
 }
 
 type HandlerConfig struct {
-	Photos HandlerConfigPhotos
-	Deps   HandlerConfigDependencies
+	Photos   HandlerConfigPhotos
+	Requests HandlerConfigRequests
+	Deps     HandlerConfigDependencies
 }
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/routes"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+)
+
+// backupController binds http requests to an api service and writes the service results to the http response
+type backupController struct {
+	service       services.BackupService
+	errorHandler  ErrorHandler
+	backupTimeout time.Duration
+}
+
+// NewBackupController creates a default api controller. The backups have backupTimeout to be written and answered,
+// instead of the server timeouts.
+func NewBackupController(s services.BackupService, backupTimeout time.Duration) Controller {
+	controller := &backupController{
+		service:       s,
+		errorHandler:  errorHandler,
+		backupTimeout: backupTimeout,
+	}
+
+	return controller
+}
+
+// Routes returns all the api routes for the backupController
+func (c *backupController) Routes() routes.Routes {
+	return routes.Routes{
+		{
+			// Only interactive sessions are granted the account scope, personal access tokens can't take backups
+			Name:         "CreateBackup",
+			Method:       http.MethodPost,
+			Path:         "/admin/backups",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.CreateBackup,
+			// Copying the photos takes longer than a request
+			Timeout: c.backupTimeout,
+		},
+	}
+}
+
+// CreateBackup - Write a snapshot of the database and of the photos to the backups directory of the server
+func (c *backupController) CreateBackup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	result, err := c.service.CreateBackup(r.Context(), ctx.User.Id)
+	if errors.Is(err, services.ErrUserForbidden) {
+		c.errorHandler(w, r, &ForbiddenError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+
+	encodeJSONResponse(result, http.StatusCreated, w, ctx)
+}
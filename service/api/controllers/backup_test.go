@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+	"github.com/lucaronca/wasa-homework/service/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// serveCreateBackup serves a backup request of userId, the user 1 is the only administrator
+func serveCreateBackup(t *testing.T, backupsDirectory string, userId int) *httptest.ResponseRecorder {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "wasa.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn, database.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bci := NewBackupController(services.NewBackupService(db, t.TempDir(), backupsDirectory, []int{1}), time.Minute)
+	bc, _ := bci.(*backupController)
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/backups", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	bc.CreateBackup(res, req, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: userId}})
+	return res
+}
+
+func TestCreateBackup_WritesArchive(t *testing.T) {
+	backupsDirectory := filepath.Join(t.TempDir(), "backups")
+	res := serveCreateBackup(t, backupsDirectory, 1)
+
+	if http.StatusCreated != res.Code {
+		t.Fatal("expected", http.StatusCreated, "got:", res.Code, res.Body.String())
+	}
+	var backup models.Backup
+	if err := json.Unmarshal(res.Body.Bytes(), &backup); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(backupsDirectory, backup.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != backup.Size || info.Mode().Perm() != 0600 {
+		t.Error("expected an archive readable by the owner only got:", info.Size(), info.Mode())
+	}
+}
+
+func TestCreateBackup_AdministratorsOnly(t *testing.T) {
+	backupsDirectory := filepath.Join(t.TempDir(), "backups")
+	res := serveCreateBackup(t, backupsDirectory, 2)
+
+	if http.StatusForbidden != res.Code {
+		t.Error("expected", http.StatusForbidden, "got:", res.Code)
+	}
+	if _, err := os.Stat(backupsDirectory); !os.IsNotExist(err) {
+		t.Error("expected no backup to be written got:", err)
+	}
+}
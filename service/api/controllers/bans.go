@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/lucaronca/wasa-homework/service/api/services"
@@ -34,6 +35,7 @@ func (c *bansController) Routes() routes.Routes {
 			Method:       http.MethodPut,
 			Path:         "/users/me/bans/:targetUserId",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.BanUser,
 		},
 		{
@@ -41,6 +43,7 @@ func (c *bansController) Routes() routes.Routes {
 			Method:       http.MethodDelete,
 			Path:         "/users/me/bans/:targetUserId",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.UnbanUser,
 		},
 	}
@@ -54,7 +57,7 @@ func (c *bansController) BanUser(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 
-	err = c.service.BanUser(ctx.User.Id, targetUserIdParam)
+	err = c.service.BanUser(r.Context(), ctx.User.Id, targetUserIdParam)
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
 		return
@@ -74,7 +77,7 @@ func (c *bansController) UnbanUser(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
-	err = c.service.UnbanUser(ctx.User.Id, targetUserIdParam)
+	err = c.service.UnbanUser(r.Context(), ctx.User.Id, targetUserIdParam)
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
 		return
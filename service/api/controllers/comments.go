@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/lucaronca/wasa-homework/service/api/services"
@@ -35,6 +36,7 @@ func (c *commentsController) Routes() routes.Routes {
 			Method:       http.MethodGet,
 			Path:         "/photos/:photoId/comments",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosRead},
 			HandlerFunc:  c.GetPhotoComments,
 		},
 		{
@@ -42,6 +44,7 @@ func (c *commentsController) Routes() routes.Routes {
 			Method:       http.MethodPost,
 			Path:         "/photos/:photoId/comments",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.CommentPhoto,
 		},
 		{
@@ -49,6 +52,7 @@ func (c *commentsController) Routes() routes.Routes {
 			Method:       http.MethodDelete,
 			Path:         "/photos/:photoId/comments/:commentId",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.UncommentPhoto,
 		},
 	}
@@ -62,7 +66,7 @@ func (c *commentsController) GetPhotoComments(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	result, err := c.service.GetPhotoComments(photoIdParam, ctx.User.Id)
+	result, err := c.service.GetPhotoComments(r.Context(), photoIdParam, ctx.User.Id)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoPhoto) {
 		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
@@ -103,7 +107,7 @@ func (c *commentsController) CommentPhoto(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	newComment, err := c.service.CommentPhoto(photoIdParam, ctx.User.Id, commentParam.Content)
+	newComment, err := c.service.CommentPhoto(r.Context(), photoIdParam, ctx.User.Id, commentParam.Content)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoPhoto) {
 		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
@@ -133,7 +137,7 @@ func (c *commentsController) UncommentPhoto(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = c.service.UncommentPhoto(photoIdParam, commentIdParam, ctx.User.Id)
+	err = c.service.UncommentPhoto(r.Context(), photoIdParam, commentIdParam, ctx.User.Id)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoPhoto) {
 		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
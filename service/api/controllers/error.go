@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -57,6 +58,45 @@ func (e *ForbiddenError) Error() string {
 	return e.Err.Error()
 }
 
+// UnauthorizedError indicates that an error has occurred when the request credentials are not valid
+type UnauthorizedError struct {
+	Err error
+}
+
+func (e *UnauthorizedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Err.Error()
+}
+
+// ConflictError indicates that an error has occurred when trying to create an already existing entity
+type ConflictError struct {
+	Err error
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+// PayloadTooLargeError indicates that the request body, or what it holds, is larger than allowed
+type PayloadTooLargeError struct {
+	Err error
+}
+
+func (e *PayloadTooLargeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return e.Err.Error()
+}
+
 // ErrorHandler defines the required method for handling error.
 type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error, ctx reqcontext.RequestContext)
 
@@ -67,6 +107,9 @@ func errorHandler(w http.ResponseWriter, r *http.Request, err error, ctx reqcont
 	var re *RequiredError
 	var nfe *NotFoundError
 	var fe *ForbiddenError
+	var ue *UnauthorizedError
+	var ce *ConflictError
+	var ple *PayloadTooLargeError
 
 	switch {
 	case
@@ -81,6 +124,19 @@ func errorHandler(w http.ResponseWriter, r *http.Request, err error, ctx reqcont
 	// Handle forbidden entity errors
 	case errors.As(err, &fe):
 		encodeTextResponse(err.Error(), http.StatusForbidden, w, ctx)
+	// Handle wrong credentials errors
+	case errors.As(err, &ue):
+		encodeTextResponse(err.Error(), http.StatusUnauthorized, w, ctx)
+	// Handle already existing entity errors
+	case errors.As(err, &ce):
+		encodeTextResponse(err.Error(), http.StatusConflict, w, ctx)
+	// Handle too large request bodies
+	case errors.As(err, &ple):
+		encodeTextResponse(err.Error(), http.StatusRequestEntityTooLarge, w, ctx)
+	// Handle requests whose deadline expired before they were handled
+	case errors.Is(err, context.DeadlineExceeded):
+		ctx.Logger.WithError(err).Warning("Request timed out")
+		encodeTextResponse("Request timed out", http.StatusServiceUnavailable, w, ctx)
 	// Handle all other errors
 	default:
 		ctx.Logger.WithError(err).Error("Internal server error")
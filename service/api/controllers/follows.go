@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/lucaronca/wasa-homework/service/api/services"
@@ -34,6 +35,7 @@ func (c *followsController) Routes() routes.Routes {
 			Method:       http.MethodPut,
 			Path:         "/users/me/followings/:targetUserId",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.FollowUser,
 		},
 		{
@@ -41,6 +43,7 @@ func (c *followsController) Routes() routes.Routes {
 			Method:       http.MethodGet,
 			Path:         "/users/:userId/followers",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialRead},
 			HandlerFunc:  c.GetUserFollowers,
 		},
 		{
@@ -48,6 +51,7 @@ func (c *followsController) Routes() routes.Routes {
 			Method:       http.MethodGet,
 			Path:         "/users/:userId/followings",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialRead},
 			HandlerFunc:  c.GetUserFollowings,
 		},
 		{
@@ -55,6 +59,7 @@ func (c *followsController) Routes() routes.Routes {
 			Method:       http.MethodDelete,
 			Path:         "/users/me/followings/:targetUserId",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.UnfollowUser,
 		},
 	}
@@ -72,7 +77,7 @@ func (c *followsController) FollowUser(w http.ResponseWriter, r *http.Request, p
 		return
 	}
 
-	err = c.service.FollowUser(ctx.User.Id, targetUserIdParam)
+	err = c.service.FollowUser(r.Context(), ctx.User.Id, targetUserIdParam)
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
 		return
@@ -99,7 +104,7 @@ func (c *followsController) GetUserFollowers(w http.ResponseWriter, r *http.Requ
 		parsedIdParam = parsed
 	}
 
-	result, err := c.service.GetUserFollowers(ctx.User.Id, parsedIdParam)
+	result, err := c.service.GetUserFollowers(r.Context(), ctx.User.Id, parsedIdParam)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
@@ -127,7 +132,7 @@ func (c *followsController) GetUserFollowings(w http.ResponseWriter, r *http.Req
 		parsedIdParam = parsed
 	}
 
-	result, err := c.service.GetUserFollowings(ctx.User.Id, parsedIdParam)
+	result, err := c.service.GetUserFollowings(r.Context(), ctx.User.Id, parsedIdParam)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
@@ -148,7 +153,7 @@ func (c *followsController) UnfollowUser(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	err = c.service.UnfollowUser(ctx.User.Id, targetUserIdParam)
+	err = c.service.UnfollowUser(r.Context(), ctx.User.Id, targetUserIdParam)
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
 		return
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/lucaronca/wasa-homework/service/api/services"
@@ -34,6 +35,7 @@ func (c *likesController) Routes() routes.Routes {
 			Method:       http.MethodGet,
 			Path:         "/photos/:photoId/likes",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosRead},
 			HandlerFunc:  c.GetPhotoLikes,
 		},
 		{
@@ -41,6 +43,7 @@ func (c *likesController) Routes() routes.Routes {
 			Method:       http.MethodPut,
 			Path:         "/photos/:photoId/likes/me",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.LikePhoto,
 		},
 		{
@@ -48,6 +51,7 @@ func (c *likesController) Routes() routes.Routes {
 			Method:       http.MethodDelete,
 			Path:         "/photos/:photoId/likes/me",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeSocialWrite},
 			HandlerFunc:  c.UnlikePhoto,
 		},
 	}
@@ -61,7 +65,7 @@ func (c *likesController) GetPhotoLikes(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	result, err := c.service.GetPhotoLikes(photoIdParam, ctx.User.Id)
+	result, err := c.service.GetPhotoLikes(r.Context(), photoIdParam, ctx.User.Id)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoPhoto) {
 		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
@@ -85,7 +89,7 @@ func (c *likesController) LikePhoto(w http.ResponseWriter, r *http.Request, ps h
 		return
 	}
 
-	err = c.service.LikePhoto(photoIdParam, ctx.User.Id)
+	err = c.service.LikePhoto(r.Context(), photoIdParam, ctx.User.Id)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoPhoto) {
 		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
@@ -109,7 +113,7 @@ func (c *likesController) UnlikePhoto(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
-	err = c.service.UnlikePhoto(photoIdParam, ctx.User.Id)
+	err = c.service.UnlikePhoto(r.Context(), photoIdParam, ctx.User.Id)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoPhoto) {
 		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
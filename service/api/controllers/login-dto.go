@@ -2,24 +2,47 @@ package controllers
 
 import (
 	"errors"
-	"regexp"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/usernames"
 )
 
-// DoLoginRequest - Name of the user
+// DoLoginRequest - Name and password of the user
 type DoLoginRequest struct {
 
 	// User name
 	Name string `json:"name,omitempty"`
+
+	// User password, optional for users created before passwords were introduced
+	Password string `json:"password,omitempty"`
+}
+
+// RegisterRequest - Name and password of the new user
+type RegisterRequest struct {
+
+	// User name
+	Name string `json:"name,omitempty"`
+
+	// User password
+	Password string `json:"password,omitempty"`
 }
 
 var ErrLoginNameIsZero = errors.New("Login name is zero value")
 var ErrLoginNameIsNotValid = errors.New("Name should be at least 3 characters long")
+var ErrLoginPasswordIsZero = errors.New("Login password is zero value")
+var ErrLoginPasswordIsNotValid = errors.New("Password should be between 8 and 72 characters long")
 
+// parseUsernameParameter checks the length and the characters of a new username. It's not checked on login, the
+// users created before the rule was introduced keep their names.
 func parseUsernameParameter(param string) error {
-	match, err := regexp.MatchString(`^.*?$`, param)
+	return usernames.Validate(param)
+}
 
-	if err != nil || !match {
-		return ErrLoginNameIsNotValid
+// parsePasswordParameter checks the password length, bcrypt ignores everything after the 72nd byte
+func parsePasswordParameter(param string) error {
+	if len(param) < 8 || len(param) > 72 {
+		return ErrLoginPasswordIsNotValid
 	}
 
 	return nil
@@ -34,7 +57,55 @@ func assertDoLoginRequestValid(obj DoLoginRequest) error {
 		return ErrLoginNameIsNotValid
 	}
 
-	return parseUsernameParameter(obj.Name)
+	if obj.Password == "" {
+		return nil
+	}
+
+	return parsePasswordParameter(obj.Password)
+}
+
+// assertRegisterRequestValid checks if the required fields are not zero-ed
+func assertRegisterRequestValid(obj RegisterRequest) error {
+	if err := assertDoLoginRequestValid(DoLoginRequest(obj)); err != nil {
+		return err
+	}
+
+	if err := parseUsernameParameter(obj.Name); err != nil {
+		return err
+	}
+
+	if obj.Password == "" {
+		return ErrLoginPasswordIsZero
+	}
+
+	return nil
+}
+
+// RefreshSessionRequest - Refresh token of a session
+type RefreshSessionRequest struct {
+
+	// Refresh token received with the last session tokens
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// VerifyLoginChallengeRequest - Challenge token of a login and its second factor
+type VerifyLoginChallengeRequest struct {
+
+	// Challenge token received from the login
+	ChallengeToken string `json:"challengeToken,omitempty"`
+
+	// TOTP code, or one of the recovery codes
+	Code string `json:"code,omitempty"`
+}
+
+// LoginChallengeResponse - Challenge token of a login waiting for the second factor
+type LoginChallengeResponse struct {
+
+	// Token to send along with the second factor
+	ChallengeToken string `json:"challengeToken"`
+
+	// Challenge token expiration date
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
 // DoLoginResponse - Token identifier for a logged in user
@@ -42,4 +113,18 @@ type DoLoginResponse struct {
 
 	// Token identifier for a logged in user
 	Identifier string `json:"identifier,omitempty"`
+
+	// Token identifier expiration date
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+
+	// Token used to get a new token identifier once it expires
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+func newDoLoginResponse(tokens *models.SessionTokens) DoLoginResponse {
+	return DoLoginResponse{
+		Identifier:   tokens.AccessToken,
+		ExpiresAt:    tokens.AccessTokenExpiresAt,
+		RefreshToken: tokens.RefreshToken,
+	}
 }
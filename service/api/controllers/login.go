@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/lucaronca/wasa-homework/service/api/services"
@@ -37,6 +38,51 @@ func (c *loginController) Routes() routes.Routes {
 			AuthRequired: false,
 			HandlerFunc:  c.DoLogin,
 		},
+		{
+			Name:         "VerifyLoginChallenge",
+			Method:       http.MethodPost,
+			Path:         "/session/2fa",
+			AuthRequired: false,
+			HandlerFunc:  c.VerifyLoginChallenge,
+		},
+		{
+			Name:         "Register",
+			Method:       http.MethodPost,
+			Path:         "/users",
+			AuthRequired: false,
+			HandlerFunc:  c.Register,
+		},
+		{
+			Name:         "RefreshSession",
+			Method:       http.MethodPost,
+			Path:         "/session/refresh",
+			AuthRequired: false,
+			HandlerFunc:  c.RefreshSession,
+		},
+		{
+			Name:         "GetSessions",
+			Method:       http.MethodGet,
+			Path:         "/session",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.GetSessions,
+		},
+		{
+			Name:         "DoLogout",
+			Method:       http.MethodDelete,
+			Path:         "/session",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.DoLogout,
+		},
+		{
+			Name:         "RevokeSession",
+			Method:       http.MethodDelete,
+			Path:         "/session/:sessionId",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.RevokeSession,
+		},
 	}
 }
 
@@ -53,22 +99,164 @@ func (c *loginController) DoLogin(w http.ResponseWriter, r *http.Request, ps htt
 	if err := assertDoLoginRequestValid(doLoginRequestParam); err != nil {
 		if errors.Is(err, ErrLoginNameIsZero) {
 			c.errorHandler(w, r, &RequiredError{"name"}, ctx)
-		} else if errors.Is(err, ErrLoginNameIsNotValid) {
+		} else {
 			c.errorHandler(w, r, &ParsingError{err}, ctx)
 		}
 		return
 	}
 
-	token, isNewUser, err := c.service.DoLogin(doLoginRequestParam.Name)
+	result, err := c.service.DoLogin(r.Context(), doLoginRequestParam.Name, doLoginRequestParam.Password, r.UserAgent())
 	// If an error occurred, encode the error with the status code
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		c.errorHandler(w, r, &UnauthorizedError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	// The user enabled the two-factor authentication, the session starts once the challenge is verified
+	if result.Session == nil {
+		encodeJSONResponse(LoginChallengeResponse{result.ChallengeToken, result.ChallengeExpiresAt}, http.StatusAccepted, w, ctx)
+		return
+	}
+	encodeJSONResponse(newDoLoginResponse(result.Session), http.StatusOK, w, ctx)
+}
+
+// VerifyLoginChallenge - Completes a login sending the second factor
+func (c *loginController) VerifyLoginChallenge(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	verifyRequestParam := VerifyLoginChallengeRequest{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&verifyRequestParam); err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("Payload not valid")}, ctx)
+		return
+	}
+	if verifyRequestParam.ChallengeToken == "" {
+		c.errorHandler(w, r, &RequiredError{"challengeToken"}, ctx)
+		return
+	}
+	if verifyRequestParam.Code == "" {
+		c.errorHandler(w, r, &RequiredError{"code"}, ctx)
+		return
+	}
+
+	tokens, err := c.service.VerifyLoginChallenge(r.Context(), verifyRequestParam.ChallengeToken, verifyRequestParam.Code)
+	// If an error occurred, encode the error with the status code
+	if errors.Is(err, services.ErrInvalidLoginChallenge) || errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		c.errorHandler(w, r, &UnauthorizedError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(newDoLoginResponse(tokens), http.StatusOK, w, ctx)
+}
+
+// Register - Creates a new user and logs it in
+func (c *loginController) Register(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	registerRequestParam := RegisterRequest{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&registerRequestParam); err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("Payload not valid")}, ctx)
+		return
+	}
+
+	if err := assertRegisterRequestValid(registerRequestParam); err != nil {
+		switch {
+		case errors.Is(err, ErrLoginNameIsZero):
+			c.errorHandler(w, r, &RequiredError{"name"}, ctx)
+		case errors.Is(err, ErrLoginPasswordIsZero):
+			c.errorHandler(w, r, &RequiredError{"password"}, ctx)
+		default:
+			c.errorHandler(w, r, &ParsingError{err}, ctx)
+		}
+		return
+	}
+
+	tokens, err := c.service.Register(r.Context(), registerRequestParam.Name, registerRequestParam.Password, r.UserAgent())
+	// If an error occurred, encode the error with the status code
+	if errors.Is(err, services.ErrUserAlreadyExists) || errors.Is(err, services.ErrUsernameReserved) {
+		c.errorHandler(w, r, &ConflictError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(newDoLoginResponse(tokens), http.StatusCreated, w, ctx)
+}
+
+// RefreshSession - Exchanges a refresh token for new session tokens
+func (c *loginController) RefreshSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	refreshSessionRequestParam := RefreshSessionRequest{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&refreshSessionRequestParam); err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("Payload not valid")}, ctx)
+		return
+	}
+	if refreshSessionRequestParam.RefreshToken == "" {
+		c.errorHandler(w, r, &RequiredError{"refreshToken"}, ctx)
+		return
+	}
+
+	tokens, err := c.service.RefreshSession(r.Context(), refreshSessionRequestParam.RefreshToken)
+	// If an error occurred, encode the error with the status code
+	if errors.Is(err, services.ErrRefreshTokenReused) {
+		ctx.Logger.Warning("refresh token reuse detected, the session has been revoked")
+		c.errorHandler(w, r, &UnauthorizedError{err}, ctx)
+		return
+	} else if errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.errorHandler(w, r, &UnauthorizedError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(newDoLoginResponse(tokens), http.StatusOK, w, ctx)
+}
+
+// GetSessions - Get the active sessions of the user
+func (c *loginController) GetSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	result, err := c.service.GetSessions(r.Context(), ctx.User.Id, ctx.SessionId)
 	if err != nil {
 		c.errorHandler(w, r, err, ctx)
 		return
 	}
-	// If no error, and new user return 201 else 200
-	if isNewUser {
-		encodeJSONResponse(DoLoginResponse{Identifier: token}, http.StatusCreated, w, ctx)
-	} else {
-		encodeJSONResponse(DoLoginResponse{Identifier: token}, http.StatusOK, w, ctx)
+	encodeJSONResponse(result, http.StatusOK, w, ctx)
+}
+
+// DoLogout - Logs out the user from the current session
+func (c *loginController) DoLogout(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	err := c.service.RevokeSession(r.Context(), ctx.User.Id, ctx.SessionId)
+	if errors.Is(err, services.ErrNoSession) {
+		c.errorHandler(w, r, &NotFoundError{"Session"}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSession - Logs out the user from another session
+func (c *loginController) RevokeSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	sessionIdParam, err := parseIntParameter(ps.ByName("sessionId"), true)
+	if err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("sessionId should be a valid int number")}, ctx)
+		return
+	}
+
+	err = c.service.RevokeSession(r.Context(), ctx.User.Id, sessionIdParam)
+	if errors.Is(err, services.ErrNoSession) {
+		c.errorHandler(w, r, &NotFoundError{"Session"}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
 	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -2,76 +2,242 @@ package controllers
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/api/repositories/memrepo"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/services"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 )
 
-type authRepositoryMock struct{}
+var testTokenHashKey = []byte("0123456789abcdef0123456789abcdef")
 
-func (am *authRepositoryMock) GetToken(relations ...repositories.Relation) (string, error) {
-	return "mock_token", nil
+// testTokenHash returns the hash the test auth service stores for a token
+func testTokenHash(token string) string {
+	return services.HashToken(testTokenHashKey, token)
 }
-func (am *authRepositoryMock) SetToken(userId int, token string) error {
-	return nil
-}
-func (am *authRepositoryMock) WithTokens() repositories.Relation {
-	return nil
-}
-func (am *authRepositoryMock) FilterByToken(token string) repositories.Relation {
-	return nil
+
+// newTestRepositories returns in-memory repositories with the given users, their ids start from 1
+func newTestRepositories(t *testing.T, usernames ...string) *repositories.Repositories {
+	t.Helper()
+	r := memrepo.NewRepositories()
+	for _, username := range usernames {
+		if _, err := r.Users.CreateUser(context.Background(), &models.BaseUser{Username: username}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
 }
 
-type usersRepositoryMock struct {
-	hasUser bool
+// setTestPassword stores the hash of the password of a user
+func setTestPassword(t *testing.T, r *repositories.Repositories, userId int, password string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Auth.SetPasswordHash(context.Background(), userId, string(hash)); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func (um *usersRepositoryMock) GetUserById(id int) (*models.BaseUser, error) {
-	return nil, nil
+// testPasswordMatches checks the stored password of a user
+func testPasswordMatches(t *testing.T, r *repositories.Repositories, userId int, password string) bool {
+	t.Helper()
+	hash, err := r.Auth.GetPasswordHash(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
-func (um *usersRepositoryMock) GetUser(relations ...repositories.Relation) (*models.BaseUser, error) {
-	if um.hasUser {
-		return &models.BaseUser{Id: 1, Username: "Mario"}, nil
+
+// newTestSession stores a session of a user, valid for an hour
+func newTestSession(t *testing.T, r *repositories.Repositories, userId int, tokenHash string) int {
+	t.Helper()
+	sessionId, err := r.Auth.SetSession(context.Background(), userId, tokenHash, "", time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
 	}
-	return nil, nil
+	return sessionId
 }
-func (um *usersRepositoryMock) GetFullUser(relations ...repositories.Relation) (*models.FullUser, error) {
-	return nil, nil
+
+// testSessions returns the sessions that weren't revoked, of every user
+func testSessions(t *testing.T, r *repositories.Repositories) []models.Session {
+	t.Helper()
+	sessions, err := r.Auth.GetSessions(context.Background(), r.Auth.WithoutRevokedSessions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *sessions
 }
-func (um *usersRepositoryMock) GetUsers(relations ...repositories.Relation) (*[]models.BaseUser, error) {
-	return nil, nil
+
+// testSessionByToken returns the session of a token, nil if there's none
+func testSessionByToken(t *testing.T, r *repositories.Repositories, token string) *models.Session {
+	t.Helper()
+	session, err := r.Auth.GetSession(context.Background(), r.Auth.FilterByTokenHash(testTokenHash(token)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
 }
-func (um *usersRepositoryMock) CreateUser(user *models.BaseUser) (userId int, err error) {
-	return 0, nil
+
+// newTestAuthService returns the auth service of the repositories, its units of work are the ones of their store
+func newTestAuthService(r *repositories.Repositories) services.AuthService {
+	uow, _ := memrepo.NewUnitOfWork(r)
+	return services.NewAuthService(testTokenHashKey, time.Minute, time.Hour, uow, r.Auth, r.Users, r.TwoFactor)
 }
-func (um *usersRepositoryMock) UpdateUser(user *models.BaseUser) (err error) {
-	return nil
+
+func TestRegister_CreateUser(t *testing.T) {
+	var jsonStr = []byte(`{"name": "Mario", "password": "supersecret"}`)
+	req, err := http.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.Register(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusCreated != res.Code {
+		t.Error("expected", http.StatusCreated, "got:", res.Code)
+	}
+
+	if !strings.Contains(res.Body.String(), "\"identifier\":") {
+		t.Error("expected JSON response with \"identifier\" got:", res.Body.String())
+	}
+
+	if !testPasswordMatches(t, repos, 1, "supersecret") {
+		t.Error("expected the password hash to be stored")
+	}
 }
-func (um *usersRepositoryMock) WithUsers() repositories.Relation {
-	return nil
+
+func TestRegister_UserAlreadyExists(t *testing.T) {
+	var jsonStr = []byte(`{"name": "Mario", "password": "supersecret"}`)
+	req, err := http.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authService := newTestAuthService(newTestRepositories(t, "Mario"))
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.Register(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusConflict != res.Code {
+		t.Error("expected", http.StatusConflict, "got:", res.Code)
+	}
 }
-func (um *usersRepositoryMock) FilterByUserId(userId int) repositories.Relation {
-	return nil
+
+func TestRegister_UsernameNotAvailable(t *testing.T) {
+	for name, expected := range map[string]int{
+		"MARIO":            http.StatusConflict,
+		"ｍａｒｉｏ":            http.StatusConflict,
+		"Admin":            http.StatusConflict,
+		"Mario!":           http.StatusBadRequest,
+		"_mario":           http.StatusBadRequest,
+		"Mario Rossi":      http.StatusBadRequest,
+		"SuperMarioBros64": http.StatusCreated,
+	} {
+		jsonStr, _ := json.Marshal(RegisterRequest{Name: name, Password: "supersecret"})
+		req, err := http.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(jsonStr))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authService := newTestAuthService(newTestRepositories(t, "Mario"))
+		lci := NewLoginController(authService)
+		lc, _ := lci.(*loginController)
+
+		res := httptest.NewRecorder()
+		lc.Register(res, req, httprouter.Params{}, reqcontext.RequestContext{})
+
+		if expected != res.Code {
+			t.Error("expected", expected, "registering", name, "got:", res.Code, res.Body.String())
+		}
+	}
 }
-func (um *usersRepositoryMock) FilterByUsername(username string, strict bool) repositories.Relation {
-	return nil
+
+func TestDoLogin_IgnoresUsernameCase(t *testing.T) {
+	var jsonStr = []byte(`{"name": "mario", "password": "supersecret"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario")
+	setTestPassword(t, repos, 1, "supersecret")
+	lci := NewLoginController(newTestAuthService(repos))
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	lc.DoLogin(res, req, httprouter.Params{}, reqcontext.RequestContext{})
+
+	if http.StatusOK != res.Code {
+		t.Error("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
 }
 
-func TestDoLogin_CreateUser(t *testing.T) {
+func TestRegister_PasswordMissing(t *testing.T) {
 	var jsonStr = []byte(`{"name": "Mario"}`)
+	req, err := http.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authService := newTestAuthService(newTestRepositories(t))
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.Register(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusBadRequest != res.Code {
+		t.Error("expected", http.StatusBadRequest, "got:", res.Code)
+	}
+
+	if res.Body.String() != "Required 'password' is missing." {
+		t.Error("expected \"Required 'password' is missing.\" got:", res.Body.String())
+	}
+}
+
+func TestDoLogin_UseExistingUser(t *testing.T) {
+	var jsonStr = []byte(`{"name": "Mario", "password": "supersecret"}`)
 	req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBuffer(jsonStr))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	authService := services.NewAuthService(&authRepositoryMock{}, &usersRepositoryMock{})
+	repos := newTestRepositories(t, "Mario")
+	setTestPassword(t, repos, 1, "supersecret")
+	authService := newTestAuthService(repos)
 	lci := NewLoginController(authService)
 	lc, _ := lci.(*loginController)
 
@@ -82,8 +248,8 @@ func TestDoLogin_CreateUser(t *testing.T) {
 
 	handler.ServeHTTP(res, req)
 
-	if http.StatusCreated != res.Code {
-		t.Error("expected", http.StatusCreated, "got:", res.Code)
+	if http.StatusOK != res.Code {
+		t.Error("expected", http.StatusOK, "got:", res.Code)
 	}
 
 	if !strings.Contains(res.Body.String(), "\"identifier\":") {
@@ -91,14 +257,95 @@ func TestDoLogin_CreateUser(t *testing.T) {
 	}
 }
 
-func TestDoLogin_UseExistingUser(t *testing.T) {
+func TestDoLogin_WrongPassword(t *testing.T) {
+	var jsonStr = []byte(`{"name": "Mario", "password": "notthesecret"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario")
+	setTestPassword(t, repos, 1, "supersecret")
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.DoLogin(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+
+	if strings.Contains(res.Body.String(), "\"identifier\":") {
+		t.Error("expected no identifier got:", res.Body.String())
+	}
+}
+
+func TestDoLogin_MissingPassword(t *testing.T) {
 	var jsonStr = []byte(`{"name": "Mario"}`)
 	req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBuffer(jsonStr))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	authService := services.NewAuthService(&authRepositoryMock{}, &usersRepositoryMock{true})
+	repos := newTestRepositories(t, "Mario")
+	setTestPassword(t, repos, 1, "supersecret")
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.DoLogin(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
+
+func TestDoLogin_UnknownUser(t *testing.T) {
+	var jsonStr = []byte(`{"name": "Mario", "password": "supersecret"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authService := newTestAuthService(newTestRepositories(t))
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.DoLogin(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
+
+func TestDoLogin_MigrateUserWithoutPassword(t *testing.T) {
+	var jsonStr = []byte(`{"name": "Mario", "password": "supersecret"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario")
+	if err := repos.Auth.SetPasswordPending(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	authService := newTestAuthService(repos)
 	lci := NewLoginController(authService)
 	lc, _ := lci.(*loginController)
 
@@ -113,8 +360,41 @@ func TestDoLogin_UseExistingUser(t *testing.T) {
 		t.Error("expected", http.StatusOK, "got:", res.Code)
 	}
 
-	if !strings.Contains(res.Body.String(), "\"identifier\":") {
-		t.Error("expected JSON response with \"identifier\" got:", res.Body.String())
+	if !testPasswordMatches(t, repos, 1, "supersecret") {
+		t.Error("expected the password to be set on first login")
+	}
+}
+
+func TestDoLogin_UserWithIdentityOnly(t *testing.T) {
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	if _, err := authService.LoginWithIdentity(context.Background(), "https://idp.example.com", "1234", "Mario", ""); err != nil {
+		t.Fatal(err)
+	}
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.DoLogin(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	// Neither a missing password nor a new one logs in the user, or sets its password
+	for _, body := range []string{`{"name": "Mario"}`, `{"name": "Mario", "password": "supersecret"}`} {
+		req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		if http.StatusUnauthorized != res.Code {
+			t.Error("expected", http.StatusUnauthorized, "got:", res.Code, body)
+		}
+	}
+	if hash, err := repos.Auth.GetPasswordHash(context.Background(), 1); err != nil || hash != "" {
+		t.Error("expected no password got:", hash, err)
+	}
+	if len(testSessions(t, repos)) != 1 {
+		t.Error("expected only the session of the OpenID Connect login got:", testSessions(t, repos))
 	}
 }
 
@@ -125,7 +405,7 @@ func TestDoLogin_PayloadError(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	authService := services.NewAuthService(&authRepositoryMock{}, &usersRepositoryMock{})
+	authService := newTestAuthService(newTestRepositories(t))
 	lci := NewLoginController(authService)
 	lc, _ := lci.(*loginController)
 
@@ -152,7 +432,7 @@ func TestDoLogin_NameNotValid(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	authService := services.NewAuthService(&authRepositoryMock{}, &usersRepositoryMock{})
+	authService := newTestAuthService(newTestRepositories(t))
 	lci := NewLoginController(authService)
 	lc, _ := lci.(*loginController)
 
@@ -171,3 +451,214 @@ func TestDoLogin_NameNotValid(t *testing.T) {
 		t.Error("expected \"Name should be at least 3 characters long\" got:", res.Body.String())
 	}
 }
+
+func TestDoLogin_CreatesSessionForDevice(t *testing.T) {
+	var jsonStr = []byte(`{"name": "Mario", "password": "supersecret"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "Mario's phone")
+
+	repos := newTestRepositories(t, "Mario")
+	setTestPassword(t, repos, 1, "supersecret")
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.DoLogin(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusOK != res.Code {
+		t.Error("expected", http.StatusOK, "got:", res.Code)
+	}
+
+	if sessions := testSessions(t, repos); len(sessions) != 1 || sessions[0].UserAgent != "Mario's phone" {
+		t.Error("expected a new session for the device got:", sessions)
+	}
+
+	var response DoLoginResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if testSessionByToken(t, repos, response.Identifier) == nil {
+		t.Error("expected only the hash of the new session token to be stored")
+	}
+}
+
+func TestRevokeSession_OtherUserSession(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, "/session/2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario", "Luigi")
+	newTestSession(t, repos, 1, "mario-session")
+	newTestSession(t, repos, 2, "luigi-session")
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.RevokeSession(
+			w,
+			r,
+			httprouter.Params{{Key: "sessionId", Value: "2"}},
+			reqcontext.RequestContext{User: reqcontext.User{Id: 1}, SessionId: 1},
+		)
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusNotFound != res.Code {
+		t.Error("expected", http.StatusNotFound, "got:", res.Code)
+	}
+
+	if sessions := testSessions(t, repos); len(sessions) != 2 {
+		t.Error("expected no revoked sessions got:", sessions)
+	}
+}
+
+func TestDoLogout_RevokesCurrentSession(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, "/session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario")
+	newTestSession(t, repos, 1, "other-session")
+	sessionId := newTestSession(t, repos, 1, "current-session")
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.DoLogout(w, r, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}, SessionId: sessionId})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusNoContent != res.Code {
+		t.Error("expected", http.StatusNoContent, "got:", res.Code)
+	}
+
+	if sessions := testSessions(t, repos); len(sessions) != 1 || sessions[0].Id == sessionId {
+		t.Error("expected only the current session to be revoked got:", sessions)
+	}
+}
+
+func TestRefreshSession_RotatesTokens(t *testing.T) {
+	repos := newTestRepositories(t, "Mario")
+	setTestPassword(t, repos, 1, "supersecret")
+	authService := newTestAuthService(repos)
+	result, err := authService.DoLogin(context.Background(), "Mario", "supersecret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := result.Session
+
+	var jsonStr = []byte(`{"refreshToken": "` + tokens.RefreshToken + `"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session/refresh", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.RefreshSession(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusOK != res.Code {
+		t.Error("expected", http.StatusOK, "got:", res.Code)
+	}
+
+	var response DoLoginResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	refreshToken, err := repos.Auth.GetRefreshToken(context.Background(), testTokenHash(response.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshToken == nil || refreshToken.UsedAt != nil {
+		t.Error("expected the new refresh token got:", res.Body.String())
+	}
+
+	if response.RefreshToken == tokens.RefreshToken {
+		t.Error("expected the refresh token to be rotated")
+	}
+}
+
+func TestRefreshSession_ReusedTokenRevokesSession(t *testing.T) {
+	repos := newTestRepositories(t, "Mario")
+	sessionId := newTestSession(t, repos, 1, "session")
+	if err := repos.Auth.SetRefreshToken(context.Background(), sessionId, testTokenHash("stolen"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	refreshToken, err := repos.Auth.GetRefreshToken(context.Background(), testTokenHash("stolen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repos.Auth.SetRefreshTokenUsed(context.Background(), refreshToken.Id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	authService := newTestAuthService(repos)
+
+	var jsonStr = []byte(`{"refreshToken": "stolen"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session/refresh", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.RefreshSession(w, r, httprouter.Params{}, reqcontext.RequestContext{Logger: logrus.New()})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+
+	if sessions := testSessions(t, repos); len(sessions) != 0 {
+		t.Error("expected the session to be revoked got:", sessions)
+	}
+}
+
+func TestRefreshSession_UnknownToken(t *testing.T) {
+	var jsonStr = []byte(`{"refreshToken": "unknown"}`)
+	req, err := http.NewRequest(http.MethodPost, "/session/refresh", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authService := newTestAuthService(newTestRepositories(t, "Mario"))
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.RefreshSession(w, r, httprouter.Params{}, reqcontext.RequestContext{})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/routes"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+)
+
+// oidcAttemptCookie stores the state and the nonce of a login attempt between the start and the callback
+const oidcAttemptCookie = "wasa_oidc_attempt"
+
+// oidcAttemptTTL is how long the user has to log in on the provider
+const oidcAttemptTTL = 10 * time.Minute
+
+// oidcController binds http requests to an api service and writes the service results to the http response
+type oidcController struct {
+	service              services.OIDCService
+	postLoginRedirectURL string
+	secureCookies        bool
+	errorHandler         ErrorHandler
+}
+
+// NewOIDCController creates a default api controller. After logging in, users are redirected to postLoginRedirectURL
+// with the session tokens in the URL fragment, if it's empty the tokens are returned as JSON.
+func NewOIDCController(s services.OIDCService, postLoginRedirectURL string, secureCookies bool) Controller {
+	controller := &oidcController{
+		service:              s,
+		postLoginRedirectURL: postLoginRedirectURL,
+		secureCookies:        secureCookies,
+		errorHandler:         errorHandler,
+	}
+
+	return controller
+}
+
+// Routes returns all the api routes for the oidcController
+func (c *oidcController) Routes() routes.Routes {
+	return routes.Routes{
+		{
+			Name:         "StartOIDCLogin",
+			Method:       http.MethodGet,
+			Path:         "/session/oidc/start",
+			AuthRequired: false,
+			HandlerFunc:  c.StartOIDCLogin,
+		},
+		{
+			Name:         "FinishOIDCLogin",
+			Method:       http.MethodGet,
+			Path:         "/session/oidc/callback",
+			AuthRequired: false,
+			HandlerFunc:  c.FinishOIDCLogin,
+		},
+	}
+}
+
+// StartOIDCLogin - Redirects the user to the OpenID Connect provider
+func (c *oidcController) StartOIDCLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	attempt, authURL, err := c.service.StartLogin(r.Context())
+	if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcAttemptCookie,
+		Value:    attempt.State + "." + attempt.Nonce,
+		Path:     "/",
+		MaxAge:   int(oidcAttemptTTL.Seconds()),
+		Secure:   c.secureCookies,
+		HttpOnly: true,
+		// The provider redirects back with a top level navigation, which Lax cookies are sent with
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// FinishOIDCLogin - Logs in the user coming back from the OpenID Connect provider
+func (c *oidcController) FinishOIDCLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// The attempt can be used only once
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcAttemptCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   c.secureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		c.errorHandler(w, r, &UnauthorizedError{errors.New("OpenID Connect login failed: " + providerError)}, ctx)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		c.errorHandler(w, r, &RequiredError{"code"}, ctx)
+		return
+	}
+
+	var attempt models.OIDCLoginAttempt
+	if cookie, err := r.Cookie(oidcAttemptCookie); err == nil {
+		parts := strings.SplitN(cookie.Value, ".", 2)
+		if len(parts) == 2 {
+			attempt = models.OIDCLoginAttempt{State: parts[0], Nonce: parts[1]}
+		}
+	}
+
+	result, err := c.service.FinishLogin(r.Context(), attempt, query.Get("state"), code, r.UserAgent())
+	// If an error occurred, encode the error with the status code
+	if errors.Is(err, services.ErrOIDCStateMismatch) || errors.Is(err, services.ErrOIDCInvalidIDToken) {
+		c.errorHandler(w, r, &UnauthorizedError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+
+	// The user enabled the two-factor authentication, the session starts once the challenge is verified, like after
+	// the password login
+	if c.postLoginRedirectURL == "" {
+		if result.Session == nil {
+			encodeJSONResponse(LoginChallengeResponse{result.ChallengeToken, result.ChallengeExpiresAt}, http.StatusAccepted, w, ctx)
+			return
+		}
+		encodeJSONResponse(newDoLoginResponse(result.Session), http.StatusOK, w, ctx)
+		return
+	}
+	// The fragment is never sent to servers, so the tokens don't end up in any access log
+	fragment := url.Values{}
+	if result.Session == nil {
+		fragment.Set("challengeToken", result.ChallengeToken)
+		fragment.Set("expiresAt", result.ChallengeExpiresAt.Format(time.RFC3339))
+	} else {
+		fragment.Set("identifier", result.Session.AccessToken)
+		fragment.Set("expiresAt", result.Session.AccessTokenExpiresAt.Format(time.RFC3339))
+		fragment.Set("refreshToken", result.Session.RefreshToken)
+	}
+	http.Redirect(w, r, c.postLoginRedirectURL+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+	"github.com/sirupsen/logrus"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const fakeProviderClientID = "wasa-photo"
+
+// fakeProvider is an in-process OpenID Connect provider issuing ID tokens for a single subject
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// nonce received by the last authorization request
+	nonce string
+
+	// overrides of the claims and of the signing key of the next ID token
+	audience   string
+	issuer     string
+	signingKey *rsa.PrivateKey
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/auth",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &p.key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		signingKey := p.key
+		if p.signingKey != nil {
+			signingKey = p.signingKey
+		}
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.RS256, Key: signingKey},
+			(&jose.SignerOptions{}).WithHeader("kid", "test"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims := map[string]interface{}{
+			"iss":                p.server.URL,
+			"aud":                fakeProviderClientID,
+			"sub":                "subject-1",
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              p.nonce,
+			"preferred_username": "mario",
+		}
+		if p.audience != "" {
+			claims["aud"] = p.audience
+		}
+		if p.issuer != "" {
+			claims["iss"] = p.issuer
+		}
+		idToken, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func newOIDCController(provider *fakeProvider, authService services.AuthService) *oidcController {
+	oidcService := services.NewOIDCService(services.OIDCConfig{
+		IssuerURL:    provider.server.URL,
+		ClientID:     fakeProviderClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/session/oidc/callback",
+	}, authService)
+	oci := NewOIDCController(oidcService, "", false)
+	oc, _ := oci.(*oidcController)
+	return oc
+}
+
+// startOIDCLogin starts a login and returns the attempt cookie and the state sent to the provider
+func startOIDCLogin(t *testing.T, provider *fakeProvider, oc *oidcController) (*http.Cookie, string) {
+	req := httptest.NewRequest(http.MethodGet, "/session/oidc/start", nil)
+	res := httptest.NewRecorder()
+	oc.StartOIDCLogin(res, req, httprouter.Params{}, reqcontext.RequestContext{})
+
+	if http.StatusFound != res.Code {
+		t.Fatal("expected", http.StatusFound, "got:", res.Code, res.Body.String())
+	}
+	location, err := url.Parse(res.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Host != provider.server.Listener.Addr().String() || location.Path != "/auth" {
+		t.Fatal("expected a redirect to the provider got:", location)
+	}
+	provider.nonce = location.Query().Get("nonce")
+
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatal("expected the login attempt cookie got:", cookies)
+	}
+	return cookies[0], location.Query().Get("state")
+}
+
+func finishOIDCLogin(oc *oidcController, cookie *http.Cookie, state string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/session/oidc/callback?code=provider-code&state="+url.QueryEscape(state),
+		nil,
+	)
+	req.AddCookie(cookie)
+	res := httptest.NewRecorder()
+	oc.FinishOIDCLogin(res, req, httprouter.Params{}, reqcontext.RequestContext{})
+	return res
+}
+
+func TestOIDCLogin_CreatesAndLinksUser(t *testing.T) {
+	provider := newFakeProvider(t)
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	oc := newOIDCController(provider, authService)
+
+	for i := 0; i < 2; i++ {
+		cookie, state := startOIDCLogin(t, provider, oc)
+		res := finishOIDCLogin(oc, cookie, state)
+
+		if http.StatusOK != res.Code {
+			t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+		}
+		var response DoLoginResponse
+		if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if session := testSessionByToken(t, repos, response.Identifier); session == nil || session.UserId != 1 {
+			t.Error("expected a session of the user for the returned token got:", session)
+		}
+	}
+
+	users, err := repos.Users.GetUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*users) != 1 || (*users)[0].Username != "mario" {
+		t.Error("expected a single user named after the provider username got:", *users)
+	}
+	userId, err := repos.Auth.GetIdentityUserId(context.Background(), provider.server.URL, "subject-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userId != 1 {
+		t.Error("expected the subject to be linked to the user got:", userId)
+	}
+}
+
+func TestOIDCLogin_TwoFactorChallenge(t *testing.T) {
+	now := time.Now().UTC()
+	pinClock(t, now)
+	provider := newFakeProvider(t)
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	oc := newOIDCController(provider, authService)
+
+	// The user created by the first login enables the two-factor authentication
+	cookie, state := startOIDCLogin(t, provider, oc)
+	if res := finishOIDCLogin(oc, cookie, state); http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	enableTestTwoFactor(t, repos, 1)
+	sessions := testSessions(t, repos)
+
+	cookie, state = startOIDCLogin(t, provider, oc)
+	res := finishOIDCLogin(oc, cookie, state)
+
+	if http.StatusAccepted != res.Code {
+		t.Fatal("expected", http.StatusAccepted, "got:", res.Code, res.Body.String())
+	}
+	if len(testSessions(t, repos)) != len(sessions) {
+		t.Error("expected no session before the challenge is verified got:", testSessions(t, repos))
+	}
+	challenge := LoginChallengeResponse{}
+	if err := json.Unmarshal(res.Body.Bytes(), &challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+	body := `{"challengeToken": "` + challenge.ChallengeToken + `", "code": "` + testTOTPCode(now) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/session/2fa", bytes.NewBufferString(body))
+	res = httptest.NewRecorder()
+	lc.VerifyLoginChallenge(res, req, httprouter.Params{}, reqcontext.RequestContext{Logger: logrus.New()})
+	if http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	var response DoLoginResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if session := testSessionByToken(t, repos, response.Identifier); session == nil || session.UserId != 1 {
+		t.Error("expected a session of the user for the returned token got:", session)
+	}
+}
+
+func TestOIDCLogin_StateMismatch(t *testing.T) {
+	provider := newFakeProvider(t)
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	oc := newOIDCController(provider, authService)
+
+	cookie, _ := startOIDCLogin(t, provider, oc)
+	res := finishOIDCLogin(oc, cookie, "forged")
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+	if sessions := testSessions(t, repos); len(sessions) != 0 {
+		t.Error("expected no session got:", sessions)
+	}
+}
+
+func TestOIDCLogin_NonceMismatch(t *testing.T) {
+	provider := newFakeProvider(t)
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	oc := newOIDCController(provider, authService)
+
+	cookie, state := startOIDCLogin(t, provider, oc)
+	provider.nonce = "replayed"
+	res := finishOIDCLogin(oc, cookie, state)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+	if sessions := testSessions(t, repos); len(sessions) != 0 {
+		t.Error("expected no session got:", sessions)
+	}
+}
+
+func TestOIDCLogin_WrongAudience(t *testing.T) {
+	provider := newFakeProvider(t)
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	oc := newOIDCController(provider, authService)
+
+	cookie, state := startOIDCLogin(t, provider, oc)
+	provider.audience = "another-client"
+	res := finishOIDCLogin(oc, cookie, state)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
+
+func TestOIDCLogin_WrongIssuer(t *testing.T) {
+	provider := newFakeProvider(t)
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	oc := newOIDCController(provider, authService)
+
+	cookie, state := startOIDCLogin(t, provider, oc)
+	provider.issuer = "https://attacker.example.com"
+	res := finishOIDCLogin(oc, cookie, state)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
+
+func TestOIDCLogin_UnknownSigningKey(t *testing.T) {
+	provider := newFakeProvider(t)
+	repos := newTestRepositories(t)
+	authService := newTestAuthService(repos)
+	oc := newOIDCController(provider, authService)
+
+	cookie, state := startOIDCLogin(t, provider, oc)
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.signingKey = signingKey
+	res := finishOIDCLogin(oc, cookie, state)
+
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// The lengths are counted in characters, so that the emojis in a caption count as one
+const (
+	maxPhotoCaptionLength = 2200
+	maxPhotoAltTextLength = 1000
+)
+
+var ErrPhotoCaptionIsNotValid = errors.New("Caption should be at most 2200 characters long")
+var ErrPhotoAltTextIsNotValid = errors.New("Alt text should be at most 1000 characters long")
+var ErrUploadPhotoImageIsZero = errors.New("Image part is missing")
+var ErrUploadPhotoPartIsNotValid = errors.New("Part should be either metadata or image")
+var ErrUploadPhotoMetadataIsNotValid = errors.New("Metadata part should precede the image part and be valid JSON")
+var ErrUpdatePhotoOpIsZero = errors.New("Op is zero value")
+var ErrUpdatePhotoOpIsNotValid = errors.New("Op value is not valid")
+var ErrUpdatePhotoPathIsZero = errors.New("Path is zero value")
+var ErrUpdatePhotoPathIsNotValid = errors.New("Path should be either /caption or /altText")
+var ErrUpdatePhotoValueIsZero = errors.New("Value is zero value")
+
+// UploadPhotoMetadata - The metadata part of a multipart photo upload, sent along with the image part
+type UploadPhotoMetadata struct {
+
+	// Caption shown along with the photo
+	Caption string `json:"caption,omitempty"`
+
+	// Description of the image for the users who can't see it
+	AltText string `json:"altText,omitempty"`
+}
+
+// assertPhotoCaptionValid checks the caption isn't too long, it can be empty
+func assertPhotoCaptionValid(caption string) error {
+	if utf8.RuneCountInString(caption) > maxPhotoCaptionLength {
+		return ErrPhotoCaptionIsNotValid
+	}
+	return nil
+}
+
+// assertPhotoAltTextValid checks the alt text isn't too long, it can be empty
+func assertPhotoAltTextValid(altText string) error {
+	if utf8.RuneCountInString(altText) > maxPhotoAltTextLength {
+		return ErrPhotoAltTextIsNotValid
+	}
+	return nil
+}
+
+// assertUploadPhotoMetadataValid checks the caption and the alt text aren't too long
+func assertUploadPhotoMetadataValid(obj UploadPhotoMetadata) error {
+	if err := assertPhotoCaptionValid(obj.Caption); err != nil {
+		return err
+	}
+	return assertPhotoAltTextValid(obj.AltText)
+}
+
+// UpdatePhotoRequest - A JSON Patch operation on a photo
+type UpdatePhotoRequest struct {
+	// Patch operation type
+	Op string `json:"op,omitempty"`
+
+	// Field to patch
+	Path string `json:"path,omitempty"`
+
+	// New field value, an empty one clears the field
+	Value *string `json:"value,omitempty"`
+}
+
+// assertUpdatePhotoRequestValid checks if the required fields are not zero-ed
+func assertUpdatePhotoRequestValid(obj UpdatePhotoRequest) error {
+	if obj.Op == "" {
+		return ErrUpdatePhotoOpIsZero
+	}
+	if obj.Op != "replace" {
+		return ErrUpdatePhotoOpIsNotValid
+	}
+	if obj.Path == "" {
+		return ErrUpdatePhotoPathIsZero
+	}
+	if obj.Value == nil {
+		return ErrUpdatePhotoValueIsZero
+	}
+
+	switch obj.Path {
+	case "/caption":
+		return assertPhotoCaptionValid(*obj.Value)
+	case "/altText":
+		return assertPhotoAltTextValid(*obj.Value)
+	default:
+		return ErrUpdatePhotoPathIsNotValid
+	}
+}
@@ -1,31 +1,68 @@
 package controllers
 
 import (
+	"encoding/json"
 	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/lucaronca/wasa-homework/service/api/services"
 )
 
+// errUploadTooLarge is returned reading an upload body larger than the maximum size
+var errUploadTooLarge = errors.New("Photo too large")
+
 // photosController binds http requests to an api service and writes the service results to the http response
 type photosController struct {
-	service      services.PhotosService
-	errorHandler ErrorHandler
+	service       services.PhotosService
+	errorHandler  ErrorHandler
+	maxUploadSize int64
+	uploadTimeout time.Duration
 }
 
-// NewPhotosController creates a default api controller
-func NewPhotosController(s services.PhotosService) Controller {
+// NewPhotosController creates a default api controller. The uploaded photos are refused if they're larger than
+// maxUploadSize bytes, their requests have uploadTimeout to be read and answered instead of the server timeouts.
+func NewPhotosController(s services.PhotosService, maxUploadSize int64, uploadTimeout time.Duration) Controller {
 	controller := &photosController{
-		service:      s,
-		errorHandler: errorHandler,
+		service:       s,
+		errorHandler:  errorHandler,
+		maxUploadSize: maxUploadSize,
+		uploadTimeout: uploadTimeout,
 	}
 
 	return controller
 }
 
+// uploadBody reads an upload body, failing with errUploadTooLarge once more than limit bytes are read
+type uploadBody struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func newUploadBody(r io.Reader, limit int64) *uploadBody {
+	return &uploadBody{r: io.LimitReader(r, limit+1), limit: limit}
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, errUploadTooLarge
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
 // Routes returns all the api routes for the BansController
 func (c *photosController) Routes() routes.Routes {
 	return routes.Routes{
@@ -34,20 +71,32 @@ func (c *photosController) Routes() routes.Routes {
 			Method:       http.MethodDelete,
 			Path:         "/photos/:photoId",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosWrite},
 			HandlerFunc:  c.DeletePhoto,
 		},
+		{
+			Name:         "UpdatePhoto",
+			Method:       http.MethodPatch,
+			Path:         "/photos/:photoId",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosWrite},
+			HandlerFunc:  c.UpdatePhoto,
+		},
 		{
 			Name:         "UploadPhoto",
 			Method:       http.MethodPost,
 			Path:         "/photos",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosWrite},
 			HandlerFunc:  c.UploadPhoto,
+			Timeout:      c.uploadTimeout,
 		},
 		{
 			Name:         "GetPhotos",
 			Method:       http.MethodGet,
 			Path:         "/users/:userId/photos",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosRead},
 			HandlerFunc:  c.GetPhotos,
 		},
 		{
@@ -55,8 +104,26 @@ func (c *photosController) Routes() routes.Routes {
 			Method:       http.MethodGet,
 			Path:         "/users/:userId/stream",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosRead},
 			HandlerFunc:  c.GetMyStream,
 		},
+		{
+			// The path can't be /users/me/trash, it would conflict with /users/:userId
+			Name:         "GetTrash",
+			Method:       http.MethodGet,
+			Path:         "/users/:userId/trash",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosRead},
+			HandlerFunc:  c.GetTrash,
+		},
+		{
+			Name:         "RestorePhoto",
+			Method:       http.MethodPost,
+			Path:         "/users/me/trash/:photoId/restore",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopePhotosWrite},
+			HandlerFunc:  c.RestorePhoto,
+		},
 	}
 }
 
@@ -91,7 +158,7 @@ func (c *photosController) GetPhotos(w http.ResponseWriter, r *http.Request, ps
 		c.errorHandler(w, r, &ParsingError{err}, ctx)
 		return
 	}
-	result, err := c.service.GetUserPhotos(ctx.User.Id, parsedIdParam, offsetParam, limitParam)
+	result, err := c.service.GetUserPhotos(r.Context(), ctx.User.Id, parsedIdParam, offsetParam, limitParam)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
@@ -108,13 +175,43 @@ func (c *photosController) GetPhotos(w http.ResponseWriter, r *http.Request, ps
 func (c *photosController) UploadPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	defer r.Body.Close()
 
-	newPhoto, err := c.service.CreatePhoto(ctx.User.Id, r.Body)
+	// The declared length is checked first, so that the body isn't read at all
+	if r.ContentLength > c.maxUploadSize {
+		c.errorHandler(w, r, &PayloadTooLargeError{errUploadTooLarge}, ctx)
+		return
+	}
+
+	// A multipart upload sends the image along with its metadata, the raw body is the image otherwise. The size limit
+	// applies to the whole body in both cases.
+	var image io.Reader = newUploadBody(r.Body, c.maxUploadSize)
+	var description models.PhotoDescription
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/form-data" {
+		var metadata UploadPhotoMetadata
+		image, metadata, err = readMultipartUpload(multipart.NewReader(image, params["boundary"]))
+		if errors.Is(err, errUploadTooLarge) {
+			c.errorHandler(w, r, &PayloadTooLargeError{err}, ctx)
+			return
+		} else if errors.Is(err, ErrUploadPhotoImageIsZero) {
+			c.errorHandler(w, r, &RequiredError{"image"}, ctx)
+			return
+		} else if err != nil {
+			c.errorHandler(w, r, &ParsingError{err}, ctx)
+			return
+		}
+		description = models.PhotoDescription{Caption: metadata.Caption, AltText: metadata.AltText}
+	}
+
+	newPhoto, err := c.service.CreatePhoto(r.Context(), ctx.User.Id, image, description)
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
 		return
 	} else if errors.Is(err, services.ErrPhotoFormatNotSupported) {
 		c.errorHandler(w, r, &ParsingError{err}, ctx)
 		return
+	} else if errors.Is(err, errUploadTooLarge) || errors.Is(err, services.ErrPhotoTooLarge) {
+		c.errorHandler(w, r, &PayloadTooLargeError{err}, ctx)
+		return
 	} else if err != nil {
 		c.errorHandler(w, r, err, ctx)
 		return
@@ -123,6 +220,106 @@ func (c *photosController) UploadPhoto(w http.ResponseWriter, r *http.Request, p
 	encodeJSONResponse(newPhoto, http.StatusCreated, w, ctx)
 }
 
+// readMultipartUpload reads the parts of a multipart upload up to the image one, which is returned unread: the image
+// is streamed like a raw upload body, so the optional metadata part has to precede it.
+func readMultipartUpload(mr *multipart.Reader) (io.Reader, UploadPhotoMetadata, error) {
+	var metadata UploadPhotoMetadata
+	seenMetadata := false
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, metadata, ErrUploadPhotoImageIsZero
+		} else if err != nil {
+			return nil, metadata, err
+		}
+
+		switch part.FormName() {
+		case "image":
+			return part, metadata, nil
+		case "metadata":
+			if seenMetadata {
+				return nil, metadata, ErrUploadPhotoMetadataIsNotValid
+			}
+			seenMetadata = true
+			d := json.NewDecoder(part)
+			d.DisallowUnknownFields()
+			if err := d.Decode(&metadata); errors.Is(err, errUploadTooLarge) {
+				return nil, metadata, err
+			} else if err != nil {
+				return nil, metadata, ErrUploadPhotoMetadataIsNotValid
+			}
+			if err := assertUploadPhotoMetadataValid(metadata); err != nil {
+				return nil, metadata, err
+			}
+		default:
+			return nil, metadata, ErrUploadPhotoPartIsNotValid
+		}
+	}
+}
+
+// UpdatePhoto - Replace the caption or the alt text of a photo of the user
+func (c *photosController) UpdatePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	photoIdParam, err := parseIntParameter(ps.ByName("photoId"), true)
+	if err != nil {
+		c.errorHandler(w, r, &ParsingError{err}, ctx)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json-patch+json" {
+		c.errorHandler(w, r, &ParsingError{errors.New("Wrong `Content-Type` header")}, ctx)
+		return
+	}
+
+	updatePhotoRequestParam := []UpdatePhotoRequest{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&updatePhotoRequestParam); err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("Payload not valid")}, ctx)
+		return
+	}
+
+	// The caption and the alt text can be replaced at once, the last operation on a path wins
+	if len(updatePhotoRequestParam) == 0 || len(updatePhotoRequestParam) > 2 {
+		c.errorHandler(w, r, &ParsingError{errors.New("Wrong patch length")}, ctx)
+		return
+	}
+	var caption, altText *string
+	for _, operation := range updatePhotoRequestParam {
+		if err := assertUpdatePhotoRequestValid(operation); err != nil {
+			switch {
+			case errors.Is(err, ErrUpdatePhotoOpIsZero):
+				c.errorHandler(w, r, &RequiredError{"op"}, ctx)
+			case errors.Is(err, ErrUpdatePhotoPathIsZero):
+				c.errorHandler(w, r, &RequiredError{"path"}, ctx)
+			case errors.Is(err, ErrUpdatePhotoValueIsZero):
+				c.errorHandler(w, r, &RequiredError{"value"}, ctx)
+			default:
+				c.errorHandler(w, r, &ParsingError{err}, ctx)
+			}
+			return
+		}
+		if operation.Path == "/caption" {
+			caption = operation.Value
+		} else {
+			altText = operation.Value
+		}
+	}
+
+	result, err := c.service.UpdatePhotoDescription(r.Context(), ctx.User.Id, photoIdParam, caption, altText)
+	if errors.Is(err, services.ErrNoPhoto) {
+		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
+		return
+	} else if errors.Is(err, services.ErrUserForbidden) {
+		c.errorHandler(w, r, &ForbiddenError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(result, http.StatusOK, w, ctx)
+}
+
 // DeletePhoto - Delete a photo
 func (c *photosController) DeletePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	targetPhotoIdParam, err := parseIntParameter(ps.ByName("photoId"), true)
@@ -131,7 +328,7 @@ func (c *photosController) DeletePhoto(w http.ResponseWriter, r *http.Request, p
 		return
 	}
 
-	err = c.service.DeletePhoto(ctx.User.Id, targetPhotoIdParam)
+	err = c.service.DeletePhoto(r.Context(), ctx.User.Id, targetPhotoIdParam)
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
 		return
@@ -172,7 +369,7 @@ func (c *photosController) GetMyStream(w http.ResponseWriter, r *http.Request, p
 		c.errorHandler(w, r, &ParsingError{err}, ctx)
 		return
 	}
-	result, err := c.service.GetStream(ctx.User.Id, offsetParam, limitParam)
+	result, err := c.service.GetStream(r.Context(), ctx.User.Id, offsetParam, limitParam)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
@@ -184,3 +381,48 @@ func (c *photosController) GetMyStream(w http.ResponseWriter, r *http.Request, p
 	// If no error, encode the result and the result code
 	encodeJSONResponse(result, http.StatusOK, w, ctx)
 }
+
+// GetTrash - Get the photos of the user in the trash
+func (c *photosController) GetTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userIdParam := ps.ByName("userId")
+	if userIdParam != "me" {
+		parsed, err := parseIntParameter(userIdParam, true)
+		if err != nil {
+			c.errorHandler(w, r, &ParsingError{errors.New("userId should be a valid int number")}, ctx)
+			return
+		}
+		if parsed != ctx.User.Id {
+			c.errorHandler(w, r, &ForbiddenError{errors.New("Can't get the trash of another user")}, ctx)
+			return
+		}
+	}
+
+	result, err := c.service.GetTrash(r.Context(), ctx.User.Id)
+	if errors.Is(err, services.ErrNoUser) {
+		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(result, http.StatusOK, w, ctx)
+}
+
+// RestorePhoto - Move a photo of the user out of the trash
+func (c *photosController) RestorePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	photoIdParam, err := parseIntParameter(ps.ByName("photoId"), true)
+	if err != nil {
+		c.errorHandler(w, r, &ParsingError{err}, ctx)
+		return
+	}
+
+	photo, err := c.service.RestorePhoto(r.Context(), ctx.User.Id, photoIdParam)
+	if errors.Is(err, services.ErrNoPhoto) {
+		c.errorHandler(w, r, &NotFoundError{"Photo"}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(photo, http.StatusOK, w, ctx)
+}
@@ -0,0 +1,636 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/api/repositories/memrepo"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+	"github.com/lucaronca/wasa-homework/service/photofiles"
+)
+
+// testTrashRetention is how long the deleted photos stay in the trash
+const testTrashRetention = 24 * time.Hour
+
+// testVariantWidths are the widths the uploaded photos are resized to
+var testVariantWidths = []int{16, 64}
+
+const (
+	// testMaxUploadSize is the maximum size of the uploaded photos, in bytes
+	testMaxUploadSize = 4096
+	// testMaxPixels is the maximum number of pixels of the uploaded photos
+	testMaxPixels = 100 * 100
+	// testMaxProcessing is how many photos are decoded at once
+	testMaxProcessing = 2
+)
+
+// newTestPhotosController returns the controller of the photos of Mario and Luigi, Mario has a photo and its asset in
+// photosDirectory
+func newTestPhotosController(t *testing.T, photosDirectory string) (*photosController, services.PhotosService, *repositories.Repositories) {
+	t.Helper()
+	repos := newTestRepositories(t, "Mario", "Luigi")
+	if _, err := repos.Photos.SetPhoto(context.Background(), "/assets/photos/a.png", 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(photosDirectory, "a.png"), []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestPhotosService(repos, photosDirectory, true)
+	pci := NewPhotosController(s, testMaxUploadSize, time.Minute)
+	pc, _ := pci.(*photosController)
+	return pc, s, repos
+}
+
+// newTestPhotosService returns the photos service storing the photos in photosDirectory
+func newTestPhotosService(repos *repositories.Repositories, photosDirectory string, keepTakenAt bool) services.PhotosService {
+	uow, _ := memrepo.NewUnitOfWork(repos)
+	return services.NewPhotosService(
+		photosDirectory,
+		"/assets/photos",
+		testTrashRetention,
+		testVariantWidths,
+		keepTakenAt,
+		testMaxPixels,
+		testMaxProcessing,
+		uow,
+		repos.Users,
+		repos.Bans,
+		repos.Photos,
+		repos.Likes,
+		repos.Comments,
+		repos.Follows,
+	)
+}
+
+func servePhotosRequest(
+	t *testing.T,
+	handle func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext),
+	method string,
+	ps httprouter.Params,
+	userId int,
+) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(method, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	handle(res, req, ps, reqcontext.RequestContext{User: reqcontext.User{Id: userId}})
+	return res
+}
+
+// testPNG encodes a PNG image of the given size, filled with noise
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadPhoto_CreatesVariants(t *testing.T) {
+	photosDirectory := t.TempDir()
+	pc, s, _ := newTestPhotosController(t, photosDirectory)
+
+	req, err := http.NewRequest(http.MethodPost, "/users/me/photos", bytes.NewReader(testPNG(t, 40, 20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	pc.UploadPhoto(res, req, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+	if http.StatusCreated != res.Code {
+		t.Fatal("expected", http.StatusCreated, "got:", res.Code, res.Body.String())
+	}
+	var photo models.Photo
+	if err := json.Unmarshal(res.Body.Bytes(), &photo); err != nil {
+		t.Fatal(err)
+	}
+
+	// The photo is narrower than the widest variant, so it's not scaled up
+	variant, ok := photo.Variants["16"]
+	if len(photo.Variants) != 1 || !ok || variant.Width != 16 || variant.Height != 8 {
+		t.Fatal("expected a 16x8 variant got:", photo.Variants)
+	}
+	file, err := os.Open(filepath.Join(photosDirectory, filepath.Base(variant.Url)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(file)
+	_ = file.Close()
+	if err != nil || format != "png" || config.Width != 16 || config.Height != 8 {
+		t.Error("expected the variant file to be a 16x8 png got:", format, config.Width, config.Height, err)
+	}
+
+	// The variants are purged along with the photo
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+	if err := s.DeletePhoto(context.Background(), 1, photo.Id); err != nil {
+		t.Fatal(err)
+	}
+	globaltime.FixedTime = time.Now().Add(2 * testTrashRetention)
+	if _, err := s.PurgeTrash(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{photo.Url, variant.Url} {
+		for _, name := range []string{filepath.Base(url), photofiles.Trashed(filepath.Base(url))} {
+			if _, err := os.Stat(filepath.Join(photosDirectory, name)); !os.IsNotExist(err) {
+				t.Error("expected", name, "to be removed got:", err)
+			}
+		}
+	}
+}
+
+func TestUploadPhoto_NotDecodable(t *testing.T) {
+	photosDirectory := t.TempDir()
+	pc, _, _ := newTestPhotosController(t, photosDirectory)
+
+	// A PNG signature followed by garbage
+	body := append(testPNG(t, 40, 20)[:16], bytes.Repeat([]byte{0xff}, 600)...)
+	req, err := http.NewRequest(http.MethodPost, "/users/me/photos", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	pc.UploadPhoto(res, req, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+	if http.StatusBadRequest != res.Code {
+		t.Error("expected", http.StatusBadRequest, "got:", res.Code, res.Body.String())
+	}
+	// The photo resource is rolled back by the unit of work, the mock only runs the work
+	if entries, _ := os.ReadDir(photosDirectory); len(entries) != 1 {
+		t.Error("expected the uploaded file to be removed got:", entries)
+	}
+}
+
+// serveUpload uploads body as the photo of Mario, with its length undeclared if chunked
+func serveUpload(t *testing.T, pc *photosController, body []byte, chunked bool) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "/photos", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunked {
+		req.ContentLength = -1
+	}
+	res := httptest.NewRecorder()
+	pc.UploadPhoto(res, req, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+	return res
+}
+
+func TestUploadPhoto_Limits(t *testing.T) {
+	photosDirectory := t.TempDir()
+	pc, _, _ := newTestPhotosController(t, photosDirectory)
+
+	// A solid image compresses well, it has too many pixels but it's small
+	var solid bytes.Buffer
+	if err := png.Encode(&solid, image.NewGray(image.Rect(0, 0, 101, 100))); err != nil {
+		t.Fatal(err)
+	}
+	noise := testPNG(t, 64, 64)
+	if len(noise) <= testMaxUploadSize || solid.Len() > testMaxUploadSize {
+		t.Fatal("expected the noise to be larger than the maximum size and the solid image to be smaller")
+	}
+	for _, test := range []struct {
+		name     string
+		body     []byte
+		chunked  bool
+		expected int
+	}{
+		{"too large", noise, false, http.StatusRequestEntityTooLarge},
+		{"too large, chunked", noise, true, http.StatusRequestEntityTooLarge},
+		{"too many pixels", solid.Bytes(), false, http.StatusRequestEntityTooLarge},
+		{"truncated", testPNG(t, 40, 20)[:1000], false, http.StatusBadRequest},
+		{"empty", nil, false, http.StatusBadRequest},
+	} {
+		if res := serveUpload(t, pc, test.body, test.chunked); test.expected != res.Code {
+			t.Error(test.name, "expected", test.expected, "got:", res.Code, res.Body.String())
+		}
+		// The partial files are removed
+		if entries, _ := os.ReadDir(photosDirectory); len(entries) != 1 {
+			t.Error(test.name, "expected the uploaded files to be removed got:", entries)
+		}
+	}
+
+	// The photos shorter than the sniffed header are read whole
+	var small bytes.Buffer
+	if err := png.Encode(&small, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	if small.Len() >= 512 {
+		t.Fatal("expected a photo shorter than 512 bytes got:", small.Len())
+	}
+	if res := serveUpload(t, pc, small.Bytes(), true); http.StatusCreated != res.Code {
+		t.Error("expected", http.StatusCreated, "got:", res.Code, res.Body.String())
+	}
+}
+
+// testMultipartPart is a part of a multipart upload
+type testMultipartPart struct {
+	name    string
+	content []byte
+}
+
+// serveMultipartUpload uploads parts as a multipart form, in order, as the photo of Mario
+func serveMultipartUpload(t *testing.T, pc *photosController, parts ...testMultipartPart) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range parts {
+		pw, err := mw.CreateFormFile(part.name, part.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pw.Write(part.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "/photos", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	pc.UploadPhoto(res, req, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+	return res
+}
+
+func TestUploadPhoto_Multipart(t *testing.T) {
+	photosDirectory := t.TempDir()
+	pc, _, repos := newTestPhotosController(t, photosDirectory)
+	photo := testPNG(t, 20, 10)
+	metadata := []byte(`{"caption": "Sunset on the lake 🌅", "altText": "An orange sun over the water"}`)
+
+	res := serveMultipartUpload(t, pc, testMultipartPart{"metadata", metadata}, testMultipartPart{"image", photo})
+	if http.StatusCreated != res.Code {
+		t.Fatal("expected", http.StatusCreated, "got:", res.Code, res.Body.String())
+	}
+	var created models.Photo
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	expected := models.PhotoDescription{Caption: "Sunset on the lake 🌅", AltText: "An orange sun over the water"}
+	if created.PhotoDescription != expected || created.Width != 20 || created.Height != 10 {
+		t.Error("expected the described 20x10 photo got:", res.Body.String())
+	}
+	if stored, _ := repos.Photos.GetPhotoById(context.Background(), created.Id); stored == nil ||
+		stored.PhotoDescription != expected {
+		t.Error("expected the description to be stored got:", stored)
+	}
+
+	// The metadata part is optional
+	res = serveMultipartUpload(t, pc, testMultipartPart{"image", photo})
+	if http.StatusCreated != res.Code || !strings.Contains(res.Body.String(), `"caption":""`) {
+		t.Error("expected", http.StatusCreated, "and an empty caption got:", res.Code, res.Body.String())
+	}
+
+	uploaded, _ := os.ReadDir(photosDirectory)
+	for _, test := range []struct {
+		name     string
+		parts    []testMultipartPart
+		expected int
+	}{
+		{"no image", []testMultipartPart{{"metadata", metadata}}, http.StatusBadRequest},
+		{"unknown part", []testMultipartPart{{"thumbnail", photo}, {"image", photo}}, http.StatusBadRequest},
+		{"unknown field", []testMultipartPart{{"metadata", []byte(`{"title": "Sunset"}`)}, {"image", photo}}, http.StatusBadRequest},
+		{"not JSON", []testMultipartPart{{"metadata", []byte("Sunset")}, {"image", photo}}, http.StatusBadRequest},
+		{
+			"caption too long",
+			[]testMultipartPart{{"metadata", []byte(`{"caption": "` + strings.Repeat("a", 2201) + `"}`)}, {"image", photo}},
+			http.StatusBadRequest,
+		},
+		{"too large", []testMultipartPart{{"image", testPNG(t, 64, 64)}}, http.StatusRequestEntityTooLarge},
+	} {
+		if res := serveMultipartUpload(t, pc, test.parts...); test.expected != res.Code {
+			t.Error(test.name, "expected", test.expected, "got:", res.Code, res.Body.String())
+		}
+		if entries, _ := os.ReadDir(photosDirectory); len(entries) != len(uploaded) {
+			t.Error(test.name, "expected no photo to be written got:", entries)
+		}
+	}
+}
+
+func TestUploadPhoto_StripsMetadata(t *testing.T) {
+	// The fixture is a 16x8 JPEG with orientation 6, GPS tags and the date it was taken at 19:32:28 +02:00
+	fixture, err := os.ReadFile("../../imaging/testdata/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	takenAt := time.Date(2022, 7, 21, 17, 32, 28, 0, time.UTC)
+
+	for _, keepTakenAt := range []bool{false, true} {
+		photosDirectory := t.TempDir()
+		_, _, repos := newTestPhotosController(t, photosDirectory)
+		pc, _ := NewPhotosController(newTestPhotosService(repos, photosDirectory, keepTakenAt), testMaxUploadSize, time.Minute).(*photosController)
+
+		req, err := http.NewRequest(http.MethodPost, "/users/me/photos", bytes.NewReader(fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := httptest.NewRecorder()
+		pc.UploadPhoto(res, req, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+		if http.StatusCreated != res.Code {
+			t.Fatal("expected", http.StatusCreated, "got:", res.Code, res.Body.String())
+		}
+		var photo models.Photo
+		if err := json.Unmarshal(res.Body.Bytes(), &photo); err != nil {
+			t.Fatal(err)
+		}
+		if keepTakenAt && (photo.TakenAt == nil || !photo.TakenAt.Equal(takenAt)) {
+			t.Error("expected the photo to be taken at", takenAt, "got:", photo.TakenAt)
+		}
+		if !keepTakenAt && (photo.TakenAt != nil || bytes.Contains(res.Body.Bytes(), []byte("takenAt"))) {
+			t.Error("expected the date the photo was taken to be dropped got:", res.Body.String())
+		}
+
+		stored, err := os.ReadFile(filepath.Join(photosDirectory, filepath.Base(photo.Url)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, leak := range []string{"Exif", "GPS", "xmpmeta"} {
+			if bytes.Contains(stored, []byte(leak)) {
+				t.Error("expected the stored photo to be stripped of", leak)
+			}
+		}
+		// The orientation is applied to the pixels
+		config, format, err := image.DecodeConfig(bytes.NewReader(stored))
+		if err != nil || format != "jpeg" || config.Width != 8 || config.Height != 16 {
+			t.Error("expected the stored photo to be a 8x16 jpeg got:", format, config.Width, config.Height, err)
+		}
+		// The image is described as it's stored, a portrait BlurHash has 3x4 components
+		if photo.Width != 8 || photo.Height != 16 || photo.Size != int64(len(stored)) || photo.MimeType != "image/jpeg" ||
+			len(photo.BlurHash) != 28 || photo.BlurHash[0] != 'T' {
+			t.Error("expected the photo to be described as the stored image got:", photo.PhotoImage)
+		}
+	}
+}
+
+func TestBackfillPhotoImages(t *testing.T) {
+	photosDirectory := t.TempDir()
+	_, s, repos := newTestPhotosController(t, photosDirectory)
+	// The photo of Mario, a.png, isn't an image. The other one is uploaded with its metadata, orientation 6 included.
+	fixture, err := os.ReadFile("../../imaging/testdata/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(photosDirectory, "b.jpeg"), fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+	photoId, err := repos.Photos.SetPhoto(context.Background(), "/assets/photos/b.jpeg", 2, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for run, expected := range []int{1, 0} {
+		described, skipped, err := s.BackfillPhotoImages(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if described != expected || len(skipped) != 1 || skipped[1] == nil {
+			t.Error("expected", expected, "photos described and a.png skipped at run", run, "got:", described, skipped)
+		}
+	}
+
+	photo, err := repos.Photos.GetPhotoById(context.Background(), photoId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Width != 8 || photo.Height != 16 || photo.Size != int64(len(fixture)) || photo.MimeType != "image/jpeg" ||
+		photo.BlurHash == "" {
+		t.Error("expected the photo to be described as it's shown got:", photo.PhotoImage)
+	}
+}
+
+func TestDeletePhoto_MovesToTrash(t *testing.T) {
+	photosDirectory := t.TempDir()
+	pc, _, repos := newTestPhotosController(t, photosDirectory)
+	photoParam := httprouter.Params{{Key: "photoId", Value: "1"}}
+
+	if res := servePhotosRequest(t, pc.DeletePhoto, http.MethodDelete, photoParam, 1); http.StatusNoContent != res.Code {
+		t.Fatal("expected", http.StatusNoContent, "got:", res.Code, res.Body.String())
+	}
+	if count, _ := repos.Photos.GetPhotosCount(context.Background()); count != 0 {
+		t.Error("expected the photo to be hidden got:", count)
+	}
+	if _, err := os.Stat(filepath.Join(photosDirectory, photofiles.Trashed("a.png"))); err != nil {
+		t.Error("expected the asset to be kept got:", err)
+	}
+	// The asset of the photo in the trash isn't served
+	files := http.FileServer(photofiles.FileSystem(photosDirectory))
+	for _, name := range []string{"a.png", photofiles.Trashed("a.png")} {
+		res := httptest.NewRecorder()
+		files.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+		if http.StatusNotFound != res.Code {
+			t.Error("expected", http.StatusNotFound, "serving", name, "got:", res.Code)
+		}
+	}
+
+	res := servePhotosRequest(t, pc.GetTrash, http.MethodGet, httprouter.Params{{Key: "userId", Value: "me"}}, 1)
+	if http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	var trash []models.Photo
+	if err := json.Unmarshal(res.Body.Bytes(), &trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].DeletedAt == nil || trash[0].PurgeAt == nil {
+		t.Fatal("expected the deleted photo in the trash got:", res.Body.String())
+	}
+	if purgeAfter := trash[0].PurgeAt.Sub(*trash[0].DeletedAt); purgeAfter != testTrashRetention {
+		t.Error("expected the photo to be purged after the retention period got:", purgeAfter)
+	}
+
+	res = servePhotosRequest(t, pc.RestorePhoto, http.MethodPost, photoParam, 1)
+	if http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	if photo, _ := repos.Photos.GetPhotoById(context.Background(), 1); photo == nil {
+		t.Error("expected the photo to be restored")
+	}
+	res = httptest.NewRecorder()
+	files.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/a.png", nil))
+	if http.StatusOK != res.Code || res.Body.String() != "photo" {
+		t.Error("expected the asset of the restored photo to be served got:", res.Code, res.Body.String())
+	}
+	if res := servePhotosRequest(t, pc.RestorePhoto, http.MethodPost, photoParam, 1); http.StatusNotFound != res.Code {
+		t.Error("expected", http.StatusNotFound, "restoring a photo out of the trash got:", res.Code)
+	}
+}
+
+func TestGetTrash_OtherUser(t *testing.T) {
+	pc, _, _ := newTestPhotosController(t, t.TempDir())
+
+	res := servePhotosRequest(t, pc.GetTrash, http.MethodGet, httprouter.Params{{Key: "userId", Value: "1"}}, 2)
+	if http.StatusForbidden != res.Code {
+		t.Error("expected", http.StatusForbidden, "got:", res.Code)
+	}
+}
+
+func TestRestorePhoto_OtherUser(t *testing.T) {
+	pc, _, repos := newTestPhotosController(t, t.TempDir())
+	photoParam := httprouter.Params{{Key: "photoId", Value: "1"}}
+	if res := servePhotosRequest(t, pc.DeletePhoto, http.MethodDelete, photoParam, 1); http.StatusNoContent != res.Code {
+		t.Fatal("expected", http.StatusNoContent, "got:", res.Code)
+	}
+
+	// The photos in the trash of the other users aren't disclosed
+	if res := servePhotosRequest(t, pc.RestorePhoto, http.MethodPost, photoParam, 2); http.StatusNotFound != res.Code {
+		t.Error("expected", http.StatusNotFound, "got:", res.Code)
+	}
+	if photo, _ := repos.Photos.GetPhotoById(context.Background(), 1); photo != nil {
+		t.Error("expected the photo to stay in the trash got:", photo)
+	}
+}
+
+// serveUpdatePhoto patches the photo with photoId as the user with userId
+func serveUpdatePhoto(t *testing.T, pc *photosController, photoId string, contentType, body string, userId int) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPatch, "/photos/"+photoId, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	res := httptest.NewRecorder()
+	pc.UpdatePhoto(res, req, httprouter.Params{{Key: "photoId", Value: photoId}}, reqcontext.RequestContext{User: reqcontext.User{Id: userId}})
+	return res
+}
+
+func TestUpdatePhoto(t *testing.T) {
+	pc, _, repos := newTestPhotosController(t, t.TempDir())
+	const patch = "application/json-patch+json"
+	if err := repos.Likes.SetLike(context.Background(), 1, 2, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	res := serveUpdatePhoto(t, pc, "1", patch, `[
+		{"op": "replace", "path": "/caption", "value": "Sunset on the lake"},
+		{"op": "replace", "path": "/altText", "value": "An orange sun over the water"}
+	]`, 1)
+	if http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	var updated models.Photo
+	if err := json.Unmarshal(res.Body.Bytes(), &updated); err != nil {
+		t.Fatal(err)
+	}
+	expected := models.PhotoDescription{Caption: "Sunset on the lake", AltText: "An orange sun over the water"}
+	if updated.PhotoDescription != expected || updated.TotalLikes != 1 || updated.Owner.Username != "Mario" {
+		t.Error("expected the described photo along with its totals got:", res.Body.String())
+	}
+
+	// The fields left out of the patch are kept, an empty value clears a field
+	res = serveUpdatePhoto(t, pc, "1", patch, `[{"op": "replace", "path": "/caption", "value": ""}]`, 1)
+	if http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	expected.Caption = ""
+	if photo, _ := repos.Photos.GetPhotoById(context.Background(), 1); photo == nil || photo.PhotoDescription != expected {
+		t.Error("expected only the caption to be cleared got:", photo)
+	}
+
+	for _, test := range []struct {
+		name        string
+		photoId     string
+		contentType string
+		body        string
+		userId      int
+		expected    int
+	}{
+		{"other user", "1", patch, `[{"op": "replace", "path": "/caption", "value": "Mine"}]`, 2, http.StatusForbidden},
+		{"no photo", "2", patch, `[{"op": "replace", "path": "/caption", "value": "Mine"}]`, 1, http.StatusNotFound},
+		{"wrong content type", "1", "application/json", `[{"op": "replace", "path": "/caption", "value": "A"}]`, 1, http.StatusBadRequest},
+		{"empty patch", "1", patch, `[]`, 1, http.StatusBadRequest},
+		{"wrong op", "1", patch, `[{"op": "remove", "path": "/caption"}]`, 1, http.StatusBadRequest},
+		{"wrong path", "1", patch, `[{"op": "replace", "path": "/url", "value": "/a.png"}]`, 1, http.StatusBadRequest},
+		{"no value", "1", patch, `[{"op": "replace", "path": "/caption"}]`, 1, http.StatusBadRequest},
+		{
+			"alt text too long",
+			"1",
+			patch,
+			`[{"op": "replace", "path": "/altText", "value": "` + strings.Repeat("a", 1001) + `"}]`,
+			1,
+			http.StatusBadRequest,
+		},
+	} {
+		if res := serveUpdatePhoto(t, pc, test.photoId, test.contentType, test.body, test.userId); test.expected != res.Code {
+			t.Error(test.name, "expected", test.expected, "got:", res.Code, res.Body.String())
+		}
+	}
+	if photo, _ := repos.Photos.GetPhotoById(context.Background(), 1); photo == nil || photo.PhotoDescription != expected {
+		t.Error("expected the refused patches to change nothing got:", photo)
+	}
+}
+
+func TestPurgeTrash_AfterRetention(t *testing.T) {
+	photosDirectory := t.TempDir()
+	pc, s, repos := newTestPhotosController(t, photosDirectory)
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+	deletedAt := time.Now()
+	globaltime.FixedTime = deletedAt
+	if res := servePhotosRequest(t, pc.DeletePhoto, http.MethodDelete, httprouter.Params{{Key: "photoId", Value: "1"}}, 1); http.StatusNoContent != res.Code {
+		t.Fatal("expected", http.StatusNoContent, "got:", res.Code)
+	}
+
+	for _, elapsed := range []time.Duration{testTrashRetention - time.Minute, testTrashRetention + time.Minute} {
+		globaltime.FixedTime = deletedAt.Add(elapsed)
+		purged, err := s.PurgeTrash(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 0
+		if elapsed > testTrashRetention {
+			expected = 1
+		}
+		if purged != expected {
+			t.Error("expected "+strconv.Itoa(expected)+" photos purged after", elapsed, "got:", purged)
+		}
+	}
+
+	if trash, _ := repos.Photos.GetTrashedPhotos(context.Background()); len(*trash) != 0 {
+		t.Error("expected the trash to be empty got:", *trash)
+	}
+	if _, err := os.Stat(filepath.Join(photosDirectory, photofiles.Trashed("a.png"))); !os.IsNotExist(err) {
+		t.Error("expected the asset to be removed got:", err)
+	}
+}
+
+func TestPurgeTrash_HidesTrashedFiles(t *testing.T) {
+	photosDirectory := t.TempDir()
+	_, s, repos := newTestPhotosController(t, photosDirectory)
+	// A photo trashed before the files of the photos in the trash were renamed
+	if err := repos.Photos.TrashPhoto(context.Background(), 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if purged, err := s.PurgeTrash(context.Background()); err != nil || purged != 0 {
+		t.Fatal("expected no photo purged got:", purged, err)
+	}
+	if _, err := os.Stat(filepath.Join(photosDirectory, "a.png")); !os.IsNotExist(err) {
+		t.Error("expected the asset to be renamed got:", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(photosDirectory, photofiles.Trashed("a.png"))); err != nil || string(content) != "photo" {
+		t.Error("expected the asset to be kept got:", string(content), err)
+	}
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+)
+
+// CreatePersonalAccessTokenRequest - Name, scopes and optional expiration of a new personal access token
+type CreatePersonalAccessTokenRequest struct {
+
+	// Token name
+	Name string `json:"name,omitempty"`
+
+	// Scopes granted to the token
+	Scopes []models.Scope `json:"scopes,omitempty"`
+
+	// Token expiration date, the token doesn't expire if it's missing
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+var ErrTokenNameIsZero = errors.New("Token name is zero value")
+var ErrTokenNameIsNotValid = errors.New("Token name should be at most 64 characters long")
+var ErrTokenScopesIsZero = errors.New("Token scopes is zero value")
+var ErrTokenScopeIsNotValid = errors.New("Scope is not valid")
+var ErrTokenExpiresAtIsNotValid = errors.New("Expiration date should be in the future")
+
+// parseScopesParameter checks every scope can be granted to a personal access token and removes the duplicates
+func parseScopesParameter(param []models.Scope) ([]models.Scope, error) {
+	scopes := make([]models.Scope, 0, len(param))
+	seen := make(map[models.Scope]bool)
+	for _, scope := range param {
+		valid := false
+		for _, grantable := range models.PersonalAccessTokenScopes {
+			if scope == grantable {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %q", ErrTokenScopeIsNotValid, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// assertCreatePersonalAccessTokenRequestValid checks if the required fields are not zero-ed
+func assertCreatePersonalAccessTokenRequestValid(obj CreatePersonalAccessTokenRequest) error {
+	if obj.Name == "" {
+		return ErrTokenNameIsZero
+	}
+	if len(obj.Name) > 64 {
+		return ErrTokenNameIsNotValid
+	}
+	if len(obj.Scopes) == 0 {
+		return ErrTokenScopesIsZero
+	}
+	if obj.ExpiresAt != nil && !obj.ExpiresAt.After(globaltime.Now()) {
+		return ErrTokenExpiresAtIsNotValid
+	}
+	return nil
+}
+
+// CreatePersonalAccessTokenResponse - The created personal access token, including its value
+type CreatePersonalAccessTokenResponse struct {
+	models.PersonalAccessToken
+
+	// Token value, it can't be retrieved again
+	Token string `json:"token"`
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/routes"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+)
+
+// tokensController binds http requests to an api service and writes the service results to the http response
+type tokensController struct {
+	service      services.AuthService
+	errorHandler ErrorHandler
+}
+
+// NewTokensController creates a default api controller
+func NewTokensController(s services.AuthService) Controller {
+	controller := &tokensController{
+		service:      s,
+		errorHandler: errorHandler,
+	}
+
+	return controller
+}
+
+// Routes returns all the api routes for the tokensController
+func (c *tokensController) Routes() routes.Routes {
+	return routes.Routes{
+		{
+			Name:         "CreatePersonalAccessToken",
+			Method:       http.MethodPost,
+			Path:         "/users/me/tokens",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.CreatePersonalAccessToken,
+		},
+		{
+			// The path can't be /users/me/tokens, it would conflict with /users/:userId
+			Name:         "GetPersonalAccessTokens",
+			Method:       http.MethodGet,
+			Path:         "/users/:userId/tokens",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.GetPersonalAccessTokens,
+		},
+		{
+			Name:         "RevokePersonalAccessToken",
+			Method:       http.MethodDelete,
+			Path:         "/users/me/tokens/:tokenId",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.RevokePersonalAccessToken,
+		},
+	}
+}
+
+// CreatePersonalAccessToken - Create a personal access token for the user
+func (c *tokensController) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	createRequestParam := CreatePersonalAccessTokenRequest{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&createRequestParam); err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("Payload not valid")}, ctx)
+		return
+	}
+
+	if err := assertCreatePersonalAccessTokenRequestValid(createRequestParam); err != nil {
+		switch {
+		case errors.Is(err, ErrTokenNameIsZero):
+			c.errorHandler(w, r, &RequiredError{"name"}, ctx)
+		case errors.Is(err, ErrTokenScopesIsZero):
+			c.errorHandler(w, r, &RequiredError{"scopes"}, ctx)
+		default:
+			c.errorHandler(w, r, &ParsingError{err}, ctx)
+		}
+		return
+	}
+	scopes, err := parseScopesParameter(createRequestParam.Scopes)
+	if err != nil {
+		c.errorHandler(w, r, &ParsingError{err}, ctx)
+		return
+	}
+
+	token, value, err := c.service.CreatePersonalAccessToken(
+		r.Context(),
+		ctx.User.Id,
+		createRequestParam.Name,
+		scopes,
+		createRequestParam.ExpiresAt,
+	)
+	if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(CreatePersonalAccessTokenResponse{*token, value}, http.StatusCreated, w, ctx)
+}
+
+// GetPersonalAccessTokens - Get the personal access tokens of the user
+func (c *tokensController) GetPersonalAccessTokens(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userIdParam := ps.ByName("userId")
+	if userIdParam != "me" {
+		parsed, err := parseIntParameter(userIdParam, true)
+		if err != nil {
+			c.errorHandler(w, r, &ParsingError{errors.New("userId should be a valid int number")}, ctx)
+			return
+		}
+		if parsed != ctx.User.Id {
+			c.errorHandler(w, r, &ForbiddenError{errors.New("Can't get the tokens of another user")}, ctx)
+			return
+		}
+	}
+
+	result, err := c.service.GetPersonalAccessTokens(r.Context(), ctx.User.Id)
+	if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(result, http.StatusOK, w, ctx)
+}
+
+// RevokePersonalAccessToken - Revoke a personal access token of the user
+func (c *tokensController) RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	tokenIdParam, err := parseIntParameter(ps.ByName("tokenId"), true)
+	if err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("tokenId should be a valid int number")}, ctx)
+		return
+	}
+
+	err = c.service.RevokePersonalAccessToken(r.Context(), ctx.User.Id, tokenIdParam)
+	if errors.Is(err, services.ErrNoPersonalAccessToken) {
+		c.errorHandler(w, r, &NotFoundError{"Personal access token"}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+)
+
+func TestCreatePersonalAccessToken_CreatesScopedToken(t *testing.T) {
+	var jsonStr = []byte(`{"name": "backup script", "scopes": ["photos:read", "photos:read"]}`)
+	req, err := http.NewRequest(http.MethodPost, "/users/me/tokens", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario")
+	authService := newTestAuthService(repos)
+	tci := NewTokensController(authService)
+	tc, _ := tci.(*tokensController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc.CreatePersonalAccessToken(w, r, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusCreated != res.Code {
+		t.Fatal("expected", http.StatusCreated, "got:", res.Code, res.Body.String())
+	}
+
+	var response CreatePersonalAccessTokenResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(response.Token, "wasa_pat_") {
+		t.Error("expected a personal access token got:", response.Token)
+	}
+	token, err := repos.Auth.GetPersonalAccessToken(context.Background(), repos.Auth.FilterByTokenHash(testTokenHash(response.Token)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == nil {
+		t.Fatal("expected only the hash of the token to be stored")
+	}
+	if len(token.Scopes) != 1 || token.Scopes[0] != models.ScopePhotosRead {
+		t.Error("expected the photos:read scope got:", token.Scopes)
+	}
+
+	user, grant, err := authService.Authorize(context.Background(), response.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != 1 || grant.SessionId != 0 || len(grant.Scopes) != 1 {
+		t.Error("expected the token to grant only its scopes got:", grant)
+	}
+}
+
+func TestCreatePersonalAccessToken_ScopeNotGrantable(t *testing.T) {
+	var jsonStr = []byte(`{"name": "backup script", "scopes": ["photos:read", "account"]}`)
+	req, err := http.NewRequest(http.MethodPost, "/users/me/tokens", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario")
+	authService := newTestAuthService(repos)
+	tci := NewTokensController(authService)
+	tc, _ := tci.(*tokensController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc.CreatePersonalAccessToken(w, r, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusBadRequest != res.Code {
+		t.Error("expected", http.StatusBadRequest, "got:", res.Code)
+	}
+
+	tokens, err := repos.Auth.GetPersonalAccessTokens(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*tokens) != 0 {
+		t.Error("expected no token to be created got:", *tokens)
+	}
+}
+
+func TestCreatePersonalAccessToken_ExpiredDate(t *testing.T) {
+	var jsonStr = []byte(`{"name": "backup script", "scopes": ["photos:read"], "expiresAt": "2020-01-01T00:00:00Z"}`)
+	req, err := http.NewRequest(http.MethodPost, "/users/me/tokens", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authService := newTestAuthService(newTestRepositories(t, "Mario"))
+	tci := NewTokensController(authService)
+	tc, _ := tci.(*tokensController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc.CreatePersonalAccessToken(w, r, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: 1}})
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusBadRequest != res.Code {
+		t.Error("expected", http.StatusBadRequest, "got:", res.Code)
+	}
+
+	if res.Body.String() != "Expiration date should be in the future" {
+		t.Error("expected \"Expiration date should be in the future\" got:", res.Body.String())
+	}
+}
+
+func TestRevokePersonalAccessToken_OtherUserToken(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, "/users/me/tokens/2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepositories(t, "Mario", "Luigi")
+	for userId := 1; userId <= 2; userId++ {
+		if _, err := repos.Auth.SetPersonalAccessToken(context.Background(), &models.PersonalAccessToken{
+			UserId:    userId,
+			Name:      "backup script",
+			TokenHash: testTokenHash(strconv.Itoa(userId)),
+			Scopes:    []models.Scope{models.ScopePhotosRead},
+			CreatedAt: time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	authService := newTestAuthService(repos)
+	tci := NewTokensController(authService)
+	tc, _ := tci.(*tokensController)
+
+	res := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc.RevokePersonalAccessToken(
+			w,
+			r,
+			httprouter.Params{{Key: "tokenId", Value: "2"}},
+			reqcontext.RequestContext{User: reqcontext.User{Id: 1}},
+		)
+	})
+
+	handler.ServeHTTP(res, req)
+
+	if http.StatusNotFound != res.Code {
+		t.Error("expected", http.StatusNotFound, "got:", res.Code)
+	}
+
+	tokens, err := repos.Auth.GetPersonalAccessTokens(context.Background(), repos.Auth.WithoutRevokedPersonalAccessTokens())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*tokens) != 2 {
+		t.Error("expected no revoked tokens got:", *tokens)
+	}
+}
@@ -0,0 +1,15 @@
+package controllers
+
+// TwoFactorCodeRequest - A code generated by the authenticator app, or one of the recovery codes
+type TwoFactorCodeRequest struct {
+
+	// Two-factor code
+	Code string `json:"code,omitempty"`
+}
+
+// VerifyTwoFactorEnrollmentResponse - The recovery codes of the enabled two-factor authentication
+type VerifyTwoFactorEnrollmentResponse struct {
+
+	// One-time codes to log in without the authenticator app, they can't be retrieved again
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/routes"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+)
+
+// twoFactorController binds http requests to an api service and writes the service results to the http response
+type twoFactorController struct {
+	service      services.AuthService
+	errorHandler ErrorHandler
+}
+
+// NewTwoFactorController creates a default api controller
+func NewTwoFactorController(s services.AuthService) Controller {
+	controller := &twoFactorController{
+		service:      s,
+		errorHandler: errorHandler,
+	}
+
+	return controller
+}
+
+// Routes returns all the api routes for the twoFactorController
+func (c *twoFactorController) Routes() routes.Routes {
+	return routes.Routes{
+		{
+			Name:         "StartTwoFactorEnrollment",
+			Method:       http.MethodPost,
+			Path:         "/users/me/2fa",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.StartTwoFactorEnrollment,
+		},
+		{
+			Name:         "VerifyTwoFactorEnrollment",
+			Method:       http.MethodPost,
+			Path:         "/users/me/2fa/verify",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.VerifyTwoFactorEnrollment,
+		},
+		{
+			Name:         "DisableTwoFactor",
+			Method:       http.MethodDelete,
+			Path:         "/users/me/2fa",
+			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeAccount},
+			HandlerFunc:  c.DisableTwoFactor,
+		},
+	}
+}
+
+// StartTwoFactorEnrollment - Create a TOTP secret for the user
+func (c *twoFactorController) StartTwoFactorEnrollment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	enrollment, err := c.service.StartTwoFactorEnrollment(r.Context(), ctx.User.Id, ctx.User.Username)
+	if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+		c.errorHandler(w, r, &ConflictError{err}, ctx)
+		return
+	} else if err != nil {
+		c.errorHandler(w, r, err, ctx)
+		return
+	}
+	encodeJSONResponse(enrollment, http.StatusCreated, w, ctx)
+}
+
+// VerifyTwoFactorEnrollment - Enable the two-factor authentication with a code generated from the new secret
+func (c *twoFactorController) VerifyTwoFactorEnrollment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	code, ok := c.decodeCode(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := c.service.VerifyTwoFactorEnrollment(r.Context(), ctx.User.Id, code)
+	switch {
+	case errors.Is(err, services.ErrNoTwoFactorEnrollment):
+		c.errorHandler(w, r, &NotFoundError{"Two-factor enrollment"}, ctx)
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled):
+		c.errorHandler(w, r, &ConflictError{err}, ctx)
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		c.errorHandler(w, r, &ForbiddenError{err}, ctx)
+	case err != nil:
+		c.errorHandler(w, r, err, ctx)
+	default:
+		encodeJSONResponse(VerifyTwoFactorEnrollmentResponse{recoveryCodes}, http.StatusOK, w, ctx)
+	}
+}
+
+// DisableTwoFactor - Disable the two-factor authentication, confirming it with a code
+func (c *twoFactorController) DisableTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	code, ok := c.decodeCode(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	err := c.service.DisableTwoFactor(r.Context(), ctx.User.Id, code)
+	switch {
+	case errors.Is(err, services.ErrNoTwoFactor):
+		c.errorHandler(w, r, &NotFoundError{"Two-factor authentication"}, ctx)
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		c.errorHandler(w, r, &ForbiddenError{err}, ctx)
+	case err != nil:
+		c.errorHandler(w, r, err, ctx)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeCode reads the code from the request body, writing the error response if it's missing
+func (c *twoFactorController) decodeCode(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) (string, bool) {
+	codeRequestParam := TwoFactorCodeRequest{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&codeRequestParam); err != nil {
+		c.errorHandler(w, r, &ParsingError{errors.New("Payload not valid")}, ctx)
+		return "", false
+	}
+	if codeRequestParam.Code == "" {
+		c.errorHandler(w, r, &RequiredError{"code"}, ctx)
+		return "", false
+	}
+	return codeRequestParam.Code, true
+}
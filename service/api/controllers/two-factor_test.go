@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // TOTP is defined over HMAC-SHA1
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+	"github.com/sirupsen/logrus"
+)
+
+// testTOTPSecret is the RFC 6238 test secret, base32 encoded
+var testTOTPSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// testTOTPCode computes the code of testTOTPSecret at a date
+func testTOTPCode(date time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(date.Unix()/30))
+	mac := hmac.New(sha1.New, []byte("12345678901234567890"))
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// pinClock fixes globaltime for the duration of a test
+func pinClock(t *testing.T, date time.Time) {
+	globaltime.FixedTime = date
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+}
+
+// enableTestTwoFactor enables the two-factor authentication of a user, with testTOTPSecret
+func enableTestTwoFactor(t *testing.T, r *repositories.Repositories, userId int) {
+	t.Helper()
+	if err := r.TwoFactor.SetTOTPSecret(context.Background(), userId, testTOTPSecret); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.TwoFactor.EnableTOTP(context.Background(), userId, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testTOTP returns the TOTP secret of a user, nil if there's none
+func testTOTP(t *testing.T, r *repositories.Repositories, userId int) *models.TOTP {
+	t.Helper()
+	totp, err := r.TwoFactor.GetTOTP(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totp
+}
+
+func TestTwoFactorEnrollment_EnablesWithValidCode(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	pinClock(t, now)
+	repos := newTestRepositories(t, "Mario")
+	authService := newTestAuthService(repos)
+	tfci := NewTwoFactorController(authService)
+	tfc, _ := tfci.(*twoFactorController)
+	ctx := reqcontext.RequestContext{User: reqcontext.User{Id: 1, Username: "Mario"}, Logger: logrus.New()}
+
+	req, _ := http.NewRequest(http.MethodPost, "/users/me/2fa", nil)
+	res := httptest.NewRecorder()
+	tfc.StartTwoFactorEnrollment(res, req, httprouter.Params{}, ctx)
+	if http.StatusCreated != res.Code {
+		t.Fatal("expected", http.StatusCreated, "got:", res.Code, res.Body.String())
+	}
+	enrollment := models.TOTPEnrollment{}
+	if err := json.NewDecoder(res.Body).Decode(&enrollment); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/WASA%20Photo:Mario?") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Error("expected an otpauth URI with the secret got:", enrollment.URI)
+	}
+
+	// Use the known secret to compute the code
+	if err := repos.TwoFactor.SetTOTPSecret(context.Background(), 1, testTOTPSecret); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest(http.MethodPost, "/users/me/2fa/verify", bytes.NewBufferString(`{"code": "000000"}`))
+	res = httptest.NewRecorder()
+	tfc.VerifyTwoFactorEnrollment(res, req, httprouter.Params{}, ctx)
+	if http.StatusForbidden != res.Code {
+		t.Error("expected", http.StatusForbidden, "got:", res.Code)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, "/users/me/2fa/verify", bytes.NewBufferString(`{"code": "`+testTOTPCode(now)+`"}`))
+	res = httptest.NewRecorder()
+	tfc.VerifyTwoFactorEnrollment(res, req, httprouter.Params{}, ctx)
+	if http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	response := VerifyTwoFactorEnrollmentResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.RecoveryCodes) != 10 {
+		t.Error("expected 10 recovery codes got:", len(response.RecoveryCodes))
+	}
+	if totp := testTOTP(t, repos, 1); totp == nil || totp.EnabledAt == nil {
+		t.Error("expected the two-factor authentication to be enabled got:", totp)
+	}
+	// Only the hashes of the recovery codes are stored
+	for _, code := range response.RecoveryCodes {
+		if used, err := repos.TwoFactor.UseRecoveryCode(context.Background(), 1, code, now); err != nil || used {
+			t.Error("expected the recovery code to be stored hashed got:", used, err)
+		}
+	}
+}
+
+func TestDoLogin_TwoFactorChallenge(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	pinClock(t, now)
+	repos := newTestRepositories(t, "Mario")
+	setTestPassword(t, repos, 1, "supersecret")
+	enableTestTwoFactor(t, repos, 1)
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+	ctx := reqcontext.RequestContext{Logger: logrus.New()}
+
+	req, _ := http.NewRequest(http.MethodPost, "/session", bytes.NewBufferString(`{"name": "Mario", "password": "supersecret"}`))
+	res := httptest.NewRecorder()
+	lc.DoLogin(res, req, httprouter.Params{}, ctx)
+	if http.StatusAccepted != res.Code {
+		t.Fatal("expected", http.StatusAccepted, "got:", res.Code, res.Body.String())
+	}
+	if sessions := testSessions(t, repos); len(sessions) != 0 {
+		t.Error("expected no session before the challenge is verified got:", sessions)
+	}
+	challenge := LoginChallengeResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	if !challenge.ExpiresAt.Equal(now.Add(5 * time.Minute)) {
+		t.Error("expected the challenge to expire in 5 minutes got:", challenge.ExpiresAt)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, "/session/2fa", bytes.NewBufferString(`{"challengeToken": "`+challenge.ChallengeToken+`", "code": "000000"}`))
+	res = httptest.NewRecorder()
+	lc.VerifyLoginChallenge(res, req, httprouter.Params{}, ctx)
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+
+	body := `{"challengeToken": "` + challenge.ChallengeToken + `", "code": "` + testTOTPCode(now) + `"}`
+	req, _ = http.NewRequest(http.MethodPost, "/session/2fa", bytes.NewBufferString(body))
+	res = httptest.NewRecorder()
+	lc.VerifyLoginChallenge(res, req, httprouter.Params{}, ctx)
+	if http.StatusOK != res.Code {
+		t.Fatal("expected", http.StatusOK, "got:", res.Code, res.Body.String())
+	}
+	if !strings.Contains(res.Body.String(), "\"identifier\":") {
+		t.Error("expected JSON response with \"identifier\" got:", res.Body.String())
+	}
+
+	// The challenge can't be used twice
+	req, _ = http.NewRequest(http.MethodPost, "/session/2fa", bytes.NewBufferString(body))
+	res = httptest.NewRecorder()
+	lc.VerifyLoginChallenge(res, req, httprouter.Params{}, ctx)
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
+
+func TestVerifyLoginChallenge_TooManyAttempts(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	pinClock(t, now)
+	repos := newTestRepositories(t, "Mario")
+	enableTestTwoFactor(t, repos, 1)
+	if err := repos.TwoFactor.SetLoginChallenge(context.Background(), 1, testTokenHash("challenge"), "", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := repos.TwoFactor.IncrementLoginChallengeAttempts(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	req, _ := http.NewRequest(http.MethodPost, "/session/2fa", bytes.NewBufferString(`{"challengeToken": "challenge", "code": "`+testTOTPCode(now)+`"}`))
+	res := httptest.NewRecorder()
+	lc.VerifyLoginChallenge(res, req, httprouter.Params{}, reqcontext.RequestContext{Logger: logrus.New()})
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
+
+func TestVerifyLoginChallenge_Expired(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	pinClock(t, now)
+	repos := newTestRepositories(t, "Mario")
+	enableTestTwoFactor(t, repos, 1)
+	if err := repos.TwoFactor.SetLoginChallenge(context.Background(), 1, testTokenHash("challenge"), "", now); err != nil {
+		t.Fatal(err)
+	}
+	authService := newTestAuthService(repos)
+	lci := NewLoginController(authService)
+	lc, _ := lci.(*loginController)
+
+	req, _ := http.NewRequest(http.MethodPost, "/session/2fa", bytes.NewBufferString(`{"challengeToken": "challenge", "code": "`+testTOTPCode(now)+`"}`))
+	res := httptest.NewRecorder()
+	lc.VerifyLoginChallenge(res, req, httprouter.Params{}, reqcontext.RequestContext{Logger: logrus.New()})
+	if http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
+
+func TestDisableTwoFactor_RecoveryCodeOnlyOnce(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	pinClock(t, now)
+	repos := newTestRepositories(t, "Mario")
+	enableTestTwoFactor(t, repos, 1)
+	if err := repos.TwoFactor.SetRecoveryCodes(context.Background(), 1, []string{testTokenHash("abcdefgh")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repos.TwoFactor.UseRecoveryCode(context.Background(), 1, testTokenHash("abcdefgh"), now); err != nil {
+		t.Fatal(err)
+	}
+	authService := newTestAuthService(repos)
+	tfci := NewTwoFactorController(authService)
+	tfc, _ := tfci.(*twoFactorController)
+	ctx := reqcontext.RequestContext{User: reqcontext.User{Id: 1, Username: "Mario"}, Logger: logrus.New()}
+
+	req, _ := http.NewRequest(http.MethodDelete, "/users/me/2fa", bytes.NewBufferString(`{"code": "abcd-efgh"}`))
+	res := httptest.NewRecorder()
+	tfc.DisableTwoFactor(res, req, httprouter.Params{}, ctx)
+	if http.StatusForbidden != res.Code {
+		t.Error("expected", http.StatusForbidden, "got:", res.Code)
+	}
+
+	if err := repos.TwoFactor.SetRecoveryCodes(context.Background(), 1, []string{testTokenHash("abcdefgh")}); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, "/users/me/2fa", bytes.NewBufferString(`{"code": "ABCD-EFGH"}`))
+	res = httptest.NewRecorder()
+	tfc.DisableTwoFactor(res, req, httprouter.Params{}, ctx)
+	if http.StatusNoContent != res.Code {
+		t.Error("expected", http.StatusNoContent, "got:", res.Code, res.Body.String())
+	}
+	if totp := testTOTP(t, repos, 1); totp != nil {
+		t.Error("expected the two-factor authentication to be removed got:", totp)
+	}
+}
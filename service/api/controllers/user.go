@@ -6,9 +6,11 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/routes"
 	"github.com/lucaronca/wasa-homework/service/api/services"
+	"github.com/lucaronca/wasa-homework/service/usernames"
 )
 
 // usersController binds http requests to an api service and writes the service results to the http response
@@ -35,6 +37,7 @@ func (c *usersController) Routes() routes.Routes {
 			Method:       http.MethodGet,
 			Path:         "/users/:userId",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeUsersRead},
 			HandlerFunc:  c.GetUserProfile,
 		},
 		{
@@ -42,6 +45,7 @@ func (c *usersController) Routes() routes.Routes {
 			Method:       http.MethodGet,
 			Path:         "/users",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeUsersRead},
 			HandlerFunc:  c.GetUsers,
 		},
 		{
@@ -49,6 +53,7 @@ func (c *usersController) Routes() routes.Routes {
 			Method:       http.MethodPatch,
 			Path:         "/users/me",
 			AuthRequired: true,
+			Scopes:       []models.Scope{models.ScopeUsersWrite},
 			HandlerFunc:  c.SetMyUserName,
 		},
 	}
@@ -68,7 +73,7 @@ func (c *usersController) GetUserProfile(w http.ResponseWriter, r *http.Request,
 		parsedIdParam = parsed
 	}
 
-	result, err := c.service.GetUser(ctx.User.Id, parsedIdParam)
+	result, err := c.service.GetUser(r.Context(), ctx.User.Id, parsedIdParam)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
@@ -91,7 +96,7 @@ func (c *usersController) GetUsers(w http.ResponseWriter, r *http.Request, ps ht
 	// 	return
 	// }
 
-	result, err := c.service.GetUsers(ctx.User.Id, username)
+	result, err := c.service.GetUsers(r.Context(), ctx.User.Id, username)
 	// If an error occurred, encode the error with the status code
 	if errors.Is(err, services.ErrNoUser) {
 		c.errorHandler(w, r, &NotFoundError{"User"}, ctx)
@@ -135,14 +140,18 @@ func (c *usersController) SetMyUserName(w http.ResponseWriter, r *http.Request,
 		case
 			errors.Is(err, ErrSeMyUserNameOpIsNotValid),
 			errors.Is(err, ErrSeMyUserNamePathIsNotValid),
-			errors.Is(err, ErrSetMyUserNameValueIsNotValid):
+			errors.Is(err, ErrSetMyUserNameValueIsNotValid),
+			errors.Is(err, usernames.ErrNotValid):
 			c.errorHandler(w, r, &ParsingError{err}, ctx)
 		}
 		return
 	}
 
-	result, err := c.service.UpdateUsername(ctx.User.Id, setMyUserNameRequestParam[0].Value)
-	if err != nil {
+	result, err := c.service.UpdateUsername(r.Context(), ctx.User.Id, setMyUserNameRequestParam[0].Value)
+	if errors.Is(err, services.ErrUserAlreadyExists) || errors.Is(err, services.ErrUsernameReserved) {
+		c.errorHandler(w, r, &ConflictError{err}, ctx)
+		return
+	} else if err != nil {
 		c.errorHandler(w, r, err, ctx)
 		return
 	}
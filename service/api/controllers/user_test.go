@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+)
+
+func serveSetMyUserName(t *testing.T, uc *usersController, userId int, username string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := `[{"op": "replace", "path": "/username", "value": "` + username + `"}]`
+	req, err := http.NewRequest(http.MethodPatch, "/users/me", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	res := httptest.NewRecorder()
+	uc.SetMyUserName(res, req, httprouter.Params{}, reqcontext.RequestContext{User: reqcontext.User{Id: userId}})
+	return res
+}
+
+func TestSetMyUserName_UsernameNotAvailable(t *testing.T) {
+	repos := newTestRepositories(t, "Mario", "Luigi", "Admin")
+	uci := NewUsersController(services.NewUsersService(repos.Users, repos.Bans, repos.Follows, repos.Photos))
+	uc, _ := uci.(*usersController)
+
+	for name, expected := range map[string]int{
+		"mario":  http.StatusConflict,
+		"ROOT":   http.StatusConflict,
+		"Luigi?": http.StatusBadRequest,
+	} {
+		if res := serveSetMyUserName(t, uc, 2, name); expected != res.Code {
+			t.Error("expected", expected, "renaming Luigi to", name, "got:", res.Code, res.Body.String())
+		}
+	}
+	if user, _ := repos.Users.GetUserById(context.Background(), 2); user == nil || user.Username != "Luigi" {
+		t.Error("expected Luigi to keep the name got:", user)
+	}
+
+	// A user can change the case of its own name, even if it's reserved
+	for userId, name := range map[int]string{2: "LUIGI", 3: "admin"} {
+		if res := serveSetMyUserName(t, uc, userId, name); http.StatusOK != res.Code {
+			t.Error("expected", http.StatusOK, "renaming to", name, "got:", res.Code, res.Body.String())
+		}
+	}
+}
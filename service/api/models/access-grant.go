@@ -0,0 +1,14 @@
+package models
+
+// AccessGrant - What an authorized access token grants to the requests it authenticates
+type AccessGrant struct {
+
+	// Session of the token, zero for personal access tokens
+	SessionId int
+
+	// Personal access token, zero for session tokens
+	PersonalAccessTokenId int
+
+	// Scopes granted to the token
+	Scopes []Scope
+}
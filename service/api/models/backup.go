@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Backup - A snapshot of the database and of the photos, written to a tar.gz archive
+type Backup struct {
+
+	// Name of the archive, in the backups directory of the server
+	Name string `json:"name"`
+
+	// Date of the snapshot
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Size of the archive in bytes
+	Size int64 `json:"size"`
+
+	// Number of photos files in the archive
+	Photos int `json:"photos"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// CounterDrift - A stored counter that differs from the count of the rows it counts
+type CounterDrift struct {
+
+	// Table of the row holding the counter
+	Table string `json:"table"`
+
+	// Identifier of the row holding the counter
+	Id int `json:"id"`
+
+	// Column of the counter
+	Column string `json:"column"`
+
+	// Value stored in the counter
+	Stored int `json:"stored"`
+
+	// Count of the related rows
+	Actual int `json:"actual"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// LoginChallenge - A password login waiting for the second factor
+type LoginChallenge struct {
+
+	// Unique identifier of a login challenge
+	Id int
+
+	// User logging in
+	UserId int
+
+	// Keyed hash of the challenge token
+	TokenHash string
+
+	// User agent of the device logging in
+	UserAgent string
+
+	// Challenge expiration date
+	ExpiresAt time.Time
+
+	// Number of wrong codes sent for the challenge
+	Attempts int
+}
+
+// LoginResult - The outcome of a login: either the session tokens, or the challenge token to exchange for
+// them along with the second factor
+type LoginResult struct {
+	Session *SessionTokens
+
+	ChallengeToken     string
+	ChallengeExpiresAt time.Time
+}
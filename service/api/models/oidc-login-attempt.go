@@ -0,0 +1,11 @@
+package models
+
+// OIDCLoginAttempt - The values binding an OpenID Connect login to the browser that started it
+type OIDCLoginAttempt struct {
+
+	// Opaque value the provider sends back to the callback, it protects it from cross-site request forgery
+	State string
+
+	// Value the provider copies in the ID token, it protects the login from replayed ID tokens
+	Nonce string
+}
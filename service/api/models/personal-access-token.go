@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// PersonalAccessToken - A named token with limited scopes, used to automate requests on behalf of a user
+type PersonalAccessToken struct {
+
+	// Unique identifier of a personal access token
+	Id int `json:"id"`
+
+	// Owner of the token
+	UserId int `json:"-"`
+
+	// Name given to the token by its owner
+	Name string `json:"name"`
+
+	// Keyed hash of the token value
+	TokenHash string `json:"-"`
+
+	// Scopes granted to the token
+	Scopes []Scope `json:"scopes"`
+
+	// Token creation date
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Date of the last request authenticated with the token, nil if it was never used
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+
+	// Token expiration date, nil if the token doesn't expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
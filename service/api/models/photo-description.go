@@ -0,0 +1,11 @@
+package models
+
+// PhotoDescription - What the owner of a photo tells about it
+type PhotoDescription struct {
+
+	// Caption shown along with the photo
+	Caption string `json:"caption"`
+
+	// Description of the image for the users who can't see it, e.g. read by the screen readers
+	AltText string `json:"altText"`
+}
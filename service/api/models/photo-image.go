@@ -0,0 +1,21 @@
+package models
+
+// PhotoImage - What the image of a photo is, computed when it's uploaded. It's empty for the photos uploaded before it
+// was computed, until they're backfilled.
+type PhotoImage struct {
+
+	// Image width in pixels
+	Width int `json:"width,omitempty"`
+
+	// Image height in pixels
+	Height int `json:"height,omitempty"`
+
+	// Image file size in bytes
+	Size int64 `json:"size,omitempty"`
+
+	// Image MIME type, e.g. image/jpeg
+	MimeType string `json:"mimeType,omitempty"`
+
+	// BlurHash of the image, decoded by the clients to a placeholder while the image loads
+	BlurHash string `json:"blurHash,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// PhotoVariant - A resized copy of a photo
+type PhotoVariant struct {
+
+	// Image URL
+	Url string `json:"url"`
+
+	// Image width in pixels
+	Width int `json:"width"`
+
+	// Image height in pixels
+	Height int `json:"height"`
+}
@@ -13,6 +13,15 @@ type Photo struct {
 	// Image URL
 	Url string `json:"url,omitempty"`
 
+	// Image dimensions, size, type and placeholder
+	PhotoImage
+
+	// Image caption and alt text
+	PhotoDescription
+
+	// Resized copies of the image by width, the ones wider than the image aren't generated
+	Variants map[string]PhotoVariant `json:"variants,omitempty"`
+
 	// Image likes number
 	TotalLikes int `json:"totalLikes"`
 
@@ -25,5 +34,14 @@ type Photo struct {
 	// Image upload date
 	UploadDate time.Time `json:"uploadDate,omitempty"`
 
+	// Date the photo was taken, read from its metadata, nil if it's unknown or not kept
+	TakenAt *time.Time `json:"takenAt,omitempty"`
+
 	Owner BaseUser `json:"owner,omitempty"`
+
+	// Date the photo was moved to the trash, nil if it's not in the trash
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// Date the photo in the trash will be deleted for good
+	PurgeAt *time.Time `json:"purgeAt,omitempty"`
 }
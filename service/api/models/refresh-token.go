@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// RefreshToken - A long lived token that can be exchanged once for new session tokens
+type RefreshToken struct {
+
+	// Unique identifier of a refresh token
+	Id int
+
+	// Session refreshed by the token
+	SessionId int
+
+	// Keyed hash of the refresh token value
+	TokenHash string
+
+	// Refresh token expiration date
+	ExpiresAt time.Time
+
+	// Date the token was exchanged, nil if it wasn't used yet
+	UsedAt *time.Time
+}
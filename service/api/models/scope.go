@@ -0,0 +1,37 @@
+package models
+
+// Scope - A permission granted to an access token
+type Scope string
+
+const (
+	ScopePhotosRead  Scope = "photos:read"
+	ScopePhotosWrite Scope = "photos:write"
+	ScopeSocialRead  Scope = "social:read"
+	ScopeSocialWrite Scope = "social:write"
+	ScopeUsersRead   Scope = "users:read"
+	ScopeUsersWrite  Scope = "users:write"
+
+	// ScopeAccount allows to manage sessions and personal access tokens, only interactive sessions are granted it
+	ScopeAccount Scope = "account"
+)
+
+// PersonalAccessTokenScopes are the scopes that can be granted to a personal access token
+var PersonalAccessTokenScopes = []Scope{
+	ScopePhotosRead,
+	ScopePhotosWrite,
+	ScopeSocialRead,
+	ScopeSocialWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+}
+
+// SessionScopes are the scopes granted to the interactive sessions
+var SessionScopes = []Scope{
+	ScopePhotosRead,
+	ScopePhotosWrite,
+	ScopeSocialRead,
+	ScopeSocialWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeAccount,
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// SessionTokens - Tokens issued to a logged in user for a session
+type SessionTokens struct {
+
+	// Short lived token identifier authenticating the requests
+	AccessToken string
+
+	// Access token expiration date
+	AccessTokenExpiresAt time.Time
+
+	// Long lived token used to get new tokens when the access token expires
+	RefreshToken string
+}
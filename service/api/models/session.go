@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Session - A login session of a user on a device
+type Session struct {
+
+	// Unique identifier of a session
+	Id int `json:"id"`
+
+	// Owner of the session
+	UserId int `json:"-"`
+
+	// Keyed hash of the token identifier used to authenticate the session requests
+	TokenHash string `json:"-"`
+
+	// User agent of the device that created the session
+	UserAgent string `json:"userAgent"`
+
+	// Session creation date
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Date of the last request authenticated with the session
+	LastUsedAt time.Time `json:"lastUsedAt"`
+
+	// Expiration date of the session token
+	ExpiresAt time.Time `json:"-"`
+
+	// If the session is the one used by the current request
+	Current bool `json:"current"`
+}
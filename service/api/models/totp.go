@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// TOTP - The time-based one-time password secret of a user
+type TOTP struct {
+
+	// Owner of the secret
+	UserId int
+
+	// Base32 encoded secret shared with the authenticator app
+	Secret string
+
+	// Date the secret was enabled, nil while the enrollment isn't verified
+	EnabledAt *time.Time
+
+	// Last time step a code was accepted for, codes can't be used twice
+	LastUsedStep int64
+}
+
+// TOTPEnrollment - A new TOTP secret to be added to an authenticator app
+type TOTPEnrollment struct {
+
+	// Base32 encoded secret, for the apps that can't scan the URI
+	Secret string `json:"secret"`
+
+	// otpauth URI of the secret, usually shown as a QR code
+	URI string `json:"otpauthUri"`
+}
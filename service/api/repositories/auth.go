@@ -1,21 +1,47 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/database"
 )
 
 type AuthRepository interface {
 	// Getters
-	GetToken(relations ...Relation) (string, error)
+	GetSession(ctx context.Context, relations ...Relation) (*models.Session, error)
+	GetSessions(ctx context.Context, relations ...Relation) (*[]models.Session, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	GetPasswordHash(ctx context.Context, userId int) (string, error)
+	IsPasswordPending(ctx context.Context, userId int) (bool, error)
+	GetPersonalAccessToken(ctx context.Context, relations ...Relation) (*models.PersonalAccessToken, error)
+	GetPersonalAccessTokens(ctx context.Context, relations ...Relation) (*[]models.PersonalAccessToken, error)
+	GetIdentityUserId(ctx context.Context, issuer string, subject string) (int, error)
 	// Setters
-	SetToken(userId int, token string) error
+	SetSession(ctx context.Context, userId int, tokenHash string, userAgent string, date time.Time, expiresAt time.Time) (sessionId int, err error)
+	SetSessionToken(ctx context.Context, sessionId int, tokenHash string, expiresAt time.Time) error
+	SetSessionLastUsed(ctx context.Context, sessionId int, date time.Time) error
+	RevokeSession(ctx context.Context, sessionId int, date time.Time) error
+	RemoveExpiredSessions(ctx context.Context, date time.Time) (int, error)
+	SetRefreshToken(ctx context.Context, sessionId int, tokenHash string, expiresAt time.Time) error
+	SetRefreshTokenUsed(ctx context.Context, refreshTokenId int, date time.Time) (bool, error)
+	SetPasswordHash(ctx context.Context, userId int, passwordHash string) error
+	SetPasswordPending(ctx context.Context, userId int) error
+	SetPersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) (tokenId int, err error)
+	SetPersonalAccessTokenLastUsed(ctx context.Context, tokenId int, date time.Time) error
+	RevokePersonalAccessToken(ctx context.Context, tokenId int, date time.Time) error
+	SetIdentity(ctx context.Context, issuer string, subject string, userId int) error
 	// Relations builders
-	WithTokens() Relation
-	FilterByToken(token string) Relation
+	FilterByTokenHash(tokenHash string) Relation
+	FilterBySessionId(sessionId int) Relation
+	FilterByPersonalAccessTokenId(tokenId int) Relation
+	WithoutRevokedSessions() Relation
+	WithoutRevokedPersonalAccessTokens() Relation
 }
 
 type authRepository struct {
@@ -32,49 +58,448 @@ func NewAuthRepository(db database.AppDatabase) (AuthRepository, error) {
 	}, nil
 }
 
-func (r *authRepository) GetToken(relations ...Relation) (string, error) {
+func (r *authRepository) GetSession(ctx context.Context, relations ...Relation) (*models.Session, error) {
 	q := queryBuilder("user_token", relations...)
-	var token string
-	err := r.Conn().QueryRow(
-		fmt.Sprintf(`
-		SELECT token FROM user_tokens
+	var session models.Session
+	var createdAt string
+	var lastUsedAt string
+	var expiresAt string
+	err := r.Conn().QueryRow(ctx, fmt.Sprintf(`
+		SELECT id, user_id, token, user_agent, created_at, last_used_at, expires_at FROM user_tokens
 		%s
-	`, q)).Scan(&token)
+	`, q.SQL), q.Args...).Scan(
+		&session.Id,
+		&session.UserId,
+		&session.TokenHash,
+		&session.UserAgent,
+		&createdAt,
+		&lastUsedAt,
+		&expiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session.CreatedAt, err = parseDate(createdAt)
+	if err != nil {
+		return nil, err
+	}
+	session.LastUsedAt, err = parseDate(lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt, err = parseDate(expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *authRepository) GetSessions(ctx context.Context, relations ...Relation) (*[]models.Session, error) {
+	q := queryBuilder("user_token", relations...)
+	rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
+		SELECT id, user_id, user_agent, created_at, last_used_at FROM user_tokens
+		%s
+		ORDER BY last_used_at DESC
+	`, q.SQL), q.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		var createdAt string
+		var lastUsedAt string
+		err = rows.Scan(&session.Id, &session.UserId, &session.UserAgent, &createdAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		session.CreatedAt, err = parseDate(createdAt)
+		if err != nil {
+			return nil, err
+		}
+		session.LastUsedAt, err = parseDate(lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return &sessions, nil
+}
+
+func (r *authRepository) SetSession(ctx context.Context,
+	userId int,
+	tokenHash string,
+	userAgent string,
+	date time.Time,
+	expiresAt time.Time,
+) (int, error) {
+	id, err := r.WriteConn().Insert(ctx, `
+		INSERT INTO user_tokens (user_id, token, user_agent, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userId, tokenHash, userAgent, formatDate(date), formatDate(date), formatDate(expiresAt))
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func (r *authRepository) SetSessionToken(ctx context.Context, sessionId int, tokenHash string, expiresAt time.Time) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		UPDATE user_tokens SET token=?, expires_at=?
+		WHERE id=?
+	`, tokenHash, formatDate(expiresAt), sessionId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *authRepository) SetSessionLastUsed(ctx context.Context, sessionId int, date time.Time) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		UPDATE user_tokens SET last_used_at=?
+		WHERE id=?
+	`, formatDate(date), sessionId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *authRepository) RevokeSession(ctx context.Context, sessionId int, date time.Time) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		UPDATE user_tokens SET revoked_at=?
+		WHERE id=? AND revoked_at IS NULL
+	`, formatDate(date), sessionId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveExpiredSessions deletes the revoked sessions and the expired ones that can't be refreshed anymore
+func (r *authRepository) RemoveExpiredSessions(ctx context.Context, date time.Time) (int, error) {
+	now := formatDate(date)
+	result, err := r.WriteConn().Exec(ctx, `
+		DELETE FROM user_tokens
+		WHERE revoked_at IS NOT NULL
+		OR (
+			expires_at <= ?
+			AND NOT EXISTS (
+				SELECT 1 FROM refresh_tokens
+				WHERE refresh_tokens.session_id = user_tokens.id
+				AND refresh_tokens.used_at IS NULL
+				AND refresh_tokens.expires_at > ?
+			)
+		);
+	`, now, now)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.WriteConn().Exec(ctx, `
+		DELETE FROM refresh_tokens
+		WHERE expires_at <= ?;
+	`, now); err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
+
+func (r *authRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var refreshToken models.RefreshToken
+	var expiresAt string
+	var usedAt sql.NullString
+	err := r.Conn().QueryRow(ctx, `
+		SELECT id, session_id, token, expires_at, used_at FROM refresh_tokens
+		WHERE token=?
+	`, tokenHash).Scan(&refreshToken.Id, &refreshToken.SessionId, &refreshToken.TokenHash, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken.ExpiresAt, err = parseDate(expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		date, err := parseDate(usedAt.String)
+		if err != nil {
+			return nil, err
+		}
+		refreshToken.UsedAt = &date
+	}
+	return &refreshToken, nil
+}
+
+func (r *authRepository) SetRefreshToken(ctx context.Context, sessionId int, tokenHash string, expiresAt time.Time) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		INSERT INTO refresh_tokens (session_id, token, expires_at)
+		VALUES (?, ?, ?)
+	`, sessionId, tokenHash, formatDate(expiresAt)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetRefreshTokenUsed marks a refresh token as used, it returns false if the token was already used
+func (r *authRepository) SetRefreshTokenUsed(ctx context.Context, refreshTokenId int, date time.Time) (bool, error) {
+	result, err := r.WriteConn().Exec(ctx, `
+		UPDATE refresh_tokens SET used_at=?
+		WHERE id=? AND used_at IS NULL
+	`, formatDate(date), refreshTokenId)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+func (r *authRepository) GetPasswordHash(ctx context.Context, userId int) (string, error) {
+	var passwordHash string
+	err := r.Conn().QueryRow(ctx, `
+		SELECT password_hash FROM user_credentials
+		WHERE user_id=?
+	`, userId).Scan(&passwordHash)
 	if errors.Is(err, sql.ErrNoRows) {
 		return "", nil
 	}
 	if err != nil {
 		return "", err
 	}
-	return token, nil
+	return passwordHash, nil
 }
 
-func (r *authRepository) SetToken(userId int, token string) error {
-	if _, err := r.Conn().Exec(`
-		INSERT INTO user_tokens (token, user_id)
+func (r *authRepository) SetPasswordHash(ctx context.Context, userId int, passwordHash string) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		INSERT INTO user_credentials (user_id, password_hash)
 		VALUES (?, ?)
-	`, token, userId); err != nil {
+		ON CONFLICT(user_id) DO UPDATE SET password_hash=excluded.password_hash
+	`, userId, passwordHash); err != nil {
+		return err
+	}
+	return nil
+}
+
+// IsPasswordPending tells whether a user without a password sets it at the next login, like the users created before
+// passwords were introduced
+func (r *authRepository) IsPasswordPending(ctx context.Context, userId int) (bool, error) {
+	var pending bool
+	err := r.Conn().QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pending_passwords WHERE user_id=?)
+	`, userId).Scan(&pending)
+	if err != nil {
+		return false, err
+	}
+	return pending, nil
+}
+
+// SetPasswordPending lets a user without a password set it at the next login
+func (r *authRepository) SetPasswordPending(ctx context.Context, userId int) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		INSERT INTO pending_passwords (user_id)
+		VALUES (?)
+		ON CONFLICT(user_id) DO NOTHING
+	`, userId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *authRepository) GetPersonalAccessToken(ctx context.Context, relations ...Relation) (*models.PersonalAccessToken, error) {
+	tokens, err := r.GetPersonalAccessTokens(ctx, relations...)
+	if err != nil {
+		return nil, err
+	}
+	if len(*tokens) == 0 {
+		return nil, nil
+	}
+	return &(*tokens)[0], nil
+}
+
+func (r *authRepository) GetPersonalAccessTokens(ctx context.Context, relations ...Relation) (*[]models.PersonalAccessToken, error) {
+	q := queryBuilder("personal_access_token", relations...)
+	rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
+		SELECT id, user_id, name, token, scopes, created_at, last_used_at, expires_at FROM personal_access_tokens
+		%s
+		ORDER BY id
+	`, q.SQL), q.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	tokens := make([]models.PersonalAccessToken, 0)
+	for rows.Next() {
+		var token models.PersonalAccessToken
+		var scopes string
+		var createdAt string
+		var lastUsedAt sql.NullString
+		var expiresAt sql.NullString
+		err = rows.Scan(
+			&token.Id,
+			&token.UserId,
+			&token.Name,
+			&token.TokenHash,
+			&scopes,
+			&createdAt,
+			&lastUsedAt,
+			&expiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, scope := range strings.Fields(scopes) {
+			token.Scopes = append(token.Scopes, models.Scope(scope))
+		}
+		token.CreatedAt, err = parseDate(createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			date, err := parseDate(lastUsedAt.String)
+			if err != nil {
+				return nil, err
+			}
+			token.LastUsedAt = &date
+		}
+		if expiresAt.Valid {
+			date, err := parseDate(expiresAt.String)
+			if err != nil {
+				return nil, err
+			}
+			token.ExpiresAt = &date
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return &tokens, nil
+}
+
+func (r *authRepository) SetPersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) (int, error) {
+	scopes := make([]string, len(token.Scopes))
+	for i, scope := range token.Scopes {
+		scopes[i] = string(scope)
+	}
+	var expiresAt sql.NullString
+	if token.ExpiresAt != nil {
+		expiresAt = sql.NullString{String: formatDate(*token.ExpiresAt), Valid: true}
+	}
+	id, err := r.WriteConn().Insert(ctx, `
+		INSERT INTO personal_access_tokens (user_id, name, token, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`,
+		token.UserId,
+		token.Name,
+		token.TokenHash,
+		strings.Join(scopes, " "),
+		formatDate(token.CreatedAt),
+		expiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func (r *authRepository) SetPersonalAccessTokenLastUsed(ctx context.Context, tokenId int, date time.Time) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		UPDATE personal_access_tokens SET last_used_at=?
+		WHERE id=?
+	`, formatDate(date), tokenId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *authRepository) RevokePersonalAccessToken(ctx context.Context, tokenId int, date time.Time) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		UPDATE personal_access_tokens SET revoked_at=?
+		WHERE id=? AND revoked_at IS NULL
+	`, formatDate(date), tokenId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetIdentityUserId returns the user linked to the subject of an OpenID Connect provider, zero if there's none
+func (r *authRepository) GetIdentityUserId(ctx context.Context, issuer string, subject string) (int, error) {
+	var userId int
+	err := r.Conn().QueryRow(ctx, `
+		SELECT user_id FROM user_identities
+		WHERE issuer=? AND subject=?
+	`, issuer, subject).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+func (r *authRepository) SetIdentity(ctx context.Context, issuer string, subject string, userId int) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		INSERT INTO user_identities (issuer, subject, user_id)
+		VALUES (?, ?, ?)
+	`, issuer, subject, userId); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Relations builders
-func (r *authRepository) WithTokens() Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(
-			"INNER JOIN user_tokens ON %ss.id = %s_id",
-			entity,
-			entity,
+func (r *authRepository) FilterByTokenHash(tokenHash string) Relation {
+	return Relation(func(entity string) Clause {
+		return where(
+			fmt.Sprintf("%ss.token=?", entity),
+			tokenHash,
 		)
 	})
 }
 
-func (r *authRepository) FilterByToken(token string) Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(
-			"WHERE token=\"%s\"",
-			token,
-		)
+func (r *authRepository) FilterBySessionId(sessionId int) Relation {
+	return Relation(func(entity string) Clause {
+		return where("user_tokens.id=?", sessionId)
+	})
+}
+
+func (r *authRepository) FilterByPersonalAccessTokenId(tokenId int) Relation {
+	return Relation(func(entity string) Clause {
+		return where("personal_access_tokens.id=?", tokenId)
+	})
+}
+
+func (r *authRepository) WithoutRevokedSessions() Relation {
+	return Relation(func(entity string) Clause {
+		return where("user_tokens.revoked_at IS NULL")
+	})
+}
+
+func (r *authRepository) WithoutRevokedPersonalAccessTokens() Relation {
+	return Relation(func(entity string) Clause {
+		return where("personal_access_tokens.revoked_at IS NULL")
 	})
 }
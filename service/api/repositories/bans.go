@@ -1,19 +1,19 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 
 	"github.com/lucaronca/wasa-homework/service/database"
 )
 
 type BansRepository interface {
 	// Getters
-	GetBanExists(int, int) (bool, error)
+	GetBanExists(context.Context, int, int) (bool, error)
 	// Setters
-	SetBan(int, int) error
-	RemoveBan(int, int) error
+	SetBan(context.Context, int, int) error
+	RemoveBan(context.Context, int, int) error
 	// Relations builders
 	WithoutBanned(int) Relation
 	WithoutBanners(int) Relation
@@ -33,18 +33,19 @@ func NewBansRepository(db database.AppDatabase) (BansRepository, error) {
 	}, nil
 }
 
-func (r *bansRepository) SetBan(userId int, bannedId int) error {
-	if _, err := r.Conn().Exec(`
-		INSERT OR IGNORE INTO user_bans (user_id, banned_id)
-		VALUES (?, ?);
+func (r *bansRepository) SetBan(ctx context.Context, userId int, bannedId int) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		INSERT INTO user_bans (user_id, banned_id)
+		VALUES (?, ?)
+		ON CONFLICT DO NOTHING
 	`, userId, bannedId); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *bansRepository) RemoveBan(userId int, bannedId int) error {
-	if _, err := r.Conn().Exec(`
+func (r *bansRepository) RemoveBan(ctx context.Context, userId int, bannedId int) error {
+	if _, err := r.WriteConn().Exec(ctx, `
 		DELETE FROM user_bans
 		WHERE user_id=? AND banned_id=?;
 	`, userId, bannedId); err != nil {
@@ -53,9 +54,9 @@ func (r *bansRepository) RemoveBan(userId int, bannedId int) error {
 	return nil
 }
 
-func (r *bansRepository) GetBanExists(userId int, targetId int) (bool, error) {
+func (r *bansRepository) GetBanExists(ctx context.Context, userId int, targetId int) (bool, error) {
 	var exists int
-	err := r.Conn().QueryRow(`
+	err := r.Conn().QueryRow(ctx, `
 		SELECT COUNT(1) FROM user_bans
 		WHERE user_id=? AND banned_id=?;
 	`, userId, targetId).Scan(&exists)
@@ -73,25 +74,24 @@ func (r *bansRepository) GetBanExists(userId int, targetId int) (bool, error) {
 
 // Relations builders
 func (r *bansRepository) WithoutBanned(userId int) Relation {
-	return Relation(func(string) string {
-		return fmt.Sprintf(
-			"WHERE user_id NOT IN (SELECT banned_id from user_bans WHERE user_id = %d)",
+	return Relation(func(string) Clause {
+		return where(
+			"user_id NOT IN (SELECT banned_id from user_bans WHERE user_id = ?)",
 			userId,
 		)
 	})
 }
 
 func (r *bansRepository) WithoutBanners(userId int) Relation {
-	return Relation(func(entity string) string {
+	return Relation(func(entity string) Clause {
 		var userIdField string
 		if entity == "user" {
 			userIdField = "id"
 		} else {
 			userIdField = "user_id"
 		}
-		return fmt.Sprintf(
-			"WHERE %s NOT IN (SELECT user_id from user_bans WHERE banned_id = %d)",
-			userIdField,
+		return where(
+			userIdField+" NOT IN (SELECT user_id from user_bans WHERE banned_id = ?)",
 			userId,
 		)
 	})
@@ -1,6 +1,7 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -12,11 +13,11 @@ import (
 
 type CommentsRepository interface {
 	// Getters
-	GetCommentById(int, ...Relation) (*models.Comment, error)
-	GetComments(relations ...Relation) (*[]models.Comment, error)
+	GetCommentById(context.Context, int, ...Relation) (*models.Comment, error)
+	GetComments(ctx context.Context, relations ...Relation) (*[]models.Comment, error)
 	// Setters
-	SetComment(int, int, time.Time, string) (int, error)
-	RemoveComment(int) error
+	SetComment(context.Context, int, int, time.Time, string) (int, error)
+	RemoveComment(context.Context, int) error
 	// Relation builders
 	WithTotalComments() Relation
 }
@@ -35,15 +36,14 @@ func NewCommentsRepository(db database.AppDatabase) (CommentsRepository, error)
 	}, nil
 }
 
-func (r *commentsRepository) GetCommentById(id int, relations ...Relation) (*models.Comment, error) {
-	q := queryBuilder("comment", relations...)
+func (r *commentsRepository) GetCommentById(ctx context.Context, id int, relations ...Relation) (*models.Comment, error) {
+	q := queryBuilder("comment", append(relations, filterByCommentId(id))...)
 	var comment models.Comment
 	var date string
-	err := r.Conn().QueryRow(fmt.Sprintf(`
+	err := r.Conn().QueryRow(ctx, fmt.Sprintf(`
 		SELECT comments.id, photo_id, user_id, username, date, content FROM comments
 		%s
-		WHERE comments.id=?
-	`, q), id).Scan(&comment.Id, &comment.Photo.Id, &comment.Owner.Id, &comment.Owner.Username, &date, &comment.Content)
+	`, q.SQL), q.Args...).Scan(&comment.Id, &comment.Photo.Id, &comment.Owner.Id, &comment.Owner.Username, &date, &comment.Content)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -51,7 +51,7 @@ func (r *commentsRepository) GetCommentById(id int, relations ...Relation) (*mod
 		return nil, err
 	}
 
-	parsedDate, err := time.Parse(dateLayout, date)
+	parsedDate, err := parseDate(date)
 	if err != nil {
 		return nil, err
 	}
@@ -61,34 +61,46 @@ func (r *commentsRepository) GetCommentById(id int, relations ...Relation) (*mod
 	return &comment, nil
 }
 
-func (r *commentsRepository) SetComment(photoId int, userId int, time time.Time, content string) (int, error) {
-	result, err := r.Conn().Exec(`
-		INSERT INTO comments (photo_id, user_id, date, content)
-		VALUES (?, ?, ?, ?)
-	`, photoId, userId, time.Format(dateLayout), content)
-	if err != nil {
-		return 0, err
-	}
-	lastInsertId, err := result.LastInsertId()
+func (r *commentsRepository) SetComment(ctx context.Context, photoId int, userId int, time time.Time, content string) (int, error) {
+	var id int64
+	err := r.InTx(ctx, func(tx database.AppDatabase) error {
+		var err error
+		id, err = tx.WriteConn().Insert(ctx, `
+			INSERT INTO comments (photo_id, user_id, date, content)
+			VALUES (?, ?, ?, ?)
+		`, photoId, userId, formatDate(time), content)
+		if err != nil {
+			return err
+		}
+		_, err = tx.WriteConn().Exec(ctx, "UPDATE photos SET total_comments = total_comments + 1 WHERE id=?", photoId)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	return int(lastInsertId), nil
+	return int(id), nil
 }
 
-func (r *commentsRepository) RemoveComment(commentId int) error {
-	if _, err := r.Conn().Exec(`
-		DELETE FROM comments
-		WHERE id=?;
-	`, commentId); err != nil {
+func (r *commentsRepository) RemoveComment(ctx context.Context, commentId int) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		// The counter is decremented first, while the comment still points to its photo
+		if _, err := tx.WriteConn().Exec(ctx, `
+			UPDATE photos SET total_comments = total_comments - 1
+			WHERE id=(SELECT photo_id FROM comments WHERE id=?);
+		`, commentId); err != nil {
+			return err
+		}
+		_, err := tx.WriteConn().Exec(ctx, `
+			DELETE FROM comments
+			WHERE id=?;
+		`, commentId)
 		return err
-	}
-	return nil
+	})
 }
 
-func (r *commentsRepository) GetComments(relations ...Relation) (*[]models.Comment, error) {
+func (r *commentsRepository) GetComments(ctx context.Context, relations ...Relation) (*[]models.Comment, error) {
 	q := queryBuilder("comment", relations...)
-	rows, err := r.Conn().Query(fmt.Sprintf(`
+	rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
 		SELECT
 			comments.id,
 			comments.photo_id,
@@ -99,7 +111,7 @@ func (r *commentsRepository) GetComments(relations ...Relation) (*[]models.Comme
 		FROM comments
 		%s
 		ORDER BY comments.date DESC
-	`, q))
+	`, q.SQL), q.Args...)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -124,7 +136,7 @@ func (r *commentsRepository) GetComments(relations ...Relation) (*[]models.Comme
 			return nil, err
 		}
 
-		parsedDate, err := time.Parse(dateLayout, date)
+		parsedDate, err := parseDate(date)
 		if err != nil {
 			return nil, err
 		}
@@ -148,14 +160,13 @@ func (r *commentsRepository) GetComments(relations ...Relation) (*[]models.Comme
 
 // Relations builders
 func (r *commentsRepository) WithTotalComments() Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(`
-				LEFT JOIN (
-					SELECT %v_id as comments_%[1]vs_id, COUNT(*) AS total_comments FROM comments GROUP BY %[1]v_id
-				)
-				ON %[1]vs.id = comments_%[1]vs_id
-			`,
-			entity,
-		)
+	return Relation(func(entity string) Clause {
+		return column("total_comments", fmt.Sprintf("%ss.total_comments", entity))
+	})
+}
+
+func filterByCommentId(commentId int) Relation {
+	return Relation(func(string) Clause {
+		return where("comments.id=?", commentId)
 	})
 }
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+// TestConcurrency_LikesAndComments hammers a SQLite database file with likes and comments in parallel, as concurrent
+// requests do, none of them should fail because the database is locked
+func TestConcurrency_LikesAndComments(t *testing.T) {
+	const users = 16
+	const iterations = 25
+
+	write, read, err := database.OpenSQLite(filepath.Join(t.TempDir(), "wasa.db"), database.SQLiteConfig{
+		JournalMode:        "WAL",
+		BusyTimeout:        5 * time.Second,
+		MaxReadConnections: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = read.Close()
+		_ = write.Close()
+	}()
+	db, err := database.NewWithPools(write, read, database.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewRepositories(db)
+	uow, _ := NewUnitOfWork(db)
+	ctx := context.Background()
+
+	usernames := make([]string, users)
+	for i := range usernames {
+		usernames[i] = fmt.Sprintf("user%d", i+1)
+	}
+	newTestUsersRepository(t, db, usernames...)
+	photoId, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, users*iterations)
+	for userId := 1; userId <= users; userId++ {
+		wg.Add(1)
+		go func(userId int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if err := r.Likes.SetLike(ctx, photoId, userId, testDate); err != nil {
+					errs <- fmt.Errorf("like: %w", err)
+					return
+				}
+				if _, err := r.Comments.SetComment(ctx, photoId, userId, testDate, "Nice"); err != nil {
+					errs <- fmt.Errorf("comment: %w", err)
+					return
+				}
+				if _, err := r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId)); err != nil {
+					errs <- fmt.Errorf("likes: %w", err)
+					return
+				}
+				if _, err := r.Comments.GetComments(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId)); err != nil {
+					errs <- fmt.Errorf("comments: %w", err)
+					return
+				}
+				// A unit of work holds the write connection while it reads and writes
+				err := uow.Do(ctx, func(tx *Repositories) error {
+					if _, err := tx.Likes.GetLikes(ctx, tx.Users.WithUsers(), tx.Photos.FilterByPhotoId(photoId)); err != nil {
+						return err
+					}
+					return tx.Likes.RemoveLike(ctx, photoId, userId)
+				})
+				if err != nil {
+					errs <- fmt.Errorf("unlike: %w", err)
+					return
+				}
+			}
+		}(userId)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	comments, err := r.Comments.GetComments(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*comments) != users*iterations {
+		t.Errorf("expected %d comments got: %d", users*iterations, len(*comments))
+	}
+	likes, err := r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*likes) != 0 {
+		t.Error("expected every like to be removed got:", len(*likes))
+	}
+}
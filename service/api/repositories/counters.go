@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+// CountersRepository checks the counter columns of photos and users against the rows they count
+type CountersRepository interface {
+	// Getters
+	GetCounterDrifts(ctx context.Context) (*[]models.CounterDrift, error)
+	// Setters
+	RepairCounters(ctx context.Context) (int, error)
+}
+
+// counter is a column of table counting the rows of source whose foreignKey is the id of the row, and that satisfy
+// condition if it's not empty
+type counter struct {
+	table      string
+	column     string
+	source     string
+	foreignKey string
+	condition  string
+}
+
+// count is the SQL expression recomputing the counter of a row
+func (c counter) count() string {
+	condition := ""
+	if c.condition != "" {
+		condition = " AND " + c.condition
+	}
+	return fmt.Sprintf("(SELECT COUNT(*) FROM %s WHERE %[1]s.%s = %s.id%s)", c.source, c.foreignKey, c.table, condition)
+}
+
+var counters = []counter{
+	{"photos", "total_likes", "likes", "photo_id", ""},
+	{"photos", "total_comments", "comments", "photo_id", ""},
+	{"users", "total_followers", "follows", "following_id", ""},
+	{"users", "total_following", "follows", "follower_id", ""},
+	// The photos in the trash aren't counted
+	{"users", "total_photos", "photos", "user_id", "photos.deleted_at IS NULL"},
+}
+
+type countersRepository struct {
+	database.AppDatabase
+}
+
+func NewCountersRepository(db database.AppDatabase) (CountersRepository, error) {
+	if db == nil {
+		return nil, errors.New("database is required")
+	}
+
+	return &countersRepository{
+		db,
+	}, nil
+}
+
+func (r *countersRepository) GetCounterDrifts(ctx context.Context) (*[]models.CounterDrift, error) {
+	drifts := []models.CounterDrift{}
+	for _, c := range counters {
+		rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
+			SELECT id, stored, actual FROM (
+				SELECT id, %s AS stored, %s AS actual FROM %s
+			) AS %[3]s_counters
+			WHERE stored <> actual
+			ORDER BY id
+		`, c.column, c.count(), c.table))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			drift := models.CounterDrift{Table: c.table, Column: c.column}
+			if err := rows.Scan(&drift.Id, &drift.Stored, &drift.Actual); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			drifts = append(drifts, drift)
+		}
+		if err := rows.Err(); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		_ = rows.Close()
+	}
+	return &drifts, nil
+}
+
+// RepairCounters recomputes the counters that drifted, and returns how many were repaired
+func (r *countersRepository) RepairCounters(ctx context.Context) (int, error) {
+	repaired := 0
+	err := r.InTx(ctx, func(tx database.AppDatabase) error {
+		for _, c := range counters {
+			result, err := tx.WriteConn().Exec(ctx, fmt.Sprintf(`
+				UPDATE %s SET %s = %s
+				WHERE %[2]s <> %[3]s
+			`, c.table, c.column, c.count()))
+			if err != nil {
+				return err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			repaired += int(affected)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return repaired, nil
+}
@@ -0,0 +1,200 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+func TestCounters_MaintainedByTheSetters(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		ctx := context.Background()
+		newTestUsersRepository(t, db, "Mario", "Luigi", "Wario")
+		photoId, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		removedPhotoId, err := r.Photos.SetPhoto(ctx, "/removed.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, userId := range []int{2, 3} {
+			// The duplicates must not be counted
+			for i := 0; i < 2; i++ {
+				if err := r.Likes.SetLike(ctx, photoId, userId, testDate); err != nil {
+					t.Fatal(err)
+				}
+				if err := r.Follows.SetFollow(ctx, userId, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := r.Comments.SetComment(ctx, photoId, userId, testDate, "Nice"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Likes.RemoveLike(ctx, photoId, 3); err != nil {
+			t.Fatal(err)
+		}
+		// Removing a missing like must not change the counter
+		if err := r.Likes.RemoveLike(ctx, photoId, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Follows.RemoveFollow(ctx, 3, 1); err != nil {
+			t.Fatal(err)
+		}
+		commentId, err := r.Comments.SetComment(ctx, photoId, 2, testDate, "Great")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Comments.RemoveComment(ctx, commentId); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Photos.RemovePhoto(ctx, removedPhotoId); err != nil {
+			t.Fatal(err)
+		}
+
+		photos, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers(), r.Likes.WithTotalLikes(), r.Comments.WithTotalComments())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*photos) != 1 || (*photos)[0].TotalLikes != 1 || (*photos)[0].TotalComments != 2 {
+			t.Error("expected the photo with one like and two comments got:", *photos)
+		}
+		mario, err := r.Users.GetFullUser(
+			ctx,
+			r.Follows.WithTotalFollowers(),
+			r.Follows.WithTotalFollowings(),
+			r.Photos.WithTotalPhotos(),
+			r.Users.FilterByUserId(1),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *mario.TotalFollowers != 1 || *mario.TotalFollowings != 0 || *mario.TotalPhotos != 1 {
+			t.Error("expected Mario with one follower and one photo got:", mario)
+		}
+		luigi, err := r.Users.GetFullUser(ctx, r.Follows.WithTotalFollowings(), r.Users.FilterByUserId(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *luigi.TotalFollowings != 1 || luigi.TotalFollowers != nil || luigi.TotalPhotos != nil {
+			t.Error("expected only the followings of Luigi got:", luigi)
+		}
+
+		cr, _ := NewCountersRepository(db)
+		drifts, err := cr.GetCounterDrifts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*drifts) != 0 {
+			t.Error("expected the counters to be correct got:", *drifts)
+		}
+	})
+}
+
+func TestCounters_TrashedPhotos(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		ctx := context.Background()
+		newTestUsersRepository(t, db, "Mario")
+		var photoIds []int
+		for _, url := range []string{"/photo.png", "/trashed.png", "/restored.png", "/purged.png"} {
+			photoId, err := r.Photos.SetPhoto(ctx, url, 1, testDate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			photoIds = append(photoIds, photoId)
+		}
+
+		// Trashing or restoring a photo twice must not change the counter twice
+		for _, photoId := range photoIds[1:] {
+			for i := 0; i < 2; i++ {
+				if err := r.Photos.TrashPhoto(ctx, photoId, testDate); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if err := r.Photos.RestorePhoto(ctx, photoIds[2]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Photos.RemovePhoto(ctx, photoIds[3]); err != nil {
+			t.Fatal(err)
+		}
+
+		mario, err := r.Users.GetFullUser(ctx, r.Photos.WithTotalPhotos(), r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *mario.TotalPhotos != 2 {
+			t.Error("expected Mario with two photos out of the trash got:", *mario.TotalPhotos)
+		}
+		count, err := r.Photos.GetPhotosCount(ctx, r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Error("expected two photos out of the trash got:", count)
+		}
+
+		cr, _ := NewCountersRepository(db)
+		drifts, err := cr.GetCounterDrifts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*drifts) != 0 {
+			t.Error("expected the counters to be correct got:", *drifts)
+		}
+	})
+}
+
+func TestCountersRepository_RepairsDrift(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		cr, _ := NewCountersRepository(db)
+		ctx := context.Background()
+		newTestUsersRepository(t, db, "Mario", "Luigi")
+		photoId, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Likes.SetLike(ctx, photoId, 2, testDate); err != nil {
+			t.Fatal(err)
+		}
+		// A like deleted by hand leaves the counter behind
+		if _, err := db.WriteConn().Exec(ctx, "DELETE FROM likes"); err != nil {
+			t.Fatal(err)
+		}
+
+		drifts, err := cr.GetCounterDrifts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*drifts) != 1 {
+			t.Fatal("expected the likes counter to drift got:", *drifts)
+		}
+		drift := (*drifts)[0]
+		if drift.Table != "photos" || drift.Id != photoId || drift.Column != "total_likes" || drift.Stored != 1 || drift.Actual != 0 {
+			t.Error("expected the likes counter of the photo got:", drift)
+		}
+
+		repaired, err := cr.RepairCounters(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repaired != 1 {
+			t.Error("expected one counter to be repaired got:", repaired)
+		}
+		drifts, err = cr.GetCounterDrifts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*drifts) != 0 {
+			t.Error("expected the counters to be correct got:", *drifts)
+		}
+	})
+}
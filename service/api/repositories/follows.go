@@ -1,6 +1,8 @@
 package repositories
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 
@@ -9,8 +11,8 @@ import (
 
 type FollowsRepository interface {
 	// Setters
-	SetFollow(int, int) error
-	RemoveFollow(int, int) error
+	SetFollow(context.Context, int, int) error
+	RemoveFollow(context.Context, int, int) error
 	// Relation builders
 	FilterByFollowerId(int) Relation
 	FilterByFollowingId(int) Relation
@@ -32,91 +34,92 @@ func NewFollowsRepository(db database.AppDatabase) (FollowsRepository, error) {
 	}, nil
 }
 
-func (r *followsRepository) SetFollow(followerId int, followingId int) error {
-	if _, err := r.Conn().Exec(`
-		INSERT OR IGNORE INTO follows (follower_id, following_id)
-		VALUES (?, ?)
-	`, followerId, followingId); err != nil {
-		return err
-	}
-	return nil
+func (r *followsRepository) SetFollow(ctx context.Context, followerId int, followingId int) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		result, err := tx.WriteConn().Exec(ctx, `
+			INSERT INTO follows (follower_id, following_id)
+			VALUES (?, ?)
+			ON CONFLICT DO NOTHING
+		`, followerId, followingId)
+		if err != nil {
+			return err
+		}
+		return updateFollowCounters(ctx, tx, result, 1, followerId, followingId)
+	})
+}
+
+func (r *followsRepository) RemoveFollow(ctx context.Context, followerId int, followingId int) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		result, err := tx.WriteConn().Exec(ctx, `
+			DELETE FROM follows
+			WHERE follower_id=? AND following_id=?
+		`, followerId, followingId)
+		if err != nil {
+			return err
+		}
+		return updateFollowCounters(ctx, tx, result, -1, followerId, followingId)
+	})
 }
 
-func (r *followsRepository) RemoveFollow(followerId int, followingId int) error {
-	if _, err := r.Conn().Exec(`
-		DELETE FROM follows
-		WHERE follower_id=? AND following_id=?
-	`, followerId, followingId); err != nil {
+// updateFollowCounters adds delta to the counters of the follower and of the followed user, if the follow was
+// actually added or removed
+func updateFollowCounters(ctx context.Context, tx database.AppDatabase, result sql.Result, delta int, followerId int, followingId int) error {
+	err := updateCounter(ctx, tx, result, "UPDATE users SET total_following = total_following + ? WHERE id=?", delta, followerId)
+	if err != nil {
 		return err
 	}
-	return nil
+	return updateCounter(ctx, tx, result, "UPDATE users SET total_followers = total_followers + ? WHERE id=?", delta, followingId)
 }
 
 // Relations builders
 func (r *followsRepository) FilterByFollowerId(followerId int) Relation {
-	return Relation(func(entity string) string {
+	return Relation(func(entity string) Clause {
 		var userIdField string
 		if entity == "user" {
 			userIdField = "id"
 		} else {
 			userIdField = "user_id"
 		}
-		return fmt.Sprintf(`
+		return join(fmt.Sprintf(`
 				INNER JOIN (
 					SELECT following_id FROM follows
-					WHERE follower_id=%d
-				) ON following_id = %ss.%s
+					WHERE follower_id=?
+				) AS followings ON following_id = %ss.%s
 			`,
-			followerId,
 			entity,
 			userIdField,
-		)
+		), followerId)
 	})
 }
 
 func (r *followsRepository) FilterByFollowingId(followingId int) Relation {
-	return Relation(func(entity string) string {
+	return Relation(func(entity string) Clause {
 		var userIdField string
 		if entity == "user" {
 			userIdField = "id"
 		} else {
 			userIdField = "user_id"
 		}
-		return fmt.Sprintf(`
+		return join(fmt.Sprintf(`
 				INNER JOIN (
 					SELECT follower_id FROM follows
-					WHERE following_id=%d
-				) ON follower_id = %ss.%s
+					WHERE following_id=?
+				) AS followers ON follower_id = %ss.%s
 			`,
-			followingId,
 			entity,
 			userIdField,
-		)
+		), followingId)
 	})
 }
 
 func (r *followsRepository) WithTotalFollowers() Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(`
-				LEFT JOIN (
-					SELECT following_id, COUNT(*) AS total_followers FROM follows GROUP BY following_id
-				)
-				ON %ss.id = following_id
-			`,
-			entity,
-		)
+	return Relation(func(entity string) Clause {
+		return column("total_followers", fmt.Sprintf("%ss.total_followers", entity))
 	})
 }
 
 func (r *followsRepository) WithTotalFollowings() Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(`
-				LEFT JOIN (
-					SELECT follower_id, COUNT(*) AS total_following FROM follows GROUP BY follower_id
-				)
-				ON %ss.id = follower_id
-			`,
-			entity,
-		)
+	return Relation(func(entity string) Clause {
+		return column("total_following", fmt.Sprintf("%ss.total_following", entity))
 	})
 }
@@ -1,6 +1,7 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -12,10 +13,10 @@ import (
 
 type LikesRepository interface {
 	// Getters
-	GetLikes(relations ...Relation) (*[]models.Like, error)
+	GetLikes(ctx context.Context, relations ...Relation) (*[]models.Like, error)
 	// Setters
-	SetLike(int, int, time.Time) error
-	RemoveLike(int, int) error
+	SetLike(context.Context, int, int, time.Time) error
+	RemoveLike(context.Context, int, int) error
 	// Relation builders
 	WithTotalLikes() Relation
 	WithLikedBy(int) Relation
@@ -35,33 +36,40 @@ func NewLikesRepository(db database.AppDatabase) (LikesRepository, error) {
 	}, nil
 }
 
-func (r *likesRepository) SetLike(photoId int, userId int, time time.Time) error {
-	if _, err := r.Conn().Exec(`
-		INSERT OR IGNORE INTO likes (photo_id, user_id, date)
-		VALUES (?, ?, ?)
-	`, photoId, userId, time.Format(dateLayout)); err != nil {
-		return err
-	}
-	return nil
+func (r *likesRepository) SetLike(ctx context.Context, photoId int, userId int, time time.Time) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		result, err := tx.WriteConn().Exec(ctx, `
+			INSERT INTO likes (photo_id, user_id, date)
+			VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING
+		`, photoId, userId, formatDate(time))
+		if err != nil {
+			return err
+		}
+		return updateCounter(ctx, tx, result, "UPDATE photos SET total_likes = total_likes + 1 WHERE id=?", photoId)
+	})
 }
 
-func (r *likesRepository) RemoveLike(photoId int, userId int) error {
-	if _, err := r.Conn().Exec(`
-		DELETE FROM likes
-		WHERE photo_id=? AND user_id=?;
-	`, photoId, userId); err != nil {
-		return err
-	}
-	return nil
+func (r *likesRepository) RemoveLike(ctx context.Context, photoId int, userId int) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		result, err := tx.WriteConn().Exec(ctx, `
+			DELETE FROM likes
+			WHERE photo_id=? AND user_id=?;
+		`, photoId, userId)
+		if err != nil {
+			return err
+		}
+		return updateCounter(ctx, tx, result, "UPDATE photos SET total_likes = total_likes - 1 WHERE id=?", photoId)
+	})
 }
 
-func (r *likesRepository) GetLikes(relations ...Relation) (*[]models.Like, error) {
+func (r *likesRepository) GetLikes(ctx context.Context, relations ...Relation) (*[]models.Like, error) {
 	q := queryBuilder("like", relations...)
-	rows, err := r.Conn().Query(fmt.Sprintf(`
+	rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
 		SELECT likes.id, likes.photo_id, likes.user_id, username, likes.date FROM likes
 		%s
 		ORDER BY date DESC
-	`, q))
+	`, q.SQL), q.Args...)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -85,7 +93,7 @@ func (r *likesRepository) GetLikes(relations ...Relation) (*[]models.Like, error
 			return nil, err
 		}
 
-		parsedDate, err := time.Parse(dateLayout, date)
+		parsedDate, err := parseDate(date)
 		if err != nil {
 			return nil, err
 		}
@@ -108,26 +116,22 @@ func (r *likesRepository) GetLikes(relations ...Relation) (*[]models.Like, error
 
 // Relations builders
 func (r *likesRepository) WithTotalLikes() Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(`
-				LEFT JOIN (
-					SELECT %v_id as likes_%[1]vs_id, COUNT(*) AS total_likes FROM likes GROUP BY %[1]v_id
-				)
-				ON %[1]vs.id = likes_%[1]vs_id
-			`,
-			entity,
-		)
+	return Relation(func(entity string) Clause {
+		return column("total_likes", fmt.Sprintf("%ss.total_likes", entity))
 	})
 }
 
 func (r *likesRepository) WithLikedBy(userId int) Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(`
-				LEFT OUTER JOIN (SELECT %v_id AS user_liked_%[1]v_id FROM likes WHERE user_id = %[2]v)
+	return Relation(func(entity string) Clause {
+		clause := join(fmt.Sprintf(`
+				LEFT OUTER JOIN (SELECT %v_id AS user_liked_%[1]v_id FROM likes WHERE user_id = ?) AS %[1]v_liked_by
 				ON user_liked_%[1]v_id = %[1]vs.id
 		`,
 			entity,
-			userId,
-		)
+		), userId)
+		clause.Columns = map[string]string{
+			fmt.Sprintf("user_liked_%s", entity): fmt.Sprintf("CASE WHEN user_liked_%s_id IS NULL THEN 0 ELSE 1 END", entity),
+		}
+		return clause
 	})
 }
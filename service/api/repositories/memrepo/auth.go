@@ -0,0 +1,422 @@
+package memrepo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+// session is a row of the sessions, the relations get a copy of it
+type session struct {
+	models.Session
+	RevokedAt *time.Time
+}
+
+type refreshToken struct {
+	models.RefreshToken
+}
+
+// accessToken is a row of the personal access tokens, the relations get a copy of it
+type accessToken struct {
+	models.PersonalAccessToken
+	RevokedAt *time.Time
+}
+
+// identity is the subject of an OpenID Connect provider
+type identity struct {
+	issuer  string
+	subject string
+}
+
+type authRepository struct {
+	*store
+}
+
+// sessionRows returns copies of the sessions selected by the relations, by id
+func (r *authRepository) sessionRows(relations []repositories.Relation) []*session {
+	ids := make([]int, 0, len(r.sessions))
+	for id := range r.sessions {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var rows []*session
+	for _, id := range ids {
+		row := *r.sessions[id]
+		if selected("user_token", &row, relations) {
+			rows = append(rows, &row)
+		}
+	}
+	return rows
+}
+
+// sessionTokenUsed checks the uniqueness of the token of a session
+func (r *authRepository) sessionTokenUsed(tokenHash string) error {
+	for _, s := range r.sessions {
+		if s.TokenHash == tokenHash {
+			return uniqueError("user_tokens.token")
+		}
+	}
+	return nil
+}
+
+func (r *authRepository) GetSession(ctx context.Context, relations ...repositories.Relation) (*models.Session, error) {
+	var found *models.Session
+	err := r.read(ctx, func() error {
+		if rows := r.sessionRows(relations); len(rows) > 0 {
+			found = &rows[0].Session
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r *authRepository) GetSessions(ctx context.Context, relations ...repositories.Relation) (*[]models.Session, error) {
+	var sessions []models.Session
+	err := r.read(ctx, func() error {
+		for _, row := range r.sessionRows(relations) {
+			// The tokens and their expiration aren't listed
+			sessions = append(sessions, models.Session{
+				Id:         row.Id,
+				UserId:     row.UserId,
+				UserAgent:  row.UserAgent,
+				CreatedAt:  row.CreatedAt,
+				LastUsedAt: row.LastUsedAt,
+			})
+		}
+		sort.SliceStable(sessions, func(i, j int) bool {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sessions, nil
+}
+
+func (r *authRepository) SetSession(ctx context.Context,
+	userId int,
+	tokenHash string,
+	userAgent string,
+	date time.Time,
+	expiresAt time.Time,
+) (int, error) {
+	var id int
+	err := r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		if err := r.sessionTokenUsed(tokenHash); err != nil {
+			return err
+		}
+		id = r.nextId("user_tokens")
+		r.sessions[id] = &session{Session: models.Session{
+			Id:         id,
+			UserId:     userId,
+			TokenHash:  tokenHash,
+			UserAgent:  userAgent,
+			CreatedAt:  storedTime(date),
+			LastUsedAt: storedTime(date),
+			ExpiresAt:  storedTime(expiresAt),
+		}}
+		return nil
+	})
+	return id, err
+}
+
+func (r *authRepository) SetSessionToken(ctx context.Context, sessionId int, tokenHash string, expiresAt time.Time) error {
+	return r.write(ctx, func() error {
+		s, ok := r.sessions[sessionId]
+		if !ok {
+			return nil
+		}
+		if s.TokenHash != tokenHash {
+			if err := r.sessionTokenUsed(tokenHash); err != nil {
+				return err
+			}
+		}
+		s.TokenHash = tokenHash
+		s.ExpiresAt = storedTime(expiresAt)
+		return nil
+	})
+}
+
+func (r *authRepository) SetSessionLastUsed(ctx context.Context, sessionId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if s, ok := r.sessions[sessionId]; ok {
+			s.LastUsedAt = storedTime(date)
+		}
+		return nil
+	})
+}
+
+func (r *authRepository) RevokeSession(ctx context.Context, sessionId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if s, ok := r.sessions[sessionId]; ok && s.RevokedAt == nil {
+			s.RevokedAt = storedTimePtr(&date)
+		}
+		return nil
+	})
+}
+
+// RemoveExpiredSessions deletes the revoked sessions and the expired ones that can't be refreshed anymore
+func (r *authRepository) RemoveExpiredSessions(ctx context.Context, date time.Time) (int, error) {
+	removed := 0
+	err := r.write(ctx, func() error {
+		refreshable := make(map[int]bool)
+		for _, t := range r.refreshTokens {
+			if t.UsedAt == nil && t.ExpiresAt.After(date) {
+				refreshable[t.SessionId] = true
+			}
+		}
+		for id, s := range r.sessions {
+			if s.RevokedAt != nil || (!s.ExpiresAt.After(date) && !refreshable[id]) {
+				delete(r.sessions, id)
+				removed++
+			}
+		}
+		for id, t := range r.refreshTokens {
+			if _, ok := r.sessions[t.SessionId]; !ok || !t.ExpiresAt.After(date) {
+				delete(r.refreshTokens, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (r *authRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var found *models.RefreshToken
+	err := r.read(ctx, func() error {
+		for _, t := range r.refreshTokens {
+			if t.TokenHash == tokenHash {
+				refreshToken := t.RefreshToken
+				found = &refreshToken
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r *authRepository) SetRefreshToken(ctx context.Context, sessionId int, tokenHash string, expiresAt time.Time) error {
+	return r.write(ctx, func() error {
+		if _, ok := r.sessions[sessionId]; !ok {
+			return foreignKeyError("user_tokens")
+		}
+		for _, t := range r.refreshTokens {
+			if t.TokenHash == tokenHash {
+				return uniqueError("refresh_tokens.token")
+			}
+		}
+		id := r.nextId("refresh_tokens")
+		r.refreshTokens[id] = &refreshToken{models.RefreshToken{
+			Id:        id,
+			SessionId: sessionId,
+			TokenHash: tokenHash,
+			ExpiresAt: storedTime(expiresAt),
+		}}
+		return nil
+	})
+}
+
+// SetRefreshTokenUsed marks a refresh token as used, it returns false if the token was already used
+func (r *authRepository) SetRefreshTokenUsed(ctx context.Context, refreshTokenId int, date time.Time) (bool, error) {
+	updated := false
+	err := r.write(ctx, func() error {
+		if t, ok := r.refreshTokens[refreshTokenId]; ok && t.UsedAt == nil {
+			t.UsedAt = storedTimePtr(&date)
+			updated = true
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (r *authRepository) GetPasswordHash(ctx context.Context, userId int) (string, error) {
+	var passwordHash string
+	err := r.read(ctx, func() error {
+		passwordHash = r.passwordHashes[userId]
+		return nil
+	})
+	return passwordHash, err
+}
+
+func (r *authRepository) SetPasswordHash(ctx context.Context, userId int, passwordHash string) error {
+	return r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		r.passwordHashes[userId] = passwordHash
+		return nil
+	})
+}
+
+func (r *authRepository) IsPasswordPending(ctx context.Context, userId int) (bool, error) {
+	var pending bool
+	err := r.read(ctx, func() error {
+		pending = r.pendingPasswords[userId]
+		return nil
+	})
+	return pending, err
+}
+
+func (r *authRepository) SetPasswordPending(ctx context.Context, userId int) error {
+	return r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		r.pendingPasswords[userId] = true
+		return nil
+	})
+}
+
+func (r *authRepository) GetPersonalAccessToken(ctx context.Context, relations ...repositories.Relation) (*models.PersonalAccessToken, error) {
+	tokens, err := r.GetPersonalAccessTokens(ctx, relations...)
+	if err != nil {
+		return nil, err
+	}
+	if len(*tokens) == 0 {
+		return nil, nil
+	}
+	return &(*tokens)[0], nil
+}
+
+func (r *authRepository) GetPersonalAccessTokens(ctx context.Context, relations ...repositories.Relation) (*[]models.PersonalAccessToken, error) {
+	tokens := make([]models.PersonalAccessToken, 0)
+	err := r.read(ctx, func() error {
+		ids := make([]int, 0, len(r.accessTokens))
+		for id := range r.accessTokens {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			row := *r.accessTokens[id]
+			row.Scopes = append([]models.Scope(nil), row.Scopes...)
+			if selected("personal_access_token", &row, relations) {
+				tokens = append(tokens, row.PersonalAccessToken)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+func (r *authRepository) SetPersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) (int, error) {
+	var id int
+	err := r.write(ctx, func() error {
+		if err := r.userExists(token.UserId); err != nil {
+			return err
+		}
+		for _, t := range r.accessTokens {
+			if t.TokenHash == token.TokenHash {
+				return uniqueError("personal_access_tokens.token")
+			}
+		}
+		id = r.nextId("personal_access_tokens")
+		r.accessTokens[id] = &accessToken{PersonalAccessToken: models.PersonalAccessToken{
+			Id:        id,
+			UserId:    token.UserId,
+			Name:      token.Name,
+			TokenHash: token.TokenHash,
+			Scopes:    append([]models.Scope(nil), token.Scopes...),
+			CreatedAt: storedTime(token.CreatedAt),
+			ExpiresAt: storedTimePtr(token.ExpiresAt),
+		}}
+		return nil
+	})
+	return id, err
+}
+
+func (r *authRepository) SetPersonalAccessTokenLastUsed(ctx context.Context, tokenId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if t, ok := r.accessTokens[tokenId]; ok {
+			t.LastUsedAt = storedTimePtr(&date)
+		}
+		return nil
+	})
+}
+
+func (r *authRepository) RevokePersonalAccessToken(ctx context.Context, tokenId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if t, ok := r.accessTokens[tokenId]; ok && t.RevokedAt == nil {
+			t.RevokedAt = storedTimePtr(&date)
+		}
+		return nil
+	})
+}
+
+// GetIdentityUserId returns the user linked to the subject of an OpenID Connect provider, zero if there's none
+func (r *authRepository) GetIdentityUserId(ctx context.Context, issuer string, subject string) (int, error) {
+	var userId int
+	err := r.read(ctx, func() error {
+		userId = r.identities[identity{issuer, subject}]
+		return nil
+	})
+	return userId, err
+}
+
+func (r *authRepository) SetIdentity(ctx context.Context, issuer string, subject string, userId int) error {
+	return r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		if _, ok := r.identities[identity{issuer, subject}]; ok {
+			return uniqueError("user_identities.issuer, user_identities.subject")
+		}
+		r.identities[identity{issuer, subject}] = userId
+		return nil
+	})
+}
+
+// Relations builders
+func (r *authRepository) FilterByTokenHash(tokenHash string) repositories.Relation {
+	return match(func(row interface{}) bool {
+		switch row := row.(type) {
+		case *session:
+			return row.TokenHash == tokenHash
+		case *accessToken:
+			return row.TokenHash == tokenHash
+		}
+		return false
+	})
+}
+
+func (r *authRepository) FilterBySessionId(sessionId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		s, ok := row.(*session)
+		return ok && s.Id == sessionId
+	})
+}
+
+func (r *authRepository) FilterByPersonalAccessTokenId(tokenId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		t, ok := row.(*accessToken)
+		return ok && t.Id == tokenId
+	})
+}
+
+func (r *authRepository) WithoutRevokedSessions() repositories.Relation {
+	return match(func(row interface{}) bool {
+		s, ok := row.(*session)
+		return ok && s.RevokedAt == nil
+	})
+}
+
+func (r *authRepository) WithoutRevokedPersonalAccessTokens() repositories.Relation {
+	return match(func(row interface{}) bool {
+		t, ok := row.(*accessToken)
+		return ok && t.RevokedAt == nil
+	})
+}
@@ -0,0 +1,55 @@
+package memrepo
+
+import (
+	"context"
+
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+type bansRepository struct {
+	*store
+}
+
+func (r *bansRepository) SetBan(ctx context.Context, userId int, bannedId int) error {
+	return r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		if err := r.userExists(bannedId); err != nil {
+			return err
+		}
+		r.bans[pair{userId, bannedId}] = true
+		return nil
+	})
+}
+
+func (r *bansRepository) RemoveBan(ctx context.Context, userId int, bannedId int) error {
+	return r.write(ctx, func() error {
+		delete(r.bans, pair{userId, bannedId})
+		return nil
+	})
+}
+
+func (r *bansRepository) GetBanExists(ctx context.Context, userId int, targetId int) (bool, error) {
+	var exists bool
+	err := r.read(ctx, func() error {
+		exists = r.bans[pair{userId, targetId}]
+		return nil
+	})
+	return exists, err
+}
+
+// Relations builders
+func (r *bansRepository) WithoutBanned(userId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		id, ok := ownerId(row)
+		return ok && !r.bans[pair{userId, id}]
+	})
+}
+
+func (r *bansRepository) WithoutBanners(userId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		id, ok := ownerId(row)
+		return ok && !r.bans[pair{id, userId}]
+	})
+}
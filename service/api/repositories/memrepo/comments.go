@@ -0,0 +1,120 @@
+package memrepo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+type comment struct {
+	Id      int
+	PhotoId int
+	UserId  int
+	Date    time.Time
+	Content string
+}
+
+// commentRow is a comment being selected, the relations fill its owner
+type commentRow struct {
+	models.Comment
+}
+
+type commentsRepository struct {
+	*store
+}
+
+// commentRows returns the rows of the comments selected by the relations, the newest first
+func (r *commentsRepository) commentRows(relations []repositories.Relation) []*commentRow {
+	ids := make([]int, 0, len(r.comments))
+	for id := range r.comments {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var rows []*commentRow
+	for _, id := range ids {
+		c := r.comments[id]
+		row := &commentRow{models.Comment{
+			Id:      c.Id,
+			Date:    c.Date,
+			Content: c.Content,
+			Photo:   models.BasePhoto{Id: c.PhotoId},
+			Owner:   models.BaseUser{Id: c.UserId},
+		}}
+		if selected("comment", row, relations) {
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Date.After(rows[j].Date)
+	})
+	return rows
+}
+
+func (r *commentsRepository) GetCommentById(ctx context.Context, id int, relations ...repositories.Relation) (*models.Comment, error) {
+	var found *models.Comment
+	err := r.read(ctx, func() error {
+		for _, row := range r.commentRows(relations) {
+			if row.Id == id {
+				found = &row.Comment
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r *commentsRepository) GetComments(ctx context.Context, relations ...repositories.Relation) (*[]models.Comment, error) {
+	var comments []models.Comment
+	err := r.read(ctx, func() error {
+		for _, row := range r.commentRows(relations) {
+			comments = append(comments, row.Comment)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comments, nil
+}
+
+func (r *commentsRepository) SetComment(ctx context.Context, photoId int, userId int, date time.Time, content string) (int, error) {
+	var id int
+	err := r.write(ctx, func() error {
+		if _, ok := r.photos[photoId]; !ok {
+			return foreignKeyError("photos")
+		}
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		id = r.nextId("comments")
+		r.comments[id] = &comment{Id: id, PhotoId: photoId, UserId: userId, Date: storedTime(date), Content: content}
+		return nil
+	})
+	return id, err
+}
+
+func (r *commentsRepository) RemoveComment(ctx context.Context, commentId int) error {
+	return r.write(ctx, func() error {
+		delete(r.comments, commentId)
+		return nil
+	})
+}
+
+// Relations builders
+func (r *commentsRepository) WithTotalComments() repositories.Relation {
+	return match(func(row interface{}) bool {
+		if row, ok := row.(*photoRow); ok {
+			row.TotalComments = 0
+			for _, c := range r.comments {
+				if c.PhotoId == row.Id {
+					row.TotalComments++
+				}
+			}
+		}
+		return true
+	})
+}
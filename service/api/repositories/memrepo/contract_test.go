@@ -0,0 +1,1240 @@
+package memrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The tests in this file are the contract of the repositories: they run against the in-memory implementation and
+// against the SQLite one, so that the two behave the same
+
+var testDate = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// implementation is what the contract tests run against: the repositories, along with the unit of work and the
+// counters of the same store
+type implementation struct {
+	*repositories.Repositories
+	UnitOfWork repositories.UnitOfWork
+	Counters   repositories.CountersRepository
+}
+
+// forEachImplementation runs test as a subtest for each implementation, every time with new empty repositories
+func forEachImplementation(t *testing.T, test func(t *testing.T, r *repositories.Repositories)) {
+	forEachStore(t, func(t *testing.T, impl implementation) {
+		test(t, impl.Repositories)
+	})
+}
+
+// forEachStore is forEachImplementation for the tests of the unit of work and of the counters
+func forEachStore(t *testing.T, test func(t *testing.T, impl implementation)) {
+	t.Run("memory", func(t *testing.T) {
+		r := NewRepositories()
+		uow, err := NewUnitOfWork(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters, err := NewCountersRepository(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, implementation{r, uow, counters})
+	})
+	t.Run("sqlite3", func(t *testing.T) {
+		conn, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=on")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every connection to :memory: opens a different database
+		conn.SetMaxOpenConns(1)
+		t.Cleanup(func() { _ = conn.Close() })
+		db, err := database.New(conn, database.SQLite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := repositories.NewRepositories(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uow, err := repositories.NewUnitOfWork(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters, err := repositories.NewCountersRepository(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, implementation{r, uow, counters})
+	})
+}
+
+// createUsers creates the users, their ids are returned in the same order
+func createUsers(t *testing.T, r *repositories.Repositories, usernames ...string) []int {
+	t.Helper()
+	ids := make([]int, len(usernames))
+	for i, username := range usernames {
+		id, err := r.Users.CreateUser(context.Background(), &models.BaseUser{Username: username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func usernamesOf(users *[]models.BaseUser) []string {
+	var names []string
+	for _, user := range *users {
+		names = append(names, user.Username)
+	}
+	return names
+}
+
+func photoIds(photos *[]models.Photo) []int {
+	var ids []int
+	for _, photo := range *photos {
+		ids = append(ids, photo.Id)
+	}
+	return ids
+}
+
+func TestContract_Users(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		ids := createUsers(t, r, "Mario", "Luigi")
+		if !reflect.DeepEqual(ids, []int{1, 2}) {
+			t.Error("expected the ids 1 and 2 got:", ids)
+		}
+
+		if err := r.Users.UpdateUser(ctx, &models.BaseUser{Id: 2, Username: "Wario"}); err != nil {
+			t.Fatal(err)
+		}
+		user, err := r.Users.GetUserById(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || user.Username != "Wario" {
+			t.Error("expected the updated user got:", user)
+		}
+		if user, err := r.Users.GetUserById(ctx, 3); err != nil || user != nil {
+			t.Error("expected no user got:", user, err)
+		}
+
+		user, err = r.Users.GetUser(ctx, r.Users.FilterByUsername("Mario", true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || user.Id != 1 {
+			t.Error("expected Mario got:", user)
+		}
+		for _, same := range []string{"mario", "MARIO", "ｍａｒｉｏ"} {
+			if user, err := r.Users.GetUser(ctx, r.Users.FilterByUsername(same, true)); err != nil || user == nil || user.Id != 1 {
+				t.Error("expected the strict filter to match Mario as", same, "got:", user, err)
+			}
+		}
+		if user, err := r.Users.GetUser(ctx, r.Users.FilterByUsername("Mari", true)); err != nil || user != nil {
+			t.Error("expected the strict filter to match the whole username got:", user, err)
+		}
+
+		users, err := r.Users.GetUsers(ctx, r.Users.FilterByUsername("AR", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(usernamesOf(users), []string{"Mario", "Wario"}) {
+			t.Error("expected Mario and Wario got:", *users)
+		}
+		users, err = r.Users.GetUsers(ctx, r.Users.FilterByUsername("%", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*users) != 0 {
+			t.Error("expected the wildcard to be literal got:", *users)
+		}
+	})
+}
+
+func TestContract_UniqueUsernames(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario", "Luigi")
+
+		if _, err := r.Users.CreateUser(ctx, &models.BaseUser{Username: "MARIO"}); !errors.Is(err, repositories.ErrUsernameTaken) {
+			t.Error("expected", repositories.ErrUsernameTaken, "creating a user got:", err)
+		}
+		if err := r.Users.UpdateUser(ctx, &models.BaseUser{Id: 2, Username: "ｍａｒｉｏ"}); !errors.Is(err, repositories.ErrUsernameTaken) {
+			t.Error("expected", repositories.ErrUsernameTaken, "renaming a user got:", err)
+		}
+		if user, _ := r.Users.GetUserById(ctx, 2); user == nil || user.Username != "Luigi" {
+			t.Error("expected Luigi to keep the name got:", user)
+		}
+
+		// A user can change the case of its own name
+		if err := r.Users.UpdateUser(ctx, &models.BaseUser{Id: 1, Username: "MARIO"}); err != nil {
+			t.Fatal(err)
+		}
+		if user, _ := r.Users.GetUser(ctx, r.Users.FilterByUsername("mario", true)); user == nil || user.Username != "MARIO" {
+			t.Error("expected MARIO got:", user)
+		}
+	})
+}
+
+func TestContract_FollowsAndBans(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario", "Luigi", "Wario")
+		// Luigi and Wario follow Mario, twice to check that duplicates are ignored, and Mario bans Wario
+		for i := 0; i < 2; i++ {
+			for _, followerId := range []int{2, 3} {
+				if err := r.Follows.SetFollow(ctx, followerId, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Bans.SetBan(ctx, 1, 3); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Follows.SetFollow(ctx, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		followers, err := r.Users.GetUsers(ctx, r.Follows.FilterByFollowingId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(usernamesOf(followers), []string{"Luigi", "Wario"}) {
+			t.Error("expected Luigi and Wario following Mario got:", *followers)
+		}
+		followings, err := r.Users.GetUsers(ctx, r.Follows.FilterByFollowerId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(usernamesOf(followings), []string{"Luigi"}) {
+			t.Error("expected Mario following Luigi got:", *followings)
+		}
+		// Wario doesn't see the users who banned him
+		users, err := r.Users.GetUsers(ctx, r.Bans.WithoutBanners(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(usernamesOf(users), []string{"Luigi", "Wario"}) {
+			t.Error("expected Luigi and Wario got:", *users)
+		}
+
+		user, err := r.Users.GetFullUser(ctx,
+			r.Follows.WithTotalFollowers(),
+			r.Follows.WithTotalFollowings(),
+			r.Photos.WithTotalPhotos(),
+			r.Users.FilterByUserId(1),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || *user.TotalFollowers != 2 || *user.TotalFollowings != 1 || *user.TotalPhotos != 0 {
+			t.Error("expected Mario with two followers and one following got:", user)
+		}
+		user, err = r.Users.GetFullUser(ctx, r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || user.TotalFollowers != nil || user.TotalFollowings != nil || user.TotalPhotos != nil {
+			t.Error("expected no totals without their relations got:", user)
+		}
+
+		banned, err := r.Bans.GetBanExists(ctx, 1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !banned {
+			t.Error("expected Wario banned by Mario")
+		}
+		if err := r.Bans.RemoveBan(ctx, 1, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Follows.RemoveFollow(ctx, 3, 1); err != nil {
+			t.Fatal(err)
+		}
+		if banned, err := r.Bans.GetBanExists(ctx, 1, 3); err != nil || banned {
+			t.Error("expected the ban to be removed got:", banned, err)
+		}
+		followers, err = r.Users.GetUsers(ctx, r.Follows.FilterByFollowingId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(usernamesOf(followers), []string{"Luigi"}) {
+			t.Error("expected only Luigi following Mario got:", *followers)
+		}
+	})
+}
+
+func TestContract_PhotosStreamAndProfile(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario", "Luigi", "Wario")
+		// Luigi follows Mario and Wario, but then bans Wario. Mario and Wario upload a photo a day, alternately.
+		for _, followingId := range []int{1, 3} {
+			if err := r.Follows.SetFollow(ctx, 2, followingId); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for day := 0; day < 4; day++ {
+			if _, err := r.Photos.SetPhoto(ctx, "/photo.png", 1+day%2*2, testDate.AddDate(0, 0, day)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Bans.SetBan(ctx, 2, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Likes.SetLike(ctx, 3, 2, testDate); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Comments.SetComment(ctx, 3, 3, testDate, "Nice"); err != nil {
+			t.Fatal(err)
+		}
+
+		stream := func(offset, rowCount int) *[]models.Photo {
+			t.Helper()
+			photos, err := r.Photos.GetPhotos(ctx, offset, rowCount,
+				r.Users.WithUsers(),
+				r.Likes.WithTotalLikes(),
+				r.Comments.WithTotalComments(),
+				r.Likes.WithLikedBy(2),
+				r.Follows.FilterByFollowerId(2),
+				r.Bans.WithoutBanned(2),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return photos
+		}
+		photos := stream(0, 10)
+		if !reflect.DeepEqual(photoIds(photos), []int{3, 1}) {
+			t.Fatal("expected the photos of Mario, newest first, got:", *photos)
+		}
+		photo := (*photos)[0]
+		if photo.Owner.Username != "Mario" || photo.TotalLikes != 1 || photo.TotalComments != 1 || !photo.UserLiked {
+			t.Error("expected the photo of Mario liked by Luigi and commented by Wario got:", photo)
+		}
+		if !photo.UploadDate.Equal(testDate.AddDate(0, 0, 2)) {
+			t.Error("expected the upload date got:", photo.UploadDate)
+		}
+		if photo := (*photos)[1]; photo.TotalLikes != 0 || photo.TotalComments != 0 || photo.UserLiked {
+			t.Error("expected the photo without likes and comments got:", photo)
+		}
+		if photos := stream(1, 1); !reflect.DeepEqual(photoIds(photos), []int{1}) {
+			t.Error("expected the second page with the oldest photo got:", *photos)
+		}
+		if photos := stream(2, 1); len(*photos) != 0 {
+			t.Error("expected no photos after the last page got:", *photos)
+		}
+		count, err := r.Photos.GetPhotosCount(ctx, r.Follows.FilterByFollowerId(2), r.Bans.WithoutBanned(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Error("expected two photos in the stream got:", count)
+		}
+
+		photos, err = r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers(), r.Users.FilterByUserId(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(photoIds(photos), []int{4, 2}) {
+			t.Error("expected the photos of Wario got:", *photos)
+		}
+		if photo := (*photos)[0]; photo.TotalLikes != 0 || photo.UserLiked {
+			t.Error("expected no totals without their relations got:", photo)
+		}
+		user, err := r.Users.GetFullUser(ctx, r.Photos.WithTotalPhotos(), r.Users.FilterByUserId(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || *user.TotalPhotos != 2 {
+			t.Error("expected Wario with two photos got:", user)
+		}
+
+		photoById, err := r.Photos.GetPhotoById(ctx, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if photoById == nil || photoById.Owner.Id != 1 || photoById.Owner.Username != "Mario" || photoById.Url != "/photo.png" {
+			t.Error("expected the photo of Mario got:", photoById)
+		}
+
+		// The likes and the comments of a photo are removed along with it
+		if err := r.Photos.RemovePhoto(ctx, 3); err != nil {
+			t.Fatal(err)
+		}
+		if photo, err := r.Photos.GetPhotoById(ctx, 3); err != nil || photo != nil {
+			t.Error("expected the photo to be removed got:", photo, err)
+		}
+		likes, err := r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Users.FilterByUserId(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		comments, err := r.Comments.GetComments(ctx, r.Users.WithUsers(), r.Users.FilterByUserId(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*likes) != 0 || len(*comments) != 0 {
+			t.Error("expected the likes and the comments to be removed got:", *likes, *comments)
+		}
+	})
+}
+
+func TestContract_LikesAndComments(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario", "Luigi", "Wario")
+		photoId, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, userId := range []int{2, 3, 2} {
+			if err := r.Likes.SetLike(ctx, photoId, userId, testDate.Add(time.Duration(userId)*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		likes, err := r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*likes) != 2 || (*likes)[0].Owner.Username != "Wario" || (*likes)[1].Owner.Username != "Luigi" {
+			t.Fatal("expected the likes of Wario and Luigi, newest first, got:", *likes)
+		}
+		if like := (*likes)[1]; like.Photo.Id != photoId || !like.Date.Equal(testDate.Add(2*time.Hour)) {
+			t.Error("expected the like of Luigi got:", like)
+		}
+		likes, err = r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Users.FilterByUserId(2), r.Photos.FilterByPhotoId(photoId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*likes) != 1 {
+			t.Error("expected the like of Luigi got:", *likes)
+		}
+		if err := r.Likes.RemoveLike(ctx, photoId, 2); err != nil {
+			t.Fatal(err)
+		}
+		likes, err = r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*likes) != 1 || (*likes)[0].Owner.Id != 3 {
+			t.Error("expected only the like of Wario got:", *likes)
+		}
+
+		var commentIds []int
+		for i, content := range []string{"First", "Second"} {
+			commentId, err := r.Comments.SetComment(ctx, photoId, 2, testDate.Add(time.Duration(i)*time.Minute), content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			commentIds = append(commentIds, commentId)
+		}
+		comments, err := r.Comments.GetComments(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*comments) != 2 || (*comments)[0].Content != "Second" || (*comments)[1].Content != "First" {
+			t.Fatal("expected the comments, newest first, got:", *comments)
+		}
+		comment, err := r.Comments.GetCommentById(ctx, commentIds[0], r.Users.WithUsers())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment == nil || comment.Content != "First" || comment.Owner.Username != "Luigi" || comment.Photo.Id != photoId {
+			t.Error("expected the first comment of Luigi got:", comment)
+		}
+		if err := r.Comments.RemoveComment(ctx, commentIds[0]); err != nil {
+			t.Fatal(err)
+		}
+		if comment, err := r.Comments.GetCommentById(ctx, commentIds[0], r.Users.WithUsers()); err != nil || comment != nil {
+			t.Error("expected the comment to be removed got:", comment, err)
+		}
+	})
+}
+
+func TestContract_Trash(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario", "Luigi")
+		var ids []int
+		for day := 0; day < 3; day++ {
+			photoId, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate.AddDate(0, 0, day))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, photoId)
+		}
+		if err := r.Likes.SetLike(ctx, ids[0], 2, testDate); err != nil {
+			t.Fatal(err)
+		}
+		// Trashing a photo twice keeps the first date
+		for i, photoId := range []int{ids[0], ids[1], ids[0]} {
+			if err := r.Photos.TrashPhoto(ctx, photoId, testDate.AddDate(0, 1, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if photo, err := r.Photos.GetPhotoById(ctx, ids[0]); err != nil || photo != nil {
+			t.Error("expected the trashed photo to be hidden got:", photo, err)
+		}
+		count, err := r.Photos.GetPhotosCount(ctx, r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Error("expected one photo out of the trash got:", count)
+		}
+		user, err := r.Users.GetFullUser(ctx, r.Photos.WithTotalPhotos(), r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || *user.TotalPhotos != 1 {
+			t.Error("expected Mario with one photo got:", user)
+		}
+
+		trash, err := r.Photos.GetTrashedPhotos(ctx, r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(photoIds(trash), []int{ids[1], ids[0]}) {
+			t.Fatal("expected the trashed photos, the last trashed first, got:", *trash)
+		}
+		photo := (*trash)[1]
+		if photo.Owner.Username != "Mario" || photo.TotalLikes != 1 || photo.DeletedAt == nil || !photo.DeletedAt.Equal(testDate.AddDate(0, 1, 0)) {
+			t.Error("expected the first trashed photo, liked by Luigi, got:", photo)
+		}
+		trash, err = r.Photos.GetTrashedPhotos(ctx, r.Photos.FilterByDeletedBefore(testDate.AddDate(0, 1, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(photoIds(trash), []int{ids[0]}) {
+			t.Error("expected the photo trashed before the date got:", *trash)
+		}
+		if trash, err := r.Photos.GetTrashedPhotos(ctx, r.Users.FilterByUserId(2)); err != nil || trash == nil || len(*trash) != 0 {
+			t.Error("expected an empty trash got:", trash, err)
+		}
+
+		// A restored photo comes back with its likes
+		if err := r.Photos.RestorePhoto(ctx, ids[0]); err != nil {
+			t.Fatal(err)
+		}
+		photos, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers(), r.Likes.WithTotalLikes(), r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(photoIds(photos), []int{ids[2], ids[0]}) || (*photos)[1].TotalLikes != 1 {
+			t.Error("expected the restored photo with its like got:", *photos)
+		}
+		if photo := (*photos)[1]; photo.DeletedAt != nil {
+			t.Error("expected the restored photo without a deletion date got:", photo.DeletedAt)
+		}
+
+		// A trashed photo can be removed for good
+		if err := r.Photos.RemovePhoto(ctx, ids[1]); err != nil {
+			t.Fatal(err)
+		}
+		if trash, err := r.Photos.GetTrashedPhotos(ctx); err != nil || len(*trash) != 0 {
+			t.Error("expected the removed photo out of the trash got:", trash, err)
+		}
+	})
+}
+
+func TestContract_PhotoVariants(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		withVariants, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		withoutVariants, err := r.Photos.SetPhoto(ctx, "/other.png", 1, testDate.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		variants := []models.PhotoVariant{
+			{Url: "/photo_150.png", Width: 150, Height: 100},
+			{Url: "/photo_640.png", Width: 640, Height: 427},
+		}
+		if err := r.Photos.SetPhotoVariants(ctx, withVariants, variants); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Photos.SetPhotoVariants(ctx, withVariants, variants[:1]); err == nil {
+			t.Error("expected a width to have a single variant")
+		}
+		expected := map[string]models.PhotoVariant{"150": variants[0], "640": variants[1]}
+
+		photo, err := r.Photos.GetPhotoById(ctx, withVariants)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if photo == nil || !reflect.DeepEqual(photo.Variants, expected) {
+			t.Error("expected the variants by width got:", photo)
+		}
+		photos, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers(), r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The newest photo first
+		if len(*photos) != 2 || (*photos)[0].Id != withoutVariants || (*photos)[0].Variants != nil ||
+			!reflect.DeepEqual((*photos)[1].Variants, expected) {
+			t.Error("expected the variants of the first uploaded photo only got:", *photos)
+		}
+
+		if err := r.Photos.TrashPhoto(ctx, withVariants, testDate); err != nil {
+			t.Fatal(err)
+		}
+		trash, err := r.Photos.GetTrashedPhotos(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*trash) != 1 || !reflect.DeepEqual((*trash)[0].Variants, expected) {
+			t.Error("expected the variants of the trashed photo got:", *trash)
+		}
+		if err := r.Photos.RemovePhoto(ctx, withVariants); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Photos.SetPhotoVariants(ctx, withVariants, variants); err == nil {
+			t.Error("expected the variants of a removed photo to be refused")
+		}
+	})
+}
+
+func TestContract_PhotoTakenAt(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		withTakenAt, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		withoutTakenAt, err := r.Photos.SetPhoto(ctx, "/other.png", 1, testDate.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		takenAt := time.Date(2022, 7, 21, 19, 32, 28, 0, time.FixedZone("CEST", 2*60*60))
+		if err := r.Photos.SetPhotoTakenAt(ctx, withTakenAt, takenAt); err != nil {
+			t.Fatal(err)
+		}
+
+		photo, err := r.Photos.GetPhotoById(ctx, withTakenAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if photo == nil || photo.TakenAt == nil || !photo.TakenAt.Equal(takenAt) || photo.TakenAt.Location() != time.UTC {
+			t.Error("expected the photo to be taken at", takenAt.UTC(), "got:", photo)
+		}
+		photos, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers(), r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*photos) != 2 || (*photos)[0].Id != withoutTakenAt || (*photos)[0].TakenAt != nil ||
+			(*photos)[1].TakenAt == nil || !(*photos)[1].TakenAt.Equal(takenAt) {
+			t.Error("expected the date only for the first uploaded photo got:", *photos)
+		}
+
+		if err := r.Photos.TrashPhoto(ctx, withTakenAt, testDate); err != nil {
+			t.Fatal(err)
+		}
+		trash, err := r.Photos.GetTrashedPhotos(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*trash) != 1 || (*trash)[0].TakenAt == nil || !(*trash)[0].TakenAt.Equal(takenAt) {
+			t.Error("expected the date of the trashed photo got:", *trash)
+		}
+	})
+}
+
+func TestContract_PhotoImage(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		described, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notDescribed, err := r.Photos.SetPhoto(ctx, "/other.png", 1, testDate.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		trashed, err := r.Photos.SetPhoto(ctx, "/trashed.png", 1, testDate.Add(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Photos.TrashPhoto(ctx, trashed, testDate); err != nil {
+			t.Fatal(err)
+		}
+		image := models.PhotoImage{
+			Width:    1080,
+			Height:   720,
+			Size:     5 << 30,
+			MimeType: "image/png",
+			BlurHash: "LxH27k2swxX8mHWWjtf7gJfjfQfj",
+		}
+		if err := r.Photos.SetPhotoImage(ctx, described, image); err != nil {
+			t.Fatal(err)
+		}
+
+		photo, err := r.Photos.GetPhotoById(ctx, described)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if photo == nil || photo.PhotoImage != image {
+			t.Error("expected the image of the photo got:", photo)
+		}
+		photos, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers(), r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*photos) != 2 || (*photos)[0].PhotoImage != (models.PhotoImage{}) || (*photos)[1].PhotoImage != image {
+			t.Error("expected the image of the first uploaded photo only got:", *photos)
+		}
+
+		// The photos in the trash are backfilled too
+		without, err := r.Photos.GetPhotosWithoutImage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*without) != 2 || (*without)[0].Id != notDescribed || (*without)[0].Url != "/other.png" ||
+			(*without)[1].Id != trashed {
+			t.Error("expected the photos without image got:", *without)
+		}
+		if err := r.Photos.SetPhotoImage(ctx, trashed, image); err != nil {
+			t.Fatal(err)
+		}
+		trash, err := r.Photos.GetTrashedPhotos(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*trash) != 1 || (*trash)[0].PhotoImage != image {
+			t.Error("expected the image of the trashed photo got:", *trash)
+		}
+	})
+}
+
+func TestContract_PhotoDescription(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		described, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Photos.SetPhoto(ctx, "/other.png", 1, testDate.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		description := models.PhotoDescription{Caption: "Sunset on the lake 🌅", AltText: "An orange sun over the water"}
+		if err := r.Photos.SetPhotoDescription(ctx, described, description); err != nil {
+			t.Fatal(err)
+		}
+
+		photo, err := r.Photos.GetPhotoById(ctx, described)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if photo == nil || photo.PhotoDescription != description {
+			t.Error("expected the description of the photo got:", photo)
+		}
+		photos, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers(), r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*photos) != 2 || (*photos)[0].PhotoDescription != (models.PhotoDescription{}) ||
+			(*photos)[1].PhotoDescription != description {
+			t.Error("expected the description of the first uploaded photo only got:", *photos)
+		}
+
+		// The description is replaced as a whole, and it's kept in the trash
+		description.Caption = ""
+		if err := r.Photos.SetPhotoDescription(ctx, described, description); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Photos.TrashPhoto(ctx, described, testDate); err != nil {
+			t.Fatal(err)
+		}
+		trash, err := r.Photos.GetTrashedPhotos(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*trash) != 1 || (*trash)[0].PhotoDescription != description {
+			t.Error("expected the description of the trashed photo got:", *trash)
+		}
+	})
+}
+
+func TestContract_DatesInUTC(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		// The second photo is uploaded 40 minutes after the first one, once the daylight saving time is over: its local
+		// time is earlier
+		summer := time.FixedZone("CEST", 2*60*60)
+		winter := time.FixedZone("CET", 60*60)
+		dates := []time.Time{
+			time.Date(2022, 10, 30, 2, 30, 0, 123456789, summer),
+			time.Date(2022, 10, 30, 2, 10, 0, 0, winter),
+		}
+		for _, date := range dates {
+			if _, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, date); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		photos, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(photoIds(photos), []int{2, 1}) {
+			t.Fatal("expected the photos, newest first, got:", *photos)
+		}
+		uploadDate := (*photos)[1].UploadDate
+		if uploadDate.Location() != time.UTC || !uploadDate.Equal(dates[0].Truncate(time.Millisecond)) {
+			t.Error("expected the upload date in UTC, to the millisecond, got:", uploadDate)
+		}
+	})
+}
+
+func TestContract_Constraints(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		if _, err := r.Photos.SetPhoto(ctx, "/photo.png", 2, testDate); err == nil {
+			t.Error("expected the photo of a missing user to be refused")
+		}
+		if err := r.Likes.SetLike(ctx, 1, 1, testDate); err == nil {
+			t.Error("expected the like of a missing photo to be refused")
+		}
+		if _, err := r.Comments.SetComment(ctx, 1, 1, testDate, "Nice"); err == nil {
+			t.Error("expected the comment of a missing photo to be refused")
+		}
+		if err := r.Follows.SetFollow(ctx, 1, 2); err == nil {
+			t.Error("expected the follow of a missing user to be refused")
+		}
+		if _, err := r.Auth.SetSession(ctx, 1, "hash", "", testDate, testDate); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Auth.SetSession(ctx, 1, "hash", "", testDate, testDate); err == nil {
+			t.Error("expected a duplicate session token to be refused")
+		}
+		if err := r.Auth.SetIdentity(ctx, "issuer", "subject", 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Auth.SetIdentity(ctx, "issuer", "subject", 1); err == nil {
+			t.Error("expected a duplicate identity to be refused")
+		}
+	})
+}
+
+func TestContract_Sessions(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		expiresAt := testDate.Add(time.Hour)
+		for _, tokenHash := range []string{"first", "second", "third"} {
+			if _, err := r.Auth.SetSession(ctx, 1, tokenHash, "Mario's "+tokenHash, testDate, expiresAt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Auth.SetSessionLastUsed(ctx, 1, testDate.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Auth.SetSessionToken(ctx, 2, "rotated", expiresAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Auth.RevokeSession(ctx, 3, testDate); err != nil {
+			t.Fatal(err)
+		}
+
+		session, err := r.Auth.GetSession(ctx, r.Auth.FilterByTokenHash("rotated"), r.Auth.WithoutRevokedSessions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := models.Session{
+			Id:         2,
+			UserId:     1,
+			TokenHash:  "rotated",
+			UserAgent:  "Mario's second",
+			CreatedAt:  testDate,
+			LastUsedAt: testDate,
+			ExpiresAt:  expiresAt.Add(time.Hour),
+		}
+		if session == nil || session.Id != expected.Id || session.TokenHash != expected.TokenHash ||
+			session.UserAgent != expected.UserAgent || !session.ExpiresAt.Equal(expected.ExpiresAt) {
+			t.Error("expected", expected, "got:", session)
+		}
+		if session, err := r.Auth.GetSession(ctx, r.Auth.FilterBySessionId(3), r.Auth.WithoutRevokedSessions()); err != nil || session != nil {
+			t.Error("expected the revoked session to be filtered out got:", session, err)
+		}
+
+		sessions, err := r.Auth.GetSessions(ctx, r.Users.FilterByUserId(1), r.Auth.WithoutRevokedSessions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*sessions) != 2 || (*sessions)[0].Id != 1 || (*sessions)[1].Id != 2 {
+			t.Fatal("expected the sessions, the last used first, got:", *sessions)
+		}
+		if (*sessions)[0].TokenHash != "" || !(*sessions)[0].ExpiresAt.IsZero() {
+			t.Error("expected the sessions to be listed without their tokens got:", (*sessions)[0])
+		}
+
+		if err := r.Auth.SetRefreshToken(ctx, 2, "refresh", expiresAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		refreshToken, err := r.Auth.GetRefreshToken(ctx, "refresh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshToken == nil || refreshToken.SessionId != 2 || refreshToken.UsedAt != nil {
+			t.Fatal("expected the unused refresh token got:", refreshToken)
+		}
+		for _, expected := range []bool{true, false} {
+			used, err := r.Auth.SetRefreshTokenUsed(ctx, refreshToken.Id, testDate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if used != expected {
+				t.Error("expected the refresh token to be used only once")
+			}
+		}
+		if refreshToken, err := r.Auth.GetRefreshToken(ctx, "refresh"); err != nil || refreshToken.UsedAt == nil {
+			t.Error("expected the refresh token to be used got:", refreshToken, err)
+		}
+
+		// When the first session expires, the revoked one is removed, the first one can't be refreshed and is
+		// removed, the second one hasn't expired yet
+		removed, err := r.Auth.RemoveExpiredSessions(ctx, expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 2 {
+			t.Error("expected two sessions removed got:", removed)
+		}
+		sessions, err = r.Auth.GetSessions(ctx, r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*sessions) != 1 || (*sessions)[0].Id != 2 {
+			t.Error("expected only the second session got:", *sessions)
+		}
+		if _, err := r.Auth.RemoveExpiredSessions(ctx, expiresAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if refreshToken, err := r.Auth.GetRefreshToken(ctx, "refresh"); err != nil || refreshToken != nil {
+			t.Error("expected the expired refresh token to be removed got:", refreshToken, err)
+		}
+	})
+}
+
+func TestContract_CredentialsAndTokens(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		if passwordHash, err := r.Auth.GetPasswordHash(ctx, 1); err != nil || passwordHash != "" {
+			t.Error("expected no password got:", passwordHash, err)
+		}
+		if pending, err := r.Auth.IsPasswordPending(ctx, 1); err != nil || pending {
+			t.Error("expected no pending password got:", pending, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := r.Auth.SetPasswordPending(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if pending, err := r.Auth.IsPasswordPending(ctx, 1); err != nil || !pending {
+			t.Error("expected the pending password got:", pending, err)
+		}
+		for _, passwordHash := range []string{"first", "second"} {
+			if err := r.Auth.SetPasswordHash(ctx, 1, passwordHash); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if passwordHash, err := r.Auth.GetPasswordHash(ctx, 1); err != nil || passwordHash != "second" {
+			t.Error("expected the replaced password got:", passwordHash, err)
+		}
+
+		if userId, err := r.Auth.GetIdentityUserId(ctx, "issuer", "subject"); err != nil || userId != 0 {
+			t.Error("expected no identity got:", userId, err)
+		}
+		if err := r.Auth.SetIdentity(ctx, "issuer", "subject", 1); err != nil {
+			t.Fatal(err)
+		}
+		if userId, err := r.Auth.GetIdentityUserId(ctx, "issuer", "subject"); err != nil || userId != 1 {
+			t.Error("expected the identity of Mario got:", userId, err)
+		}
+
+		expiresAt := testDate.Add(time.Hour)
+		for _, name := range []string{"ci", "backup"} {
+			if _, err := r.Auth.SetPersonalAccessToken(ctx, &models.PersonalAccessToken{
+				UserId:    1,
+				Name:      name,
+				TokenHash: name + "-hash",
+				Scopes:    []models.Scope{"photos:read", "photos:write"},
+				CreatedAt: testDate,
+				ExpiresAt: &expiresAt,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Auth.SetPersonalAccessTokenLastUsed(ctx, 2, testDate); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Auth.RevokePersonalAccessToken(ctx, 1, testDate); err != nil {
+			t.Fatal(err)
+		}
+		token, err := r.Auth.GetPersonalAccessToken(ctx, r.Auth.FilterByTokenHash("backup-hash"), r.Auth.WithoutRevokedPersonalAccessTokens())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token == nil || token.Id != 2 || token.Name != "backup" || token.LastUsedAt == nil || !token.ExpiresAt.Equal(expiresAt) ||
+			!reflect.DeepEqual(token.Scopes, []models.Scope{"photos:read", "photos:write"}) {
+			t.Error("expected the backup token got:", token)
+		}
+		if token, err := r.Auth.GetPersonalAccessToken(ctx,
+			r.Auth.FilterByPersonalAccessTokenId(1),
+			r.Auth.WithoutRevokedPersonalAccessTokens(),
+		); err != nil || token != nil {
+			t.Error("expected the revoked token to be filtered out got:", token, err)
+		}
+		tokens, err := r.Auth.GetPersonalAccessTokens(ctx, r.Users.FilterByUserId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*tokens) != 2 || (*tokens)[0].Name != "ci" || (*tokens)[0].LastUsedAt != nil {
+			t.Error("expected the tokens by id got:", *tokens)
+		}
+	})
+}
+
+func TestContract_TwoFactor(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		if err := r.TwoFactor.SetTOTPSecret(ctx, 2, "secret"); err == nil {
+			t.Error("expected the secret of a missing user to be refused")
+		}
+		if totp, err := r.TwoFactor.GetTOTP(ctx, 1); err != nil || totp != nil {
+			t.Error("expected no secret got:", totp, err)
+		}
+
+		if err := r.TwoFactor.SetTOTPSecret(ctx, 1, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TwoFactor.EnableTOTP(ctx, 1, testDate.Add(time.Microsecond)); err != nil {
+			t.Fatal(err)
+		}
+		for step, expected := range []bool{true, false} {
+			if updated, err := r.TwoFactor.SetTOTPLastUsedStep(ctx, 1, 10); err != nil || updated != expected {
+				t.Error("expected the step", step, "to be updated", expected, "got:", updated, err)
+			}
+		}
+		totp, err := r.TwoFactor.GetTOTP(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totp == nil || totp.Secret != "first" || totp.EnabledAt == nil || !totp.EnabledAt.Equal(testDate) ||
+			totp.LastUsedStep != 10 {
+			t.Error("expected the enabled secret got:", totp)
+		}
+		// A new secret is pending until it's enabled
+		if err := r.TwoFactor.SetTOTPSecret(ctx, 1, "second"); err != nil {
+			t.Fatal(err)
+		}
+		totp, err = r.TwoFactor.GetTOTP(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totp == nil || totp.Secret != "second" || totp.EnabledAt != nil || totp.LastUsedStep != 0 {
+			t.Error("expected the pending secret got:", totp)
+		}
+
+		if err := r.TwoFactor.SetRecoveryCodes(ctx, 1, []string{"a", "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TwoFactor.SetRecoveryCodes(ctx, 1, []string{"b", "c"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, use := range []struct {
+			code     string
+			expected bool
+		}{{"a", false}, {"b", true}, {"b", false}, {"c", true}} {
+			if used, err := r.TwoFactor.UseRecoveryCode(ctx, 1, use.code, testDate); err != nil || used != use.expected {
+				t.Error("expected the use of", use.code, "to be", use.expected, "got:", used, err)
+			}
+		}
+		if err := r.TwoFactor.SetRecoveryCodes(ctx, 1, []string{"d"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TwoFactor.RemoveTOTP(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if totp, err := r.TwoFactor.GetTOTP(ctx, 1); err != nil || totp != nil {
+			t.Error("expected the secret to be removed got:", totp, err)
+		}
+		if used, err := r.TwoFactor.UseRecoveryCode(ctx, 1, "d", testDate); err != nil || used {
+			t.Error("expected the recovery codes to be removed got:", used, err)
+		}
+	})
+}
+
+func TestContract_LoginChallenges(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		ctx := context.Background()
+		createUsers(t, r, "Mario")
+		if err := r.TwoFactor.SetLoginChallenge(ctx, 2, "missing", "", testDate); err == nil {
+			t.Error("expected the challenge of a missing user to be refused")
+		}
+		for i, tokenHash := range []string{"expired", "valid"} {
+			if err := r.TwoFactor.SetLoginChallenge(ctx, 1, tokenHash, "Firefox", testDate.Add(time.Duration(i)*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.TwoFactor.SetLoginChallenge(ctx, 1, "valid", "", testDate); err == nil {
+			t.Error("expected a duplicate challenge token to be refused")
+		}
+
+		challenge, err := r.TwoFactor.GetLoginChallenge(ctx, "valid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge == nil || challenge.UserId != 1 || challenge.UserAgent != "Firefox" ||
+			!challenge.ExpiresAt.Equal(testDate.Add(time.Hour)) {
+			t.Fatal("expected the challenge got:", challenge)
+		}
+		if err := r.TwoFactor.IncrementLoginChallengeAttempts(ctx, challenge.Id); err != nil {
+			t.Fatal(err)
+		}
+		if challenge, err := r.TwoFactor.GetLoginChallenge(ctx, "valid"); err != nil || challenge.Attempts != 1 {
+			t.Error("expected an attempt got:", challenge, err)
+		}
+
+		if removed, err := r.TwoFactor.RemoveExpiredLoginChallenges(ctx, testDate); err != nil || removed != 1 {
+			t.Error("expected the expired challenge to be removed got:", removed, err)
+		}
+		if challenge, err := r.TwoFactor.GetLoginChallenge(ctx, "expired"); err != nil || challenge != nil {
+			t.Error("expected no expired challenge got:", challenge, err)
+		}
+		if err := r.TwoFactor.RemoveLoginChallenge(ctx, challenge.Id); err != nil {
+			t.Fatal(err)
+		}
+		if challenge, err := r.TwoFactor.GetLoginChallenge(ctx, "valid"); err != nil || challenge != nil {
+			t.Error("expected the challenge to be removed got:", challenge, err)
+		}
+	})
+}
+
+func TestContract_UnitOfWork(t *testing.T) {
+	forEachStore(t, func(t *testing.T, impl implementation) {
+		ctx := context.Background()
+		createUsers(t, impl.Repositories, "Mario")
+		sessionId, err := impl.Auth.SetSession(ctx, 1, "hash", "", testDate, testDate.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		errFailed := errors.New("failed")
+
+		// The writes of a failed unit of work are undone, the new rows like the changed ones
+		err = impl.UnitOfWork.Do(ctx, func(r *repositories.Repositories) error {
+			if _, err := r.Users.CreateUser(ctx, &models.BaseUser{Username: "Luigi"}); err != nil {
+				return err
+			}
+			if err := r.Auth.RevokeSession(ctx, sessionId, testDate); err != nil {
+				return err
+			}
+			if err := r.TwoFactor.SetTOTPSecret(ctx, 1, "secret"); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Fatal("expected the error of the work got:", err)
+		}
+		users, err := impl.Users.GetUsers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(usernamesOf(users), []string{"Mario"}) {
+			t.Error("expected the user to be rolled back got:", usernamesOf(users))
+		}
+		if session, err := impl.Auth.GetSession(ctx, impl.Auth.FilterBySessionId(sessionId), impl.Auth.WithoutRevokedSessions()); err != nil || session == nil {
+			t.Error("expected the revocation to be rolled back got:", session, err)
+		}
+		if totp, err := impl.TwoFactor.GetTOTP(ctx, 1); err != nil || totp != nil {
+			t.Error("expected the secret to be rolled back got:", totp, err)
+		}
+
+		err = impl.UnitOfWork.Do(ctx, func(r *repositories.Repositories) error {
+			_, err := r.Users.CreateUser(ctx, &models.BaseUser{Username: "Luigi"})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if users, err := impl.Users.GetUsers(ctx); err != nil || len(*users) != 2 {
+			t.Error("expected the user to be committed got:", users, err)
+		}
+	})
+}
+
+func TestContract_Counters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, impl implementation) {
+		ctx := context.Background()
+		createUsers(t, impl.Repositories, "Mario", "Luigi")
+		if err := impl.Follows.SetFollow(ctx, 2, 1); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := impl.Photos.SetPhoto(ctx, "/photo.png", 1, testDate); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := impl.Likes.SetLike(ctx, 1, 2, testDate); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := impl.Comments.SetComment(ctx, 1, 2, testDate, "Nice"); err != nil {
+			t.Fatal(err)
+		}
+		if err := impl.Photos.TrashPhoto(ctx, 2, testDate); err != nil {
+			t.Fatal(err)
+		}
+
+		// The setters keep the counters
+		drifts, err := impl.Counters.GetCounterDrifts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*drifts) != 0 {
+			t.Error("expected no drift got:", *drifts)
+		}
+		if repaired, err := impl.Counters.RepairCounters(ctx); err != nil || repaired != 0 {
+			t.Error("expected nothing to repair got:", repaired, err)
+		}
+	})
+}
+
+func TestContract_CanceledContext(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, r *repositories.Repositories) {
+		createUsers(t, r, "Mario")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := r.Users.GetUserById(ctx, 1); !errors.Is(err, context.Canceled) {
+			t.Error("expected the read to be canceled got:", err)
+		}
+		if _, err := r.Photos.SetPhoto(ctx, "/photo.png", 1, testDate); !errors.Is(err, context.Canceled) {
+			t.Error("expected the write to be canceled got:", err)
+		}
+		if _, err := r.Photos.GetPhotos(ctx, 0, 10, r.Users.WithUsers()); !errors.Is(err, context.Canceled) {
+			t.Error("expected the query to be canceled got:", err)
+		}
+	})
+}
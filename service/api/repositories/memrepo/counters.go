@@ -0,0 +1,37 @@
+package memrepo
+
+import (
+	"context"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+// countersRepository checks the counters of a store. Its totals are counted from the rows when they're read, there are
+// no counter columns to drift, so there's never a drift to report or to repair.
+type countersRepository struct {
+	*store
+}
+
+// NewCountersRepository creates the counters repository of the store of r, the repositories created by NewRepositories
+func NewCountersRepository(r *repositories.Repositories) (repositories.CountersRepository, error) {
+	s, err := storeOf(r)
+	if err != nil {
+		return nil, err
+	}
+	return &countersRepository{s}, nil
+}
+
+func (r *countersRepository) GetCounterDrifts(ctx context.Context) (*[]models.CounterDrift, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &[]models.CounterDrift{}, nil
+}
+
+func (r *countersRepository) RepairCounters(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
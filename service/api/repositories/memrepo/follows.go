@@ -0,0 +1,75 @@
+package memrepo
+
+import (
+	"context"
+
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+type followsRepository struct {
+	*store
+}
+
+func (r *followsRepository) SetFollow(ctx context.Context, followerId int, followingId int) error {
+	return r.write(ctx, func() error {
+		if err := r.userExists(followerId); err != nil {
+			return err
+		}
+		if err := r.userExists(followingId); err != nil {
+			return err
+		}
+		r.follows[pair{followerId, followingId}] = true
+		return nil
+	})
+}
+
+func (r *followsRepository) RemoveFollow(ctx context.Context, followerId int, followingId int) error {
+	return r.write(ctx, func() error {
+		delete(r.follows, pair{followerId, followingId})
+		return nil
+	})
+}
+
+// countFollows returns the number of follows for which fn returns true
+func (r *followsRepository) countFollows(fn func(follow pair) bool) *int {
+	count := 0
+	for follow := range r.follows {
+		if fn(follow) {
+			count++
+		}
+	}
+	return &count
+}
+
+// Relations builders
+func (r *followsRepository) FilterByFollowerId(followerId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		id, ok := ownerId(row)
+		return ok && r.follows[pair{followerId, id}]
+	})
+}
+
+func (r *followsRepository) FilterByFollowingId(followingId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		id, ok := ownerId(row)
+		return ok && r.follows[pair{id, followingId}]
+	})
+}
+
+func (r *followsRepository) WithTotalFollowings() repositories.Relation {
+	return match(func(row interface{}) bool {
+		if row, ok := row.(*userRow); ok {
+			row.TotalFollowings = r.countFollows(func(follow pair) bool { return follow.from == row.Id })
+		}
+		return true
+	})
+}
+
+func (r *followsRepository) WithTotalFollowers() repositories.Relation {
+	return match(func(row interface{}) bool {
+		if row, ok := row.(*userRow); ok {
+			row.TotalFollowers = r.countFollows(func(follow pair) bool { return follow.to == row.Id })
+		}
+		return true
+	})
+}
@@ -0,0 +1,118 @@
+package memrepo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+type like struct {
+	Id      int
+	PhotoId int
+	UserId  int
+	Date    time.Time
+}
+
+// likeRow is a like being selected, the relations fill its owner
+type likeRow struct {
+	models.Like
+}
+
+type likesRepository struct {
+	*store
+}
+
+// findLike returns the like of a user to a photo, nil if there's none
+func (r *likesRepository) findLike(photoId int, userId int) *like {
+	for _, l := range r.likes {
+		if l.PhotoId == photoId && l.UserId == userId {
+			return l
+		}
+	}
+	return nil
+}
+
+func (r *likesRepository) SetLike(ctx context.Context, photoId int, userId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if _, ok := r.photos[photoId]; !ok {
+			return foreignKeyError("photos")
+		}
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		if r.findLike(photoId, userId) != nil {
+			return nil
+		}
+		id := r.nextId("likes")
+		r.likes[id] = &like{Id: id, PhotoId: photoId, UserId: userId, Date: storedTime(date)}
+		return nil
+	})
+}
+
+func (r *likesRepository) RemoveLike(ctx context.Context, photoId int, userId int) error {
+	return r.write(ctx, func() error {
+		if l := r.findLike(photoId, userId); l != nil {
+			delete(r.likes, l.Id)
+		}
+		return nil
+	})
+}
+
+func (r *likesRepository) GetLikes(ctx context.Context, relations ...repositories.Relation) (*[]models.Like, error) {
+	var likes []models.Like
+	err := r.read(ctx, func() error {
+		ids := make([]int, 0, len(r.likes))
+		for id := range r.likes {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			l := r.likes[id]
+			row := &likeRow{models.Like{
+				Id:    l.Id,
+				Date:  l.Date,
+				Photo: models.BasePhoto{Id: l.PhotoId},
+				Owner: models.BaseUser{Id: l.UserId},
+			}}
+			if selected("like", row, relations) {
+				likes = append(likes, row.Like)
+			}
+		}
+		sort.SliceStable(likes, func(i, j int) bool {
+			return likes[i].Date.After(likes[j].Date)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &likes, nil
+}
+
+// Relations builders
+func (r *likesRepository) WithTotalLikes() repositories.Relation {
+	return match(func(row interface{}) bool {
+		if row, ok := row.(*photoRow); ok {
+			row.TotalLikes = 0
+			for _, l := range r.likes {
+				if l.PhotoId == row.Id {
+					row.TotalLikes++
+				}
+			}
+		}
+		return true
+	})
+}
+
+func (r *likesRepository) WithLikedBy(userId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		if row, ok := row.(*photoRow); ok {
+			row.UserLiked = r.findLike(row.Id, userId) != nil
+		}
+		return true
+	})
+}
@@ -0,0 +1,192 @@
+/*
+Package memrepo implements the repositories in memory, with the same behavior as the SQL ones: the relations filter
+and fill the rows like the joins and the predicates do, the setters enforce the constraints of the schema and remove
+the related rows in cascade.
+
+It's meant for the tests of the services and of the controllers, that get repositories without a database:
+
+	r := memrepo.NewRepositories()
+	userId, err := r.Users.CreateUser(ctx, &models.BaseUser{Username: "Mario"})
+
+NewUnitOfWork and NewCountersRepository build the unit of work and the counters repository of the same store.
+
+The relations built by the repositories of a store can be used only with the getters of the same store, and the ones
+of the SQL repositories only with the SQL getters.
+*/
+package memrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+// ErrConstraint is returned by the setters when a write violates a constraint of the schema
+var ErrConstraint = errors.New("constraint failed")
+
+// store holds the tables shared by the repositories. The relations read the tables while the getters hold the lock.
+type store struct {
+	mu sync.RWMutex
+	tables
+}
+
+// tables are the rows of a store, by table
+type tables struct {
+	lastId map[string]int
+
+	users            map[int]*user
+	passwordHashes   map[int]string
+	pendingPasswords map[int]bool
+	identities       map[identity]int
+	sessions         map[int]*session
+	refreshTokens    map[int]*refreshToken
+	accessTokens     map[int]*accessToken
+	bans             map[pair]bool
+	follows          map[pair]bool
+	photos           map[int]*photo
+	likes            map[int]*like
+	comments         map[int]*comment
+	totps            map[int]*models.TOTP
+	recoveryCodes    map[int]*recoveryCode
+	loginChallenges  map[int]*models.LoginChallenge
+}
+
+// pair is the key of the tables relating two users, e.g. the follower and the followed one
+type pair struct {
+	from int
+	to   int
+}
+
+// NewRepositories creates every repository on the same new, empty, store
+func NewRepositories() *repositories.Repositories {
+	s := &store{tables: tables{
+		lastId:           make(map[string]int),
+		users:            make(map[int]*user),
+		passwordHashes:   make(map[int]string),
+		pendingPasswords: make(map[int]bool),
+		identities:       make(map[identity]int),
+		sessions:         make(map[int]*session),
+		refreshTokens:    make(map[int]*refreshToken),
+		accessTokens:     make(map[int]*accessToken),
+		bans:             make(map[pair]bool),
+		follows:          make(map[pair]bool),
+		photos:           make(map[int]*photo),
+		likes:            make(map[int]*like),
+		comments:         make(map[int]*comment),
+		totps:            make(map[int]*models.TOTP),
+		recoveryCodes:    make(map[int]*recoveryCode),
+		loginChallenges:  make(map[int]*models.LoginChallenge),
+	}}
+
+	return &repositories.Repositories{
+		Auth:      &authRepository{s},
+		Users:     &usersRepository{s},
+		Bans:      &bansRepository{s},
+		Follows:   &followsRepository{s},
+		Photos:    &photosRepository{s},
+		Likes:     &likesRepository{s},
+		Comments:  &commentsRepository{s},
+		TwoFactor: &twoFactorRepository{s},
+	}
+}
+
+// read runs fn holding the lock for reading, unless ctx is already done
+func (s *store) read(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn()
+}
+
+// write runs fn holding the lock for writing, unless ctx is already done
+func (s *store) write(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+// nextId returns the id of a new row of table, ids are never reused
+func (s *store) nextId(table string) int {
+	s.lastId[table]++
+	return s.lastId[table]
+}
+
+// userExists checks the foreign key of a row referencing a user
+func (s *store) userExists(userId int) error {
+	if _, ok := s.users[userId]; !ok {
+		return foreignKeyError("users")
+	}
+	return nil
+}
+
+func foreignKeyError(table string) error {
+	return fmt.Errorf("%w: FOREIGN KEY references %s", ErrConstraint, table)
+}
+
+func uniqueError(column string) error {
+	return fmt.Errorf("%w: UNIQUE %s", ErrConstraint, column)
+}
+
+// match returns a relation whose clause selects the rows for which fn returns true, fn can also fill the columns
+// added by the relation
+func match(fn func(row interface{}) bool) repositories.Relation {
+	return repositories.Relation(func(string) repositories.Clause {
+		return repositories.Clause{Match: fn}
+	})
+}
+
+// selected applies the relations to a row of entity, it returns false if any of them doesn't select it
+func selected(entity string, row interface{}, relations []repositories.Relation) bool {
+	for _, relation := range relations {
+		if relation == nil {
+			continue
+		}
+		if m := relation(entity).Match; m != nil && !m(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// ownerId returns the id of the user a row refers to: the user itself, or the owner of a photo, a like or a comment
+func ownerId(row interface{}) (int, bool) {
+	switch row := row.(type) {
+	case *userRow:
+		return row.Id, true
+	case *photoRow:
+		return row.Owner.Id, true
+	case *likeRow:
+		return row.Owner.Id, true
+	case *commentRow:
+		return row.Owner.Id, true
+	case *session:
+		return row.UserId, true
+	case *accessToken:
+		return row.UserId, true
+	}
+	return 0, false
+}
+
+// storedTime drops what the SQL repositories don't store of a date, its location and the fraction of the millisecond
+func storedTime(date time.Time) time.Time {
+	return date.UTC().Truncate(time.Millisecond)
+}
+
+// storedTimePtr is storedTime for the optional dates
+func storedTimePtr(date *time.Time) *time.Time {
+	if date == nil {
+		return nil
+	}
+	stored := storedTime(*date)
+	return &stored
+}
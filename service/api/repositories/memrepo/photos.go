@@ -0,0 +1,327 @@
+package memrepo
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+type photo struct {
+	Id         int
+	Url        string
+	UserId     int
+	UploadDate time.Time
+	TakenAt    *time.Time
+	DeletedAt  *time.Time
+	// Image is the zero value until it's set
+	Image models.PhotoImage
+	// Description is the caption and the alt text, empty if the owner didn't set them
+	Description models.PhotoDescription
+	// Variants are the resized copies of the photo, by width
+	Variants map[string]models.PhotoVariant
+}
+
+// photoRow is a photo being selected, the relations fill its owner and its totals
+type photoRow struct {
+	models.Photo
+}
+
+type photosRepository struct {
+	*store
+}
+
+func newPhotoRow(p *photo) *photoRow {
+	row := &photoRow{models.Photo{
+		Id:               p.Id,
+		Url:              p.Url,
+		PhotoImage:       p.Image,
+		PhotoDescription: p.Description,
+		UploadDate:       p.UploadDate,
+		TakenAt:          p.TakenAt,
+		Owner:            models.BaseUser{Id: p.UserId},
+		DeletedAt:        p.DeletedAt,
+	}}
+	if len(p.Variants) > 0 {
+		row.Variants = make(map[string]models.PhotoVariant, len(p.Variants))
+		for width, variant := range p.Variants {
+			row.Variants[width] = variant
+		}
+	}
+	return row
+}
+
+// photoRows returns the rows of the photos selected by the relations, among the ones in the trash or out of it, the
+// newest first
+func (r *photosRepository) photoRows(relations []repositories.Relation, trashed bool) []*photoRow {
+	ids := make([]int, 0, len(r.photos))
+	for id := range r.photos {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var rows []*photoRow
+	for _, id := range ids {
+		if (r.photos[id].DeletedAt != nil) != trashed {
+			continue
+		}
+		row := newPhotoRow(r.photos[id])
+		if selected("photo", row, relations) {
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].UploadDate.After(rows[j].UploadDate)
+	})
+	return rows
+}
+
+func (r *photosRepository) GetPhotoById(ctx context.Context, photoId int) (*models.Photo, error) {
+	var found *models.Photo
+	err := r.read(ctx, func() error {
+		p, ok := r.photos[photoId]
+		if !ok || p.DeletedAt != nil {
+			return nil
+		}
+		owner, ok := r.users[p.UserId]
+		if !ok {
+			return nil
+		}
+		found = &newPhotoRow(p).Photo
+		found.Owner.Username = owner.Username
+		return nil
+	})
+	return found, err
+}
+
+func (r *photosRepository) GetPhotos(ctx context.Context, offset, rowCount int, relations ...repositories.Relation) (*[]models.Photo, error) {
+	var photos []models.Photo
+	err := r.read(ctx, func() error {
+		rows := r.photoRows(relations, false)
+		if offset > len(rows) {
+			offset = len(rows)
+		}
+		rows = rows[offset:]
+		if rowCount >= 0 && rowCount < len(rows) {
+			rows = rows[:rowCount]
+		}
+		for _, row := range rows {
+			photos = append(photos, row.Photo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &photos, nil
+}
+
+func (r *photosRepository) GetPhotosCount(ctx context.Context, relations ...repositories.Relation) (int, error) {
+	var count int
+	err := r.read(ctx, func() error {
+		count = len(r.photoRows(relations, false))
+		return nil
+	})
+	return count, err
+}
+
+// GetTrashedPhotos returns the photos in the trash, along with their owner and their totals, the last trashed first
+func (r *photosRepository) GetTrashedPhotos(ctx context.Context, relations ...repositories.Relation) (*[]models.Photo, error) {
+	photos := []models.Photo{}
+	err := r.read(ctx, func() error {
+		rows := r.photoRows(relations, true)
+		sort.SliceStable(rows, func(i, j int) bool {
+			if !rows[i].DeletedAt.Equal(*rows[j].DeletedAt) {
+				return rows[i].DeletedAt.After(*rows[j].DeletedAt)
+			}
+			return rows[i].Id > rows[j].Id
+		})
+		for _, row := range rows {
+			owner, ok := r.users[row.Owner.Id]
+			if !ok {
+				continue
+			}
+			row.Owner.Username = owner.Username
+			for _, l := range r.likes {
+				if l.PhotoId == row.Id {
+					row.TotalLikes++
+				}
+			}
+			for _, c := range r.comments {
+				if c.PhotoId == row.Id {
+					row.TotalComments++
+				}
+			}
+			photos = append(photos, row.Photo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &photos, nil
+}
+
+// GetPhotosWithoutImage returns the id and the url of the photos whose image isn't set, the ones in the trash included
+func (r *photosRepository) GetPhotosWithoutImage(ctx context.Context) (*[]models.Photo, error) {
+	photos := []models.Photo{}
+	err := r.read(ctx, func() error {
+		for _, p := range r.photos {
+			if p.Image.BlurHash == "" {
+				photos = append(photos, models.Photo{Id: p.Id, Url: p.Url})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(photos, func(i, j int) bool {
+		return photos[i].Id < photos[j].Id
+	})
+	return &photos, nil
+}
+
+func (r *photosRepository) SetPhoto(ctx context.Context, url string, userId int, date time.Time) (int, error) {
+	var id int
+	err := r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		id = r.nextId("photos")
+		r.photos[id] = &photo{Id: id, Url: url, UserId: userId, UploadDate: storedTime(date)}
+		return nil
+	})
+	return id, err
+}
+
+// SetPhotoVariants stores the resized copies of a photo
+func (r *photosRepository) SetPhotoVariants(ctx context.Context, photoId int, variants []models.PhotoVariant) error {
+	return r.write(ctx, func() error {
+		p, ok := r.photos[photoId]
+		if !ok {
+			return foreignKeyError("photos")
+		}
+		stored := make(map[string]models.PhotoVariant, len(p.Variants)+len(variants))
+		for width, variant := range p.Variants {
+			stored[width] = variant
+		}
+		for _, variant := range variants {
+			width := strconv.Itoa(variant.Width)
+			if _, ok := stored[width]; ok {
+				return uniqueError("photo_variants.photo_id, photo_variants.width")
+			}
+			stored[width] = variant
+		}
+		p.Variants = stored
+		return nil
+	})
+}
+
+// SetPhotoTakenAt stores the date a photo was taken
+func (r *photosRepository) SetPhotoTakenAt(ctx context.Context, photoId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if p, ok := r.photos[photoId]; ok {
+			p.TakenAt = storedTimePtr(&date)
+		}
+		return nil
+	})
+}
+
+// SetPhotoImage stores what the image of a photo is
+func (r *photosRepository) SetPhotoImage(ctx context.Context, photoId int, image models.PhotoImage) error {
+	return r.write(ctx, func() error {
+		if p, ok := r.photos[photoId]; ok {
+			p.Image = image
+		}
+		return nil
+	})
+}
+
+// SetPhotoDescription stores the caption and the alt text of a photo
+func (r *photosRepository) SetPhotoDescription(ctx context.Context, photoId int, description models.PhotoDescription) error {
+	return r.write(ctx, func() error {
+		if p, ok := r.photos[photoId]; ok {
+			p.Description = description
+		}
+		return nil
+	})
+}
+
+// TrashPhoto moves a photo to the trash at date
+func (r *photosRepository) TrashPhoto(ctx context.Context, photoId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if p, ok := r.photos[photoId]; ok && p.DeletedAt == nil {
+			p.DeletedAt = storedTimePtr(&date)
+		}
+		return nil
+	})
+}
+
+// RestorePhoto moves a photo out of the trash
+func (r *photosRepository) RestorePhoto(ctx context.Context, photoId int) error {
+	return r.write(ctx, func() error {
+		if p, ok := r.photos[photoId]; ok {
+			p.DeletedAt = nil
+		}
+		return nil
+	})
+}
+
+// RemovePhoto removes a photo, in the trash or not, along with its likes and comments
+func (r *photosRepository) RemovePhoto(ctx context.Context, photoId int) error {
+	return r.write(ctx, func() error {
+		delete(r.photos, photoId)
+		for id, l := range r.likes {
+			if l.PhotoId == photoId {
+				delete(r.likes, id)
+			}
+		}
+		for id, c := range r.comments {
+			if c.PhotoId == photoId {
+				delete(r.comments, id)
+			}
+		}
+		return nil
+	})
+}
+
+// Relations builders
+func (r *photosRepository) WithTotalPhotos() repositories.Relation {
+	return match(func(row interface{}) bool {
+		if row, ok := row.(*userRow); ok {
+			total := 0
+			for _, p := range r.photos {
+				if p.UserId == row.Id && p.DeletedAt == nil {
+					total++
+				}
+			}
+			row.TotalPhotos = &total
+		}
+		return true
+	})
+}
+
+func (r *photosRepository) FilterByDeletedBefore(date time.Time) repositories.Relation {
+	return match(func(row interface{}) bool {
+		p, ok := row.(*photoRow)
+		return ok && p.DeletedAt != nil && p.DeletedAt.Before(date)
+	})
+}
+
+func (r *photosRepository) FilterByPhotoId(photoId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		switch row := row.(type) {
+		case *photoRow:
+			return row.Id == photoId
+		case *likeRow:
+			return row.Photo.Id == photoId
+		case *commentRow:
+			return row.Photo.Id == photoId
+		}
+		return false
+	})
+}
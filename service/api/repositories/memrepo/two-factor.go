@@ -0,0 +1,198 @@
+package memrepo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+)
+
+type recoveryCode struct {
+	Id       int
+	UserId   int
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+type twoFactorRepository struct {
+	*store
+}
+
+func (r *twoFactorRepository) GetTOTP(ctx context.Context, userId int) (*models.TOTP, error) {
+	var found *models.TOTP
+	err := r.read(ctx, func() error {
+		if totp, ok := r.totps[userId]; ok {
+			row := *totp
+			found = &row
+		}
+		return nil
+	})
+	return found, err
+}
+
+// SetTOTPSecret stores a pending secret, replacing the previous one
+func (r *twoFactorRepository) SetTOTPSecret(ctx context.Context, userId int, secret string) error {
+	return r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		r.totps[userId] = &models.TOTP{UserId: userId, Secret: secret}
+		return nil
+	})
+}
+
+func (r *twoFactorRepository) EnableTOTP(ctx context.Context, userId int, date time.Time) error {
+	return r.write(ctx, func() error {
+		if totp, ok := r.totps[userId]; ok {
+			totp.EnabledAt = storedTimePtr(&date)
+		}
+		return nil
+	})
+}
+
+// SetTOTPLastUsedStep records the time step of an accepted code, it returns false if a code of the same or of a later
+// step was already accepted
+func (r *twoFactorRepository) SetTOTPLastUsedStep(ctx context.Context, userId int, step int64) (bool, error) {
+	updated := false
+	err := r.write(ctx, func() error {
+		if totp, ok := r.totps[userId]; ok && totp.LastUsedStep < step {
+			totp.LastUsedStep = step
+			updated = true
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// RemoveTOTP disables the two-factor authentication, along with the recovery codes
+func (r *twoFactorRepository) RemoveTOTP(ctx context.Context, userId int) error {
+	return r.write(ctx, func() error {
+		r.removeRecoveryCodes(userId)
+		delete(r.totps, userId)
+		return nil
+	})
+}
+
+// SetRecoveryCodes replaces the recovery codes of a user
+func (r *twoFactorRepository) SetRecoveryCodes(ctx context.Context, userId int, codeHashes []string) error {
+	return r.write(ctx, func() error {
+		if len(codeHashes) > 0 {
+			if err := r.userExists(userId); err != nil {
+				return err
+			}
+		}
+		r.removeRecoveryCodes(userId)
+		for _, codeHash := range codeHashes {
+			id := r.nextId("user_recovery_codes")
+			r.recoveryCodes[id] = &recoveryCode{Id: id, UserId: userId, CodeHash: codeHash}
+		}
+		return nil
+	})
+}
+
+func (r *twoFactorRepository) removeRecoveryCodes(userId int) {
+	for id, code := range r.recoveryCodes {
+		if code.UserId == userId {
+			delete(r.recoveryCodes, id)
+		}
+	}
+}
+
+// UseRecoveryCode marks an unused recovery code as used, it returns false if there's no such code
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userId int, codeHash string, date time.Time) (bool, error) {
+	updated := false
+	err := r.write(ctx, func() error {
+		ids := make([]int, 0, len(r.recoveryCodes))
+		for id := range r.recoveryCodes {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			code := r.recoveryCodes[id]
+			if code.UserId == userId && code.CodeHash == codeHash && code.UsedAt == nil {
+				code.UsedAt = storedTimePtr(&date)
+				updated = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (r *twoFactorRepository) GetLoginChallenge(ctx context.Context, tokenHash string) (*models.LoginChallenge, error) {
+	var found *models.LoginChallenge
+	err := r.read(ctx, func() error {
+		for _, challenge := range r.loginChallenges {
+			if challenge.TokenHash == tokenHash {
+				row := *challenge
+				found = &row
+				return nil
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r *twoFactorRepository) SetLoginChallenge(ctx context.Context,
+	userId int,
+	tokenHash string,
+	userAgent string,
+	expiresAt time.Time,
+) error {
+	return r.write(ctx, func() error {
+		if err := r.userExists(userId); err != nil {
+			return err
+		}
+		for _, challenge := range r.loginChallenges {
+			if challenge.TokenHash == tokenHash {
+				return uniqueError("login_challenges.token")
+			}
+		}
+		id := r.nextId("login_challenges")
+		r.loginChallenges[id] = &models.LoginChallenge{
+			Id:        id,
+			UserId:    userId,
+			TokenHash: tokenHash,
+			UserAgent: userAgent,
+			ExpiresAt: storedTime(expiresAt),
+		}
+		return nil
+	})
+}
+
+func (r *twoFactorRepository) IncrementLoginChallengeAttempts(ctx context.Context, challengeId int) error {
+	return r.write(ctx, func() error {
+		if challenge, ok := r.loginChallenges[challengeId]; ok {
+			challenge.Attempts++
+		}
+		return nil
+	})
+}
+
+func (r *twoFactorRepository) RemoveLoginChallenge(ctx context.Context, challengeId int) error {
+	return r.write(ctx, func() error {
+		delete(r.loginChallenges, challengeId)
+		return nil
+	})
+}
+
+func (r *twoFactorRepository) RemoveExpiredLoginChallenges(ctx context.Context, date time.Time) (int, error) {
+	removed := 0
+	err := r.write(ctx, func() error {
+		for id, challenge := range r.loginChallenges {
+			if !challenge.ExpiresAt.After(date) {
+				delete(r.loginChallenges, id)
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
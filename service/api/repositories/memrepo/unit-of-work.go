@@ -0,0 +1,153 @@
+package memrepo
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+)
+
+// ErrNotInMemory is returned when the repositories given to build a unit of work or a repository on their store aren't
+// the ones of NewRepositories
+var ErrNotInMemory = errors.New("the repositories aren't in memory")
+
+type unitOfWork struct {
+	repositories *repositories.Repositories
+	store        *store
+
+	// mu serializes the units of work, like the write transactions of SQLite
+	mu sync.Mutex
+}
+
+// NewUnitOfWork creates the unit of work of the store of r, the repositories created by NewRepositories. Like the SQL
+// one, the work is undone if it fails: the store is restored to the snapshot taken before it. The units of work run
+// one at a time, the writes made meanwhile by the repositories outside of them are undone too.
+func NewUnitOfWork(r *repositories.Repositories) (repositories.UnitOfWork, error) {
+	s, err := storeOf(r)
+	if err != nil {
+		return nil, err
+	}
+	return &unitOfWork{repositories: r, store: s}, nil
+}
+
+func (u *unitOfWork) Do(ctx context.Context, fn func(r *repositories.Repositories) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	snapshot := u.store.snapshot()
+	if err := fn(u.repositories); err != nil {
+		u.store.restore(snapshot)
+		return err
+	}
+	return nil
+}
+
+// storeOf returns the store of the repositories created by NewRepositories
+func storeOf(r *repositories.Repositories) (*store, error) {
+	if r == nil {
+		return nil, ErrNotInMemory
+	}
+	users, ok := r.Users.(*usersRepository)
+	if !ok {
+		return nil, ErrNotInMemory
+	}
+	return users.store, nil
+}
+
+// snapshot returns a copy of the tables of the store. The rows are copied one by one since the setters change them in
+// place, their maps and slices are replaced instead, so they're shared with the copy.
+func (s *store) snapshot() tables {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t := tables{
+		lastId:           make(map[string]int, len(s.lastId)),
+		users:            make(map[int]*user, len(s.users)),
+		passwordHashes:   make(map[int]string, len(s.passwordHashes)),
+		pendingPasswords: make(map[int]bool, len(s.pendingPasswords)),
+		identities:       make(map[identity]int, len(s.identities)),
+		sessions:         make(map[int]*session, len(s.sessions)),
+		refreshTokens:    make(map[int]*refreshToken, len(s.refreshTokens)),
+		accessTokens:     make(map[int]*accessToken, len(s.accessTokens)),
+		bans:             make(map[pair]bool, len(s.bans)),
+		follows:          make(map[pair]bool, len(s.follows)),
+		photos:           make(map[int]*photo, len(s.photos)),
+		likes:            make(map[int]*like, len(s.likes)),
+		comments:         make(map[int]*comment, len(s.comments)),
+		totps:            make(map[int]*models.TOTP, len(s.totps)),
+		recoveryCodes:    make(map[int]*recoveryCode, len(s.recoveryCodes)),
+		loginChallenges:  make(map[int]*models.LoginChallenge, len(s.loginChallenges)),
+	}
+	for k, v := range s.lastId {
+		t.lastId[k] = v
+	}
+	for k, v := range s.users {
+		row := *v
+		t.users[k] = &row
+	}
+	for k, v := range s.passwordHashes {
+		t.passwordHashes[k] = v
+	}
+	for k, v := range s.pendingPasswords {
+		t.pendingPasswords[k] = v
+	}
+	for k, v := range s.identities {
+		t.identities[k] = v
+	}
+	for k, v := range s.sessions {
+		row := *v
+		t.sessions[k] = &row
+	}
+	for k, v := range s.refreshTokens {
+		row := *v
+		t.refreshTokens[k] = &row
+	}
+	for k, v := range s.accessTokens {
+		row := *v
+		t.accessTokens[k] = &row
+	}
+	for k, v := range s.bans {
+		t.bans[k] = v
+	}
+	for k, v := range s.follows {
+		t.follows[k] = v
+	}
+	for k, v := range s.photos {
+		row := *v
+		t.photos[k] = &row
+	}
+	for k, v := range s.likes {
+		row := *v
+		t.likes[k] = &row
+	}
+	for k, v := range s.comments {
+		row := *v
+		t.comments[k] = &row
+	}
+	for k, v := range s.totps {
+		row := *v
+		t.totps[k] = &row
+	}
+	for k, v := range s.recoveryCodes {
+		row := *v
+		t.recoveryCodes[k] = &row
+	}
+	for k, v := range s.loginChallenges {
+		row := *v
+		t.loginChallenges[k] = &row
+	}
+	return t
+}
+
+// restore replaces the tables of the store with a snapshot, which can't be restored again
+func (s *store) restore(t tables) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tables = t
+}
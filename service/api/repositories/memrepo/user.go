@@ -0,0 +1,175 @@
+package memrepo
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/usernames"
+)
+
+type user struct {
+	Id       int
+	Username string
+}
+
+// userRow is a user being selected, the relations fill its totals
+type userRow struct {
+	models.FullUser
+}
+
+type usersRepository struct {
+	*store
+}
+
+// userRows returns the rows of the users selected by the relations, by id
+func (r *usersRepository) userRows(relations []repositories.Relation) []*userRow {
+	ids := make([]int, 0, len(r.users))
+	for id := range r.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var rows []*userRow
+	for _, id := range ids {
+		row := &userRow{models.FullUser{BaseUser: models.BaseUser{Id: id, Username: r.users[id].Username}}}
+		if selected("user", row, relations) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func (r *usersRepository) GetUserById(ctx context.Context, id int) (*models.BaseUser, error) {
+	var found *models.BaseUser
+	err := r.read(ctx, func() error {
+		if u, ok := r.users[id]; ok {
+			found = &models.BaseUser{Id: u.Id, Username: u.Username}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r *usersRepository) GetUser(ctx context.Context, relations ...repositories.Relation) (*models.BaseUser, error) {
+	var found *models.BaseUser
+	err := r.read(ctx, func() error {
+		if rows := r.userRows(relations); len(rows) > 0 {
+			found = &rows[0].BaseUser
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r *usersRepository) GetFullUser(ctx context.Context, relations ...repositories.Relation) (*models.FullUser, error) {
+	var found *models.FullUser
+	err := r.read(ctx, func() error {
+		if rows := r.userRows(relations); len(rows) > 0 {
+			found = &rows[0].FullUser
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r *usersRepository) GetUsers(ctx context.Context, relations ...repositories.Relation) (*[]models.BaseUser, error) {
+	var users []models.BaseUser
+	err := r.read(ctx, func() error {
+		for _, row := range r.userRows(relations) {
+			users = append(users, row.BaseUser)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &users, nil
+}
+
+// usernameTaken reports whether another user than id has the same username, once normalized
+func (r *usersRepository) usernameTaken(username string, id int) bool {
+	key := usernames.Key(username)
+	for _, u := range r.users {
+		if u.Id != id && usernames.Key(u.Username) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *usersRepository) CreateUser(ctx context.Context, u *models.BaseUser) (int, error) {
+	var id int
+	err := r.write(ctx, func() error {
+		if r.usernameTaken(u.Username, 0) {
+			return repositories.ErrUsernameTaken
+		}
+		id = r.nextId("users")
+		r.users[id] = &user{Id: id, Username: u.Username}
+		return nil
+	})
+	return id, err
+}
+
+func (r *usersRepository) UpdateUser(ctx context.Context, u *models.BaseUser) error {
+	return r.write(ctx, func() error {
+		stored, ok := r.users[u.Id]
+		if !ok {
+			return nil
+		}
+		if r.usernameTaken(u.Username, u.Id) {
+			return repositories.ErrUsernameTaken
+		}
+		stored.Username = u.Username
+		return nil
+	})
+}
+
+// Relations builders
+func (r *usersRepository) WithUsers() repositories.Relation {
+	return match(func(row interface{}) bool {
+		var owner *models.BaseUser
+		switch row := row.(type) {
+		case *photoRow:
+			owner = &row.Owner
+		case *likeRow:
+			owner = &row.Owner
+		case *commentRow:
+			owner = &row.Owner
+		default:
+			return true
+		}
+		u, ok := r.users[owner.Id]
+		if !ok {
+			return false
+		}
+		owner.Username = u.Username
+		return true
+	})
+}
+
+func (r *usersRepository) FilterByUserId(userId int) repositories.Relation {
+	return match(func(row interface{}) bool {
+		id, ok := ownerId(row)
+		return ok && id == userId
+	})
+}
+
+func (r *usersRepository) FilterByUsername(username string, strict bool) repositories.Relation {
+	return match(func(row interface{}) bool {
+		id, ok := ownerId(row)
+		if !ok {
+			return false
+		}
+		u, ok := r.users[id]
+		if !ok {
+			return false
+		}
+		if strict {
+			return usernames.Key(u.Username) == usernames.Key(username)
+		}
+		return strings.Contains(usernames.Key(u.Username), usernames.Key(username))
+	})
+}
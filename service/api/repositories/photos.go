@@ -1,26 +1,71 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/database"
 )
 
+// PhotosRepository gets the photos that aren't in the trash, except for GetTrashedPhotos
 type PhotosRepository interface {
 	// Getters
-	GetPhotoById(int) (*models.Photo, error)
-	GetPhotos(int, int, ...Relation) (*[]models.Photo, error)
-	GetPhotosCount(...Relation) (int, error)
+	GetPhotoById(context.Context, int) (*models.Photo, error)
+	GetPhotos(context.Context, int, int, ...Relation) (*[]models.Photo, error)
+	GetPhotosCount(context.Context, ...Relation) (int, error)
+	GetTrashedPhotos(context.Context, ...Relation) (*[]models.Photo, error)
+	GetPhotosWithoutImage(context.Context) (*[]models.Photo, error)
 	// Setters
-	SetPhoto(string, int, time.Time) (int, error)
-	RemovePhoto(int) error
+	SetPhoto(context.Context, string, int, time.Time) (int, error)
+	SetPhotoVariants(context.Context, int, []models.PhotoVariant) error
+	SetPhotoTakenAt(context.Context, int, time.Time) error
+	SetPhotoImage(context.Context, int, models.PhotoImage) error
+	SetPhotoDescription(context.Context, int, models.PhotoDescription) error
+	TrashPhoto(context.Context, int, time.Time) error
+	RestorePhoto(context.Context, int) error
+	RemovePhoto(context.Context, int) error
 	// Relation builders
 	WithTotalPhotos() Relation
 	FilterByPhotoId(int) Relation
+	FilterByDeletedBefore(time.Time) Relation
+}
+
+// notTrashed and trashed are the relations selecting the photos out of the trash, and the ones in it
+var (
+	notTrashed = Relation(func(string) Clause { return where("photos.deleted_at IS NULL") })
+	trashed    = Relation(func(string) Clause { return where("photos.deleted_at IS NOT NULL") })
+)
+
+// photoImageColumns are the columns of the image of a photo, in the order they're scanned by nullPhotoImage
+const photoImageColumns = "photos.width, photos.height, photos.size, photos.mime_type, photos.blur_hash"
+
+// photoDescriptionColumns are the columns of the caption and the alt text of a photo, scanned after the image ones
+const photoDescriptionColumns = "photos.caption, photos.alt_text"
+
+// nullPhotoImage scans the image of a photo, whose columns are NULL until it's backfilled
+type nullPhotoImage struct {
+	width, height, size sql.NullInt64
+	mimeType, blurHash  sql.NullString
+}
+
+// dest returns the destinations of the photoImageColumns
+func (i *nullPhotoImage) dest() []interface{} {
+	return []interface{}{&i.width, &i.height, &i.size, &i.mimeType, &i.blurHash}
+}
+
+func (i *nullPhotoImage) photoImage() models.PhotoImage {
+	return models.PhotoImage{
+		Width:    int(i.width.Int64),
+		Height:   int(i.height.Int64),
+		Size:     i.size.Int64,
+		MimeType: i.mimeType.String,
+		BlurHash: i.blurHash.String,
+	}
 }
 
 type photosRepository struct {
@@ -37,45 +82,68 @@ func NewPhotosRepository(db database.AppDatabase) (PhotosRepository, error) {
 	}, nil
 }
 
-func (r *photosRepository) GetPhotoById(photoId int) (*models.Photo, error) {
+func (r *photosRepository) GetPhotoById(ctx context.Context, photoId int) (*models.Photo, error) {
 	var photo models.Photo
 	var uploadDate string
-	err := r.Conn().QueryRow(`
-		SELECT photos.id, url, user_id, users.username, upload_date FROM photos
+	var takenAt sql.NullString
+	var image nullPhotoImage
+	err := r.Conn().QueryRow(ctx, `
+		SELECT photos.id, url, user_id, users.username, upload_date, taken_at, `+photoImageColumns+`, `+photoDescriptionColumns+` FROM photos
 		INNER JOIN users ON users.id = user_id
-		WHERE photos.id=?;
-	`, photoId).Scan(&photo.Id, &photo.Url, &photo.Owner.Id, &photo.Owner.Username, &uploadDate)
+		WHERE photos.id=? AND photos.deleted_at IS NULL;
+	`, photoId).Scan(append(
+		[]interface{}{&photo.Id, &photo.Url, &photo.Owner.Id, &photo.Owner.Username, &uploadDate, &takenAt},
+		append(image.dest(), &photo.Caption, &photo.AltText)...,
+	)...)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	date, err := time.Parse(dateLayout, uploadDate)
+	date, err := parseDate(uploadDate)
 	if err != nil {
 		return nil, err
 	}
 	photo.UploadDate = date
-	return &photo, nil
+	if photo.TakenAt, err = parseNullDate(takenAt); err != nil {
+		return nil, err
+	}
+	photo.PhotoImage = image.photoImage()
+	photos := []models.Photo{photo}
+	if err := r.withVariants(ctx, photos); err != nil {
+		return nil, err
+	}
+	return &photos[0], nil
 }
 
-func (r *photosRepository) GetPhotos(offset, rowCount int, relations ...Relation) (*[]models.Photo, error) {
-	q := queryBuilder("photo", relations...)
-	rows, err := r.Conn().Query(fmt.Sprintf(`
+func (r *photosRepository) GetPhotos(ctx context.Context, offset, rowCount int, relations ...Relation) (*[]models.Photo, error) {
+	q := queryBuilder("photo", append([]Relation{notTrashed}, relations...)...)
+	rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
 		SELECT
 			photos.id,
 			url,
 			user_id,
 			users.username,
 			upload_date,
-			CASE WHEN total_likes IS NULL THEN 0 ELSE total_likes END as total_likes,
-			CASE WHEN total_comments IS NULL THEN 0 ELSE total_comments END as total_comments,
-			CASE WHEN user_liked_photo_id IS NULL THEN 0 ELSE 1 END as user_liked_photo
+			taken_at,
+			%s,
+			%s,
+			%s,
+			%s,
+			%s
 		FROM photos
 		%s
 		ORDER BY upload_date DESC
 		LIMIT ? OFFSET ?;
-	`, q), rowCount, offset)
+	`,
+		q.column("total_likes", "0"),
+		q.column("total_comments", "0"),
+		q.column("user_liked_photo", "0"),
+		photoImageColumns,
+		photoDescriptionColumns,
+		q.SQL,
+	), append(q.Args, rowCount, offset)...)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -94,23 +162,36 @@ func (r *photosRepository) GetPhotos(offset, rowCount int, relations ...Relation
 		var ownerId int
 		var ownerUsername string
 		var uploadDate string
+		var takenAt sql.NullString
 		var totalLikes int
 		var totalComments int
 		var userLiked bool
-		err = rows.Scan(&id, &url, &ownerId, &ownerUsername, &uploadDate, &totalLikes, &totalComments, &userLiked)
+		var image nullPhotoImage
+		var description models.PhotoDescription
+		err = rows.Scan(append(
+			[]interface{}{&id, &url, &ownerId, &ownerUsername, &uploadDate, &takenAt, &totalLikes, &totalComments, &userLiked},
+			append(image.dest(), &description.Caption, &description.AltText)...,
+		)...)
 		if err != nil {
 			return nil, err
 		}
 
-		date, err := time.Parse(dateLayout, uploadDate)
+		date, err := parseDate(uploadDate)
+		if err != nil {
+			return nil, err
+		}
+		takenAtDate, err := parseNullDate(takenAt)
 		if err != nil {
 			return nil, err
 		}
 
 		photos = append(photos, models.Photo{
-			Id:         id,
-			Url:        url,
-			UploadDate: date,
+			Id:               id,
+			Url:              url,
+			PhotoImage:       image.photoImage(),
+			PhotoDescription: description,
+			UploadDate:       date,
+			TakenAt:          takenAtDate,
 			Owner: models.BaseUser{
 				Id:       ownerId,
 				Username: ownerUsername,
@@ -120,17 +201,23 @@ func (r *photosRepository) GetPhotos(offset, rowCount int, relations ...Relation
 			UserLiked:     userLiked,
 		})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.withVariants(ctx, photos); err != nil {
+		return nil, err
+	}
 
 	return &photos, nil
 }
 
-func (r *photosRepository) GetPhotosCount(relations ...Relation) (int, error) {
-	q := queryBuilder("photo", relations...)
+func (r *photosRepository) GetPhotosCount(ctx context.Context, relations ...Relation) (int, error) {
+	q := queryBuilder("photo", append([]Relation{notTrashed}, relations...)...)
 	var count int
-	err := r.Conn().QueryRow(fmt.Sprintf(`
+	err := r.Conn().QueryRow(ctx, fmt.Sprintf(`
 		SELECT COUNT(*) FROM photos
 		%s;
-	`, q)).Scan(&count)
+	`, q.SQL), q.Args...).Scan(&count)
 	if errors.Is(err, sql.ErrNoRows) {
 		return 0, nil
 	}
@@ -141,55 +228,279 @@ func (r *photosRepository) GetPhotosCount(relations ...Relation) (int, error) {
 	return count, nil
 }
 
-func (r *photosRepository) SetPhoto(url string, userId int, time time.Time) (int, error) {
-	result, err := r.Conn().Exec(`
-		INSERT INTO photos (url, user_id, upload_date)
-		VALUES (?, ?, ?);
-	`, url, userId, time.Format(dateLayout))
+// GetTrashedPhotos returns the photos in the trash, along with their owner and their totals, the last trashed first
+func (r *photosRepository) GetTrashedPhotos(ctx context.Context, relations ...Relation) (*[]models.Photo, error) {
+	q := queryBuilder("photo", append([]Relation{trashed}, relations...)...)
+	rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
+		SELECT
+			photos.id,
+			url,
+			user_id,
+			users.username,
+			upload_date,
+			taken_at,
+			photos.total_likes,
+			photos.total_comments,
+			photos.deleted_at,
+			%s,
+			%s
+		FROM photos
+		INNER JOIN users ON users.id = photos.user_id
+		%s
+		ORDER BY photos.deleted_at DESC, photos.id DESC;
+	`, photoImageColumns, photoDescriptionColumns, q.SQL), q.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	photos := []models.Photo{}
+	for rows.Next() {
+		var photo models.Photo
+		var uploadDate, deletedAt string
+		var takenAt sql.NullString
+		var image nullPhotoImage
+		err := rows.Scan(append([]interface{}{
+			&photo.Id,
+			&photo.Url,
+			&photo.Owner.Id,
+			&photo.Owner.Username,
+			&uploadDate,
+			&takenAt,
+			&photo.TotalLikes,
+			&photo.TotalComments,
+			&deletedAt,
+		}, append(image.dest(), &photo.Caption, &photo.AltText)...)...)
+		if err != nil {
+			return nil, err
+		}
+		photo.PhotoImage = image.photoImage()
+		if photo.UploadDate, err = parseDate(uploadDate); err != nil {
+			return nil, err
+		}
+		if photo.TakenAt, err = parseNullDate(takenAt); err != nil {
+			return nil, err
+		}
+		date, err := parseDate(deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		photo.DeletedAt = &date
+		photos = append(photos, photo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.withVariants(ctx, photos); err != nil {
+		return nil, err
+	}
+	return &photos, nil
+}
+
+// GetPhotosWithoutImage returns the id and the url of the photos whose image hasn't been described yet, the ones in the
+// trash included, the first uploaded first
+func (r *photosRepository) GetPhotosWithoutImage(ctx context.Context) (*[]models.Photo, error) {
+	rows, err := r.Conn().Query(ctx, `
+		SELECT id, url FROM photos
+		WHERE blur_hash IS NULL
+		ORDER BY id;
+	`)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	photos := []models.Photo{}
+	for rows.Next() {
+		var photo models.Photo
+		if err := rows.Scan(&photo.Id, &photo.Url); err != nil {
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &photos, nil
+}
+
+// withVariants fills the variants of the photos, by width
+func (r *photosRepository) withVariants(ctx context.Context, photos []models.Photo) error {
+	if len(photos) == 0 {
+		return nil
+	}
+	byId := make(map[int]*models.Photo, len(photos))
+	ids := make([]interface{}, len(photos))
+	for i := range photos {
+		byId[photos[i].Id] = &photos[i]
+		ids[i] = photos[i].Id
+	}
+
+	rows, err := r.Conn().Query(ctx, fmt.Sprintf(`
+		SELECT photo_id, width, height, url FROM photo_variants
+		WHERE photo_id IN (%s);
+	`, placeholders(len(ids))), ids...)
+	if err != nil {
+		return err
 	}
-	lastInsertId, err := result.LastInsertId()
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var photoId int
+		var variant models.PhotoVariant
+		if err := rows.Scan(&photoId, &variant.Width, &variant.Height, &variant.Url); err != nil {
+			return err
+		}
+		photo := byId[photoId]
+		if photo.Variants == nil {
+			photo.Variants = make(map[string]models.PhotoVariant)
+		}
+		photo.Variants[strconv.Itoa(variant.Width)] = variant
+	}
+	return rows.Err()
+}
+
+func (r *photosRepository) SetPhoto(ctx context.Context, url string, userId int, time time.Time) (int, error) {
+	var id int64
+	err := r.InTx(ctx, func(tx database.AppDatabase) error {
+		var err error
+		id, err = tx.WriteConn().Insert(ctx, `
+			INSERT INTO photos (url, user_id, upload_date)
+			VALUES (?, ?, ?);
+		`, url, userId, formatDate(time))
+		if err != nil {
+			return err
+		}
+		_, err = tx.WriteConn().Exec(ctx, "UPDATE users SET total_photos = total_photos + 1 WHERE id=?", userId)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	return int(lastInsertId), nil
+	return int(id), nil
 }
 
-func (r *photosRepository) RemovePhoto(photoId int) error {
-	if _, err := r.Conn().Exec(`
-		DELETE FROM photos
+// SetPhotoVariants stores the resized copies of a photo
+func (r *photosRepository) SetPhotoVariants(ctx context.Context, photoId int, variants []models.PhotoVariant) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		for _, variant := range variants {
+			_, err := tx.WriteConn().Exec(ctx, `
+				INSERT INTO photo_variants (photo_id, width, height, url)
+				VALUES (?, ?, ?, ?);
+			`, photoId, variant.Width, variant.Height, variant.Url)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetPhotoTakenAt stores the date a photo was taken
+func (r *photosRepository) SetPhotoTakenAt(ctx context.Context, photoId int, date time.Time) error {
+	_, err := r.WriteConn().Exec(ctx, `
+		UPDATE photos SET taken_at=?
 		WHERE id=?;
-	`, photoId); err != nil {
+	`, formatDate(date), photoId)
+	return err
+}
+
+// SetPhotoImage stores the dimensions, the file size, the MIME type and the BlurHash of the image of a photo
+func (r *photosRepository) SetPhotoImage(ctx context.Context, photoId int, image models.PhotoImage) error {
+	_, err := r.WriteConn().Exec(ctx, `
+		UPDATE photos SET width=?, height=?, size=?, mime_type=?, blur_hash=?
+		WHERE id=?;
+	`, image.Width, image.Height, image.Size, image.MimeType, image.BlurHash, photoId)
+	return err
+}
+
+// SetPhotoDescription stores the caption and the alt text of a photo
+func (r *photosRepository) SetPhotoDescription(ctx context.Context, photoId int, description models.PhotoDescription) error {
+	_, err := r.WriteConn().Exec(ctx, `
+		UPDATE photos SET caption=?, alt_text=?
+		WHERE id=?;
+	`, description.Caption, description.AltText, photoId)
+	return err
+}
+
+// TrashPhoto moves a photo to the trash at date, the photos of its owner don't count it anymore
+func (r *photosRepository) TrashPhoto(ctx context.Context, photoId int, date time.Time) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		result, err := tx.WriteConn().Exec(ctx, `
+			UPDATE photos SET deleted_at=?
+			WHERE id=? AND deleted_at IS NULL;
+		`, formatDate(date), photoId)
+		if err != nil {
+			return err
+		}
+		return updateCounter(ctx, tx, result, `
+			UPDATE users SET total_photos = total_photos - 1
+			WHERE id=(SELECT user_id FROM photos WHERE id=?);
+		`, photoId)
+	})
+}
+
+// RestorePhoto moves a photo out of the trash, with its likes and comments
+func (r *photosRepository) RestorePhoto(ctx context.Context, photoId int) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		result, err := tx.WriteConn().Exec(ctx, `
+			UPDATE photos SET deleted_at=NULL
+			WHERE id=? AND deleted_at IS NOT NULL;
+		`, photoId)
+		if err != nil {
+			return err
+		}
+		return updateCounter(ctx, tx, result, `
+			UPDATE users SET total_photos = total_photos + 1
+			WHERE id=(SELECT user_id FROM photos WHERE id=?);
+		`, photoId)
+	})
+}
+
+// RemovePhoto deletes a photo for good, whether it's in the trash or not
+func (r *photosRepository) RemovePhoto(ctx context.Context, photoId int) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		// The counter is decremented first, while the photo still points to its owner, unless the photo was already
+		// discounted when it was trashed. Its likes and comments are deleted in cascade, along with their counters.
+		if _, err := tx.WriteConn().Exec(ctx, `
+			UPDATE users SET total_photos = total_photos - 1
+			WHERE id=(SELECT user_id FROM photos WHERE id=? AND deleted_at IS NULL);
+		`, photoId); err != nil {
+			return err
+		}
+		_, err := tx.WriteConn().Exec(ctx, `
+			DELETE FROM photos
+			WHERE id=?;
+		`, photoId)
 		return err
-	}
-	return nil
+	})
 }
 
 func (r *photosRepository) WithTotalPhotos() Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(`
-				LEFT JOIN (
-					SELECT user_id, COUNT(*) AS total_photos FROM photos GROUP BY user_id
-				)
-				ON %ss.id = user_id
-			`,
-			entity,
-		)
+	return Relation(func(entity string) Clause {
+		return column("total_photos", fmt.Sprintf("%ss.total_photos", entity))
+	})
+}
+
+// FilterByDeletedBefore selects the photos moved to the trash before date
+func (r *photosRepository) FilterByDeletedBefore(date time.Time) Relation {
+	return Relation(func(entity string) Clause {
+		return where("photos.deleted_at < ?", formatDate(date))
 	})
 }
 
 func (r *photosRepository) FilterByPhotoId(photoId int) Relation {
-	return Relation(func(entity string) string {
+	return Relation(func(entity string) Clause {
 		if entity == "photo" {
-			return fmt.Sprintf(
-				"WHERE id=%d",
-				photoId,
-			)
-		}
-		return fmt.Sprintf(
-			"WHERE %ss.photo_id=%d",
-			entity,
+			return where("photos.id=?", photoId)
+		}
+		return where(
+			fmt.Sprintf("%ss.photo_id=?", entity),
 			photoId,
 		)
 	})
@@ -0,0 +1,300 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+// The tests in this file run every repository query the services use, so that the SQL is checked against each dialect
+
+func TestUsersRepository_GeneratedIds(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		ur, _ := NewUsersRepository(db)
+		for i, username := range []string{"Mario", "Luigi"} {
+			userId, err := ur.CreateUser(context.Background(), &models.BaseUser{Username: username})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userId != i+1 {
+				t.Errorf("expected the id %d got: %d", i+1, userId)
+			}
+		}
+
+		if err := ur.UpdateUser(context.Background(), &models.BaseUser{Id: 2, Username: "Wario"}); err != nil {
+			t.Fatal(err)
+		}
+		user, err := ur.GetUserById(context.Background(), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || user.Username != "Wario" {
+			t.Error("expected the updated user got:", user)
+		}
+	})
+}
+
+func TestFilterByUsername_CaseInsensitiveSearch(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		ur := newTestUsersRepository(t, db, "Mario", "Luigi")
+
+		users, err := ur.GetUsers(context.Background(), ur.FilterByUsername("MAR", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*users) != 1 || (*users)[0].Username != "Mario" {
+			t.Error("expected Mario got:", *users)
+		}
+	})
+}
+
+func TestSetters_IgnoreDuplicates(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		newTestUsersRepository(t, db, "Mario", "Luigi")
+		photoId, err := r.Photos.SetPhoto(context.Background(), "/photo.png", 1, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := r.Bans.SetBan(context.Background(), 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Follows.SetFollow(context.Background(), 2, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Likes.SetLike(context.Background(), photoId, 2, testDate); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		likes, err := r.Likes.GetLikes(context.Background(), r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*likes) != 1 {
+			t.Error("expected one like got:", len(*likes))
+		}
+	})
+}
+
+func TestPhotosRepository_StreamAndProfile(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		newTestUsersRepository(t, db, "Mario", "Luigi", "Wario")
+		// Luigi follows Mario and Wario, but then bans Wario
+		for _, followingId := range []int{1, 3} {
+			if err := r.Follows.SetFollow(context.Background(), 2, followingId); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Photos.SetPhoto(context.Background(), "/photo.png", followingId, testDate); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Bans.SetBan(context.Background(), 2, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Likes.SetLike(context.Background(), 1, 2, testDate); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Comments.SetComment(context.Background(), 1, 2, testDate, "Nice"); err != nil {
+			t.Fatal(err)
+		}
+
+		stream, err := r.Photos.GetPhotos(context.Background(),
+			0,
+			10,
+			r.Users.WithUsers(),
+			r.Likes.WithTotalLikes(),
+			r.Comments.WithTotalComments(),
+			r.Likes.WithLikedBy(2),
+			r.Follows.FilterByFollowerId(2),
+			r.Bans.WithoutBanned(2),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*stream) != 1 {
+			t.Fatal("expected only the photo of Mario got:", *stream)
+		}
+		photo := (*stream)[0]
+		if photo.Owner.Username != "Mario" || photo.TotalLikes != 1 || photo.TotalComments != 1 || !photo.UserLiked {
+			t.Error("expected the photo of Mario liked and commented by Luigi got:", photo)
+		}
+		if !photo.UploadDate.Equal(testDate) {
+			t.Error("expected the upload date got:", photo.UploadDate)
+		}
+		count, err := r.Photos.GetPhotosCount(context.Background(), r.Follows.FilterByFollowerId(2), r.Bans.WithoutBanned(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Error("expected one photo in the stream got:", count)
+		}
+
+		user, err := r.Users.GetFullUser(context.Background(),
+			r.Follows.WithTotalFollowers(),
+			r.Follows.WithTotalFollowings(),
+			r.Photos.WithTotalPhotos(),
+			r.Users.FilterByUserId(2),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || *user.TotalFollowers != 0 || *user.TotalFollowings != 2 || *user.TotalPhotos != 0 {
+			t.Error("expected Luigi following two users got:", user)
+		}
+		followers, err := r.Users.GetUsers(context.Background(), r.Follows.FilterByFollowingId(1), r.Bans.WithoutBanners(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*followers) != 1 || (*followers)[0].Username != "Luigi" {
+			t.Error("expected Luigi following Mario got:", *followers)
+		}
+
+		comments, err := r.Comments.GetComments(context.Background(), r.Users.WithUsers(), r.Photos.FilterByPhotoId(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*comments) != 1 || (*comments)[0].Content != "Nice" {
+			t.Error("expected the comment of Luigi got:", *comments)
+		}
+	})
+}
+
+func TestAuthRepository_Sessions(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		newTestUsersRepository(t, db, "Mario")
+
+		if err := r.Auth.SetPasswordHash(context.Background(), 1, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Auth.SetPasswordHash(context.Background(), 1, "second"); err != nil {
+			t.Fatal(err)
+		}
+		if hash, _ := r.Auth.GetPasswordHash(context.Background(), 1); hash != "second" {
+			t.Error("expected the password hash to be replaced got:", hash)
+		}
+
+		sessionId, err := r.Auth.SetSession(context.Background(), 1, "access", "agent", testDate, testDate.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Auth.SetRefreshToken(context.Background(), sessionId, "refresh", testDate.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		refreshToken, err := r.Auth.GetRefreshToken(context.Background(), "refresh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshToken == nil || refreshToken.SessionId != sessionId {
+			t.Fatal("expected the refresh token of the session got:", refreshToken)
+		}
+		if marked, _ := r.Auth.SetRefreshTokenUsed(context.Background(), refreshToken.Id, testDate); !marked {
+			t.Error("expected the refresh token to be marked as used")
+		}
+		if marked, _ := r.Auth.SetRefreshTokenUsed(context.Background(), refreshToken.Id, testDate); marked {
+			t.Error("expected the refresh token to be already used")
+		}
+
+		tokenId, err := r.Auth.SetPersonalAccessToken(context.Background(), &models.PersonalAccessToken{
+			UserId:    1,
+			Name:      "ci",
+			TokenHash: "pat",
+			Scopes:    []models.Scope{models.ScopePhotosRead},
+			CreatedAt: testDate,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pat, err := r.Auth.GetPersonalAccessToken(context.Background(),
+			r.Auth.FilterByTokenHash("pat"),
+			r.Auth.WithoutRevokedPersonalAccessTokens(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pat == nil || pat.Id != tokenId {
+			t.Error("expected the personal access token got:", pat)
+		}
+
+		removed, err := r.Auth.RemoveExpiredSessions(context.Background(), testDate.Add(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 1 {
+			t.Error("expected the expired session to be removed got:", removed)
+		}
+	})
+}
+
+func TestTwoFactorRepository_RecoveryCodes(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		newTestUsersRepository(t, db, "Mario")
+
+		if err := r.TwoFactor.SetTOTPSecret(context.Background(), 1, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TwoFactor.SetTOTPSecret(context.Background(), 1, "second"); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TwoFactor.EnableTOTP(context.Background(), 1, testDate); err != nil {
+			t.Fatal(err)
+		}
+		totp, err := r.TwoFactor.GetTOTP(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totp == nil || totp.Secret != "second" || totp.EnabledAt == nil {
+			t.Error("expected the enabled secret got:", totp)
+		}
+
+		if err := r.TwoFactor.SetRecoveryCodes(context.Background(), 1, []string{"a", "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if used, _ := r.TwoFactor.UseRecoveryCode(context.Background(), 1, "a", testDate); !used {
+			t.Error("expected the recovery code to be used")
+		}
+		if used, _ := r.TwoFactor.UseRecoveryCode(context.Background(), 1, "a", testDate); used {
+			t.Error("expected the recovery code to be already used")
+		}
+	})
+}
+
+func TestRepositories_CanceledContext(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		r, _ := NewRepositories(db)
+		uow, _ := NewUnitOfWork(db)
+		newTestUsersRepository(t, db, "Mario", "Luigi")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := r.Users.GetUsers(ctx, r.Users.FilterByUsername("Mario", true)); !errors.Is(err, context.Canceled) {
+			t.Error("expected the query to be canceled got:", err)
+		}
+		if err := r.Bans.SetBan(ctx, 1, 2); !errors.Is(err, context.Canceled) {
+			t.Error("expected the write to be canceled got:", err)
+		}
+		err := uow.Do(ctx, func(tx *Repositories) error {
+			return tx.Follows.SetFollow(ctx, 2, 1)
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Error("expected the unit of work to be canceled got:", err)
+		}
+
+		banned, err := r.Bans.GetBanExists(context.Background(), 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if banned {
+			t.Error("expected the canceled write not to be applied")
+		}
+	})
+}
@@ -0,0 +1,295 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+// recordedQuery is a query run by a repository, with its arguments
+type recordedQuery struct {
+	sql  string
+	args []interface{}
+}
+
+// recordingDatabase records the queries run through it, transactions included
+type recordingDatabase struct {
+	database.AppDatabase
+	queries *[]recordedQuery
+}
+
+func (db *recordingDatabase) Conn() database.Conn {
+	return &recordingConn{db.AppDatabase.Conn(), db.queries}
+}
+
+func (db *recordingDatabase) WriteConn() database.Conn {
+	return &recordingConn{db.AppDatabase.WriteConn(), db.queries}
+}
+
+func (db *recordingDatabase) InTx(ctx context.Context, fn func(tx database.AppDatabase) error) error {
+	return db.AppDatabase.InTx(ctx, func(tx database.AppDatabase) error {
+		return fn(&recordingDatabase{tx, db.queries})
+	})
+}
+
+type recordingConn struct {
+	database.Conn
+	queries *[]recordedQuery
+}
+
+func (c *recordingConn) record(query string, args []interface{}) {
+	*c.queries = append(*c.queries, recordedQuery{query, args})
+}
+
+func (c *recordingConn) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	c.record(query, args)
+	return c.Conn.Exec(ctx, query, args...)
+}
+
+func (c *recordingConn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c.record(query, args)
+	return c.Conn.Query(ctx, query, args...)
+}
+
+func (c *recordingConn) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	c.record(query, args)
+	return c.Conn.QueryRow(ctx, query, args...)
+}
+
+func (c *recordingConn) Insert(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	c.record(query, args)
+	return c.Conn.Insert(ctx, query, args...)
+}
+
+// seedPlansDatabase fills the database with enough users, photos, likes, comments and follows for the query planner
+// to prefer the indexes, and analyzes it
+func seedPlansDatabase(t *testing.T, db database.AppDatabase) {
+	t.Helper()
+	ctx := context.Background()
+	r, _ := NewRepositories(db)
+
+	const users = 50
+	usernames := make([]string, users)
+	for i := range usernames {
+		usernames[i] = fmt.Sprintf("user%d", i+1)
+	}
+	newTestUsersRepository(t, db, usernames...)
+	for userId := 1; userId <= users; userId++ {
+		for i := 1; i <= 5; i++ {
+			if err := r.Follows.SetFollow(ctx, userId, (userId+i*7)%users+1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		photoId, err := r.Photos.SetPhoto(ctx, "/photo.png", userId, testDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for likerId := 1; likerId <= users; likerId += 3 {
+			if err := r.Likes.SetLike(ctx, photoId, likerId, testDate); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Comments.SetComment(ctx, photoId, likerId, testDate, "Nice"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for userId := 1; userId <= users; userId++ {
+		for i := 1; i <= 2; i++ {
+			if err := r.Bans.SetBan(ctx, userId, (userId+i*11)%users+1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := db.WriteConn().Exec(ctx, "ANALYZE"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fullScans returns the steps of the plan of query that read a whole table: the scans that don't use an index, and
+// the automatic indexes SQLite builds scanning the table
+func fullScans(t *testing.T, db database.AppDatabase, tables map[string]bool, query recordedQuery) []string {
+	t.Helper()
+	rows, err := db.Conn().Query(context.Background(), "EXPLAIN QUERY PLAN "+query.sql, query.args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var scans []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Fields(detail)
+		if len(fields) < 2 || !tables[fields[1]] {
+			continue
+		}
+		if (fields[0] == "SCAN" && !strings.Contains(detail, " USING ")) || strings.Contains(detail, "AUTOMATIC") {
+			scans = append(scans, detail)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return scans
+}
+
+// TestQueryPlans_NoFullTableScans runs the queries of the photos, likes, comments and follows repositories the way the
+// services do, and checks that none of them reads a whole table
+func TestQueryPlans_NoFullTableScans(t *testing.T) {
+	db := newTestDatabase(t, database.SQLite)
+	seedPlansDatabase(t, db)
+	ctx := context.Background()
+
+	var queries []recordedQuery
+	r, _ := NewRepositories(&recordingDatabase{db, &queries})
+	const userId, targetUserId = 1, 3
+	photoId, err := r.Photos.SetPhoto(ctx, "/photo.png", targetUserId, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scenarios run in order, the photo is removed last
+	for _, scenario := range []struct {
+		name string
+		run  func() error
+	}{
+		{"GetPhotoById", func() error {
+			if err := r.Photos.SetPhotoVariants(ctx, photoId, []models.PhotoVariant{{Url: "/photo_150.png", Width: 150, Height: 100}}); err != nil {
+				return err
+			}
+			_, err := r.Photos.GetPhotoById(ctx, photoId)
+			return err
+		}},
+		{"GetUserPhotos", func() error {
+			_, err := r.Photos.GetPhotos(ctx, 0, 20,
+				r.Users.WithUsers(),
+				r.Likes.WithTotalLikes(),
+				r.Comments.WithTotalComments(),
+				r.Likes.WithLikedBy(userId),
+				r.Users.FilterByUserId(targetUserId),
+			)
+			if err != nil {
+				return err
+			}
+			_, err = r.Photos.GetPhotosCount(ctx, r.Users.FilterByUserId(targetUserId))
+			return err
+		}},
+		{"GetStream", func() error {
+			_, err := r.Photos.GetPhotos(ctx, 0, 20,
+				r.Users.WithUsers(),
+				r.Likes.WithTotalLikes(),
+				r.Comments.WithTotalComments(),
+				r.Likes.WithLikedBy(userId),
+				r.Follows.FilterByFollowerId(userId),
+				r.Bans.WithoutBanned(userId),
+			)
+			if err != nil {
+				return err
+			}
+			_, err = r.Photos.GetPhotosCount(ctx, r.Follows.FilterByFollowerId(userId), r.Bans.WithoutBanned(userId))
+			return err
+		}},
+		{"GetLikes", func() error {
+			if _, err := r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId)); err != nil {
+				return err
+			}
+			_, err := r.Likes.GetLikes(ctx, r.Users.WithUsers(), r.Users.FilterByUserId(userId), r.Photos.FilterByPhotoId(photoId))
+			return err
+		}},
+		{"GetComments", func() error {
+			_, err := r.Comments.GetComments(ctx, r.Users.WithUsers(), r.Photos.FilterByPhotoId(photoId))
+			return err
+		}},
+		{"GetFollowers", func() error {
+			_, err := r.Users.GetUsers(ctx, r.Follows.FilterByFollowingId(targetUserId))
+			return err
+		}},
+		{"GetFollowings", func() error {
+			_, err := r.Users.GetUsers(ctx, r.Follows.FilterByFollowerId(targetUserId))
+			return err
+		}},
+		{"GetFullUser", func() error {
+			_, err := r.Users.GetFullUser(ctx,
+				r.Follows.WithTotalFollowers(),
+				r.Follows.WithTotalFollowings(),
+				r.Photos.WithTotalPhotos(),
+				r.Users.FilterByUserId(targetUserId),
+			)
+			return err
+		}},
+		{"Likes", func() error {
+			if err := r.Likes.SetLike(ctx, photoId, userId, testDate); err != nil {
+				return err
+			}
+			return r.Likes.RemoveLike(ctx, photoId, userId)
+		}},
+		{"Comments", func() error {
+			commentId, err := r.Comments.SetComment(ctx, photoId, userId, testDate, "Nice")
+			if err != nil {
+				return err
+			}
+			if _, err := r.Comments.GetCommentById(ctx, commentId, r.Users.WithUsers()); err != nil {
+				return err
+			}
+			return r.Comments.RemoveComment(ctx, commentId)
+		}},
+		{"Follows", func() error {
+			if err := r.Follows.SetFollow(ctx, userId, targetUserId); err != nil {
+				return err
+			}
+			return r.Follows.RemoveFollow(ctx, userId, targetUserId)
+		}},
+		{"Trash", func() error {
+			if err := r.Photos.TrashPhoto(ctx, photoId, testDate); err != nil {
+				return err
+			}
+			if _, err := r.Photos.GetTrashedPhotos(ctx, r.Users.FilterByUserId(targetUserId)); err != nil {
+				return err
+			}
+			if _, err := r.Photos.GetTrashedPhotos(ctx, r.Photos.FilterByPhotoId(photoId)); err != nil {
+				return err
+			}
+			if _, err := r.Photos.GetTrashedPhotos(ctx, r.Photos.FilterByDeletedBefore(testDate)); err != nil {
+				return err
+			}
+			return r.Photos.RestorePhoto(ctx, photoId)
+		}},
+		{"RemovePhoto", func() error {
+			return r.Photos.RemovePhoto(ctx, photoId)
+		}},
+	} {
+		if err := scenario.run(); err != nil {
+			t.Fatal(scenario.name, err)
+		}
+	}
+
+	tables := make(map[string]bool)
+	rows, err := db.Conn().Query(ctx, "SELECT name FROM sqlite_master WHERE type='table'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables[table] = true
+	}
+	_ = rows.Close()
+
+	for _, query := range queries {
+		if scans := fullScans(t, db, tables, query); len(scans) > 0 {
+			t.Errorf("full table scan %q in query:\n%s", scans, strings.TrimSpace(query.sql))
+		}
+	}
+}
@@ -1,25 +1,134 @@
 package repositories
 
-import "strings"
-
-type Relation func(entity string) string
-
-func queryBuilder(entity string, relations ...Relation) string {
-	relationsStrings := make([]string, len(relations))
-	alreadyAddedWhereRelation := false
-	for i, relation := range relations {
-		relString := relation(entity)
-		if !strings.Contains(relString, "JOIN") && strings.Contains(relString, "WHERE") {
-			if alreadyAddedWhereRelation {
-				relString = strings.Replace(relString, "WHERE", "AND", 1)
-			} else {
-				alreadyAddedWhereRelation = true
-			}
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+// Query is a fragment of SQL along with the arguments bound to its placeholders
+type Query struct {
+	SQL  string
+	Args []interface{}
+	// Columns are the expressions of the columns added by the relations, by name
+	Columns map[string]string
+}
+
+// column returns the select expression of the column added by a relation, or of fallback if none added it
+func (q Query) column(name string, fallback string) string {
+	if sql, ok := q.Columns[name]; ok {
+		return sql + " AS " + name
+	}
+	return fallback + " AS " + name
+}
+
+// Clause is what a relation adds to a query: columns of the selected rows, tables joined to the entity, and
+// predicates the rows must satisfy
+type Clause struct {
+	Columns    map[string]string
+	Joins      []Query
+	Predicates []Query
+	// Match is the clause of the implementations that don't build SQL queries, like the in-memory one: it's called
+	// with each row of the entity, fills the columns added by the relation and returns false if the row isn't selected
+	Match func(row interface{}) bool
+}
+
+// Relation returns the clause relating an entity to the other tables. The entity is the singular name of the table
+// being queried, e.g. "photo" for the photos table.
+type Relation func(entity string) Clause
+
+// column returns a clause adding a column, the getters select it by name
+func column(name string, sql string) Clause {
+	return Clause{Columns: map[string]string{name: sql}}
+}
+
+// join returns a clause adding a join
+func join(sql string, args ...interface{}) Clause {
+	return Clause{Joins: []Query{{SQL: sql, Args: args}}}
+}
+
+// where returns a clause adding a predicate
+func where(sql string, args ...interface{}) Clause {
+	return Clause{Predicates: []Query{{SQL: sql, Args: args}}}
+}
+
+// queryBuilder combines the clauses of the relations: the joins come first, followed by a WHERE with all the
+// predicates. The arguments are returned in the order of their placeholders, the columns are returned apart for the
+// getters to select them.
+func queryBuilder(entity string, relations ...Relation) Query {
+	var joins, predicates []string
+	var joinArgs, predicateArgs []interface{}
+	columns := make(map[string]string)
+	for _, relation := range relations {
+		if relation == nil {
+			continue
+		}
+		clause := relation(entity)
+		for name, sql := range clause.Columns {
+			columns[name] = sql
+		}
+		for _, j := range clause.Joins {
+			joins = append(joins, j.SQL)
+			joinArgs = append(joinArgs, j.Args...)
 		}
-		relationsStrings[i] = relString
+		for _, p := range clause.Predicates {
+			predicates = append(predicates, "("+p.SQL+")")
+			predicateArgs = append(predicateArgs, p.Args...)
+		}
+	}
+
+	q := Query{SQL: strings.Join(joins, " "), Args: append(joinArgs, predicateArgs...), Columns: columns}
+	if len(predicates) > 0 {
+		q.SQL += " WHERE " + strings.Join(predicates, " AND ")
 	}
+	return q
+}
+
+// updateCounter runs the update of a counter, if the write in result affected a row
+func updateCounter(ctx context.Context, tx database.AppDatabase, result sql.Result, update string, args ...interface{}) error {
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return err
+	}
+	_, err = tx.WriteConn().Exec(ctx, update, args...)
+	return err
+}
+
+// placeholders returns n comma separated placeholders, for an IN list
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
 
-	return strings.Join(relationsStrings, " ")
+// escapeLike escapes the LIKE wildcards of a value, to be used with ESCAPE '\'
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
 }
 
-var dateLayout = "2006-01-02T15:04:05-0700"
+// dateLayout is the layout of the stored dates: RFC 3339 in UTC, with a fixed number of fraction digits, so that their
+// order as strings is their order in time
+const dateLayout = "2006-01-02T15:04:05.000Z"
+
+// formatDate formats a date to be stored, in UTC whatever its location
+func formatDate(date time.Time) string {
+	return date.UTC().Format(dateLayout)
+}
+
+// parseDate parses a stored date, the result is in UTC
+func parseDate(value string) (time.Time, error) {
+	return time.Parse(dateLayout, value)
+}
+
+// parseNullDate parses a stored date that can be NULL, the result is nil if it is
+func parseNullDate(value sql.NullString) (*time.Time, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	date, err := parseDate(value.String)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
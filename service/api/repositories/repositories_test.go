@@ -0,0 +1,226 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// postgresURLVariable names the environment variable with the connection string of the Postgres instance the tests
+// run against, they run only against SQLite if it's not set. The CI always sets it: there the tests fail without it.
+const postgresURLVariable = "WASA_TEST_POSTGRES_URL"
+
+var testDate = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// forEachDatabase runs test as a subtest for each dialect, every time with a new empty database
+func forEachDatabase(t *testing.T, test func(t *testing.T, db database.AppDatabase)) {
+	for _, dialect := range []database.Dialect{database.SQLite, database.Postgres} {
+		dialect := dialect
+		t.Run(string(dialect), func(t *testing.T) {
+			test(t, newTestDatabase(t, dialect))
+		})
+	}
+}
+
+// newTestDatabase returns an empty database, dropped at the end of the test
+func newTestDatabase(t *testing.T, dialect database.Dialect) database.AppDatabase {
+	t.Helper()
+	var conn *sql.DB
+	if dialect == database.Postgres {
+		conn = newTestPostgresConn(t)
+	} else {
+		conn = newTestSQLiteConn(t)
+	}
+	db, err := database.New(conn, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestSQLiteConn opens a database kept in memory for the duration of the test
+func newTestSQLiteConn(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a different database
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// newTestPostgresConn opens a connection whose tables are created in a new schema, so that tests don't share data
+func newTestPostgresConn(t *testing.T) *sql.DB {
+	t.Helper()
+	postgresURL := os.Getenv(postgresURLVariable)
+	if postgresURL == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal(postgresURLVariable + " is required in the CI")
+		}
+		t.Skip(postgresURLVariable + " is not set")
+	}
+
+	admin, err := sql.Open("postgres", postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = admin.Close() })
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	schema := "wasa_test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// Parameters unknown to the driver are sent as run-time parameters of the connection
+	if strings.HasPrefix(postgresURL, "postgres://") || strings.HasPrefix(postgresURL, "postgresql://") {
+		u, err := url.Parse(postgresURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		postgresURL = u.String()
+	} else {
+		postgresURL += " search_path=" + schema
+	}
+	conn, err := sql.Open("postgres", postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func newTestUsersRepository(t *testing.T, db database.AppDatabase, usernames ...string) UsersRepository {
+	t.Helper()
+	ur, _ := NewUsersRepository(db)
+	for _, username := range usernames {
+		if _, err := ur.CreateUser(context.Background(), &models.BaseUser{Username: username}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ur
+}
+
+func TestQueryBuilder_JoinsBeforePredicates(t *testing.T) {
+	relations := []Relation{
+		func(string) Clause { return where("a=?", 1) },
+		func(entity string) Clause { return join("JOIN "+entity+"s_extra ON x=?", 2) },
+		nil,
+		func(string) Clause { return where("b=? OR c=?", 3, 4) },
+	}
+
+	q := queryBuilder("photo", relations...)
+
+	expected := "JOIN photos_extra ON x=? WHERE (a=?) AND (b=? OR c=?)"
+	if q.SQL != expected {
+		t.Errorf("expected %q got: %q", expected, q.SQL)
+	}
+	if !reflect.DeepEqual(q.Args, []interface{}{2, 1, 3, 4}) {
+		t.Error("expected the arguments in placeholders order got:", q.Args)
+	}
+}
+
+func TestFilterByUsername_StrictQuotes(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		ur := newTestUsersRepository(t, db, "Mario", "Luigi")
+
+		user, err := ur.GetUser(context.Background(), ur.FilterByUsername(`Mario" OR "1"="1`, true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != nil {
+			t.Error("expected no user got:", user.Username)
+		}
+	})
+}
+
+func TestFilterByUsername_StrictMatchesQuotedUsername(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		ur := newTestUsersRepository(t, db, "Mario", `Ma"rio`)
+
+		user, err := ur.GetUser(context.Background(), ur.FilterByUsername(`Ma"rio`, true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || user.Username != `Ma"rio` {
+			t.Error("expected the user with the quote got:", user)
+		}
+	})
+}
+
+func TestFilterByUsername_LikeWildcardsAreLiteral(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		ur := newTestUsersRepository(t, db, "Mario", "Luigi", "100%real", "snake_case", `back\slash`)
+
+		for search, expected := range map[string][]string{
+			"%":            {"100%real"},
+			"_":            {"snake_case"},
+			`\`:            {`back\slash`},
+			`" OR "1"="1`:  nil,
+			"ar":           {"Mario"},
+			"%' OR 1=1 --": nil,
+		} {
+			users, err := ur.GetUsers(context.Background(), ur.FilterByUsername(search, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var usernames []string
+			for _, user := range *users {
+				usernames = append(usernames, user.Username)
+			}
+			if !reflect.DeepEqual(usernames, expected) {
+				t.Errorf("searching %q expected %v got: %v", search, expected, usernames)
+			}
+		}
+	})
+}
+
+func TestFilterByTokenHash_Quotes(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		ur, _ := NewUsersRepository(db)
+		ar, _ := NewAuthRepository(db)
+		userId, err := ur.CreateUser(context.Background(), &models.BaseUser{Username: "Mario"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ar.SetSession(context.Background(), userId, "somehash", "", testDate, testDate); err != nil {
+			t.Fatal(err)
+		}
+
+		session, err := ar.GetSession(context.Background(), ar.FilterByTokenHash(`" OR "1"="1`), ar.WithoutRevokedSessions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session != nil {
+			t.Error("expected no session got:", session.Id)
+		}
+
+		session, err = ar.GetSession(context.Background(), ar.FilterByTokenHash("somehash"), ar.WithoutRevokedSessions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session == nil || session.UserId != userId {
+			t.Error("expected the session of the user got:", session)
+		}
+	})
+}
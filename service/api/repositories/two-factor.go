@@ -0,0 +1,232 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+type TwoFactorRepository interface {
+	// Getters
+	GetTOTP(ctx context.Context, userId int) (*models.TOTP, error)
+	GetLoginChallenge(ctx context.Context, tokenHash string) (*models.LoginChallenge, error)
+	// Setters
+	SetTOTPSecret(ctx context.Context, userId int, secret string) error
+	EnableTOTP(ctx context.Context, userId int, date time.Time) error
+	SetTOTPLastUsedStep(ctx context.Context, userId int, step int64) (bool, error)
+	RemoveTOTP(ctx context.Context, userId int) error
+	SetRecoveryCodes(ctx context.Context, userId int, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userId int, codeHash string, date time.Time) (bool, error)
+	SetLoginChallenge(ctx context.Context, userId int, tokenHash string, userAgent string, expiresAt time.Time) error
+	IncrementLoginChallengeAttempts(ctx context.Context, challengeId int) error
+	RemoveLoginChallenge(ctx context.Context, challengeId int) error
+	RemoveExpiredLoginChallenges(ctx context.Context, date time.Time) (int, error)
+}
+
+type twoFactorRepository struct {
+	database.AppDatabase
+}
+
+func NewTwoFactorRepository(db database.AppDatabase) (TwoFactorRepository, error) {
+	if db == nil {
+		return nil, errors.New("database is required")
+	}
+
+	return &twoFactorRepository{
+		db,
+	}, nil
+}
+
+func (r *twoFactorRepository) GetTOTP(ctx context.Context, userId int) (*models.TOTP, error) {
+	totp := models.TOTP{UserId: userId}
+	var enabledAt sql.NullString
+	err := r.Conn().QueryRow(ctx, `
+		SELECT secret, enabled_at, last_used_step FROM user_totp
+		WHERE user_id=?
+	`, userId).Scan(&totp.Secret, &enabledAt, &totp.LastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if enabledAt.Valid {
+		date, err := parseDate(enabledAt.String)
+		if err != nil {
+			return nil, err
+		}
+		totp.EnabledAt = &date
+	}
+	return &totp, nil
+}
+
+// SetTOTPSecret stores a pending secret, replacing the previous one
+func (r *twoFactorRepository) SetTOTPSecret(ctx context.Context, userId int, secret string) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret=excluded.secret, enabled_at=NULL, last_used_step=0
+	`, userId, secret); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) EnableTOTP(ctx context.Context, userId int, date time.Time) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		UPDATE user_totp SET enabled_at=?
+		WHERE user_id=?
+	`, formatDate(date), userId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetTOTPLastUsedStep records the time step of an accepted code, it returns false if a code of the same or of a later
+// step was already accepted
+func (r *twoFactorRepository) SetTOTPLastUsedStep(ctx context.Context, userId int, step int64) (bool, error) {
+	result, err := r.WriteConn().Exec(ctx, `
+		UPDATE user_totp SET last_used_step=?
+		WHERE user_id=? AND last_used_step < ?
+	`, step, userId, step)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+// RemoveTOTP disables the two-factor authentication, along with the recovery codes
+func (r *twoFactorRepository) RemoveTOTP(ctx context.Context, userId int) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		if _, err := tx.WriteConn().Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id=?`, userId); err != nil {
+			return err
+		}
+		_, err := tx.WriteConn().Exec(ctx, `DELETE FROM user_totp WHERE user_id=?`, userId)
+		return err
+	})
+}
+
+// SetRecoveryCodes replaces the recovery codes of a user
+func (r *twoFactorRepository) SetRecoveryCodes(ctx context.Context, userId int, codeHashes []string) error {
+	return r.InTx(ctx, func(tx database.AppDatabase) error {
+		if _, err := tx.WriteConn().Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id=?`, userId); err != nil {
+			return err
+		}
+		for _, codeHash := range codeHashes {
+			if _, err := tx.WriteConn().Exec(ctx, `
+				INSERT INTO user_recovery_codes (user_id, code_hash)
+				VALUES (?, ?)
+			`, userId, codeHash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code as used, it returns false if there's no such code
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userId int, codeHash string, date time.Time) (bool, error) {
+	result, err := r.WriteConn().Exec(ctx, `
+		UPDATE user_recovery_codes SET used_at=?
+		WHERE id = (
+			SELECT id FROM user_recovery_codes
+			WHERE user_id=? AND code_hash=? AND used_at IS NULL
+			LIMIT 1
+		)
+	`, formatDate(date), userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+func (r *twoFactorRepository) GetLoginChallenge(ctx context.Context, tokenHash string) (*models.LoginChallenge, error) {
+	var challenge models.LoginChallenge
+	var expiresAt string
+	err := r.Conn().QueryRow(ctx, `
+		SELECT id, user_id, token, user_agent, expires_at, attempts FROM login_challenges
+		WHERE token=?
+	`, tokenHash).Scan(
+		&challenge.Id,
+		&challenge.UserId,
+		&challenge.TokenHash,
+		&challenge.UserAgent,
+		&expiresAt,
+		&challenge.Attempts,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	challenge.ExpiresAt, err = parseDate(expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (r *twoFactorRepository) SetLoginChallenge(ctx context.Context,
+	userId int,
+	tokenHash string,
+	userAgent string,
+	expiresAt time.Time,
+) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		INSERT INTO login_challenges (user_id, token, user_agent, expires_at)
+		VALUES (?, ?, ?, ?)
+	`, userId, tokenHash, userAgent, formatDate(expiresAt)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) IncrementLoginChallengeAttempts(ctx context.Context, challengeId int) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		UPDATE login_challenges SET attempts=attempts+1
+		WHERE id=?
+	`, challengeId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) RemoveLoginChallenge(ctx context.Context, challengeId int) error {
+	if _, err := r.WriteConn().Exec(ctx, `
+		DELETE FROM login_challenges
+		WHERE id=?
+	`, challengeId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) RemoveExpiredLoginChallenges(ctx context.Context, date time.Time) (int, error) {
+	result, err := r.WriteConn().Exec(ctx, `
+		DELETE FROM login_challenges
+		WHERE expires_at <= ?
+	`, formatDate(date))
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
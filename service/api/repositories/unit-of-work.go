@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+// Repositories groups the repositories bound to the same database, or to the same transaction
+type Repositories struct {
+	Auth      AuthRepository
+	Users     UsersRepository
+	Bans      BansRepository
+	Follows   FollowsRepository
+	Photos    PhotosRepository
+	Likes     LikesRepository
+	Comments  CommentsRepository
+	TwoFactor TwoFactorRepository
+}
+
+// NewRepositories creates every repository on the same database
+func NewRepositories(db database.AppDatabase) (*Repositories, error) {
+	if db == nil {
+		return nil, errors.New("database is required")
+	}
+
+	return &Repositories{
+		Auth:      &authRepository{db},
+		Users:     &usersRepository{db},
+		Bans:      &bansRepository{db},
+		Follows:   &followsRepository{db},
+		Photos:    &photosRepository{db},
+		Likes:     &likesRepository{db},
+		Comments:  &commentsRepository{db},
+		TwoFactor: &twoFactorRepository{db},
+	}, nil
+}
+
+// UnitOfWork runs a group of repository operations atomically: fn gets repositories bound to a transaction, which
+// is committed if fn returns nil and rolled back otherwise
+type UnitOfWork interface {
+	Do(ctx context.Context, fn func(r *Repositories) error) error
+}
+
+type unitOfWork struct {
+	database.AppDatabase
+}
+
+func NewUnitOfWork(db database.AppDatabase) (UnitOfWork, error) {
+	if db == nil {
+		return nil, errors.New("database is required")
+	}
+
+	return &unitOfWork{
+		db,
+	}, nil
+}
+
+func (u *unitOfWork) Do(ctx context.Context, fn func(r *Repositories) error) error {
+	return u.InTx(ctx, func(tx database.AppDatabase) error {
+		r, err := NewRepositories(tx)
+		if err != nil {
+			return err
+		}
+		return fn(r)
+	})
+}
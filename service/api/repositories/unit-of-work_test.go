@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+func TestUnitOfWork_CommitsAllWrites(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		uow, _ := NewUnitOfWork(db)
+		r, _ := NewRepositories(db)
+
+		err := uow.Do(context.Background(), func(tx *Repositories) error {
+			userId, err := tx.Users.CreateUser(context.Background(), &models.BaseUser{Username: "Mario"})
+			if err != nil {
+				return err
+			}
+			bannedId, err := tx.Users.CreateUser(context.Background(), &models.BaseUser{Username: "Luigi"})
+			if err != nil {
+				return err
+			}
+			return tx.Bans.SetBan(context.Background(), userId, bannedId)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		banned, err := r.Bans.GetBanExists(context.Background(), 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !banned {
+			t.Error("expected the ban to be committed")
+		}
+	})
+}
+
+func TestUnitOfWork_RollsBackOnError(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.AppDatabase) {
+		uow, _ := NewUnitOfWork(db)
+		r, _ := NewRepositories(db)
+		errFailed := errors.New("failed")
+
+		if _, err := r.Users.CreateUser(context.Background(), &models.BaseUser{Username: "Mario"}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := uow.Do(context.Background(), func(tx *Repositories) error {
+			if _, err := tx.Users.CreateUser(context.Background(), &models.BaseUser{Username: "Luigi"}); err != nil {
+				return err
+			}
+			if err := tx.Bans.SetBan(context.Background(), 1, 2); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Fatal("expected the error of the work got:", err)
+		}
+
+		user, err := r.Users.GetUser(context.Background(), r.Users.FilterByUsername("Luigi", true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != nil {
+			t.Error("expected the user to be rolled back")
+		}
+		banned, err := r.Bans.GetBanExists(context.Background(), 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if banned {
+			t.Error("expected the ban to be rolled back")
+		}
+	})
+}
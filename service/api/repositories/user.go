@@ -1,23 +1,29 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 
 	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/database"
+	"github.com/lucaronca/wasa-homework/service/usernames"
 )
 
+// ErrUsernameTaken is returned by the setters when the username is the same as the one of another user, once
+// normalized
+var ErrUsernameTaken = errors.New("username already taken")
+
 type UsersRepository interface {
 	// Getters
-	GetUserById(id int) (*models.BaseUser, error)
-	GetUser(relations ...Relation) (*models.BaseUser, error)
-	GetFullUser(relations ...Relation) (*models.FullUser, error)
-	GetUsers(relations ...Relation) (*[]models.BaseUser, error)
+	GetUserById(ctx context.Context, id int) (*models.BaseUser, error)
+	GetUser(ctx context.Context, relations ...Relation) (*models.BaseUser, error)
+	GetFullUser(ctx context.Context, relations ...Relation) (*models.FullUser, error)
+	GetUsers(ctx context.Context, relations ...Relation) (*[]models.BaseUser, error)
 	// Setters
-	CreateUser(user *models.BaseUser) (userId int, err error)
-	UpdateUser(user *models.BaseUser) (err error)
+	CreateUser(ctx context.Context, user *models.BaseUser) (userId int, err error)
+	UpdateUser(ctx context.Context, user *models.BaseUser) (err error)
 	// Relations builders
 	WithUsers() Relation
 	FilterByUserId(userId int) Relation
@@ -38,9 +44,9 @@ func NewUsersRepository(db database.AppDatabase) (UsersRepository, error) {
 	}, nil
 }
 
-func (r *usersRepository) GetUserById(id int) (*models.BaseUser, error) {
+func (r *usersRepository) GetUserById(ctx context.Context, id int) (*models.BaseUser, error) {
 	var user models.BaseUser
-	err := r.Conn().QueryRow(`
+	err := r.Conn().QueryRow(ctx, `
 		SELECT id, username FROM users
 		WHERE id=?
 	`, id).Scan(&user.Id, &user.Username)
@@ -53,20 +59,25 @@ func (r *usersRepository) GetUserById(id int) (*models.BaseUser, error) {
 	return &user, nil
 }
 
-func (r *usersRepository) GetFullUser(relations ...Relation) (*models.FullUser, error) {
+func (r *usersRepository) GetFullUser(ctx context.Context, relations ...Relation) (*models.FullUser, error) {
 	q := queryBuilder("user", relations...)
 	var user models.FullUser
-	err := r.Conn().QueryRow(fmt.Sprintf(`
+	err := r.Conn().QueryRow(ctx, fmt.Sprintf(`
 		SELECT
 			id,
 			username,
-			CASE WHEN total_followers IS NULL THEN 0 ELSE total_followers END as total_followers,
-			CASE WHEN total_following IS NULL THEN 0 ELSE total_following END as total_followings,
-			CASE WHEN total_photos IS NULL THEN 0 ELSE total_photos END as total_photos
+			%s,
+			%s,
+			%s
 		FROM users
 		%s
 		LIMIT 1;
-	`, q)).Scan(
+	`,
+		q.column("total_followers", "NULL"),
+		q.column("total_following", "NULL"),
+		q.column("total_photos", "NULL"),
+		q.SQL,
+	), q.Args...).Scan(
 		&user.Id,
 		&user.Username,
 		&user.TotalFollowers,
@@ -82,50 +93,41 @@ func (r *usersRepository) GetFullUser(relations ...Relation) (*models.FullUser,
 	return &user, nil
 }
 
-func (r *usersRepository) GetUserByUsername(username string) (*models.BaseUser, error) {
-	var user models.BaseUser
-	err := r.Conn().QueryRow(`
-		SELECT id, username FROM users
-		WHERE username=?
-	`, username).Scan(&user.Id, &user.Username)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
-func (r *usersRepository) CreateUser(user *models.BaseUser) (int, error) {
-	result, err := r.Conn().Exec("INSERT INTO users (username) VALUES (?)", user.Username)
-	if err != nil {
-		return 0, err
+func (r *usersRepository) CreateUser(ctx context.Context, user *models.BaseUser) (int, error) {
+	id, err := r.WriteConn().Insert(ctx,
+		"INSERT INTO users (username, username_key) VALUES (?, ?)",
+		user.Username,
+		usernames.Key(user.Username),
+	)
+	if database.IsUniqueViolation(err) {
+		return 0, ErrUsernameTaken
 	}
-	lastInsertId, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
-	return int(lastInsertId), nil
+	return int(id), nil
 }
 
-func (r *usersRepository) UpdateUser(user *models.BaseUser) error {
-	_, err := r.Conn().Exec(`
-		UPDATE users SET username=? WHERE id =?
-	`, user.Username, user.Id)
+func (r *usersRepository) UpdateUser(ctx context.Context, user *models.BaseUser) error {
+	_, err := r.WriteConn().Exec(ctx, `
+		UPDATE users SET username=?, username_key=? WHERE id =?
+	`, user.Username, usernames.Key(user.Username), user.Id)
+	if database.IsUniqueViolation(err) {
+		return ErrUsernameTaken
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *usersRepository) GetUser(relations ...Relation) (*models.BaseUser, error) {
+func (r *usersRepository) GetUser(ctx context.Context, relations ...Relation) (*models.BaseUser, error) {
 	q := queryBuilder("user", relations...)
 	var user models.BaseUser
-	err := r.Conn().QueryRow(fmt.Sprintf(`
+	err := r.Conn().QueryRow(ctx, fmt.Sprintf(`
 		SELECT users.id, users.username FROM users
 		%s
-	`, q)).Scan(&user.Id, &user.Username)
+	`, q.SQL), q.Args...).Scan(&user.Id, &user.Username)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -135,13 +137,14 @@ func (r *usersRepository) GetUser(relations ...Relation) (*models.BaseUser, erro
 	return &user, nil
 }
 
-func (r *usersRepository) GetUsers(relations ...Relation) (*[]models.BaseUser, error) {
+func (r *usersRepository) GetUsers(ctx context.Context, relations ...Relation) (*[]models.BaseUser, error) {
 	q := queryBuilder("user", relations...)
-	rows, err := r.Conn().Query(
+	rows, err := r.Conn().Query(ctx,
 		fmt.Sprintf(
 			"SELECT id, username FROM users %s",
-			q,
+			q.SQL,
 		),
+		q.Args...,
 	)
 	if err != nil {
 		return nil, err
@@ -171,38 +174,31 @@ func (r *usersRepository) GetUsers(relations ...Relation) (*[]models.BaseUser, e
 
 // Relations builders
 func (r *usersRepository) WithUsers() Relation {
-	return Relation(func(entity string) string {
-		return fmt.Sprintf(
+	return Relation(func(entity string) Clause {
+		return join(fmt.Sprintf(
 			"INNER JOIN users ON users.id = %ss.user_id",
 			entity,
-		)
+		))
 	})
 }
 
 func (r *usersRepository) FilterByUserId(userId int) Relation {
-	return Relation(func(entity string) string {
+	return Relation(func(entity string) Clause {
 		if entity == "user" {
-			return fmt.Sprintf(
-				"WHERE id=%d",
-				userId,
-			)
+			return where("users.id=?", userId)
 		}
-		return fmt.Sprintf(
-			"WHERE %ss.user_id=%d",
-			entity,
+		return where(
+			fmt.Sprintf("%ss.user_id=?", entity),
 			userId,
 		)
 	})
 }
 
 func (r *usersRepository) FilterByUsername(username string, strict bool) Relation {
-	return Relation(func(entity string) string {
+	return Relation(func(entity string) Clause {
 		if strict {
-			return fmt.Sprintf(
-				"WHERE username=\"%s\"",
-				username,
-			)
+			return where("users.username_key=?", usernames.Key(username))
 		}
-		return "WHERE username LIKE " + "\"%" + username + "%\""
+		return where(`users.username_key LIKE ? ESCAPE '\'`, "%"+escapeLike(usernames.Key(username))+"%")
 	})
 }
@@ -28,4 +28,7 @@ type RequestContext struct {
 	Logger logrus.FieldLogger
 
 	User User
+
+	// SessionId is the identifier of the session the request was authenticated with
+	SessionId int
 }
@@ -2,50 +2,87 @@ package routes
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 	"github.com/lucaronca/wasa-homework/service/api/services"
 )
 
-// wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
-func NewTokenAuthMiddleware(authService services.AuthService) Middleware {
-	return func(fn Handler) Handler {
-		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-			// Get the Basic Authentication credentials
-			authHeader := strings.Split(r.Header.Get("Authorization"), " ")
-			var bearer string
-			if len(authHeader) < 2 {
-				bearer = ""
-			} else {
-				bearer = authHeader[1]
-			}
+// NewTokenAuthMiddleware authenticates the request bearer token, then checks the token is granted the scopes of the
+// route before delegating the request
+func NewTokenAuthMiddleware(authService services.AuthService) ScopedMiddleware {
+	return func(scopes []models.Scope) Middleware {
+		return func(fn Handler) Handler {
+			return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+				// Get the Basic Authentication credentials
+				authHeader := strings.Split(r.Header.Get("Authorization"), " ")
+				var bearer string
+				if len(authHeader) < 2 {
+					bearer = ""
+				} else {
+					bearer = authHeader[1]
+				}
 
-			if len(bearer) > 0 {
-				if user, err := authService.Authorize(bearer); err != nil {
-					if errors.Is(err, services.ErrNoUser) {
-						w.Header().Set("WWW-Authenticate", "Bearer")
+				if len(bearer) > 0 {
+					if user, grant, err := authService.Authorize(r.Context(), bearer); err != nil {
+						if errors.Is(err, services.ErrTokenExpired) {
+							w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+							http.Error(w, "Access token expired", http.StatusUnauthorized)
+						} else if errors.Is(err, services.ErrNoUser) {
+							w.Header().Set("WWW-Authenticate", "Bearer")
+							http.Error(
+								w,
+								"Cannot find a user associated with the given access token",
+								http.StatusUnauthorized,
+							)
+						} else {
+							http.Error(w, err.Error(), http.StatusInternalServerError)
+						}
+					} else if missing := missingScopes(grant.Scopes, scopes); len(missing) > 0 {
+						w.Header().Set(
+							"WWW-Authenticate",
+							fmt.Sprintf("Bearer error=\"insufficient_scope\", scope=\"%s\"", strings.Join(missing, " ")),
+						)
 						http.Error(
 							w,
-							"Cannot find a user associated with the given access token",
-							http.StatusUnauthorized,
+							"Access token lacks the required scopes: "+strings.Join(missing, " "),
+							http.StatusForbidden,
 						)
 					} else {
-						http.Error(w, err.Error(), http.StatusInternalServerError)
+						// Set user info in reqcontext
+						ctx.User = reqcontext.User{Id: user.Id, Username: user.Username}
+						ctx.SessionId = grant.SessionId
+						// Delegate request to the given handle
+						fn(w, r, ps, ctx)
 					}
 				} else {
-					// Set user info in reqcontext
-					ctx.User = reqcontext.User{Id: user.Id, Username: user.Username}
-					// Delegate request to the given handle
-					fn(w, r, ps, ctx)
+					// Request Authentication otherwise
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					http.Error(w, "Access token is missing or invalid", http.StatusUnauthorized)
 				}
-			} else {
-				// Request Authentication otherwise
-				w.Header().Set("WWW-Authenticate", "Bearer")
-				http.Error(w, "Access token is missing or invalid", http.StatusUnauthorized)
 			}
 		}
 	}
 }
+
+// missingScopes returns the required scopes which weren't granted
+func missingScopes(granted []models.Scope, required []models.Scope) []string {
+	var missing []string
+	for _, requiredScope := range required {
+		found := false
+		for _, grantedScope := range granted {
+			if grantedScope == requiredScope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, string(requiredScope))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/lucaronca/wasa-homework/service/api/services"
+)
+
+type authServiceStub struct {
+	services.AuthService
+	grant *models.AccessGrant
+	err   error
+}
+
+func (as *authServiceStub) Authorize(ctx context.Context, token string) (*models.BaseUser, *models.AccessGrant, error) {
+	if as.err != nil {
+		return nil, nil, as.err
+	}
+	return &models.BaseUser{Id: 1, Username: "Mario"}, as.grant, nil
+}
+
+func serveWithTokenAuth(authService services.AuthService, scopes []models.Scope) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := NewTokenAuthMiddleware(authService)(scopes)(
+		func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+			called = true
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/photos", nil)
+	req.Header.Set("Authorization", "Bearer wasa_pat_abcdef")
+	res := httptest.NewRecorder()
+	handler(res, req, httprouter.Params{}, reqcontext.RequestContext{})
+	return res, called
+}
+
+func TestTokenAuthMiddleware_GrantedScopes(t *testing.T) {
+	authService := &authServiceStub{
+		grant: &models.AccessGrant{
+			PersonalAccessTokenId: 1,
+			Scopes:                []models.Scope{models.ScopePhotosRead, models.ScopePhotosWrite},
+		},
+	}
+
+	res, called := serveWithTokenAuth(authService, []models.Scope{models.ScopePhotosRead})
+
+	if !called || http.StatusNoContent != res.Code {
+		t.Error("expected the request to be delegated got:", res.Code)
+	}
+}
+
+func TestTokenAuthMiddleware_MissingScope(t *testing.T) {
+	authService := &authServiceStub{
+		grant: &models.AccessGrant{PersonalAccessTokenId: 1, Scopes: []models.Scope{models.ScopePhotosRead}},
+	}
+
+	res, called := serveWithTokenAuth(authService, []models.Scope{models.ScopeSocialWrite})
+
+	if called {
+		t.Error("expected the request not to be delegated")
+	}
+	if http.StatusForbidden != res.Code {
+		t.Error("expected", http.StatusForbidden, "got:", res.Code)
+	}
+	if res.Header().Get("WWW-Authenticate") != "Bearer error=\"insufficient_scope\", scope=\"social:write\"" {
+		t.Error("expected an insufficient_scope challenge got:", res.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestTokenAuthMiddleware_PersonalAccessTokenCantManageAccount(t *testing.T) {
+	authService := &authServiceStub{
+		grant: &models.AccessGrant{PersonalAccessTokenId: 1, Scopes: models.PersonalAccessTokenScopes},
+	}
+
+	res, called := serveWithTokenAuth(authService, []models.Scope{models.ScopeAccount})
+
+	if called || http.StatusForbidden != res.Code {
+		t.Error("expected", http.StatusForbidden, "got:", res.Code)
+	}
+}
+
+func TestTokenAuthMiddleware_ExpiredToken(t *testing.T) {
+	res, called := serveWithTokenAuth(&authServiceStub{err: services.ErrTokenExpired}, nil)
+
+	if called || http.StatusUnauthorized != res.Code {
+		t.Error("expected", http.StatusUnauthorized, "got:", res.Code)
+	}
+}
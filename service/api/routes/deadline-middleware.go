@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// connContextKey is the key of the connection of a request in its context
+type connContextKey struct{}
+
+// ConnContext adds the connection to the context of its requests, it's meant to be the http.Server ConnContext so that
+// NewConnDeadlineMiddleware can change the deadlines of the connection
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// NewConnDeadlineMiddleware sets the read and write deadlines of the connection of the request to timeout from now,
+// replacing the ReadTimeout and WriteTimeout of the server for a route. The server resets them for the next request.
+// Only HTTP/1 connections are changed, the HTTP/2 ones are shared by several requests.
+func NewConnDeadlineMiddleware(timeout time.Duration) func(fn httprouter.Handle) httprouter.Handle {
+	return func(fn httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok && r.ProtoMajor == 1 {
+				deadline := time.Now().Add(timeout)
+				_ = conn.SetReadDeadline(deadline)
+				_ = conn.SetWriteDeadline(deadline)
+			}
+			fn(w, r, ps)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// postSlowly posts a body whose second half is sent after a pause, to a server with a short read timeout, and returns
+// the length of the body read by the handler
+func postSlowly(t *testing.T, middleware func(httprouter.Handle) httprouter.Handle) (int, error) {
+	t.Helper()
+	router := httprouter.New()
+	router.POST("/photos", middleware(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestTimeout)
+			return
+		}
+		_, _ = w.Write([]byte(strconv.Itoa(len(body))))
+	}))
+	server := httptest.NewUnstartedServer(router)
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	defer server.Close()
+
+	body, w := io.Pipe()
+	go func() {
+		_, _ = w.Write([]byte("photo"))
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write([]byte("photo"))
+		_ = w.Close()
+	}()
+	res, err := http.Post(server.URL+"/photos", "image/png", body)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	read, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(read))
+}
+
+func TestConnDeadlineMiddleware(t *testing.T) {
+	if read, err := postSlowly(t, NewConnDeadlineMiddleware(time.Minute)); err != nil || read != 10 {
+		t.Error("expected the whole body to be read got:", read, err)
+	}
+
+	// Without the middleware the server read timeout expires
+	unchanged := func(fn httprouter.Handle) httprouter.Handle { return fn }
+	if read, err := postSlowly(t, unchanged); err == nil {
+		t.Error("expected the body to be cut got:", read)
+	}
+}
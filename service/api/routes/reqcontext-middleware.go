@@ -1,7 +1,9 @@
 package routes
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -9,8 +11,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// WithReqCtx parses the request and adds a reqcontext.RequestContext instance related to the request.
-func NewReqCtxMiddleware(logger *logrus.FieldLogger) func(fn Handler) httprouter.Handle {
+// WithReqCtx parses the request and adds a reqcontext.RequestContext instance related to the request. If timeout is not
+// zero, the context of the request is given that deadline, so that the queries run for it are canceled once it expires.
+func NewReqCtxMiddleware(logger *logrus.FieldLogger, timeout time.Duration) func(fn Handler) httprouter.Handle {
 	return func(fn Handler) httprouter.Handle {
 		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 			reqUUID, err := uuid.NewV4()
@@ -29,6 +32,12 @@ func NewReqCtxMiddleware(logger *logrus.FieldLogger) func(fn Handler) httprouter
 				"remote-ip": r.RemoteAddr,
 			})
 
+			if timeout > 0 {
+				reqCtx, cancel := context.WithTimeout(r.Context(), timeout)
+				defer cancel()
+				r = r.WithContext(reqCtx)
+			}
+
 			// Call the next handler in chain
 			fn(w, r, ps, ctx)
 		}
@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
+	"github.com/sirupsen/logrus"
+)
+
+// serveWithReqCtx serves a request with the request context middleware, returning the request seen by the handler
+func serveWithReqCtx(timeout time.Duration) *http.Request {
+	var logger logrus.FieldLogger = logrus.New()
+	var handled *http.Request
+	handler := NewReqCtxMiddleware(&logger, timeout)(
+		func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+			handled = r
+		},
+	)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/photos", nil), httprouter.Params{})
+	return handled
+}
+
+func TestReqCtxMiddleware_RequestDeadline(t *testing.T) {
+	before := time.Now()
+	r := serveWithReqCtx(time.Minute)
+
+	deadline, ok := r.Context().Deadline()
+	if !ok {
+		t.Fatal("expected the request to have a deadline")
+	}
+	if deadline.Before(before.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Error("expected the deadline in a minute got:", deadline)
+	}
+	// The context is released once the request is handled
+	if r.Context().Err() == nil {
+		t.Error("expected the request context to be canceled after the handler")
+	}
+}
+
+func TestReqCtxMiddleware_NoTimeout(t *testing.T) {
+	r := serveWithReqCtx(0)
+
+	if _, ok := r.Context().Deadline(); ok {
+		t.Error("expected the request to have no deadline")
+	}
+}
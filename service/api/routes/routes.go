@@ -2,8 +2,10 @@ package routes
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/reqcontext"
 )
 
@@ -13,13 +15,21 @@ type Handler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcont
 
 type Middleware func(Handler) Handler
 
+// ScopedMiddleware builds a Middleware enforcing the given scopes
+type ScopedMiddleware func(scopes []models.Scope) Middleware
+
 // A Route defines the parameters for an api endpoint
 type Route struct {
 	Name         string
 	Method       string
 	Path         string
 	AuthRequired bool
-	HandlerFunc  Handler
+	// Scopes the access token needs to be granted, checked only if AuthRequired
+	Scopes      []models.Scope
+	HandlerFunc Handler
+	// Timeout replaces the read and write timeouts of the server, and the deadline of the request context, for the
+	// routes that need longer, like the uploads. The defaults are kept if it's zero.
+	Timeout time.Duration
 }
 
 // Routes are a collection of defined api endpoints
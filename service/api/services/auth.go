@@ -1,71 +1,614 @@
 package services
 
 import (
-	"crypto/sha1"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+	"github.com/lucaronca/wasa-homework/service/usernames"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrInvalidCredentials = errors.New("Invalid username or password")
+var ErrUserAlreadyExists = errors.New("User already exists")
+var ErrUsernameReserved = errors.New("Username is reserved")
+var ErrNoSession = errors.New("Session not found")
+var ErrTokenExpired = errors.New("Access token expired")
+var ErrInvalidRefreshToken = errors.New("Refresh token is not valid")
+var ErrRefreshTokenReused = errors.New("Refresh token was already used")
+var ErrNoPersonalAccessToken = errors.New("Personal access token not found")
+
+// sessionLastUsedResolution is how stale the last used date of a session can be before a request updates it, so
+// that not every authenticated request results in a write
+const sessionLastUsedResolution = time.Minute
+
+// tokenBytes is the number of random bytes of access and refresh tokens
+const tokenBytes = 32
+
+// personalAccessTokenPrefix tells personal access tokens apart from session tokens, it also makes them easy to spot
+// in scripts and secret scanners
+const personalAccessTokenPrefix = "wasa_pat_"
+
 type AuthService interface {
-	DoLogin(username string) (token string, newUser bool, err error)
-	Authorize(token string) (*models.BaseUser, error)
+	Register(ctx context.Context, username string, password string, userAgent string) (*models.SessionTokens, error)
+	DoLogin(ctx context.Context, username string, password string, userAgent string) (*models.LoginResult, error)
+	VerifyLoginChallenge(ctx context.Context, challengeToken string, code string) (*models.SessionTokens, error)
+	RefreshSession(ctx context.Context, refreshToken string) (*models.SessionTokens, error)
+	Authorize(ctx context.Context, token string) (*models.BaseUser, *models.AccessGrant, error)
+	GetSessions(ctx context.Context, userId int, currentSessionId int) (*[]models.Session, error)
+	RevokeSession(ctx context.Context, userId int, sessionId int) error
+	RemoveExpiredSessions(ctx context.Context) (int, error)
+	CreatePersonalAccessToken(
+		ctx context.Context,
+		userId int,
+		name string,
+		scopes []models.Scope,
+		expiresAt *time.Time,
+	) (*models.PersonalAccessToken, string, error)
+	GetPersonalAccessTokens(ctx context.Context, userId int) (*[]models.PersonalAccessToken, error)
+	RevokePersonalAccessToken(ctx context.Context, userId int, tokenId int) error
+	LoginWithIdentity(ctx context.Context, issuer string, subject string, username string, userAgent string) (*models.LoginResult, error)
+	StartTwoFactorEnrollment(ctx context.Context, userId int, username string) (*models.TOTPEnrollment, error)
+	VerifyTwoFactorEnrollment(ctx context.Context, userId int, code string) (recoveryCodes []string, err error)
+	DisableTwoFactor(ctx context.Context, userId int, code string) error
 }
 
 type authService struct {
-	ar repositories.AuthRepository
-	ur repositories.UsersRepository
+	tokenHashKey    []byte
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	uow             repositories.UnitOfWork
+	ar              repositories.AuthRepository
+	ur              repositories.UsersRepository
+	tfr             repositories.TwoFactorRepository
+	// inTx is set on the copies of the service bound to a unit of work
+	inTx bool
 }
 
-func NewAuthService(ar repositories.AuthRepository, ur repositories.UsersRepository) AuthService {
+// NewAuthService creates the auth service. Tokens are persisted only as their HMAC-SHA256 keyed with tokenHashKey,
+// changing the key invalidates every session.
+func NewAuthService(
+	tokenHashKey []byte,
+	accessTokenTTL time.Duration,
+	refreshTokenTTL time.Duration,
+	uow repositories.UnitOfWork,
+	ar repositories.AuthRepository,
+	ur repositories.UsersRepository,
+	tfr repositories.TwoFactorRepository,
+) AuthService {
 	return &authService{
-		ar: ar,
-		ur: ur,
+		tokenHashKey:    tokenHashKey,
+		accessTokenTTL:  accessTokenTTL,
+		refreshTokenTTL: refreshTokenTTL,
+		uow:             uow,
+		ar:              ar,
+		ur:              ur,
+		tfr:             tfr,
+	}
+}
+
+// withTx calls fn with a copy of the service whose repositories are bound to a single unit of work, so that the
+// writes made by fn are either all committed or all discarded. If the service is already bound, fn joins its unit of
+// work.
+func (s *authService) withTx(ctx context.Context, fn func(tx *authService) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	return s.uow.Do(ctx, func(r *repositories.Repositories) error {
+		tx := *s
+		tx.ar, tx.ur, tx.tfr = r.Auth, r.Users, r.TwoFactor
+		tx.inTx = true
+		return fn(&tx)
+	})
+}
+
+// generateToken returns a random URL safe token
+func generateToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash of a token keyed with key, the only form in which tokens are stored
+func HashToken(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashToken returns the keyed hash of a token
+func (s *authService) hashToken(token string) string {
+	return HashToken(s.tokenHashKey, token)
+}
+
+// newSessionTokens generates a new pair of access and refresh tokens
+func (s *authService) newSessionTokens(now time.Time) (*models.SessionTokens, error) {
+	accessToken, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := generateToken()
+	if err != nil {
+		return nil, err
 	}
+	return &models.SessionTokens{
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: now.Add(s.accessTokenTTL),
+		RefreshToken:         refreshToken,
+	}, nil
 }
 
-func (a *authService) generateToken(username string) string {
-	now := time.Now().String()
-	hasher := sha1.New()
-	hasher.Write([]byte(username + now))
-	return hex.EncodeToString(hasher.Sum(nil))
+// dummyPasswordHash is the hash, at the cost of the stored ones, the password of a login is compared with when there's
+// no hash to compare it with: the login is refused as slowly as with a wrong password, so that the time taken doesn't
+// tell which usernames exist
+var dummyPasswordHash = []byte("$2a$10$nZMtackNXnMywB0vnCLafuhp4rwOr82vf2mRRi/G0581BzSOKVy4K")
+
+// refuseLogin compares password with dummyPasswordHash, and refuses the login
+func refuseLogin(password string) error {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+	return ErrInvalidCredentials
+}
+
+func (s *authService) hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
 }
 
-func (s *authService) DoLogin(username string) (string, bool, error) {
-	user, err := s.ur.GetUser(s.ur.FilterByUsername(username, true))
+// Register - Create a new user protected by the given password. The username is stored normalized, and it can't be
+// the same as the one of another user once normalized
+func (s *authService) Register(ctx context.Context, username string, password string, userAgent string) (*models.SessionTokens, error) {
+	username = usernames.Normalize(username)
+	if usernames.Reserved(username) {
+		return nil, ErrUsernameReserved
+	}
+	passwordHash, err := s.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens *models.SessionTokens
+	err = s.withTx(ctx, func(tx *authService) error {
+		user, err := tx.ur.GetUser(ctx, tx.ur.FilterByUsername(username, true))
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			return ErrUserAlreadyExists
+		}
+
+		userId, err := tx.ur.CreateUser(ctx, &models.BaseUser{Username: username})
+		if errors.Is(err, repositories.ErrUsernameTaken) {
+			return ErrUserAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.ar.SetPasswordHash(ctx, userId, passwordHash); err != nil {
+			return err
+		}
+		tokens, err = tx.createSession(ctx, userId, userAgent)
+		return err
+	})
 	if err != nil {
-		return "", false, err
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DoLogin - Check the user credentials and start a new session. If the user enabled the two-factor authentication the
+// session is started only once the login challenge is verified.
+func (s *authService) DoLogin(ctx context.Context, username string, password string, userAgent string) (*models.LoginResult, error) {
+	user, err := s.ur.GetUser(ctx, s.ur.FilterByUsername(username, true))
+	if err != nil {
+		return nil, err
 	}
-	// User doesn't exist, create it and its token
 	if user == nil {
-		userId, err := s.ur.CreateUser(&models.BaseUser{Username: username})
+		return nil, refuseLogin(password)
+	}
+
+	passwordHash, err := s.ar.GetPasswordHash(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	var newPasswordHash string
+	if passwordHash == "" {
+		// Only the users created before credentials were introduced set their password at the first login, the ones
+		// created by an OpenID Connect login have none and can't log in with one
+		pending, err := s.ar.IsPasswordPending(ctx, user.Id)
 		if err != nil {
-			return "", false, err
+			return nil, err
+		}
+		if !pending || password == "" {
+			return nil, refuseLogin(password)
 		}
-		token := s.generateToken(username)
-		if err := s.ar.SetToken(userId, token); err != nil {
-			return "", false, err
+		newPasswordHash, err = s.hashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	var result *models.LoginResult
+	err = s.withTx(ctx, func(tx *authService) error {
+		if newPasswordHash != "" {
+			if err := tx.ar.SetPasswordHash(ctx, user.Id, newPasswordHash); err != nil {
+				return err
+			}
 		}
-		return token, true, nil
+
+		result, err = tx.startLogin(ctx, user.Id, userAgent)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return result, nil
+}
+
+// startLogin starts a new session for a user whose first factor was verified, or the login challenge if the user
+// enabled the two-factor authentication
+func (s *authService) startLogin(ctx context.Context, userId int, userAgent string) (*models.LoginResult, error) {
+	totp, err := s.tfr.GetTOTP(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if totp != nil && totp.EnabledAt != nil {
+		return s.createLoginChallenge(ctx, userId, userAgent)
+	}
+
+	tokens, err := s.createSession(ctx, userId, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoginResult{Session: tokens}, nil
+}
+
+// LoginWithIdentity - Start a new session for the user linked to the subject of an external identity provider, or the
+// login challenge if the user enabled the two-factor authentication. The first login creates the user and links it,
+// naming it after username or a variation of it if it's already taken
+func (s *authService) LoginWithIdentity(
+	ctx context.Context,
+	issuer string,
+	subject string,
+	username string,
+	userAgent string,
+) (*models.LoginResult, error) {
+	var result *models.LoginResult
+	err := s.withTx(ctx, func(tx *authService) error {
+		userId, err := tx.ar.GetIdentityUserId(ctx, issuer, subject)
+		if err != nil {
+			return err
+		}
+		if userId == 0 {
+			username, err = tx.availableUsername(ctx, username)
+			if err != nil {
+				return err
+			}
+			userId, err = tx.ur.CreateUser(ctx, &models.BaseUser{Username: username})
+			if err != nil {
+				return err
+			}
+			if err := tx.ar.SetIdentity(ctx, issuer, subject, userId); err != nil {
+				return err
+			}
+		}
+		result, err = tx.startLogin(ctx, userId, userAgent)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// availableUsername returns username, or username followed by a number if it's already taken or reserved. The
+// characters not allowed in usernames are dropped, and the result is a valid username.
+func (s *authService) availableUsername(ctx context.Context, username string) (string, error) {
+	var base []rune
+	for _, r := range usernames.Normalize(username) {
+		if usernames.Allowed(r) && (len(base) > 0 || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			base = append(base, r)
+		}
+	}
+	if len(base) < usernames.MinLength {
+		base = []rune("user")
+	}
+	if len(base) > usernames.MaxLength {
+		base = base[:usernames.MaxLength]
+	}
+	candidate := string(base)
+	for i := 2; ; i++ {
+		if !usernames.Reserved(candidate) {
+			user, err := s.ur.GetUser(ctx, s.ur.FilterByUsername(candidate, true))
+			if err != nil {
+				return "", err
+			}
+			if user == nil {
+				return candidate, nil
+			}
+		}
+		suffix := strconv.Itoa(i)
+		if len(base)+len(suffix) > usernames.MaxLength {
+			candidate = string(base[:usernames.MaxLength-len(suffix)]) + suffix
+		} else {
+			candidate = string(base) + suffix
+		}
+	}
+}
 
-	// User exists, just return its token
-	token, err := s.ar.GetToken(s.ur.FilterByUserId(user.Id))
+// createSession - Store a new session for the user and return its tokens
+func (s *authService) createSession(ctx context.Context, userId int, userAgent string) (*models.SessionTokens, error) {
+	now := globaltime.Now()
+	tokens, err := s.newSessionTokens(now)
 	if err != nil {
-		return "", false, err
+		return nil, err
+	}
+	err = s.withTx(ctx, func(tx *authService) error {
+		sessionId, err := tx.ar.SetSession(ctx,
+			userId,
+			tx.hashToken(tokens.AccessToken),
+			userAgent,
+			now,
+			tokens.AccessTokenExpiresAt,
+		)
+		if err != nil {
+			return err
+		}
+		return tx.ar.SetRefreshToken(ctx, sessionId, tx.hashToken(tokens.RefreshToken), now.Add(tx.refreshTokenTTL))
+	})
+	if err != nil {
+		return nil, err
 	}
-	return token, false, nil
+	return tokens, nil
 }
 
-func (s *authService) Authorize(token string) (*models.BaseUser, error) {
-	user, err := s.ur.GetUser(s.ar.WithTokens(), s.ar.FilterByToken(token))
+// RefreshSession - Exchange a refresh token for new session tokens. Refresh tokens can be used only once: using one
+// again means it was stolen, so the whole session is revoked
+func (s *authService) RefreshSession(ctx context.Context, refreshToken string) (*models.SessionTokens, error) {
+	refreshTokenHash := s.hashToken(refreshToken)
+	now := globaltime.Now()
+	tokens, err := s.newSessionTokens(now)
+	if err != nil {
+		return nil, err
+	}
+
+	// The revocation of a session whose refresh token was reused has to be committed, so the error is returned only
+	// once the unit of work is done
+	var reused bool
+	err = s.withTx(ctx, func(tx *authService) error {
+		storedToken, err := tx.ar.GetRefreshToken(ctx, refreshTokenHash)
+		if err != nil {
+			return err
+		}
+		if storedToken == nil ||
+			subtle.ConstantTimeCompare([]byte(storedToken.TokenHash), []byte(refreshTokenHash)) != 1 {
+			return ErrInvalidRefreshToken
+		}
+		session, err := tx.ar.GetSession(ctx, tx.ar.FilterBySessionId(storedToken.SessionId), tx.ar.WithoutRevokedSessions())
+		if err != nil {
+			return err
+		}
+		if session == nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if storedToken.UsedAt != nil {
+			reused = true
+			return tx.ar.RevokeSession(ctx, session.Id, now)
+		}
+		if !now.Before(storedToken.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		// Another request could have used the token in the meantime
+		marked, err := tx.ar.SetRefreshTokenUsed(ctx, storedToken.Id, now)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			reused = true
+			return tx.ar.RevokeSession(ctx, session.Id, now)
+		}
+
+		user, err := tx.ur.GetUserById(ctx, session.UserId)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return ErrInvalidRefreshToken
+		}
+		err = tx.ar.SetSessionToken(ctx, session.Id, tx.hashToken(tokens.AccessToken), tokens.AccessTokenExpiresAt)
+		if err != nil {
+			return err
+		}
+		return tx.ar.SetRefreshToken(ctx, session.Id, tx.hashToken(tokens.RefreshToken), now.Add(tx.refreshTokenTTL))
+	})
 	if err != nil {
 		return nil, err
 	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+	return tokens, nil
+}
+
+// Authorize - Get the user owning a not revoked token and what the token grants
+func (s *authService) Authorize(ctx context.Context, token string) (*models.BaseUser, *models.AccessGrant, error) {
+	if strings.HasPrefix(token, personalAccessTokenPrefix) {
+		return s.authorizePersonalAccessToken(ctx, token)
+	}
+
+	tokenHash := s.hashToken(token)
+	session, err := s.ar.GetSession(ctx, s.ar.FilterByTokenHash(tokenHash), s.ar.WithoutRevokedSessions())
+	if err != nil {
+		return nil, nil, err
+	}
+	if session == nil || subtle.ConstantTimeCompare([]byte(session.TokenHash), []byte(tokenHash)) != 1 {
+		return nil, nil, ErrNoUser
+	}
+	now := globaltime.Now()
+	if !now.Before(session.ExpiresAt) {
+		return nil, nil, ErrTokenExpired
+	}
+	user, err := s.ur.GetUserById(ctx, session.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
 	if user == nil {
-		return nil, ErrNoUser
+		return nil, nil, ErrNoUser
+	}
+
+	if now.Sub(session.LastUsedAt) >= sessionLastUsedResolution {
+		if err := s.ar.SetSessionLastUsed(ctx, session.Id, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	return user, &models.AccessGrant{SessionId: session.Id, Scopes: models.SessionScopes}, nil
+}
+
+// authorizePersonalAccessToken - Get the user owning a not revoked personal access token and the token scopes
+func (s *authService) authorizePersonalAccessToken(ctx context.Context, token string) (*models.BaseUser, *models.AccessGrant, error) {
+	tokenHash := s.hashToken(token)
+	pat, err := s.ar.GetPersonalAccessToken(ctx,
+		s.ar.FilterByTokenHash(tokenHash),
+		s.ar.WithoutRevokedPersonalAccessTokens(),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pat == nil || subtle.ConstantTimeCompare([]byte(pat.TokenHash), []byte(tokenHash)) != 1 {
+		return nil, nil, ErrNoUser
+	}
+	now := globaltime.Now()
+	if pat.ExpiresAt != nil && !now.Before(*pat.ExpiresAt) {
+		return nil, nil, ErrTokenExpired
+	}
+	user, err := s.ur.GetUserById(ctx, pat.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrNoUser
+	}
+
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) >= sessionLastUsedResolution {
+		if err := s.ar.SetPersonalAccessTokenLastUsed(ctx, pat.Id, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	return user, &models.AccessGrant{PersonalAccessTokenId: pat.Id, Scopes: pat.Scopes}, nil
+}
+
+// GetSessions - Get the active sessions of a user
+func (s *authService) GetSessions(ctx context.Context, userId int, currentSessionId int) (*[]models.Session, error) {
+	sessions, err := s.ar.GetSessions(ctx, s.ur.FilterByUserId(userId), s.ar.WithoutRevokedSessions())
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil || len(*sessions) == 0 {
+		empty := make([]models.Session, 0)
+		return &empty, nil
+	}
+	for i := range *sessions {
+		(*sessions)[i].Current = (*sessions)[i].Id == currentSessionId
+	}
+	return sessions, nil
+}
+
+// RevokeSession - Revoke a session of a user, its token can't be used anymore
+func (s *authService) RevokeSession(ctx context.Context, userId int, sessionId int) error {
+	session, err := s.ar.GetSession(ctx, s.ar.FilterBySessionId(sessionId), s.ar.WithoutRevokedSessions())
+	if err != nil {
+		return err
+	}
+	if session == nil || session.UserId != userId {
+		return ErrNoSession
+	}
+	return s.ar.RevokeSession(ctx, session.Id, globaltime.Now())
+}
+
+// RemoveExpiredSessions - Delete the sessions that can't be used or refreshed anymore, along with the expired login
+// challenges
+func (s *authService) RemoveExpiredSessions(ctx context.Context) (int, error) {
+	now := globaltime.Now()
+	var removed int
+	err := s.withTx(ctx, func(tx *authService) error {
+		if _, err := tx.tfr.RemoveExpiredLoginChallenges(ctx, now); err != nil {
+			return err
+		}
+		var err error
+		removed, err = tx.ar.RemoveExpiredSessions(ctx, now)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// CreatePersonalAccessToken - Create a named token granting only the given scopes, the token value is returned
+// only here since only its hash is stored
+func (s *authService) CreatePersonalAccessToken(
+	ctx context.Context,
+	userId int,
+	name string,
+	scopes []models.Scope,
+	expiresAt *time.Time,
+) (*models.PersonalAccessToken, string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	token = personalAccessTokenPrefix + token
+
+	pat := models.PersonalAccessToken{
+		UserId:    userId,
+		Name:      name,
+		TokenHash: s.hashToken(token),
+		Scopes:    scopes,
+		CreatedAt: globaltime.Now(),
+		ExpiresAt: expiresAt,
+	}
+	pat.Id, err = s.ar.SetPersonalAccessToken(ctx, &pat)
+	if err != nil {
+		return nil, "", err
+	}
+	return &pat, token, nil
+}
+
+// GetPersonalAccessTokens - Get the not revoked personal access tokens of a user
+func (s *authService) GetPersonalAccessTokens(ctx context.Context, userId int) (*[]models.PersonalAccessToken, error) {
+	return s.ar.GetPersonalAccessTokens(ctx, s.ur.FilterByUserId(userId), s.ar.WithoutRevokedPersonalAccessTokens())
+}
+
+// RevokePersonalAccessToken - Revoke a personal access token of a user, it can't be used anymore
+func (s *authService) RevokePersonalAccessToken(ctx context.Context, userId int, tokenId int) error {
+	pat, err := s.ar.GetPersonalAccessToken(ctx,
+		s.ar.FilterByPersonalAccessTokenId(tokenId),
+		s.ar.WithoutRevokedPersonalAccessTokens(),
+	)
+	if err != nil {
+		return err
+	}
+	if pat == nil || pat.UserId != userId {
+		return ErrNoPersonalAccessToken
 	}
-	return user, nil
+	return s.ar.RevokePersonalAccessToken(ctx, pat.Id, globaltime.Now())
 }
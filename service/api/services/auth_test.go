@@ -0,0 +1,24 @@
+package services
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The dummy hash must cost as much as the stored ones, or refused logins of unknown users are faster
+func TestDummyPasswordHash_Cost(t *testing.T) {
+	cost, err := bcrypt.Cost(dummyPasswordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Error("expected cost", bcrypt.DefaultCost, "got:", cost)
+	}
+}
+
+func TestRefuseLogin(t *testing.T) {
+	if err := refuseLogin("supersecret"); err != ErrInvalidCredentials {
+		t.Error("expected", ErrInvalidCredentials, "got:", err)
+	}
+}
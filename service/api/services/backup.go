@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/backup"
+	"github.com/lucaronca/wasa-homework/service/database"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+)
+
+// BackupService defines the api actions to back up the app data, reserved to the administrators
+type BackupService interface {
+	CreateBackup(context.Context, int) (*models.Backup, error)
+}
+
+// backupService is a service that implements the logic for the BackupService
+type backupService struct {
+	db               database.AppDatabase
+	photosDirectory  string
+	backupsDirectory string
+	adminIds         map[int]bool
+}
+
+// NewBackupService creates a default api service, the users with adminIds are the administrators
+func NewBackupService(
+	db database.AppDatabase,
+	photosDirectory string,
+	backupsDirectory string,
+	adminIds []int,
+) BackupService {
+	admins := make(map[int]bool, len(adminIds))
+	for _, id := range adminIds {
+		admins[id] = true
+	}
+	return &backupService{
+		db:               db,
+		photosDirectory:  photosDirectory,
+		backupsDirectory: backupsDirectory,
+		adminIds:         admins,
+	}
+}
+
+// CreateBackup - Write a snapshot of the database and of the photos to a new archive in the backups directory
+func (s *backupService) CreateBackup(ctx context.Context, userId int) (*models.Backup, error) {
+	if !s.adminIds[userId] {
+		return nil, ErrUserForbidden
+	}
+	if err := os.MkdirAll(s.backupsDirectory, 0700); err != nil {
+		return nil, err
+	}
+
+	name := backup.FileName(globaltime.Now())
+	path := filepath.Join(s.backupsDirectory, name)
+	manifest, err := backup.WriteFile(ctx, path, s.db, s.photosDirectory)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Backup{
+		Name:      name,
+		CreatedAt: manifest.CreatedAt,
+		Size:      info.Size(),
+		Photos:    len(manifest.Photos),
+	}, nil
+}
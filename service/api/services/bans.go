@@ -1,74 +1,78 @@
 package services
 
 import (
+	"context"
 	"github.com/lucaronca/wasa-homework/service/api/repositories"
 )
 
 // BansService defines the api actions to ban/unban a user
 type BansService interface {
-	BanUser(int, int) error
-	UnbanUser(int, int) error
-	IsBannedForUser(int, int) (bool, error)
+	BanUser(context.Context, int, int) error
+	UnbanUser(context.Context, int, int) error
+	IsBannedForUser(context.Context, int, int) (bool, error)
 }
 
 // bansService is a service that implements the logic for the BansService
 type bansService struct {
-	ur repositories.UsersRepository
-	br repositories.BansRepository
-	fr repositories.FollowsRepository
+	uow repositories.UnitOfWork
+	ur  repositories.UsersRepository
+	br  repositories.BansRepository
+	fr  repositories.FollowsRepository
 }
 
 // NewBansService creates a default api service
 func NewBansService(
+	uow repositories.UnitOfWork,
 	ur repositories.UsersRepository,
 	br repositories.BansRepository,
 	fr repositories.FollowsRepository,
 ) BansService {
 	return &bansService{
-		ur: ur,
-		br: br,
-		fr: fr,
+		uow: uow,
+		ur:  ur,
+		br:  br,
+		fr:  fr,
 	}
 }
 
 // BanUser - Ban a user
-func (s *bansService) BanUser(userId, bannedId int) error {
-	user, err := s.ur.GetUserById(userId)
+func (s *bansService) BanUser(ctx context.Context, userId, bannedId int) error {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	user, err = s.ur.GetUserById(bannedId)
+	user, err = s.ur.GetUserById(ctx, bannedId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	if err := s.br.SetBan(userId, bannedId); err != nil {
-		return err
-	}
-	if err = s.fr.RemoveFollow(userId, bannedId); err != nil {
-		return err
-	}
-	if err = s.fr.RemoveFollow(bannedId, userId); err != nil {
-		return err
-	}
-	return nil
+	// The follows between the users are removed along with the ban
+	return s.uow.Do(ctx, func(r *repositories.Repositories) error {
+		if err := r.Bans.SetBan(ctx, userId, bannedId); err != nil {
+			return err
+		}
+		if err := r.Follows.RemoveFollow(ctx, userId, bannedId); err != nil {
+			return err
+		}
+		return r.Follows.RemoveFollow(ctx, bannedId, userId)
+	})
 }
 
 // UnbanUser - Unban a user
-func (s *bansService) UnbanUser(userId, bannedId int) error {
-	user, err := s.ur.GetUserById(userId)
+func (s *bansService) UnbanUser(ctx context.Context, userId, bannedId int) error {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	user, err = s.ur.GetUserById(bannedId)
+	user, err = s.ur.GetUserById(ctx, bannedId)
 	if err != nil {
 		return err
 	}
@@ -76,22 +80,22 @@ func (s *bansService) UnbanUser(userId, bannedId int) error {
 		return ErrNoUser
 	}
 
-	if err := s.br.RemoveBan(userId, bannedId); err != nil {
+	if err := s.br.RemoveBan(ctx, userId, bannedId); err != nil {
 		return err
 	}
 	return nil
 }
 
 // IsBannedForUser - If a target user is banned/unbanned a user
-func (s *bansService) IsBannedForUser(userId, targetUserId int) (bool, error) {
-	user, err := s.ur.GetUserById(userId)
+func (s *bansService) IsBannedForUser(ctx context.Context, userId, targetUserId int) (bool, error) {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return false, err
 	}
 	if user == nil {
 		return false, ErrNoUser
 	}
-	user, err = s.ur.GetUserById(targetUserId)
+	user, err = s.ur.GetUserById(ctx, targetUserId)
 	if err != nil {
 		return false, err
 	}
@@ -99,7 +103,7 @@ func (s *bansService) IsBannedForUser(userId, targetUserId int) (bool, error) {
 		return false, ErrNoUser
 	}
 
-	banExists, err := s.br.GetBanExists(userId, targetUserId)
+	banExists, err := s.br.GetBanExists(ctx, userId, targetUserId)
 	if err != nil {
 		return false, err
 	}
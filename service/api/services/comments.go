@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 
 	"github.com/lucaronca/wasa-homework/service/api/models"
@@ -13,9 +14,9 @@ var ErrDeleteNotAllowed = errors.New("You can't delete this comment")
 
 // CommentsService defines the api actions to comment/uncomment a photo
 type CommentsService interface {
-	CommentPhoto(int, int, string) (*models.Comment, error)
-	UncommentPhoto(int, int, int) error
-	GetPhotoComments(int, int) (*[]models.Comment, error)
+	CommentPhoto(context.Context, int, int, string) (*models.Comment, error)
+	UncommentPhoto(context.Context, int, int, int) error
+	GetPhotoComments(context.Context, int, int) (*[]models.Comment, error)
 }
 
 // commentsService is a service that implements the logic for the CommentsService
@@ -37,29 +38,29 @@ func NewCommentsService(ur repositories.UsersRepository, br repositories.BansRep
 }
 
 // CommentPhoto - Put a comment to a photo
-func (s *commentsService) CommentPhoto(photoId, userId int, content string) (*models.Comment, error) {
-	photo, err := s.pr.GetPhotoById(photoId)
+func (s *commentsService) CommentPhoto(ctx context.Context, photoId, userId int, content string) (*models.Comment, error) {
+	photo, err := s.pr.GetPhotoById(ctx, photoId)
 	if err != nil {
 		return nil, err
 	}
 	if photo == nil {
 		return nil, ErrNoPhoto
 	}
-	user, err := s.ur.GetUserById(userId)
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(userId, photo.Owner.Id)
+	isBannedForUser, err := s.br.GetBanExists(ctx, userId, photo.Owner.Id)
 	if err != nil {
 		return nil, err
 	}
 	if isBannedForUser {
 		return nil, ErrNoPhoto
 	}
-	isBannedForUser, err = s.br.GetBanExists(photo.Owner.Id, userId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, photo.Owner.Id, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -67,11 +68,11 @@ func (s *commentsService) CommentPhoto(photoId, userId int, content string) (*mo
 		return nil, ErrNoPhoto
 	}
 
-	commentId, err := s.cr.SetComment(photo.Id, user.Id, globaltime.Now(), content)
+	commentId, err := s.cr.SetComment(ctx, photo.Id, user.Id, globaltime.Now(), content)
 	if err != nil {
 		return nil, err
 	}
-	comment, err := s.cr.GetCommentById(commentId, s.ur.WithUsers())
+	comment, err := s.cr.GetCommentById(ctx, commentId, s.ur.WithUsers())
 	if err != nil {
 		return nil, err
 	}
@@ -79,15 +80,15 @@ func (s *commentsService) CommentPhoto(photoId, userId int, content string) (*mo
 }
 
 // UncommentPhoto - Remove a comment from a photo
-func (s *commentsService) UncommentPhoto(photoId, commentId, userId int) error {
-	photo, err := s.pr.GetPhotoById(photoId)
+func (s *commentsService) UncommentPhoto(ctx context.Context, photoId, commentId, userId int) error {
+	photo, err := s.pr.GetPhotoById(ctx, photoId)
 	if err != nil {
 		return err
 	}
 	if photo == nil {
 		return ErrNoPhoto
 	}
-	comment, err := s.cr.GetCommentById(commentId, s.ur.WithUsers())
+	comment, err := s.cr.GetCommentById(ctx, commentId, s.ur.WithUsers())
 	if err != nil {
 		return err
 	}
@@ -97,7 +98,7 @@ func (s *commentsService) UncommentPhoto(photoId, commentId, userId int) error {
 	if comment.Owner.Id != userId {
 		return ErrDeleteNotAllowed
 	}
-	user, err := s.ur.GetUserById(userId)
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return err
 	}
@@ -105,36 +106,36 @@ func (s *commentsService) UncommentPhoto(photoId, commentId, userId int) error {
 		return ErrNoUser
 	}
 
-	if err := s.cr.RemoveComment(commentId); err != nil {
+	if err := s.cr.RemoveComment(ctx, commentId); err != nil {
 		return err
 	}
 	return nil
 }
 
 // GetPhotoComments - Get photo comments
-func (s *commentsService) GetPhotoComments(photoId, userId int) (*[]models.Comment, error) {
-	photo, err := s.pr.GetPhotoById(photoId)
+func (s *commentsService) GetPhotoComments(ctx context.Context, photoId, userId int) (*[]models.Comment, error) {
+	photo, err := s.pr.GetPhotoById(ctx, photoId)
 	if err != nil {
 		return nil, err
 	}
 	if photo == nil {
 		return nil, ErrNoPhoto
 	}
-	user, err := s.ur.GetUserById(userId)
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(userId, photo.Owner.Id)
+	isBannedForUser, err := s.br.GetBanExists(ctx, userId, photo.Owner.Id)
 	if err != nil {
 		return nil, err
 	}
 	if isBannedForUser {
 		return nil, ErrNoPhoto
 	}
-	isBannedForUser, err = s.br.GetBanExists(photo.Owner.Id, userId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, photo.Owner.Id, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +143,7 @@ func (s *commentsService) GetPhotoComments(photoId, userId int) (*[]models.Comme
 		return nil, ErrNoPhoto
 	}
 
-	comments, err := s.cr.GetComments(s.ur.WithUsers(), s.pr.FilterByPhotoId(photoId))
+	comments, err := s.cr.GetComments(ctx, s.ur.WithUsers(), s.pr.FilterByPhotoId(photoId))
 	if err != nil {
 		return nil, err
 	}
@@ -1,16 +1,17 @@
 package services
 
 import (
+	"context"
 	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/repositories"
 )
 
 // FollowsService defines the api actions to follow/unfollow a user
 type FollowsService interface {
-	FollowUser(int, int) error
-	GetUserFollowers(int, int) (*[]models.BaseUser, error)
-	GetUserFollowings(int, int) (*[]models.BaseUser, error)
-	UnfollowUser(int, int) error
+	FollowUser(context.Context, int, int) error
+	GetUserFollowers(context.Context, int, int) (*[]models.BaseUser, error)
+	GetUserFollowings(context.Context, int, int) (*[]models.BaseUser, error)
+	UnfollowUser(context.Context, int, int) error
 }
 
 // followsService is a service that implements the logic for the FollowsService
@@ -30,29 +31,29 @@ func NewFollowsService(ur repositories.UsersRepository, br repositories.BansRepo
 }
 
 // FollowUser - Follow a user
-func (s *followsService) FollowUser(followerUserId, followingUserId int) error {
-	user, err := s.ur.GetUserById(followerUserId)
+func (s *followsService) FollowUser(ctx context.Context, followerUserId, followingUserId int) error {
+	user, err := s.ur.GetUserById(ctx, followerUserId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	user, err = s.ur.GetUserById(followingUserId)
+	user, err = s.ur.GetUserById(ctx, followingUserId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(followerUserId, followingUserId)
+	isBannedForUser, err := s.br.GetBanExists(ctx, followerUserId, followingUserId)
 	if err != nil {
 		return err
 	}
 	if isBannedForUser {
 		return ErrNoUser
 	}
-	isBannedForUser, err = s.br.GetBanExists(followingUserId, followerUserId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, followingUserId, followerUserId)
 	if err != nil {
 		return err
 	}
@@ -60,36 +61,36 @@ func (s *followsService) FollowUser(followerUserId, followingUserId int) error {
 		return ErrNoUser
 	}
 
-	if err := s.fr.SetFollow(followerUserId, followingUserId); err != nil {
+	if err := s.fr.SetFollow(ctx, followerUserId, followingUserId); err != nil {
 		return err
 	}
 	return nil
 }
 
 // GetUserFollowers - Get user followers
-func (s *followsService) GetUserFollowers(userId int, targetUserId int) (*[]models.BaseUser, error) {
-	user, err := s.ur.GetUserById(userId)
+func (s *followsService) GetUserFollowers(ctx context.Context, userId int, targetUserId int) (*[]models.BaseUser, error) {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	user, err = s.ur.GetUserById(targetUserId)
+	user, err = s.ur.GetUserById(ctx, targetUserId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(userId, targetUserId)
+	isBannedForUser, err := s.br.GetBanExists(ctx, userId, targetUserId)
 	if err != nil {
 		return nil, err
 	}
 	if isBannedForUser {
 		return nil, ErrNoUser
 	}
-	isBannedForUser, err = s.br.GetBanExists(targetUserId, userId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, targetUserId, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +98,7 @@ func (s *followsService) GetUserFollowers(userId int, targetUserId int) (*[]mode
 		return nil, ErrNoUser
 	}
 
-	users, err := s.ur.GetUsers(s.fr.FilterByFollowingId(targetUserId))
+	users, err := s.ur.GetUsers(ctx, s.fr.FilterByFollowingId(targetUserId))
 	if err != nil {
 		return nil, err
 	}
@@ -110,29 +111,29 @@ func (s *followsService) GetUserFollowers(userId int, targetUserId int) (*[]mode
 }
 
 // GetUserFollowings - Get user followings
-func (s *followsService) GetUserFollowings(userId int, targetUserId int) (*[]models.BaseUser, error) {
-	user, err := s.ur.GetUserById(userId)
+func (s *followsService) GetUserFollowings(ctx context.Context, userId int, targetUserId int) (*[]models.BaseUser, error) {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	user, err = s.ur.GetUserById(targetUserId)
+	user, err = s.ur.GetUserById(ctx, targetUserId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(userId, targetUserId)
+	isBannedForUser, err := s.br.GetBanExists(ctx, userId, targetUserId)
 	if err != nil {
 		return nil, err
 	}
 	if isBannedForUser {
 		return nil, ErrNoUser
 	}
-	isBannedForUser, err = s.br.GetBanExists(targetUserId, userId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, targetUserId, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +141,7 @@ func (s *followsService) GetUserFollowings(userId int, targetUserId int) (*[]mod
 		return nil, ErrNoUser
 	}
 
-	users, err := s.ur.GetUsers(s.fr.FilterByFollowerId(targetUserId))
+	users, err := s.ur.GetUsers(ctx, s.fr.FilterByFollowerId(targetUserId))
 	if err != nil {
 		return nil, err
 	}
@@ -152,29 +153,29 @@ func (s *followsService) GetUserFollowings(userId int, targetUserId int) (*[]mod
 }
 
 // UnfollowUser - Unfollow a user
-func (s *followsService) UnfollowUser(followerUserId, followingUserId int) error {
-	user, err := s.ur.GetUserById(followerUserId)
+func (s *followsService) UnfollowUser(ctx context.Context, followerUserId, followingUserId int) error {
+	user, err := s.ur.GetUserById(ctx, followerUserId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	user, err = s.ur.GetUserById(followingUserId)
+	user, err = s.ur.GetUserById(ctx, followingUserId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(followerUserId, followingUserId)
+	isBannedForUser, err := s.br.GetBanExists(ctx, followerUserId, followingUserId)
 	if err != nil {
 		return err
 	}
 	if isBannedForUser {
 		return ErrNoUser
 	}
-	isBannedForUser, err = s.br.GetBanExists(followingUserId, followerUserId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, followingUserId, followerUserId)
 	if err != nil {
 		return err
 	}
@@ -182,7 +183,7 @@ func (s *followsService) UnfollowUser(followerUserId, followingUserId int) error
 		return ErrNoUser
 	}
 
-	if err := s.fr.RemoveFollow(followerUserId, followingUserId); err != nil {
+	if err := s.fr.RemoveFollow(ctx, followerUserId, followingUserId); err != nil {
 		return err
 	}
 	return nil
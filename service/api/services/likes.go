@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 
 	"github.com/lucaronca/wasa-homework/service/api/models"
@@ -12,9 +13,9 @@ var ErrNoLike = errors.New("Like not found")
 
 // LikesService defines the api actions to like/unlike a photo
 type LikesService interface {
-	LikePhoto(int, int) error
-	UnlikePhoto(int, int) error
-	GetPhotoLikes(int, int) (*[]models.Like, error)
+	LikePhoto(context.Context, int, int) error
+	UnlikePhoto(context.Context, int, int) error
+	GetPhotoLikes(context.Context, int, int) (*[]models.Like, error)
 }
 
 // likesService is a service that implements the logic for the LikesService
@@ -36,29 +37,29 @@ func NewLikesService(ur repositories.UsersRepository, br repositories.BansReposi
 }
 
 // LikePhoto - Put a like to a photo
-func (s *likesService) LikePhoto(photoId, userId int) error {
-	photo, err := s.pr.GetPhotoById(photoId)
+func (s *likesService) LikePhoto(ctx context.Context, photoId, userId int) error {
+	photo, err := s.pr.GetPhotoById(ctx, photoId)
 	if err != nil {
 		return err
 	}
 	if photo == nil {
 		return ErrNoPhoto
 	}
-	user, err := s.ur.GetUserById(userId)
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(userId, photo.Owner.Id)
+	isBannedForUser, err := s.br.GetBanExists(ctx, userId, photo.Owner.Id)
 	if err != nil {
 		return err
 	}
 	if isBannedForUser {
 		return ErrNoPhoto
 	}
-	isBannedForUser, err = s.br.GetBanExists(photo.Owner.Id, userId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, photo.Owner.Id, userId)
 	if err != nil {
 		return err
 	}
@@ -66,36 +67,36 @@ func (s *likesService) LikePhoto(photoId, userId int) error {
 		return ErrNoPhoto
 	}
 
-	if err := s.lr.SetLike(photo.Id, user.Id, globaltime.Now()); err != nil {
+	if err := s.lr.SetLike(ctx, photo.Id, user.Id, globaltime.Now()); err != nil {
 		return err
 	}
 	return nil
 }
 
 // UnlikePhoto - Remove a like from a photo
-func (s *likesService) UnlikePhoto(photoId, userId int) error {
-	photo, err := s.pr.GetPhotoById(photoId)
+func (s *likesService) UnlikePhoto(ctx context.Context, photoId, userId int) error {
+	photo, err := s.pr.GetPhotoById(ctx, photoId)
 	if err != nil {
 		return err
 	}
 	if photo == nil {
 		return ErrNoPhoto
 	}
-	user, err := s.ur.GetUserById(userId)
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoPhoto
 	}
-	isBannedForUser, err := s.br.GetBanExists(userId, photo.Owner.Id)
+	isBannedForUser, err := s.br.GetBanExists(ctx, userId, photo.Owner.Id)
 	if err != nil {
 		return err
 	}
 	if isBannedForUser {
 		return ErrNoPhoto
 	}
-	isBannedForUser, err = s.br.GetBanExists(photo.Owner.Id, userId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, photo.Owner.Id, userId)
 	if err != nil {
 		return err
 	}
@@ -103,7 +104,7 @@ func (s *likesService) UnlikePhoto(photoId, userId int) error {
 		return ErrNoPhoto
 	}
 
-	likes, err := s.lr.GetLikes(s.ur.WithUsers(), s.ur.FilterByUserId(userId), s.pr.FilterByPhotoId(photoId))
+	likes, err := s.lr.GetLikes(ctx, s.ur.WithUsers(), s.ur.FilterByUserId(userId), s.pr.FilterByPhotoId(photoId))
 	if err != nil {
 		return err
 	}
@@ -111,36 +112,36 @@ func (s *likesService) UnlikePhoto(photoId, userId int) error {
 		return ErrNoLike
 	}
 
-	if err := s.lr.RemoveLike(photoId, userId); err != nil {
+	if err := s.lr.RemoveLike(ctx, photoId, userId); err != nil {
 		return err
 	}
 	return nil
 }
 
 // GetPhotoLikes - Get photo likes
-func (s *likesService) GetPhotoLikes(photoId, userId int) (*[]models.Like, error) {
-	photo, err := s.pr.GetPhotoById(photoId)
+func (s *likesService) GetPhotoLikes(ctx context.Context, photoId, userId int) (*[]models.Like, error) {
+	photo, err := s.pr.GetPhotoById(ctx, photoId)
 	if err != nil {
 		return nil, err
 	}
 	if photo == nil {
 		return nil, ErrNoPhoto
 	}
-	user, err := s.ur.GetUserById(userId)
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	isBannedForUser, err := s.br.GetBanExists(userId, photo.Owner.Id)
+	isBannedForUser, err := s.br.GetBanExists(ctx, userId, photo.Owner.Id)
 	if err != nil {
 		return nil, err
 	}
 	if isBannedForUser {
 		return nil, ErrNoPhoto
 	}
-	isBannedForUser, err = s.br.GetBanExists(photo.Owner.Id, userId)
+	isBannedForUser, err = s.br.GetBanExists(ctx, photo.Owner.Id, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -148,7 +149,7 @@ func (s *likesService) GetPhotoLikes(photoId, userId int) (*[]models.Like, error
 		return nil, ErrNoPhoto
 	}
 
-	likes, err := s.lr.GetLikes(s.ur.WithUsers(), s.pr.FilterByPhotoId(photoId))
+	likes, err := s.lr.GetLikes(ctx, s.ur.WithUsers(), s.pr.FilterByPhotoId(photoId))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"golang.org/x/oauth2"
+)
+
+var ErrOIDCStateMismatch = errors.New("OpenID Connect login state is not valid")
+var ErrOIDCInvalidIDToken = errors.New("OpenID Connect ID token is not valid")
+
+// OIDCConfig - Settings of the external OpenID Connect provider
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type OIDCService interface {
+	StartLogin(ctx context.Context) (attempt *models.OIDCLoginAttempt, authURL string, err error)
+	FinishLogin(
+		ctx context.Context,
+		attempt models.OIDCLoginAttempt,
+		state string,
+		code string,
+		userAgent string,
+	) (*models.LoginResult, error)
+}
+
+type oidcService struct {
+	cfg OIDCConfig
+	as  AuthService
+
+	// The provider is discovered on the first login, so that the API starts even if the provider is unreachable
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDCService(cfg OIDCConfig, as AuthService) OIDCService {
+	return &oidcService{
+		cfg: cfg,
+		as:  as,
+	}
+}
+
+// getProvider returns the provider, fetching its discovery document if it wasn't fetched yet
+func (s *oidcService) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider == nil {
+		provider, err := oidc.NewProvider(ctx, s.cfg.IssuerURL)
+		if err != nil {
+			return nil, fmt.Errorf("discovering the OpenID Connect provider: %w", err)
+		}
+		s.provider = provider
+	}
+	return s.provider, nil
+}
+
+func (s *oidcService) oauth2Config(provider *oidc.Provider) oauth2.Config {
+	scopes := s.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID}
+	}
+	return oauth2.Config{
+		ClientID:     s.cfg.ClientID,
+		ClientSecret: s.cfg.ClientSecret,
+		RedirectURL:  s.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+}
+
+// StartLogin - Create a login attempt and the provider URL the user has to be redirected to
+func (s *oidcService) StartLogin(ctx context.Context) (*models.OIDCLoginAttempt, string, error) {
+	provider, err := s.getProvider(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	state, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	oauth2Config := s.oauth2Config(provider)
+	authURL := oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce))
+	return &models.OIDCLoginAttempt{State: state, Nonce: nonce}, authURL, nil
+}
+
+// FinishLogin - Exchange the authorization code, validate the ID token against the provider keys, issuer, audience
+// and the attempt nonce, then start a session for the user linked to the token subject, or its login challenge
+func (s *oidcService) FinishLogin(
+	ctx context.Context,
+	attempt models.OIDCLoginAttempt,
+	state string,
+	code string,
+	userAgent string,
+) (*models.LoginResult, error) {
+	if attempt.State == "" || subtle.ConstantTimeCompare([]byte(attempt.State), []byte(state)) != 1 {
+		return nil, ErrOIDCStateMismatch
+	}
+	provider, err := s.getProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	oauth2Config := s.oauth2Config(provider)
+	oauth2Token, err := oauth2Config.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging the OpenID Connect authorization code: %w", err)
+	}
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrOIDCInvalidIDToken
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: s.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrOIDCInvalidIDToken, err.Error())
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(attempt.Nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidIDToken)
+	}
+
+	var claims struct {
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrOIDCInvalidIDToken, err.Error())
+	}
+	username := claims.PreferredUsername
+	if username == "" {
+		username = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	return s.as.LoginWithIdentity(ctx, idToken.Issuer, idToken.Subject, username, userAgent)
+}
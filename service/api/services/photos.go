@@ -2,20 +2,27 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"image"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/repositories"
 	"github.com/lucaronca/wasa-homework/service/globaltime"
+	"github.com/lucaronca/wasa-homework/service/imaging"
+	"github.com/lucaronca/wasa-homework/service/photofiles"
 )
 
 var ErrNoPhoto = errors.New("Photo not found")
 var ErrPhotoFormatNotSupported = errors.New("Unsupported image type")
+var ErrPhotoTooLarge = errors.New("Photo too large")
 var allowedImagesTypes = map[string]string{
 	"image/jpeg": "jpeg",
 	"image/png":  "png",
@@ -24,16 +31,27 @@ var allowedImagesTypes = map[string]string{
 
 // PhotosService defines the api actions to manage photos
 type PhotosService interface {
-	GetUserPhotos(int, int, int, int) (*models.PaginatedPhotos, error)
-	GetStream(int, int, int) (*models.PaginatedPhotos, error)
-	CreatePhoto(int, io.Reader) (*models.Photo, error)
-	DeletePhoto(int, int) error
+	GetUserPhotos(context.Context, int, int, int, int) (*models.PaginatedPhotos, error)
+	GetStream(context.Context, int, int, int) (*models.PaginatedPhotos, error)
+	CreatePhoto(context.Context, int, io.Reader, models.PhotoDescription) (*models.Photo, error)
+	UpdatePhotoDescription(context.Context, int, int, *string, *string) (*models.Photo, error)
+	DeletePhoto(context.Context, int, int) error
+	GetTrash(context.Context, int) (*[]models.Photo, error)
+	RestorePhoto(context.Context, int, int) (*models.Photo, error)
+	PurgeTrash(context.Context) (int, error)
+	BackfillPhotoImages(context.Context) (int, map[int]error, error)
 }
 
 // photosService is a service that implements the logic for the PhotosService
 type photosService struct {
 	photosDirectory string
 	photosUrlPath   string
+	trashRetention  time.Duration
+	variantWidths   []int
+	keepTakenAt     bool
+	maxPixels       int
+	processing      chan struct{}
+	uow             repositories.UnitOfWork
 	ur              repositories.UsersRepository
 	br              repositories.BansRepository
 	pr              repositories.PhotosRepository
@@ -42,10 +60,20 @@ type photosService struct {
 	fr              repositories.FollowsRepository
 }
 
-// NewPhotosService creates a default api service
+// NewPhotosService creates a default api service. Deleted photos are kept in the trash for trashRetention, before
+// PurgeTrash removes them for good. Every uploaded photo is resized to each of variantWidths narrower than the photo.
+// The metadata of the uploaded photos is stripped, the date they were taken is kept only if keepTakenAt is true. The
+// photos with more than maxPixels pixels are refused before they're decoded, and at most maxProcessing photos are
+// decoded at once, so that the memory taken by their pixels is bounded.
 func NewPhotosService(
 	photosDirectory string,
 	photosUrlPath string,
+	trashRetention time.Duration,
+	variantWidths []int,
+	keepTakenAt bool,
+	maxPixels int,
+	maxProcessing int,
+	uow repositories.UnitOfWork,
 	ur repositories.UsersRepository,
 	br repositories.BansRepository,
 	pr repositories.PhotosRepository,
@@ -56,6 +84,12 @@ func NewPhotosService(
 	return &photosService{
 		photosDirectory: photosDirectory,
 		photosUrlPath:   photosUrlPath,
+		trashRetention:  trashRetention,
+		variantWidths:   variantWidths,
+		keepTakenAt:     keepTakenAt,
+		maxPixels:       maxPixels,
+		processing:      make(chan struct{}, maxProcessing),
+		uow:             uow,
 		ur:              ur,
 		br:              br,
 		pr:              pr,
@@ -65,15 +99,15 @@ func NewPhotosService(
 	}
 }
 
-func (s *photosService) GetUserPhotos(userId, targetUserId, offset, limit int) (*models.PaginatedPhotos, error) {
-	user, err := s.ur.GetUserById(userId)
+func (s *photosService) GetUserPhotos(ctx context.Context, userId, targetUserId, offset, limit int) (*models.PaginatedPhotos, error) {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	targetUser, err := s.ur.GetUserById(targetUserId)
+	targetUser, err := s.ur.GetUserById(ctx, targetUserId)
 	if err != nil {
 		return nil, err
 	}
@@ -81,14 +115,14 @@ func (s *photosService) GetUserPhotos(userId, targetUserId, offset, limit int) (
 		return nil, ErrNoUser
 	}
 	if userId != targetUserId {
-		isBannedForUser, err := s.br.GetBanExists(userId, targetUserId)
+		isBannedForUser, err := s.br.GetBanExists(ctx, userId, targetUserId)
 		if err != nil {
 			return nil, err
 		}
 		if isBannedForUser {
 			return nil, ErrNoUser
 		}
-		isBannedForUser, err = s.br.GetBanExists(targetUserId, userId)
+		isBannedForUser, err = s.br.GetBanExists(ctx, targetUserId, userId)
 		if err != nil {
 			return nil, err
 		}
@@ -99,7 +133,7 @@ func (s *photosService) GetUserPhotos(userId, targetUserId, offset, limit int) (
 
 	out := NewWorkersFacade(
 		NewJob(func(sendRes SendFunc) {
-			result, err := s.pr.GetPhotos(
+			result, err := s.pr.GetPhotos(ctx,
 				offset,
 				limit,
 				s.ur.WithUsers(),
@@ -119,7 +153,7 @@ func (s *photosService) GetUserPhotos(userId, targetUserId, offset, limit int) (
 			sendRes(result, nil)
 		}),
 		NewJob(func(sendRes SendFunc) {
-			result, err := s.pr.GetPhotosCount(s.ur.FilterByUserId(targetUserId))
+			result, err := s.pr.GetPhotosCount(ctx, s.ur.FilterByUserId(targetUserId))
 			if err != nil {
 				sendRes(nil, err)
 			}
@@ -150,8 +184,8 @@ func (s *photosService) GetUserPhotos(userId, targetUserId, offset, limit int) (
 	}, nil
 }
 
-func (s *photosService) GetStream(targetUserId, offset, limit int) (*models.PaginatedPhotos, error) {
-	user, err := s.ur.GetUserById(targetUserId)
+func (s *photosService) GetStream(ctx context.Context, targetUserId, offset, limit int) (*models.PaginatedPhotos, error) {
+	user, err := s.ur.GetUserById(ctx, targetUserId)
 	if err != nil {
 		return nil, err
 	}
@@ -161,7 +195,7 @@ func (s *photosService) GetStream(targetUserId, offset, limit int) (*models.Pagi
 
 	out := NewWorkersFacade(
 		NewJob(func(sendRes SendFunc) {
-			result, err := s.pr.GetPhotos(
+			result, err := s.pr.GetPhotos(ctx,
 				offset,
 				limit,
 				s.ur.WithUsers(),
@@ -182,7 +216,7 @@ func (s *photosService) GetStream(targetUserId, offset, limit int) (*models.Pagi
 			sendRes(result, nil)
 		}),
 		NewJob(func(sendRes SendFunc) {
-			result, err := s.pr.GetPhotosCount(
+			result, err := s.pr.GetPhotosCount(ctx,
 				s.fr.FilterByFollowerId(targetUserId),
 				s.br.WithoutBanned(targetUserId),
 			)
@@ -216,16 +250,16 @@ func (s *photosService) GetStream(targetUserId, offset, limit int) (*models.Pagi
 	}, nil
 }
 
-func (s *photosService) CreatePhoto(userId int, photo io.Reader) (*models.Photo, error) {
+func (s *photosService) CreatePhoto(ctx context.Context, userId int, photo io.Reader, description models.PhotoDescription) (*models.Photo, error) {
 	// Read first 512 bytes of file to get its content type
 	header := make([]byte, 512)
 	shortPhoto := false
-	if _, err := io.ReadFull(photo, header); err != nil {
-		if errors.Is(err, io.ErrShortBuffer) {
-			shortPhoto = true
-		} else {
+	if n, err := io.ReadFull(photo, header); err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
 			return nil, err
 		}
+		header = header[:n]
+		shortPhoto = true
 	}
 	contentType := http.DetectContentType(header)
 
@@ -239,88 +273,506 @@ func (s *photosService) CreatePhoto(userId int, photo io.Reader) (*models.Photo,
 		return nil, err
 	}
 
-	photoNameWithExt := photoName.String() + "." + ext
-	photoFilePath := filepath.Join(s.photosDirectory, photoNameWithExt)
+	// The upload is written aside, the photo is stored once its metadata is stripped. The names starting with a dot
+	// are skipped by the backups.
+	uploadPath := filepath.Join(s.photosDirectory, "."+photoName.String()+"."+ext+".upload")
+	defer func() {
+		_ = os.Remove(uploadPath)
+	}()
+	if err := writePhotoAsset(uploadPath, header, photo, shortPhoto); err != nil {
+		return nil, err
+	}
 
-	out := NewWorkersFacade(
-		// Save photo resource
-		NewJob(func(sendRes SendFunc) {
-			photoId, err := s.pr.SetPhoto(filepath.Join(s.photosUrlPath, photoNameWithExt), userId, globaltime.Now())
+	// The photo assets are written before the photo resource is committed, so that there's never a photo without its
+	// assets: if either fails both are discarded
+	var written []string
+	assets, err := s.writePhotoAssets(ctx, uploadPath, photoName.String(), &written)
+	var newPhoto *models.Photo
+	if err == nil {
+		err = s.uow.Do(ctx, func(r *repositories.Repositories) error {
+			photoId, err := r.Photos.SetPhoto(ctx, assets.url, userId, globaltime.Now())
 			if err != nil {
-				sendRes(nil, err)
+				return err
 			}
-
-			newPhoto, err := s.pr.GetPhotoById(photoId)
-			if err != nil {
-				sendRes(nil, err)
+			if err := r.Photos.SetPhotoImage(ctx, photoId, assets.image); err != nil {
+				return err
 			}
-			sendRes(newPhoto, nil)
-		}),
-		// Save photo asset
-		NewJob(func(sendRes SendFunc) {
-			// Open a new file with specific permissions to append bytes to it
-			file, err := os.OpenFile(photoFilePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-			if err != nil {
-				sendRes(nil, err)
+			if err := r.Photos.SetPhotoVariants(ctx, photoId, assets.variants); err != nil {
+				return err
 			}
-			defer file.Close()
-
-			// Copy the first 512 bytes of the photo to the file
-			_, err = io.Copy(file, bytes.NewReader(header))
-			if err != nil {
-				sendRes(nil, err)
+			if assets.takenAt != nil {
+				if err := r.Photos.SetPhotoTakenAt(ctx, photoId, *assets.takenAt); err != nil {
+					return err
+				}
 			}
-			// Copy the remaining bytes of the photo to the file, io.Copy uses a buffer so it's efficient
-			if !shortPhoto {
-				_, err = io.Copy(file, photo)
-				if err != nil {
-					sendRes(nil, err)
+			if description != (models.PhotoDescription{}) {
+				if err := r.Photos.SetPhotoDescription(ctx, photoId, description); err != nil {
+					return err
 				}
 			}
+			newPhoto, err = r.Photos.GetPhotoById(ctx, photoId)
+			return err
+		})
+	}
+	if err != nil {
+		for _, path := range written {
+			_ = os.Remove(path)
+		}
+		return nil, err
+	}
 
-			sendRes(nil, nil)
-		}),
-	)
+	return newPhoto, nil
+}
 
-	var newPhoto *models.Photo
-	for work := range out {
-		if work.err != nil {
-			return nil, work.err
+// photoAssets are the files of an uploaded photo, and what its metadata told before it was stripped
+type photoAssets struct {
+	url      string
+	image    models.PhotoImage
+	variants []models.PhotoVariant
+	takenAt  *time.Time
+}
+
+// writePhotoAssets writes the photo uploaded at uploadPath without its metadata, named after name, and its resized
+// copies for each of the variant widths narrower than the photo. The photo is re-encoded if its EXIF orientation has
+// to be applied to its pixels, and rewritten as it is otherwise. The paths of the written files are appended to
+// written, also on failure.
+func (s *photosService) writePhotoAssets(ctx context.Context, uploadPath string, name string, written *[]string) (*photoAssets, error) {
+	release, err := s.acquireProcessing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	img, format, err := decodePhotoFile(uploadPath, s.maxPixels)
+	if errors.Is(err, imaging.ErrTooManyPixels) {
+		return nil, ErrPhotoTooLarge
+	}
+	if err != nil {
+		return nil, ErrPhotoFormatNotSupported
+	}
+	// The upload is at most the maximum upload size, it's read whole for its metadata only
+	data, err := os.ReadFile(uploadPath)
+	if err != nil {
+		return nil, err
+	}
+	metadata := imaging.ReadMetadata(data, format)
+
+	var assets photoAssets
+	if s.keepTakenAt {
+		assets.takenAt = metadata.TakenAt
+	}
+	storedFormat := format
+	write := func(w io.Writer) error {
+		return imaging.StripMetadata(w, data, format)
+	}
+	if metadata.Orientation != 1 {
+		img = imaging.Orient(img, metadata.Orientation)
+		storedFormat = imaging.EncodingFormat(img, format)
+		write = func(w io.Writer) error {
+			return imaging.Encode(w, img, storedFormat)
 		}
-		if work.idx == 0 {
-			newPhoto, _ = work.res.(*models.Photo)
+	}
+	fileName := name + "." + storedFormat
+	filePath := filepath.Join(s.photosDirectory, fileName)
+	if err := writeNewFile(filePath, written, write); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	assets.url = filepath.Join(s.photosUrlPath, fileName)
+	assets.image = describePhoto(img, storedFormat, info.Size())
+
+	encodingFormat := imaging.EncodingFormat(img, format)
+	for _, width := range s.variantWidths {
+		if width >= img.Bounds().Dx() {
+			continue
+		}
+		resized := imaging.Resize(img, width)
+		fileName := name + "_" + strconv.Itoa(width) + "." + encodingFormat
+		err := writeNewFile(filepath.Join(s.photosDirectory, fileName), written, func(w io.Writer) error {
+			return imaging.Encode(w, resized, encodingFormat)
+		})
+		if err != nil {
+			return nil, err
 		}
+		assets.variants = append(assets.variants, models.PhotoVariant{
+			Url:    filepath.Join(s.photosUrlPath, fileName),
+			Width:  width,
+			Height: resized.Bounds().Dy(),
+		})
 	}
+	return &assets, nil
+}
 
-	return newPhoto, nil
+// acquireProcessing waits until a photo can be decoded, or until ctx is done. The returned func lets the next one be
+// decoded.
+func (s *photosService) acquireProcessing(ctx context.Context) (func(), error) {
+	select {
+	case s.processing <- struct{}{}:
+		return func() { <-s.processing }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
-// DeletePhoto - Delete a photos
-func (s *photosService) DeletePhoto(userId, photoId int) error {
-	user, err := s.ur.GetUserById(userId)
+// decodePhotoFile decodes the photo file at path, if it has at most maxPixels pixels
+func decodePhotoFile(path string, maxPixels int) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return imaging.DecodeLimited(file, maxPixels)
+}
+
+// describePhoto returns what the image of a photo is, given its pixels as they're shown, the format it's stored with
+// and the size of its file
+func describePhoto(img image.Image, format string, size int64) models.PhotoImage {
+	return models.PhotoImage{
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+		Size:     size,
+		MimeType: "image/" + format,
+		BlurHash: imaging.BlurHash(img),
+	}
+}
+
+// writeNewFile writes a new file at path with write, failing if it already exists. The path is appended to written
+// once the file is created.
+func writeNewFile(path string, written *[]string, write func(w io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	*written = append(*written, path)
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writePhotoAsset writes the photo to a new file, starting from its already read header
+func writePhotoAsset(path string, header []byte, photo io.Reader, shortPhoto bool) error {
+	// Open a new file with specific permissions, failing if it already exists
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Copy the header of the photo to the file, it's shorter than 512 bytes if the photo is
+	_, err = io.Copy(file, bytes.NewReader(header))
+	// Copy the remaining bytes of the photo to the file, io.Copy uses a buffer so it's efficient
+	if err == nil && !shortPhoto {
+		_, err = io.Copy(file, photo)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// DeletePhoto - Move a photo to the trash, it can be restored until it's purged. Its files aren't served meanwhile.
+func (s *photosService) DeletePhoto(ctx context.Context, userId, photoId int) error {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return err
 	}
 	if user == nil {
 		return ErrNoUser
 	}
-	photo, err := s.pr.GetPhotoById(photoId)
+
+	var hidden *models.Photo
+	err = s.uow.Do(ctx, func(r *repositories.Repositories) error {
+		hidden = nil
+		photo, err := r.Photos.GetPhotoById(ctx, photoId)
+		if err != nil {
+			return err
+		}
+		if photo == nil {
+			return ErrNoPhoto
+		}
+		if userId != photo.Owner.Id {
+			return ErrUserForbidden
+		}
+		if err := r.Photos.TrashPhoto(ctx, photoId, globaltime.Now()); err != nil {
+			return err
+		}
+		// The files are renamed along with the row, so that a backup finds them as the rows it copies tell
+		if err := s.renamePhotoFiles(photo, shownPhotoFile, photofiles.Trashed); err != nil {
+			return err
+		}
+		hidden = photo
+		return nil
+	})
+	if err != nil && hidden != nil {
+		_ = s.renamePhotoFiles(hidden, photofiles.Trashed, shownPhotoFile)
+	}
+	return err
+}
+
+// shownPhotoFile returns the name of a file of a photo out of the trash, the one it was written with
+func shownPhotoFile(name string) string {
+	return name
+}
+
+// renamePhotoFiles renames the files of a photo, and of its variants, from the names returned by from to the ones
+// returned by to. The missing files are skipped, like the ones of the photos trashed before their files were renamed.
+// If a file can't be renamed the ones already renamed are renamed back.
+func (s *photosService) renamePhotoFiles(photo *models.Photo, from, to func(string) string) error {
+	names := []string{filepath.Base(photo.Url)}
+	for _, variant := range photo.Variants {
+		names = append(names, filepath.Base(variant.Url))
+	}
+
+	var renamed []string
+	for _, name := range names {
+		err := os.Rename(filepath.Join(s.photosDirectory, from(name)), filepath.Join(s.photosDirectory, to(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, name := range renamed {
+				_ = os.Rename(filepath.Join(s.photosDirectory, to(name)), filepath.Join(s.photosDirectory, from(name)))
+			}
+			return err
+		}
+		renamed = append(renamed, name)
+	}
+	return nil
+}
+
+// UpdatePhotoDescription - Replace the caption and the alt text of a photo of the user, a nil one is left as it is
+func (s *photosService) UpdatePhotoDescription(ctx context.Context, userId, photoId int, caption, altText *string) (*models.Photo, error) {
+	err := s.uow.Do(ctx, func(r *repositories.Repositories) error {
+		photo, err := r.Photos.GetPhotoById(ctx, photoId)
+		if err != nil {
+			return err
+		}
+		if photo == nil {
+			return ErrNoPhoto
+		}
+		if userId != photo.Owner.Id {
+			return ErrUserForbidden
+		}
+		description := photo.PhotoDescription
+		if caption != nil {
+			description.Caption = *caption
+		}
+		if altText != nil {
+			description.AltText = *altText
+		}
+		return r.Photos.SetPhotoDescription(ctx, photoId, description)
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// The photo is returned like in the user photos, along with its totals
+	photos, err := s.pr.GetPhotos(ctx,
+		0,
+		1,
+		s.ur.WithUsers(),
+		s.lr.WithTotalLikes(),
+		s.cr.WithTotalComments(),
+		s.lr.WithLikedBy(userId),
+		s.pr.FilterByPhotoId(photoId),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(*photos) == 0 {
+		return nil, ErrNoPhoto
 	}
-	if photo == nil {
-		return ErrNoPhoto
+	return &(*photos)[0], nil
+}
+
+// GetTrash - Get the photos of the user in the trash, along with the date they'll be purged
+func (s *photosService) GetTrash(ctx context.Context, userId int) (*[]models.Photo, error) {
+	user, err := s.ur.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
 	}
-	if userId != photo.Owner.Id {
-		return ErrUserForbidden
+	if user == nil {
+		return nil, ErrNoUser
 	}
 
-	if err := s.pr.RemovePhoto(photoId); err != nil {
+	photos, err := s.pr.GetTrashedPhotos(ctx, s.ur.FilterByUserId(userId))
+	if err != nil {
+		return nil, err
+	}
+	for i := range *photos {
+		photo := &(*photos)[i]
+		purgeAt := photo.DeletedAt.Add(s.trashRetention)
+		photo.PurgeAt = &purgeAt
+	}
+	return photos, nil
+}
+
+// RestorePhoto - Move a photo of the user out of the trash, its files are served again
+func (s *photosService) RestorePhoto(ctx context.Context, userId, photoId int) (*models.Photo, error) {
+	var photo, shown *models.Photo
+	err := s.uow.Do(ctx, func(r *repositories.Repositories) error {
+		shown = nil
+		trashed, err := r.Photos.GetTrashedPhotos(ctx, r.Photos.FilterByPhotoId(photoId))
+		if err != nil {
+			return err
+		}
+		// The photos in the trash of the other users aren't disclosed
+		if len(*trashed) == 0 || (*trashed)[0].Owner.Id != userId {
+			return ErrNoPhoto
+		}
+		if err := r.Photos.RestorePhoto(ctx, photoId); err != nil {
+			return err
+		}
+		if err := s.renamePhotoFiles(&(*trashed)[0], photofiles.Trashed, shownPhotoFile); err != nil {
+			return err
+		}
+		shown = &(*trashed)[0]
+		photo, err = r.Photos.GetPhotoById(ctx, photoId)
 		return err
+	})
+	if err != nil {
+		if shown != nil {
+			_ = s.renamePhotoFiles(shown, shownPhotoFile, photofiles.Trashed)
+		}
+		return nil, err
 	}
-	filePath := filepath.Join(s.photosDirectory, filepath.Base(photo.Url))
-	if err := os.Remove(filePath); err != nil {
+	return photo, nil
+}
+
+// PurgeTrash - Remove for good the photos in the trash for longer than the retention period, along with their assets,
+// likes and comments, and hide the files of the other ones if they're still served. It returns how many photos were
+// removed.
+func (s *photosService) PurgeTrash(ctx context.Context) (int, error) {
+	deletedBefore := globaltime.Now().Add(-s.trashRetention)
+	expired, err := s.pr.GetTrashedPhotos(ctx, s.pr.FilterByDeletedBefore(deletedBefore))
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int
+	for _, photo := range *expired {
+		removed := false
+		err := s.uow.Do(ctx, func(r *repositories.Repositories) error {
+			// The photo could have been restored meanwhile
+			trashed, err := r.Photos.GetTrashedPhotos(ctx,
+				r.Photos.FilterByPhotoId(photo.Id),
+				r.Photos.FilterByDeletedBefore(deletedBefore),
+			)
+			if err != nil || len(*trashed) == 0 {
+				return err
+			}
+			// The photo resource is kept if its assets can't be removed
+			if err := r.Photos.RemovePhoto(ctx, photo.Id); err != nil {
+				return err
+			}
+			urls := []string{photo.Url}
+			for _, variant := range photo.Variants {
+				urls = append(urls, variant.Url)
+			}
+			// The files of the photos trashed before their files were renamed have still their own name
+			for _, url := range urls {
+				for _, name := range []string{photofiles.Trashed(filepath.Base(url)), filepath.Base(url)} {
+					filePath := filepath.Join(s.photosDirectory, name)
+					if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+						return err
+					}
+				}
+			}
+			removed = true
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		if removed {
+			purged++
+		}
+	}
+	return purged, s.hideTrashedPhotoFiles(ctx)
+}
+
+// hideTrashedPhotoFiles renames the files of the photos in the trash that are still served, the ones trashed before
+// their files were renamed
+func (s *photosService) hideTrashedPhotoFiles(ctx context.Context) error {
+	trash, err := s.pr.GetTrashedPhotos(ctx)
+	if err != nil {
 		return err
 	}
+	for _, photo := range *trash {
+		if _, err := os.Stat(filepath.Join(s.photosDirectory, filepath.Base(photo.Url))); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err := s.uow.Do(ctx, func(r *repositories.Repositories) error {
+			// The photo could have been restored meanwhile
+			trashed, err := r.Photos.GetTrashedPhotos(ctx, r.Photos.FilterByPhotoId(photo.Id))
+			if err != nil || len(*trashed) == 0 {
+				return err
+			}
+			return s.renamePhotoFiles(&(*trashed)[0], shownPhotoFile, photofiles.Trashed)
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// BackfillPhotoImages - Describe the images of the photos uploaded before their dimensions, size, type and BlurHash
+// were stored, reading their files from the photos directory. The photos whose file can't be read or decoded are
+// skipped, they're returned with the reason by id. It returns how many photos were described.
+func (s *photosService) BackfillPhotoImages(ctx context.Context) (int, map[int]error, error) {
+	photos, err := s.pr.GetPhotosWithoutImage(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var described int
+	skipped := make(map[int]error)
+	for _, photo := range *photos {
+		if err := ctx.Err(); err != nil {
+			return described, skipped, err
+		}
+		photoImage, err := s.describePhotoFile(ctx, filepath.Join(s.photosDirectory, filepath.Base(photo.Url)))
+		if err != nil {
+			skipped[photo.Id] = err
+			continue
+		}
+		if err := s.pr.SetPhotoImage(ctx, photo.Id, *photoImage); err != nil {
+			return described, skipped, err
+		}
+		described++
+	}
+	return described, skipped, nil
+}
+
+// describePhotoFile describes the image of the photo file at path, as it's shown
+func (s *photosService) describePhotoFile(ctx context.Context, path string) (*models.PhotoImage, error) {
+	release, err := s.acquireProcessing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	img, format, err := decodePhotoFile(path, s.maxPixels)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// The photos uploaded before their metadata was stripped are still shown rotated by their EXIF orientation
+	if orientation := imaging.ReadMetadata(data, format).Orientation; orientation != 1 {
+		img = imaging.Orient(img, orientation)
+	}
+	photoImage := describePhoto(img, format, int64(len(data)))
+	return &photoImage, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors for HMAC-SHA1, truncated to 6 digits
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		if code := totpCode(secret, totpStep(time.Unix(v.unix, 0))); code != v.code {
+			t.Errorf("at %d expected %s got: %s", v.unix, v.code, code)
+		}
+	}
+}
+
+func TestVerifyTOTPCode_Window(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+	step := totpStep(now)
+
+	previous := totpCode([]byte("12345678901234567890"), step-1)
+	if matched, ok := verifyTOTPCode(secret, previous, now, 0); !ok || matched != step-1 {
+		t.Error("expected the code of the previous step to be accepted")
+	}
+	tooOld := totpCode([]byte("12345678901234567890"), step-2)
+	if _, ok := verifyTOTPCode(secret, tooOld, now, 0); ok {
+		t.Error("expected a code older than the window to be rejected")
+	}
+	current := totpCode([]byte("12345678901234567890"), step)
+	if _, ok := verifyTOTPCode(secret, current, now, step); ok {
+		t.Error("expected an already used code to be rejected")
+	}
+}
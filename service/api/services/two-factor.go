@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/api/models"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+)
+
+var ErrTwoFactorAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+var ErrNoTwoFactor = errors.New("Two-factor authentication is not enabled")
+var ErrNoTwoFactorEnrollment = errors.New("Two-factor authentication enrollment not found")
+var ErrInvalidTwoFactorCode = errors.New("Two-factor code is not valid")
+var ErrInvalidLoginChallenge = errors.New("Login challenge is not valid")
+
+const (
+	// loginChallengeTTL is how long the user has to send the second factor after the password
+	loginChallengeTTL = 5 * time.Minute
+	// loginChallengeMaxAttempts is the number of wrong codes after which the login has to start over
+	loginChallengeMaxAttempts = 5
+	// recoveryCodesCount is the number of recovery codes generated at once
+	recoveryCodesCount = 10
+	// recoveryCodeBytes is the number of random bytes of a recovery code
+	recoveryCodeBytes = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCode returns a random code, formatted as two groups of four characters
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+// normalizeRecoveryCode removes the formatting the user could have changed while typing a recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// StartTwoFactorEnrollment - Create a pending TOTP secret, it's enabled once a code generated from it is verified
+func (s *authService) StartTwoFactorEnrollment(ctx context.Context, userId int, username string) (*models.TOTPEnrollment, error) {
+	totp, err := s.tfr.GetTOTP(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if totp != nil && totp.EnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tfr.SetTOTPSecret(ctx, userId, secret); err != nil {
+		return nil, err
+	}
+	return &models.TOTPEnrollment{Secret: secret, URI: totpURI(username, secret)}, nil
+}
+
+// VerifyTwoFactorEnrollment - Enable the pending TOTP secret if the code is valid, and return the recovery codes
+func (s *authService) VerifyTwoFactorEnrollment(ctx context.Context, userId int, code string) ([]string, error) {
+	codes := make([]string, recoveryCodesCount)
+	codeHashes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		var err error
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codeHashes[i] = s.hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	err := s.withTx(ctx, func(tx *authService) error {
+		totp, err := tx.tfr.GetTOTP(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if totp == nil {
+			return ErrNoTwoFactorEnrollment
+		}
+		if totp.EnabledAt != nil {
+			return ErrTwoFactorAlreadyEnabled
+		}
+
+		now := globaltime.Now()
+		ok, err := tx.useTOTPCode(ctx, totp, code, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		if err := tx.tfr.EnableTOTP(ctx, userId, now); err != nil {
+			return err
+		}
+		return tx.tfr.SetRecoveryCodes(ctx, userId, codeHashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor - Disable the two-factor authentication, the code can be a TOTP code or a recovery code
+func (s *authService) DisableTwoFactor(ctx context.Context, userId int, code string) error {
+	return s.withTx(ctx, func(tx *authService) error {
+		totp, err := tx.tfr.GetTOTP(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if totp == nil || totp.EnabledAt == nil {
+			return ErrNoTwoFactor
+		}
+		ok, err := tx.useSecondFactor(ctx, totp, code, globaltime.Now())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		return tx.tfr.RemoveTOTP(ctx, userId)
+	})
+}
+
+// VerifyLoginChallenge - Exchange a login challenge and its second factor for the session tokens. The code can be a
+// TOTP code or a recovery code.
+func (s *authService) VerifyLoginChallenge(ctx context.Context, challengeToken string, code string) (*models.SessionTokens, error) {
+	// The attempt made with a wrong code has to be committed, so the error is returned only once the unit of work is
+	// done
+	var wrongCode bool
+	var tokens *models.SessionTokens
+	err := s.withTx(ctx, func(tx *authService) error {
+		challenge, err := tx.tfr.GetLoginChallenge(ctx, tx.hashToken(challengeToken))
+		if err != nil {
+			return err
+		}
+		now := globaltime.Now()
+		if challenge == nil || !now.Before(challenge.ExpiresAt) || challenge.Attempts >= loginChallengeMaxAttempts {
+			return ErrInvalidLoginChallenge
+		}
+		totp, err := tx.tfr.GetTOTP(ctx, challenge.UserId)
+		if err != nil {
+			return err
+		}
+		if totp == nil || totp.EnabledAt == nil {
+			return ErrInvalidLoginChallenge
+		}
+
+		ok, err := tx.useSecondFactor(ctx, totp, code, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			wrongCode = true
+			return tx.tfr.IncrementLoginChallengeAttempts(ctx, challenge.Id)
+		}
+
+		if err := tx.tfr.RemoveLoginChallenge(ctx, challenge.Id); err != nil {
+			return err
+		}
+		tokens, err = tx.createSession(ctx, challenge.UserId, challenge.UserAgent)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if wrongCode {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	return tokens, nil
+}
+
+// createLoginChallenge - Store a challenge for a password login waiting for the second factor
+func (s *authService) createLoginChallenge(ctx context.Context, userId int, userAgent string) (*models.LoginResult, error) {
+	token, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := globaltime.Now().Add(loginChallengeTTL)
+	if err := s.tfr.SetLoginChallenge(ctx, userId, s.hashToken(token), userAgent, expiresAt); err != nil {
+		return nil, err
+	}
+	return &models.LoginResult{ChallengeToken: token, ChallengeExpiresAt: expiresAt}, nil
+}
+
+// useSecondFactor accepts either a TOTP code or an unused recovery code, marking it as used
+func (s *authService) useSecondFactor(ctx context.Context, totp *models.TOTP, code string, date time.Time) (bool, error) {
+	ok, err := s.useTOTPCode(ctx, totp, code, date)
+	if err != nil || ok {
+		return ok, err
+	}
+	return s.tfr.UseRecoveryCode(ctx, totp.UserId, s.hashToken(normalizeRecoveryCode(code)), date)
+}
+
+// useTOTPCode accepts a TOTP code only once, so that an observed code can't be replayed
+func (s *authService) useTOTPCode(ctx context.Context, totp *models.TOTP, code string, date time.Time) (bool, error) {
+	step, ok := verifyTOTPCode(totp.Secret, code, date, totp.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+	return s.tfr.SetTOTPLastUsedStep(ctx, totp.UserId, step)
+}
@@ -1,19 +1,21 @@
 package services
 
 import (
+	"context"
 	"errors"
 
 	"github.com/lucaronca/wasa-homework/service/api/models"
 	"github.com/lucaronca/wasa-homework/service/api/repositories"
+	"github.com/lucaronca/wasa-homework/service/usernames"
 )
 
 var ErrNoUser = errors.New("User not found")
 var ErrUserForbidden = errors.New("You are not authorized to do this operations")
 
 type UsersService interface {
-	GetUser(int, int) (*models.FullUser, error)
-	GetUsers(int, string) (*[]models.BaseUser, error)
-	UpdateUsername(int, string) (*models.FullUser, error)
+	GetUser(context.Context, int, int) (*models.FullUser, error)
+	GetUsers(context.Context, int, string) (*[]models.BaseUser, error)
+	UpdateUsername(context.Context, int, string) (*models.FullUser, error)
 }
 
 type usersService struct {
@@ -37,15 +39,15 @@ func NewUsersService(
 	}
 }
 
-func (s *usersService) GetUser(userId int, targetUserId int) (*models.FullUser, error) {
-	user, err := s.ur.GetUserById(userId)
+func (s *usersService) GetUser(ctx context.Context, userId int, targetUserId int) (*models.FullUser, error) {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
 		return nil, ErrNoUser
 	}
-	targetUser, err := s.ur.GetUserById(targetUserId)
+	targetUser, err := s.ur.GetUserById(ctx, targetUserId)
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +59,7 @@ func (s *usersService) GetUser(userId int, targetUserId int) (*models.FullUser,
 	if userId == targetUserId {
 		requestedUser.BannedForUser = nil
 	} else {
-		isBannedForUser, err := s.br.GetBanExists(userId, targetUserId)
+		isBannedForUser, err := s.br.GetBanExists(ctx, userId, targetUserId)
 		if err != nil {
 			return nil, err
 		}
@@ -73,7 +75,7 @@ func (s *usersService) GetUser(userId int, targetUserId int) (*models.FullUser,
 			requestedUser.BannedForUser = bfu
 		}
 
-		isBannedForUser, err = s.br.GetBanExists(targetUserId, userId)
+		isBannedForUser, err = s.br.GetBanExists(ctx, targetUserId, userId)
 		if err != nil {
 			return nil, err
 		}
@@ -82,7 +84,7 @@ func (s *usersService) GetUser(userId int, targetUserId int) (*models.FullUser,
 		}
 	}
 
-	fullUser, err := s.ur.GetFullUser(
+	fullUser, err := s.ur.GetFullUser(ctx,
 		s.fr.WithTotalFollowers(),
 		s.fr.WithTotalFollowings(),
 		s.pr.WithTotalPhotos(),
@@ -98,8 +100,8 @@ func (s *usersService) GetUser(userId int, targetUserId int) (*models.FullUser,
 	return &requestedUser, nil
 }
 
-func (s *usersService) GetUsers(userId int, username string) (*[]models.BaseUser, error) {
-	users, err := s.ur.GetUsers(
+func (s *usersService) GetUsers(ctx context.Context, userId int, username string) (*[]models.BaseUser, error) {
+	users, err := s.ur.GetUsers(ctx,
 		s.ur.FilterByUsername(username, false),
 		s.br.WithoutBanners(userId),
 	)
@@ -113,8 +115,10 @@ func (s *usersService) GetUsers(userId int, username string) (*[]models.BaseUser
 	return users, nil
 }
 
-func (s *usersService) UpdateUsername(userId int, username string) (*models.FullUser, error) {
-	user, err := s.ur.GetUserById(userId)
+// UpdateUsername - Rename the user, the username is stored normalized and it can't be the same as the one of another
+// user once normalized. A reserved name is refused, unless the user already had it.
+func (s *usersService) UpdateUsername(ctx context.Context, userId int, username string) (*models.FullUser, error) {
+	user, err := s.ur.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -122,13 +126,20 @@ func (s *usersService) UpdateUsername(userId int, username string) (*models.Full
 		return nil, ErrNoUser
 	}
 
+	username = usernames.Normalize(username)
+	if usernames.Reserved(username) && usernames.Key(username) != usernames.Key(user.Username) {
+		return nil, ErrUsernameReserved
+	}
 	user.Username = username
 
-	err = s.ur.UpdateUser(user)
+	err = s.ur.UpdateUser(ctx, user)
+	if errors.Is(err, repositories.ErrUsernameTaken) {
+		return nil, ErrUserAlreadyExists
+	}
 	if err != nil {
 		return nil, err
 	}
-	fullUser, err := s.ur.GetFullUser(
+	fullUser, err := s.ur.GetFullUser(ctx,
 		s.fr.WithTotalFollowers(),
 		s.fr.WithTotalFollowings(),
 		s.pr.WithTotalPhotos(),
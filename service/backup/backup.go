@@ -0,0 +1,256 @@
+/*
+Package backup writes a consistent snapshot of the app data, the database and the photos files, to a single tar.gz
+archive, and restores it.
+
+The database is copied with database.AppDatabase.Snapshot, which holds the writes while the photos files are linked
+aside: a photo file is written before its row is committed and removed along with it, so the files linked while the
+writes wait are all the ones the rows of the copy point to. The archive is then written from the copy, with the
+manifest of its files and their SHA-256 checksums:
+
+	database.db
+	photos/<name>
+	...
+	manifest.json
+
+The files of the photos in the trash are archived along with the other ones, by the names they have in the trash.
+
+Restore extracts an archive next to the data it replaces and verifies it against the manifest before swapping it in,
+so that a corrupted or truncated archive leaves the data untouched. It must run while the web server is stopped.
+*/
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/database"
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+	"github.com/lucaronca/wasa-homework/service/photofiles"
+)
+
+const (
+	// manifestVersion is the version of the archive layout
+	manifestVersion = 1
+
+	databaseEntry = "database.db"
+	photosEntry   = "photos"
+	manifestEntry = "manifest.json"
+)
+
+// File is an entry of the archive
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists the files of an archive, it's its last entry
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Database  File      `json:"database"`
+	Photos    []File    `json:"photos"`
+}
+
+// Create writes a snapshot of db and of the files in photosDirectory to w, as a tar.gz archive
+func Create(ctx context.Context, w io.Writer, db database.AppDatabase, photosDirectory string) (*Manifest, error) {
+	staging, err := stagingDirectory(photosDirectory)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+
+	databasePath := filepath.Join(staging, databaseEntry)
+	stagedPhotos := filepath.Join(staging, photosEntry)
+	var photos []string
+	err = db.Snapshot(ctx, databasePath, func() error {
+		var err error
+		photos, err = linkPhotos(photosDirectory, stagedPhotos)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("taking the snapshot: %w", err)
+	}
+
+	manifest := Manifest{Version: manifestVersion, CreatedAt: globaltime.Now()}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest.Database, err = writeFile(ctx, tw, databaseEntry, databasePath)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Photos = make([]File, 0, len(photos))
+	for _, name := range photos {
+		file, err := writeFile(ctx, tw, path.Join(photosEntry, name), filepath.Join(stagedPhotos, name))
+		if err != nil {
+			return nil, err
+		}
+		manifest.Photos = append(manifest.Photos, file)
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    manifestEntry,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err == nil {
+		_, err = tw.Write(content)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("writing the archive: %w", err)
+	}
+	return &manifest, nil
+}
+
+// stagingDirectory creates a temporary directory next to photosDirectory, so that the photos can be linked into it,
+// or in the default one if that can't be written
+func stagingDirectory(photosDirectory string) (string, error) {
+	staging, err := os.MkdirTemp(filepath.Dir(photosDirectory), ".wasa-backup-")
+	if err != nil {
+		staging, err = os.MkdirTemp("", "wasa-backup-")
+	}
+	return staging, err
+}
+
+// linkPhotos links the photos files into staging, or copies them if they can't be linked, and returns their names.
+// Linking is quick, and it keeps the content of a file even after it's removed from the photos directory.
+func linkPhotos(photosDirectory string, staging string) ([]string, error) {
+	entries, err := os.ReadDir(photosDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(staging, 0700); err != nil {
+		return nil, err
+	}
+
+	var photos []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !photoName(entry.Name()) {
+			continue
+		}
+		src := filepath.Join(photosDirectory, entry.Name())
+		dst := filepath.Join(staging, entry.Name())
+		if err := os.Link(src, dst); err != nil {
+			if err := copyFile(src, dst); err != nil {
+				return nil, err
+			}
+		}
+		photos = append(photos, entry.Name())
+	}
+	return photos, nil
+}
+
+// photoName checks whether a file in the photos directory is the one of a photo, in the trash or out of it. The other
+// names starting with a dot are the ones of the uploads being processed.
+func photoName(name string) bool {
+	return !strings.HasPrefix(name, ".") || photofiles.IsTrashed(name)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeFile adds the file at src to the archive as name, and returns its manifest entry
+func writeFile(ctx context.Context, tw *tar.Writer, name string, src string) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return File{}, err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return File{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return File{}, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return File{}, fmt.Errorf("writing the archive: %w", err)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, hash), f); err != nil {
+		return File{}, fmt.Errorf("writing the archive: %w", err)
+	}
+	return File{Path: name, Size: info.Size(), SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// FileName is the default name of the archive of a backup taken at date
+func FileName(date time.Time) string {
+	return "wasa-backup-" + date.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+// WriteFile is like Create, but it writes the archive to a new file at path. The file is readable by the owner only,
+// the database holds the credentials of the users, and it's created once the archive is complete.
+func WriteFile(ctx context.Context, path string, db database.AppDatabase, photosDirectory string) (*Manifest, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	manifest, err := Create(ctx, tmp, db, photosDirectory)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Unlike a rename, linking fails if there's already a file at path
+	if err := os.Link(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,314 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/database"
+	"github.com/lucaronca/wasa-homework/service/photofiles"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testData is the data of an app: a SQLite database opened like webapi does, and a photos directory
+type testData struct {
+	databasePath    string
+	photosDirectory string
+	db              database.AppDatabase
+}
+
+func newTestData(t *testing.T) *testData {
+	t.Helper()
+	dir := t.TempDir()
+	data := &testData{
+		databasePath:    filepath.Join(dir, "data", "wasa-photo.db"),
+		photosDirectory: filepath.Join(dir, "static", "photos"),
+	}
+	for _, d := range []string{filepath.Dir(data.databasePath), data.photosDirectory} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write, read, err := database.OpenSQLite(data.databasePath, database.SQLiteConfig{
+		JournalMode:        "WAL",
+		BusyTimeout:        time.Second,
+		MaxReadConnections: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = read.Close()
+		_ = write.Close()
+	})
+	data.db, err = database.NewWithPools(write, read, database.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// addPhoto stores a photo the way the photos service does, the file first and then its row
+func (d *testData) addPhoto(t *testing.T, name string, content string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := os.WriteFile(filepath.Join(d.photosDirectory, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	userId, err := d.db.WriteConn().Insert(ctx, "INSERT INTO users (username) VALUES (?)", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.db.WriteConn().Exec(
+		ctx,
+		"INSERT INTO photos (url, user_id, upload_date) VALUES (?, ?, ?)",
+		"/assets/photos/"+name,
+		userId,
+		"2023-01-01T10:00:00.000Z",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func photoUrls(t *testing.T, databasePath string) []string {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", "file:"+databasePath+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	rows, err := conn.Query("SELECT url FROM photos ORDER BY url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, url)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return urls
+}
+
+func readPhoto(t *testing.T, photosDirectory string, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(photosDirectory, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// rewriteEntry copies the archive, replacing the content of the entry called name
+func rewriteEntry(t *testing.T, archive []byte, name string, content []byte) []byte {
+	t.Helper()
+	gzr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	var out bytes.Buffer
+	gzw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gzw)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name == name {
+			entry = content
+			header.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestCreateRestore(t *testing.T) {
+	source := newTestData(t)
+	source.addPhoto(t, "a.jpeg", "first photo")
+	source.addPhoto(t, "b.png", "second photo")
+
+	var archive bytes.Buffer
+	manifest, err := Create(context.Background(), &archive, source.db, source.photosDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Photos) != 2 || manifest.Photos[0].Path != "photos/a.jpeg" || manifest.Photos[1].Path != "photos/b.png" {
+		t.Error("expected the manifest to list the 2 photos got:", manifest.Photos)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(source.photosDirectory)); len(entries) != 1 {
+		t.Error("expected the staging directory to be removed got:", entries)
+	}
+
+	// The restored data replaces an app with a photo of its own
+	target := newTestData(t)
+	target.addPhoto(t, "c.webp", "replaced photo")
+	restored, err := Restore(bytes.NewReader(archive.Bytes()), target.databasePath, target.photosDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Database != manifest.Database {
+		t.Error("expected the manifest of the archive got:", restored.Database)
+	}
+
+	urls := photoUrls(t, target.databasePath)
+	if len(urls) != 2 || urls[0] != "/assets/photos/a.jpeg" || urls[1] != "/assets/photos/b.png" {
+		t.Error("expected the photos of the archive got:", urls)
+	}
+	if content := readPhoto(t, target.photosDirectory, "a.jpeg"); content != "first photo" {
+		t.Error("expected the photo file of the archive got:", content)
+	}
+	if _, err := os.Stat(filepath.Join(target.photosDirectory, "c.webp")); !os.IsNotExist(err) {
+		t.Error("expected the replaced photo to be gone got:", err)
+	}
+	if content := readPhoto(t, target.photosDirectory+".pre-restore", "c.webp"); content != "replaced photo" {
+		t.Error("expected the replaced photos to be kept got:", content)
+	}
+	if urls := photoUrls(t, target.databasePath+".pre-restore"); len(urls) != 1 || urls[0] != "/assets/photos/c.webp" {
+		t.Error("expected the replaced database to be kept got:", urls)
+	}
+}
+
+func TestCreateRestore_TrashedPhotos(t *testing.T) {
+	source := newTestData(t)
+	source.addPhoto(t, "a.jpeg", "trashed photo")
+	trashed := photofiles.Trashed("a.jpeg")
+	if err := os.Rename(filepath.Join(source.photosDirectory, "a.jpeg"), filepath.Join(source.photosDirectory, trashed)); err != nil {
+		t.Fatal(err)
+	}
+	// An upload being processed isn't a photo yet
+	if err := os.WriteFile(filepath.Join(source.photosDirectory, ".b.png.upload"), []byte("upload"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	manifest, err := Create(context.Background(), &archive, source.db, source.photosDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Photos) != 1 || manifest.Photos[0].Path != "photos/"+trashed {
+		t.Error("expected the manifest to list the trashed photo only got:", manifest.Photos)
+	}
+
+	target := newTestData(t)
+	if _, err := Restore(bytes.NewReader(archive.Bytes()), target.databasePath, target.photosDirectory); err != nil {
+		t.Fatal(err)
+	}
+	if content := readPhoto(t, target.photosDirectory, trashed); content != "trashed photo" {
+		t.Error("expected the trashed photo file of the archive got:", content)
+	}
+}
+
+func TestCreate_PhotoRemovedWhileWriting(t *testing.T) {
+	source := newTestData(t)
+	source.addPhoto(t, "a.jpeg", "first photo")
+
+	// The archive is written once the snapshot is taken: a photo removed meanwhile is still in it, along with its row
+	var archive bytes.Buffer
+	pr, pw := io.Pipe()
+	created := make(chan error, 1)
+	go func() {
+		_, err := Create(context.Background(), pw, source.db, source.photosDirectory)
+		_ = pw.CloseWithError(err)
+		created <- err
+	}()
+	if _, err := io.CopyN(&archive, pr, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.db.WriteConn().Exec(context.Background(), "DELETE FROM photos"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(source.photosDirectory, "a.jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(&archive, pr); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-created; err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestData(t)
+	if _, err := Restore(&archive, target.databasePath, target.photosDirectory); err != nil {
+		t.Fatal(err)
+	}
+	if urls := photoUrls(t, target.databasePath); len(urls) != 1 {
+		t.Error("expected the photo row got:", urls)
+	}
+	if content := readPhoto(t, target.photosDirectory, "a.jpeg"); content != "first photo" {
+		t.Error("expected the photo file got:", content)
+	}
+}
+
+func TestRestore_InvalidArchive(t *testing.T) {
+	source := newTestData(t)
+	source.addPhoto(t, "a.jpeg", "first photo")
+	var archive bytes.Buffer
+	if _, err := Create(context.Background(), &archive, source.db, source.photosDirectory); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{
+		"tampered photo": rewriteEntry(t, archive.Bytes(), "photos/a.jpeg", []byte("other photo")),
+		"truncated":      archive.Bytes()[:archive.Len()/2],
+		"no manifest":    rewriteEntry(t, archive.Bytes(), "manifest.json", []byte("{}")),
+		"not a backup":   []byte("photos"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			target := newTestData(t)
+			target.addPhoto(t, "c.webp", "kept photo")
+
+			if _, err := Restore(bytes.NewReader(content), target.databasePath, target.photosDirectory); !errors.Is(err, ErrInvalidArchive) {
+				t.Fatal("expected ErrInvalidArchive got:", err)
+			}
+			if urls := photoUrls(t, target.databasePath); len(urls) != 1 || urls[0] != "/assets/photos/c.webp" {
+				t.Error("expected the database to be untouched got:", urls)
+			}
+			if content := readPhoto(t, target.photosDirectory, "c.webp"); content != "kept photo" {
+				t.Error("expected the photos to be untouched got:", content)
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(target.photosDirectory)); len(entries) != 1 {
+				t.Error("expected the staging directory to be removed got:", entries)
+			}
+		})
+	}
+}
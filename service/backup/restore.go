@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lucaronca/wasa-homework/service/database"
+)
+
+// ErrInvalidArchive is returned by Restore when the archive doesn't match its manifest, or it isn't a backup at all
+var ErrInvalidArchive = errors.New("invalid backup archive")
+
+// previousSuffix is appended to the names of the database and of the photos directory replaced by Restore
+const previousSuffix = ".pre-restore"
+
+// maxManifestSize bounds the manifest read from an archive
+const maxManifestSize = 64 << 20
+
+// Restore replaces the SQLite database at databasePath and the files in photosDirectory with the content of the
+// archive read from r, once it's verified. The replaced data is kept, with the ".pre-restore" suffix, until the next
+// restore.
+func Restore(r io.Reader, databasePath string, photosDirectory string) (*Manifest, error) {
+	for _, dir := range []string{filepath.Dir(databasePath), filepath.Dir(photosDirectory)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	// The archive is extracted next to the data, so that it can be renamed in place
+	databaseStaging, err := os.MkdirTemp(filepath.Dir(databasePath), ".wasa-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(databaseStaging)
+	}()
+	photosStaging, err := os.MkdirTemp(filepath.Dir(photosDirectory), "."+filepath.Base(photosDirectory)+"-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(photosStaging)
+	}()
+
+	stagedDatabase := filepath.Join(databaseStaging, databaseEntry)
+	manifest, extracted, err := extract(r, stagedDatabase, photosStaging)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(manifest, extracted); err != nil {
+		return nil, err
+	}
+	if err := database.VerifySQLite(stagedDatabase); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+
+	// MkdirTemp creates the directory accessible by the owner only, the photos are served from it
+	if err := os.Chmod(photosStaging, 0755); err != nil {
+		return nil, err
+	}
+	if err := swap(photosStaging, photosDirectory, ""); err != nil {
+		return nil, fmt.Errorf("restoring the photos: %w", err)
+	}
+	// The journal files belong to the replaced database, they'd corrupt the restored one
+	if err := swap(stagedDatabase, databasePath, "", "-wal", "-shm"); err != nil {
+		_ = unswap(photosDirectory, "")
+		return nil, fmt.Errorf("restoring the database: %w", err)
+	}
+	return manifest, nil
+}
+
+// extract writes the database and the photos of the archive to their staging paths, and returns its manifest along
+// with the entries actually found
+func extract(r io.Reader, stagedDatabase string, photosStaging string) (*Manifest, map[string]File, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *Manifest
+	extracted := make(map[string]File)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+		if _, ok := extracted[header.Name]; ok || header.Typeflag != tar.TypeReg || manifest != nil && header.Name == manifestEntry {
+			return nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, header.Name)
+		}
+
+		var dst string
+		switch name := strings.TrimPrefix(header.Name, photosEntry+"/"); {
+		case header.Name == manifestEntry:
+			manifest = &Manifest{}
+			if err := json.NewDecoder(io.LimitReader(tr, maxManifestSize)).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("%w: reading the manifest: %s", ErrInvalidArchive, err)
+			}
+			continue
+		case header.Name == databaseEntry:
+			dst = stagedDatabase
+		case name != header.Name && validPhotoName(name):
+			dst = filepath.Join(photosStaging, name)
+		default:
+			return nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, header.Name)
+		}
+
+		file, err := extractFile(tr, dst)
+		if err != nil {
+			return nil, nil, fmt.Errorf("extracting %s: %w", header.Name, err)
+		}
+		file.Path = header.Name
+		extracted[header.Name] = file
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%w: the manifest is missing", ErrInvalidArchive)
+	}
+	return manifest, extracted, nil
+}
+
+// validPhotoName checks that the name of a photo entry is a plain file name, which can't escape the photos directory
+func validPhotoName(name string) bool {
+	return name != "" && photoName(name) && !strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+func extractFile(r io.Reader, dst string) (File, error) {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return File{}, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), archiveReader{r})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return File{}, err
+	}
+	return File{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// archiveReader tells the errors reading the archive, e.g. a truncated one, from the ones writing the files
+type archiveReader struct {
+	r io.Reader
+}
+
+func (ar archiveReader) Read(p []byte) (int, error) {
+	n, err := ar.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	return n, err
+}
+
+// verify checks that the entries extracted are exactly the ones of the manifest, with the same sizes and checksums
+func verify(manifest *Manifest, extracted map[string]File) error {
+	if manifest.Version != manifestVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, manifest.Version)
+	}
+	if manifest.Database.Path != databaseEntry {
+		return fmt.Errorf("%w: the manifest doesn't list the database", ErrInvalidArchive)
+	}
+
+	expected := append([]File{manifest.Database}, manifest.Photos...)
+	if len(expected) != len(extracted) {
+		return fmt.Errorf("%w: the manifest lists %d files, the archive has %d", ErrInvalidArchive, len(expected), len(extracted))
+	}
+	for _, file := range expected {
+		found, ok := extracted[file.Path]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrInvalidArchive, file.Path)
+		}
+		if found.Size != file.Size || found.SHA256 != file.SHA256 {
+			return fmt.Errorf("%w: the checksum of %s doesn't match", ErrInvalidArchive, file.Path)
+		}
+	}
+	return nil
+}
+
+// swap moves staged to path, after moving what's at path, and at path with each of the suffixes, to the previous
+// paths
+func swap(staged string, path string, suffixes ...string) error {
+	for _, suffix := range suffixes {
+		previous := path + previousSuffix + suffix
+		if err := os.RemoveAll(previous); err != nil {
+			return err
+		}
+		if err := os.Rename(path+suffix, previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = unswap(path, suffixes...)
+			return err
+		}
+	}
+	if err := os.Rename(staged, path); err != nil {
+		_ = unswap(path, suffixes...)
+		return err
+	}
+	return nil
+}
+
+// unswap moves the previous paths back where swap found them
+func unswap(path string, suffixes ...string) error {
+	var err error
+	for _, suffix := range suffixes {
+		previous := path + previousSuffix + suffix
+		if _, statErr := os.Stat(previous); statErr != nil {
+			continue
+		}
+		_ = os.RemoveAll(path + suffix)
+		if renameErr := os.Rename(previous, path+suffix); renameErr != nil && err == nil {
+			err = renameErr
+		}
+	}
+	return err
+}
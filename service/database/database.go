@@ -2,8 +2,18 @@
 Package database is the middleware between the app database and the code. All data (de)serialization (save/load) from a
 persistent database are handled here. Database specific logic should never escape this package.
 
-To use this package you need to apply migrations to the database if needed/wanted, connect to it (using the database
-data source name from config), and then initialize an instance of AppDatabase from the DB connection.
+To use this package you need to connect to the database (using the database data source name from config), and then
+initialize an instance of AppDatabase from the DB connection and its Dialect, SQLite or Postgres. New applies the
+migrations embedded in the `migrations` directory the database doesn't have yet, PendingMigrations lists them without
+applying them. The few migrations that need more than the database, e.g. the key the auth tokens are hashed with, fail
+there with ErrMigrationConfigRequired, the migrations before them applied: Migrate applies them first with a
+MigrationConfig, like webapi does at startup.
+
+Queries are written once for every dialect, with ? placeholders: Conn rewrites them for the engine in use.
+
+An AppDatabase can have two connection pools: reads go through Conn, writes and transactions through WriteConn and InTx.
+OpenSQLite opens them for SQLite, with the write pool limited to a single connection, so that concurrent writes wait for
+their turn instead of failing with "database is locked".
 
 For example, this code adds a parameter in `webapi` executable for the database data source name (add it to the
 main.WebAPIConfiguration structure):
@@ -26,146 +36,170 @@ This is an example on how to migrate the DB and connect to it:
 		_ = db.Close()
 	}()
 
-Then you can initialize the AppDatabase and pass it to the api package.
+Then you can initialize the AppDatabase, with database.New(db, database.SQLite), and pass it to the api package.
+database.NewWithPools(write, read, database.SQLite) does the same with separate pools.
 */
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 )
 
+// Conn runs queries, on the database connection or on a transaction. Queries are aborted when ctx is done.
+type Conn interface {
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row
+	// Insert runs an INSERT into a table whose primary key is the id column, and returns the id of the new row
+	Insert(ctx context.Context, query string, args ...interface{}) (int64, error)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn is a Conn adapting the queries to the dialect
+type conn struct {
+	q       querier
+	dialect Dialect
+}
+
+func (c *conn) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.q.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.q.QueryContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.q.QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Insert(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	// The Postgres driver doesn't support LastInsertId
+	if c.dialect == Postgres {
+		var id int64
+		query = strings.TrimSuffix(strings.TrimSpace(query), ";") + " RETURNING id"
+		err := c.QueryRow(ctx, query, args...).Scan(&id)
+		return id, err
+	}
+	result, err := c.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
-	Conn() *sql.DB
+	// Conn runs the queries that only read
+	Conn() Conn
+	// WriteConn runs the queries that write
+	WriteConn() Conn
 	Ping() error
+	// InTx runs fn with a database bound to a transaction, which is committed if fn returns nil and rolled back
+	// otherwise, or if ctx is done first. If the database is already bound to a transaction fn joins it, so that
+	// callers can compose.
+	InTx(ctx context.Context, fn func(tx AppDatabase) error) error
+	// Snapshot copies the database to a new SQLite file at path while the writes wait, they're held until fn returns so
+	// that fn can capture what has to be consistent with the copy, e.g. the files its rows point to. It returns
+	// ErrSnapshotUnsupported for the engines other than SQLite.
+	Snapshot(ctx context.Context, path string, fn func() error) error
 }
 
 type appdbimpl struct {
 	connectionInstance *sql.DB
+	readInstance       *sql.DB
+	dialect            Dialect
+}
+
+// txdbimpl is an AppDatabase bound to a transaction
+type txdbimpl struct {
+	parent *appdbimpl
+	tx     *sql.Tx
 }
 
-// New returns a new instance of AppDatabase based on the SQLite connection `db`.
+// New returns a new instance of AppDatabase based on the connection `db`, opened with the driver of `dialect`.
 // `db` is required - an error will be returned if `db` is `nil`.
-func New(db *sql.DB) (AppDatabase, error) {
-	if db == nil {
+func New(db *sql.DB, dialect Dialect) (AppDatabase, error) {
+	return NewWithPools(db, db, dialect)
+}
+
+// NewWithPools is like New, but the queries that only read go through the `read` connection pool, while the writes,
+// the transactions and the migrations go through `write`.
+func NewWithPools(write *sql.DB, read *sql.DB, dialect Dialect) (AppDatabase, error) {
+	if write == nil || read == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
-
-	// Check if table exists. If not, the database is empty, and we need to create the structure
-	// Users table
-	sqlStmt := `CREATE TABLE IF NOT EXISTS users (id INTEGER NOT NULL PRIMARY KEY, username TEXT);`
-	_, err := db.Exec(sqlStmt)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database structure: %w", err)
+	if _, err := ParseDialect(string(dialect)); err != nil {
+		return nil, err
 	}
 
-	// Users tokens table
-	sqlStmt = `
-		CREATE TABLE IF NOT EXISTS user_tokens (
-			user_id INTEGER NOT NULL,
-			token TEXT NOT NULL,
-			UNIQUE(user_id, token),
-			PRIMARY KEY(user_id, token),
-			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-	`
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database structure: %w", err)
+	// Bring the schema up to date, the migrations that need a MigrationConfig are left to Migrate
+	if _, err := Migrate(write, dialect, MigrationConfig{}); errors.Is(err, ErrMigrationConfigRequired) {
+		return nil, fmt.Errorf("apply the migrations with Migrate and their config first: %w", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("error migrating database structure: %w", err)
 	}
 
-	// Users bans table
-	sqlStmt = `
-		CREATE TABLE IF NOT EXISTS user_bans (
-			user_id INTEGER NOT NULL,
-			banned_id INTEGER NOT NULL,
-			UNIQUE(user_id, banned_id),
-			PRIMARY KEY(user_id, banned_id),
-			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
-			FOREIGN KEY(banned_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-	`
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database structure: %w", err)
-	}
+	return &appdbimpl{
+		write,
+		read,
+		dialect,
+	}, nil
+}
 
-	// Follows table
-	sqlStmt = `
-		CREATE TABLE IF NOT EXISTS follows (
-			follower_id INTEGER NOT NULL,
-			following_id INTEGER NOT NULL,
-			UNIQUE(follower_id, following_id),
-			PRIMARY KEY (following_id, follower_id),
-			FOREIGN KEY(follower_id) REFERENCES users(id) ON DELETE CASCADE,
-			FOREIGN KEY(following_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-	`
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database structure: %w", err)
-	}
+func (db *appdbimpl) Conn() Conn {
+	return &conn{db.readInstance, db.dialect}
+}
 
-	// Photos table
-	sqlStmt = `
-		CREATE TABLE IF NOT EXISTS photos (
-			id INTEGER NOT NULL PRIMARY KEY,
-			url TEXT NOT NULL,
-			user_id INTEGER NOT NULL,
-			upload_date TEXT NOT NULL,
-			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-	`
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database structure: %w", err)
+func (db *appdbimpl) WriteConn() Conn {
+	return &conn{db.connectionInstance, db.dialect}
+}
+
+func (db *appdbimpl) Ping() error {
+	if err := db.connectionInstance.Ping(); err != nil {
+		return err
 	}
+	return db.readInstance.Ping()
+}
 
-	// Likes table
-	sqlStmt = `
-		CREATE TABLE IF NOT EXISTS likes (
-			id INTEGER NOT NULL PRIMARY KEY,
-			photo_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			date TEXT NOT NULL,
-			UNIQUE(photo_id, user_id),
-			FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
-			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-	`
-	_, err = db.Exec(sqlStmt)
+func (db *appdbimpl) InTx(ctx context.Context, fn func(tx AppDatabase) error) error {
+	tx, err := db.connectionInstance.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating database structure: %w", err)
+		return err
 	}
+	// Rolling back a committed transaction is a no-op, this covers both the errors and the panics of fn
+	defer func() {
+		_ = tx.Rollback()
+	}()
 
-	// Likes table
-	sqlStmt = `
-		CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER NOT NULL PRIMARY KEY,
-			photo_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			date TEXT NOT NULL,
-			content TEXT NOT NULL,
-			FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
-			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-	`
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database structure: %w", err)
+	if err := fn(&txdbimpl{db, tx}); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
-	return &appdbimpl{
-		db,
-	}, nil
+func (db *txdbimpl) Conn() Conn {
+	return &conn{db.tx, db.parent.dialect}
 }
 
-func (db *appdbimpl) Conn() *sql.DB {
-	return db.connectionInstance
+func (db *txdbimpl) WriteConn() Conn {
+	return db.Conn()
 }
 
-func (db *appdbimpl) Ping() error {
-	return db.connectionInstance.Ping()
+func (db *txdbimpl) Ping() error {
+	return db.parent.Ping()
+}
+
+func (db *txdbimpl) InTx(ctx context.Context, fn func(tx AppDatabase) error) error {
+	return fn(db)
 }
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestSQLiteDatabase(t *testing.T) AppDatabase {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := New(conn, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestConn_QueryAbortedOnDeadline(t *testing.T) {
+	db := newTestSQLiteDatabase(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Counting up to a billion takes far longer than the deadline
+	start := time.Now()
+	var count int
+	err := db.Conn().QueryRow(ctx, `
+		WITH RECURSIVE counter(n) AS (SELECT 1 UNION ALL SELECT n+1 FROM counter WHERE n < 1000000000)
+		SELECT COUNT(*) FROM counter
+	`).Scan(&count)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected context.DeadlineExceeded got:", err, count)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error("expected the query to be aborted at the deadline, it took:", elapsed)
+	}
+}
+
+func TestInTx_CanceledContext(t *testing.T) {
+	db := newTestSQLiteDatabase(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := db.InTx(ctx, func(tx AppDatabase) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected context.Canceled got:", err)
+	}
+	if called {
+		t.Error("expected the transaction not to begin")
+	}
+}
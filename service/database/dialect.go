@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the SQL engine behind an AppDatabase, its value is the name of the database/sql driver
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+// ParseDialect returns the dialect of a database/sql driver name
+func ParseDialect(driverName string) (Dialect, error) {
+	switch d := Dialect(driverName); d {
+	case SQLite, Postgres:
+		return d, nil
+	}
+	return "", fmt.Errorf("unsupported database driver %q", driverName)
+}
+
+// migrationValues are the dialect specific fragments of SQL the migrations are rendered with
+type migrationValues struct {
+	// IdPrimaryKey is the type of an integer primary key generated on insert
+	IdPrimaryKey string
+	// Now is an expression for the current date in the layout of the first release, 2006-01-02T15:04:05+0000, the one
+	// of the migrations released before it. Like every value its rendering never changes: migration 0011 rewrites the
+	// dates it wrote to the layout of the repositories.
+	Now string
+
+	dialect Dialect
+}
+
+// UTCDate is an expression converting the date in column, stored with the offset of the server as
+// 2006-01-02T15:04:05-0700, to the UTC format of the dates stored by the repositories
+func (v migrationValues) UTCDate(column string) string {
+	if v.dialect == Postgres {
+		return fmt.Sprintf(`to_char(%s::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')`, column)
+	}
+	// SQLite reads the offsets only as +hh:mm
+	return fmt.Sprintf("strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ', substr(%[1]s, 1, 22) || ':' || substr(%[1]s, 23, 2))", column)
+}
+
+// NowPlus is an expression for the current date plus hours, formatted like the dates stored by the repositories
+func (v migrationValues) NowPlus(hours int) string {
+	if v.dialect == Postgres {
+		return fmt.Sprintf(`to_char((now() + interval '%d hours') AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')`, hours)
+	}
+	return fmt.Sprintf("strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ', 'now', '+%d hours')", hours)
+}
+
+func (d Dialect) migrationValues() migrationValues {
+	if d == Postgres {
+		return migrationValues{
+			IdPrimaryKey: "INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY",
+			Now:          `to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"+0000"')`,
+			dialect:      d,
+		}
+	}
+	return migrationValues{
+		IdPrimaryKey: "INTEGER NOT NULL PRIMARY KEY",
+		Now:          "strftime('%Y-%m-%dT%H:%M:%S+0000', 'now')",
+		dialect:      d,
+	}
+}
+
+// rebind rewrites the ? placeholders of a query to the placeholders of the dialect. Question marks in quoted strings
+// and identifiers are left as they are.
+func (d Dialect) rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote rune
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			// A doubled quote escapes itself, so it just closes and reopens the string
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// tableExists reports whether the database has the given table
+func (d Dialect) tableExists(db *sql.DB, table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?"
+	if d == Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema=current_schema() AND table_name=?"
+	}
+	var count int
+	if err := db.QueryRow(d.rebind(query), table).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// tableHasColumn reports whether the table already has the given column
+func (d Dialect) tableHasColumn(db *sql.DB, table string, column string) (bool, error) {
+	query := "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?"
+	if d == Postgres {
+		query = `
+			SELECT COUNT(*) FROM information_schema.columns
+			WHERE table_schema=current_schema() AND table_name=? AND column_name=?
+		`
+	}
+	var count int
+	if err := db.QueryRow(d.rebind(query), table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// IsUniqueViolation reports whether err is the failure of a write breaking a UNIQUE constraint, in either engine
+func IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
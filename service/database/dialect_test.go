@@ -0,0 +1,55 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRebind_Postgres(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT id FROM users WHERE id=? AND username=?":        "SELECT id FROM users WHERE id=$1 AND username=$2",
+		`SELECT id FROM users WHERE username LIKE ? ESCAPE '\'`: `SELECT id FROM users WHERE username LIKE $1 ESCAPE '\'`,
+		"SELECT '?', \"a?\" FROM t WHERE a=? AND b='it''s?'":    "SELECT '?', \"a?\" FROM t WHERE a=$1 AND b='it''s?'",
+		"SELECT 1": "SELECT 1",
+	} {
+		if rebound := Postgres.rebind(query); rebound != expected {
+			t.Errorf("expected %q got: %q", expected, rebound)
+		}
+	}
+}
+
+func TestRebind_SQLiteKeepsPlaceholders(t *testing.T) {
+	query := "SELECT id FROM users WHERE id=?"
+	if rebound := SQLite.rebind(query); rebound != query {
+		t.Error("expected the query unchanged got:", rebound)
+	}
+}
+
+func TestLoadMigrations_RenderedForEachDialect(t *testing.T) {
+	for _, dialect := range []Dialect{SQLite, Postgres} {
+		migrations, err := loadMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := dialect.migrationValues()
+		for _, migration := range migrations {
+			if strings.Contains(migration.SQL, "{{") {
+				t.Errorf("%s: expected migration %d to be rendered", dialect, migration.Version)
+			}
+		}
+		if !strings.Contains(migrations[0].SQL, "id "+values.IdPrimaryKey) {
+			t.Errorf("%s: expected the users id to be generated", dialect)
+		}
+	}
+}
+
+func TestParseDialect(t *testing.T) {
+	for _, name := range []string{"sqlite3", "postgres"} {
+		if _, err := ParseDialect(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := ParseDialect("mysql"); err == nil {
+		t.Error("expected an error for an unsupported driver")
+	}
+}
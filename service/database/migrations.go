@@ -0,0 +1,268 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lucaronca/wasa-homework/service/globaltime"
+)
+
+// migrationsFS holds the schema migrations, named <version>_<name>.sql. Versions start from 1 and have no gaps, a
+// migration is never changed once released: changes to the schema go in a new migration.
+//
+// The same migrations are applied to every dialect: they're text/template files rendered with the migrationValues of
+// the dialect, for the few constructs that have no common syntax. What a value renders to is released along with the
+// migrations using it, so values are only added: the SQLite rendering of the migrations released before PostgreSQL was
+// supported is the released SQL, unchanged.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// Migration is a forward change to the database schema
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+
+	// step is the part of the migration that can't be written in SQL, it runs after it in the same transaction
+	step migrationStep
+}
+
+// MigrationConfig is what the migrations need besides the database, for the steps that can't be written in SQL
+type MigrationConfig struct {
+	// TokenHash returns the hash the auth tokens are stored as. The migration hashing the tokens stored in plaintext
+	// fails without it, if there are some.
+	TokenHash func(token string) string
+}
+
+// ErrMigrationConfigRequired is returned by the migrations whose step needs what their MigrationConfig lacks
+var ErrMigrationConfigRequired = errors.New("a pending migration needs the migration config")
+
+type migrationStep func(tx *sql.Tx, dialect Dialect, cfg MigrationConfig) error
+
+// migrationSteps are the steps of the migrations, by version
+var migrationSteps = map[int]migrationStep{
+	12: fillUsernameKeys,
+	18: hashLegacyTokens,
+}
+
+var ErrMigrationsNotValid = errors.New("migrations are not valid")
+
+// loadMigrations returns the embedded migrations rendered for the dialect, sorted by version
+func loadMigrations(dialect Dialect) ([]Migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: %s has no name", ErrMigrationsNotValid, file)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s has no version", ErrMigrationsNotValid, file)
+		}
+		tmpl, err := template.ParseFS(migrationsFS, file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMigrationsNotValid, err)
+		}
+		var content strings.Builder
+		if err := tmpl.Execute(&content, dialect.migrationValues()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMigrationsNotValid, err)
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    parts[1],
+			SQL:     content.String(),
+			step:    migrationSteps[version],
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("%w: expected version %d, found %d", ErrMigrationsNotValid, i+1, migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// PendingMigrations returns the migrations not applied to the database yet, without changing it
+func PendingMigrations(db *sql.DB, dialect Dialect) ([]Migration, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	version, _, err := schemaVersion(db, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return pendingMigrations(migrations, version), nil
+}
+
+// Migrate applies the pending migrations, each one in a transaction along with the update of the schema version. It
+// returns the applied migrations.
+func Migrate(db *sql.DB, dialect Dialect, cfg MigrationConfig) ([]Migration, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return migrate(db, dialect, migrations, cfg)
+}
+
+func migrate(db *sql.DB, dialect Dialect, migrations []Migration, cfg MigrationConfig) ([]Migration, error) {
+	version, versioned, err := schemaVersion(db, dialect)
+	if err != nil {
+		return nil, err
+	}
+	if !versioned {
+		if err := createSchemaVersion(db, dialect, migrations, version); err != nil {
+			return nil, fmt.Errorf("creating schema version: %w", err)
+		}
+	}
+
+	pending := pendingMigrations(migrations, version)
+	for _, migration := range pending {
+		if err := applyMigration(db, dialect, migration, cfg); err != nil {
+			return nil, fmt.Errorf("applying migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+func pendingMigrations(migrations []Migration, version int) []Migration {
+	pending := make([]Migration, 0)
+	for _, migration := range migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+func applyMigration(db *sql.DB, dialect Dialect, migration Migration, cfg MigrationConfig) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		return err
+	}
+	if migration.step != nil {
+		if err := migration.step(tx, dialect, cfg); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(
+		dialect.rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+		migration.Version,
+		migration.Name,
+		globaltime.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaVersion returns the version of the database schema, and whether it's recorded in schema_version. The version
+// of databases created before schema versioning is inferred from their tables.
+func schemaVersion(db *sql.DB, dialect Dialect) (int, bool, error) {
+	versioned, err := dialect.tableExists(db, "schema_version")
+	if err != nil {
+		return 0, false, err
+	}
+	if !versioned {
+		version, err := legacySchemaVersion(db, dialect)
+		return version, false, err
+	}
+
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, true, err
+}
+
+// createSchemaVersion creates the schema_version table, recording the migrations a database created before schema
+// versioning already has
+func createSchemaVersion(db *sql.DB, dialect Dialect, migrations []Migration, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.Exec(`
+		CREATE TABLE schema_version (
+			version INTEGER NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		);
+	`); err != nil {
+		return err
+	}
+	for _, migration := range migrations[:version] {
+		if _, err := tx.Exec(
+			dialect.rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+			migration.Version,
+			migration.Name,
+			globaltime.Now().UTC().Format(time.RFC3339),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// legacySchemaVersion infers the version of a database created before schema versioning, looking for the newest
+// table or column each migration added
+func legacySchemaVersion(db *sql.DB, dialect Dialect) (int, error) {
+	markers := []struct {
+		version int
+		table   string
+		column  string
+	}{
+		{7, "login_challenges", ""},
+		{6, "user_identities", ""},
+		{5, "personal_access_tokens", ""},
+		{4, "refresh_tokens", ""},
+		{3, "user_tokens", "id"},
+		{2, "user_credentials", ""},
+		{1, "users", ""},
+	}
+	for _, marker := range markers {
+		found, err := dialect.tableExists(db, marker.table)
+		if err != nil {
+			return 0, err
+		}
+		if found && marker.column != "" {
+			found, err = dialect.tableHasColumn(db, marker.table, marker.column)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if found {
+			return marker.version, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,57 @@
+-- Schema of the first release. Existing databases created by it already have these tables.
+
+CREATE TABLE IF NOT EXISTS users (id {{.IdPrimaryKey}}, username TEXT);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+	user_id INTEGER NOT NULL,
+	token TEXT NOT NULL,
+	UNIQUE(user_id, token),
+	PRIMARY KEY(user_id, token),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_bans (
+	user_id INTEGER NOT NULL,
+	banned_id INTEGER NOT NULL,
+	UNIQUE(user_id, banned_id),
+	PRIMARY KEY(user_id, banned_id),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY(banned_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS follows (
+	follower_id INTEGER NOT NULL,
+	following_id INTEGER NOT NULL,
+	UNIQUE(follower_id, following_id),
+	PRIMARY KEY (following_id, follower_id),
+	FOREIGN KEY(follower_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY(following_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS photos (
+	id {{.IdPrimaryKey}},
+	url TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	upload_date TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS likes (
+	id {{.IdPrimaryKey}},
+	photo_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	date TEXT NOT NULL,
+	UNIQUE(photo_id, user_id),
+	FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id {{.IdPrimaryKey}},
+	photo_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	date TEXT NOT NULL,
+	content TEXT NOT NULL,
+	FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+-- Password hashes of the users, users created before passwords were introduced have none until their next login
+
+CREATE TABLE user_credentials (
+	user_id INTEGER NOT NULL PRIMARY KEY,
+	password_hash TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,24 @@
+-- Each row of user_tokens becomes a login session. Existing tokens are kept, so logged in clients keep working.
+
+CREATE TABLE user_tokens_sessions (
+	id {{.IdPrimaryKey}},
+	user_id INTEGER NOT NULL,
+	token TEXT NOT NULL UNIQUE,
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	last_used_at TEXT NOT NULL,
+	revoked_at TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO user_tokens_sessions (user_id, token, created_at, last_used_at)
+SELECT
+	user_id,
+	token,
+	{{.Now}},
+	{{.Now}}
+FROM user_tokens;
+
+DROP TABLE user_tokens;
+
+ALTER TABLE user_tokens_sessions RENAME TO user_tokens;
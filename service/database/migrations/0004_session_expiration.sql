@@ -0,0 +1,13 @@
+-- Tokens created before expiration was introduced are considered expired, their owners need to log in again
+
+ALTER TABLE user_tokens ADD COLUMN expires_at TEXT NOT NULL DEFAULT '1970-01-01T00:00:00+0000';
+
+-- Used refresh tokens are kept until they expire to detect their reuse
+CREATE TABLE refresh_tokens (
+	id {{.IdPrimaryKey}},
+	session_id INTEGER NOT NULL,
+	token TEXT NOT NULL UNIQUE,
+	expires_at TEXT NOT NULL,
+	used_at TEXT,
+	FOREIGN KEY(session_id) REFERENCES user_tokens(id) ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+-- Scopes are stored space separated
+
+CREATE TABLE personal_access_tokens (
+	id {{.IdPrimaryKey}},
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	last_used_at TEXT,
+	expires_at TEXT,
+	revoked_at TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+-- Links the subject of an external OpenID Connect provider to a user
+
+CREATE TABLE user_identities (
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	PRIMARY KEY(issuer, subject),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
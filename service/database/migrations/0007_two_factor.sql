@@ -0,0 +1,28 @@
+-- A TOTP secret is pending until its first code is verified and enabled_at is set
+CREATE TABLE user_totp (
+	user_id INTEGER NOT NULL PRIMARY KEY,
+	secret TEXT NOT NULL,
+	enabled_at TEXT,
+	last_used_step INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Recovery codes are stored hashed and can be used once
+CREATE TABLE user_recovery_codes (
+	id {{.IdPrimaryKey}},
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- A challenge is created when a password login still needs the second factor
+CREATE TABLE login_challenges (
+	id {{.IdPrimaryKey}},
+	user_id INTEGER NOT NULL,
+	token TEXT NOT NULL UNIQUE,
+	user_agent TEXT NOT NULL DEFAULT '',
+	expires_at TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
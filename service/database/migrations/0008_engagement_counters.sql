@@ -0,0 +1,16 @@
+-- Counters of the rows related to photos and users, kept up to date by the repositories along with the rows they count
+
+ALTER TABLE photos ADD COLUMN total_likes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE photos ADD COLUMN total_comments INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN total_followers INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN total_following INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN total_photos INTEGER NOT NULL DEFAULT 0;
+
+UPDATE photos SET
+	total_likes = (SELECT COUNT(*) FROM likes WHERE likes.photo_id = photos.id),
+	total_comments = (SELECT COUNT(*) FROM comments WHERE comments.photo_id = photos.id);
+
+UPDATE users SET
+	total_followers = (SELECT COUNT(*) FROM follows WHERE follows.following_id = users.id),
+	total_following = (SELECT COUNT(*) FROM follows WHERE follows.follower_id = users.id),
+	total_photos = (SELECT COUNT(*) FROM photos WHERE photos.user_id = users.id);
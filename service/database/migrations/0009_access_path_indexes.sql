@@ -0,0 +1,15 @@
+-- Indexes on the access paths of the repositories, the query plans test fails if a query scans a whole table
+
+-- The photos of a user and the stream of the followed users, newest first
+CREATE INDEX photos_user_id_upload_date ON photos (user_id, upload_date);
+CREATE INDEX photos_upload_date ON photos (upload_date);
+
+-- The likes of a user, the ones of a photo are covered by UNIQUE(photo_id, user_id)
+CREATE INDEX likes_user_id ON likes (user_id);
+
+-- The comments of a photo, newest first, and the ones of a user
+CREATE INDEX comments_photo_id_date ON comments (photo_id, date);
+CREATE INDEX comments_user_id ON comments (user_id);
+
+-- The followings of a user are covered by UNIQUE(follower_id, following_id), the bans of a user by the primary key
+CREATE INDEX user_bans_banned_id ON user_bans (banned_id);
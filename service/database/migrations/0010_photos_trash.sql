@@ -0,0 +1,6 @@
+-- Deleted photos are moved to the trash, they're removed for good once they've been there for the retention period
+
+ALTER TABLE photos ADD COLUMN deleted_at TEXT;
+
+-- The photos whose retention period expired, for the purger
+CREATE INDEX photos_deleted_at ON photos (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,29 @@
+-- Dates were stored with the offset of the server, so they didn't sort as strings across a change of time zone or of
+-- daylight saving time. They're rewritten in UTC with milliseconds, 2006-01-02T15:04:05.000Z, except for the ones
+-- already in UTC, e.g. the ones of the sessions created by an earlier migration of the same upgrade.
+
+UPDATE photos SET upload_date = {{.UTCDate "upload_date"}} WHERE upload_date NOT LIKE '%Z';
+UPDATE photos SET deleted_at = {{.UTCDate "deleted_at"}} WHERE deleted_at NOT LIKE '%Z';
+
+UPDATE likes SET date = {{.UTCDate "date"}} WHERE date NOT LIKE '%Z';
+
+UPDATE comments SET date = {{.UTCDate "date"}} WHERE date NOT LIKE '%Z';
+
+UPDATE user_tokens SET created_at = {{.UTCDate "created_at"}} WHERE created_at NOT LIKE '%Z';
+UPDATE user_tokens SET last_used_at = {{.UTCDate "last_used_at"}} WHERE last_used_at NOT LIKE '%Z';
+UPDATE user_tokens SET revoked_at = {{.UTCDate "revoked_at"}} WHERE revoked_at NOT LIKE '%Z';
+UPDATE user_tokens SET expires_at = {{.UTCDate "expires_at"}} WHERE expires_at NOT LIKE '%Z';
+
+UPDATE refresh_tokens SET expires_at = {{.UTCDate "expires_at"}} WHERE expires_at NOT LIKE '%Z';
+UPDATE refresh_tokens SET used_at = {{.UTCDate "used_at"}} WHERE used_at NOT LIKE '%Z';
+
+UPDATE personal_access_tokens SET created_at = {{.UTCDate "created_at"}} WHERE created_at NOT LIKE '%Z';
+UPDATE personal_access_tokens SET last_used_at = {{.UTCDate "last_used_at"}} WHERE last_used_at NOT LIKE '%Z';
+UPDATE personal_access_tokens SET expires_at = {{.UTCDate "expires_at"}} WHERE expires_at NOT LIKE '%Z';
+UPDATE personal_access_tokens SET revoked_at = {{.UTCDate "revoked_at"}} WHERE revoked_at NOT LIKE '%Z';
+
+UPDATE user_totp SET enabled_at = {{.UTCDate "enabled_at"}} WHERE enabled_at NOT LIKE '%Z';
+
+UPDATE user_recovery_codes SET used_at = {{.UTCDate "used_at"}} WHERE used_at NOT LIKE '%Z';
+
+UPDATE login_challenges SET expires_at = {{.UTCDate "expires_at"}} WHERE expires_at NOT LIKE '%Z';
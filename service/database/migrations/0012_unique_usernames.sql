@@ -0,0 +1,7 @@
+-- Usernames are unique once normalized: username_key is the case folded NFKC form of the username. It's filled by the
+-- step of this migration, which fails listing the users whose names are the same one, if any: they have to be renamed
+-- before upgrading.
+
+ALTER TABLE users ADD COLUMN username_key TEXT;
+
+CREATE UNIQUE INDEX users_username_key ON users (username_key);
@@ -0,0 +1,10 @@
+-- The resized copies of the photos, their files are stored along with the one of the photo
+
+CREATE TABLE photo_variants (
+	photo_id INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	PRIMARY KEY(photo_id, width),
+	FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE
+);
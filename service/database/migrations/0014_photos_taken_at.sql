@@ -0,0 +1,3 @@
+-- The date a photo was taken, read from its EXIF before it's stripped. It's kept only if the server is configured to.
+
+ALTER TABLE photos ADD COLUMN taken_at TEXT;
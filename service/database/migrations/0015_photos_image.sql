@@ -0,0 +1,8 @@
+-- The dimensions, the file size, the MIME type and the BlurHash of the image of the photos. They're NULL for the photos
+-- uploaded before, until they're backfilled with wasa-photos-backfill.
+
+ALTER TABLE photos ADD COLUMN width INTEGER;
+ALTER TABLE photos ADD COLUMN height INTEGER;
+ALTER TABLE photos ADD COLUMN size BIGINT;
+ALTER TABLE photos ADD COLUMN mime_type TEXT;
+ALTER TABLE photos ADD COLUMN blur_hash TEXT;
@@ -0,0 +1,4 @@
+-- The caption of the photos and their alt text, the description of the image for the users who can't see it
+
+ALTER TABLE photos ADD COLUMN caption TEXT NOT NULL DEFAULT '';
+ALTER TABLE photos ADD COLUMN alt_text TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- The sessions created before expiration was introduced were expired by 0004. They have no refresh token: they're kept
+-- for 30 days, the default lifetime of a refresh token, so that logged in clients keep working until their owners log in
+-- again. Those of the databases that ran 0004 before are kept too, if they weren't removed yet.
+
+UPDATE user_tokens SET expires_at = {{.NowPlus 720}} WHERE expires_at = '1970-01-01T00:00:00.000Z';
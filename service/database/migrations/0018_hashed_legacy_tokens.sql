@@ -0,0 +1,3 @@
+-- The access and refresh tokens stored in plaintext before tokens were hashed are replaced with their keyed hash. It's
+-- done by the step of this migration, since it needs the key: the databases with such tokens are migrated past it
+-- only by webapi.
@@ -0,0 +1,12 @@
+-- The users created before passwords were introduced set theirs at their next login, they're the only ones that can:
+-- the users created since have a password, or only the identity of an OpenID Connect provider and can't log in with one
+
+CREATE TABLE pending_passwords (
+	user_id INTEGER NOT NULL PRIMARY KEY,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO pending_passwords (user_id)
+SELECT id FROM users
+WHERE id NOT IN (SELECT user_id FROM user_credentials)
+	AND id NOT IN (SELECT user_id FROM user_identities);
@@ -0,0 +1,630 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// baselineSchema is the schema created by the first release, before schema versioning
+const baselineSchema = `
+	CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY, username TEXT);
+	CREATE TABLE user_tokens (
+		user_id INTEGER NOT NULL,
+		token TEXT NOT NULL,
+		UNIQUE(user_id, token),
+		PRIMARY KEY(user_id, token),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE user_bans (
+		user_id INTEGER NOT NULL,
+		banned_id INTEGER NOT NULL,
+		UNIQUE(user_id, banned_id),
+		PRIMARY KEY(user_id, banned_id),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY(banned_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE follows (
+		follower_id INTEGER NOT NULL,
+		following_id INTEGER NOT NULL,
+		UNIQUE(follower_id, following_id),
+		PRIMARY KEY (following_id, follower_id),
+		FOREIGN KEY(follower_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY(following_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE photos (
+		id INTEGER NOT NULL PRIMARY KEY,
+		url TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		upload_date TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE likes (
+		id INTEGER NOT NULL PRIMARY KEY,
+		photo_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		date TEXT NOT NULL,
+		UNIQUE(photo_id, user_id),
+		FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE comments (
+		id INTEGER NOT NULL PRIMARY KEY,
+		photo_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		date TEXT NOT NULL,
+		content TEXT NOT NULL,
+		FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+`
+
+// testTokenHash is the hash of the tokens in the tests, longer than the plaintext tokens like the real one
+func testTokenHash(token string) string {
+	return "hashed-" + token
+}
+
+func newTestConn(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a different database
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func latestVersion(t *testing.T) int {
+	t.Helper()
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func assertSchemaVersion(t *testing.T, conn *sql.DB, expected int) {
+	t.Helper()
+	version, versioned, err := schemaVersion(conn, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !versioned {
+		t.Error("expected the schema version to be recorded")
+	}
+	if version != expected {
+		t.Error("expected schema version", expected, "got:", version)
+	}
+	var rows int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != expected {
+		t.Error("expected a schema_version row for each of the", expected, "migrations got:", rows)
+	}
+}
+
+func TestLoadMigrations_Ordered(t *testing.T) {
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "baseline" {
+		t.Fatal("expected the baseline to be the first migration")
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 || migration.SQL == "" {
+			t.Errorf("migration %d is not valid: %+v", i, migration)
+		}
+	}
+}
+
+// releasedMigrations are the checksums of the migrations rendered for each dialect. A migration is never changed once
+// released, along with what its template values render to: a new migration adds its checksums here, the existing ones
+// never change. The migrations up to 0010 were released for SQLite first, PostgreSQL got them later rendered with the
+// same values, whose SQLite rendering is the one released.
+var releasedMigrations = map[Dialect]map[int]string{
+	SQLite: {
+		1:  "a519dd597b76657077162e817c99ce92cfd5851bca207ab7ef1fd6ac75bde32b",
+		2:  "184fe516f07cf6361ce427298923d555b601c2187cf2575055876fe14cc0ec23",
+		3:  "6798eaee9677ede8fc9ae931498a997ccd831b6bce2116f3e07eba76c29e1de7",
+		4:  "93dc23ec6efa99871b95e23ec14f98c07ba30b899dd285fbb97609adde19ae34",
+		5:  "b2c45fead77445294c71fa9d8c5140dbaf1bf2b206d9117c42b45d80e91e62d9",
+		6:  "745eb162a70e75a4a2788588725be847539656a1d1dda1812249123fe3c800ee",
+		7:  "a9ee5f707c64a30bfede9500a7225a3ed0cb3f913fd41539478898913d9ed4b6",
+		8:  "f0afabdb3bc2bb7331ecd82cd20ce5f0222860bedbdf0c01a4451afaf3885dbf",
+		9:  "887614b4e6deb968ec6cddbfedb9f4ceec658f0cdde67d09a7abd53f604ef5c8",
+		10: "4e3919f7303cbedcc387402031c0cb7eba5bc1360c29770ce1fb6a4afdef70c0",
+		11: "43f718238a8568602bd79ac59e44ba8a1b766c323cd61444b6daf22a6bf4face",
+		12: "c58f3f1346106de85aeba56d0a98a729a3b8ebad49cc36c701771bc2d207c9b5",
+		13: "9ccf66dd13b4130661045d9ea97f51a9d615cd131f41a6a8b150f509def87a95",
+		14: "48ca3fa497f1944ea733af194b334e45b96ae3c5674c5ec143dcf999c54a5720",
+		15: "92c4c8522b391288633b77a882970d73c872b2434f8138f55aabb2a971d14fdc",
+		16: "1f8fe417a42261afee49fd6ccc6350f2a11a9c45e9e9f0591f1359e7c60a82ce",
+		17: "93b2b5c2354338eea581d6e84dbda2fc0c592ca2427498ca33e4a8e71f7b3bde",
+		18: "292d5a54cf33d3d3be8a5f51006ec3d5999a17f095059470197b00d7ad5b6ec9",
+		19: "6c32b09777a78a66ee0ae336380fcbcdeec21ba22ef79adacd2c110964851f55",
+	},
+	Postgres: {
+		1:  "d214fd71a55b9539e6ff4855d9f8b6010e37da4bdaacbc372674053898fe1296",
+		2:  "184fe516f07cf6361ce427298923d555b601c2187cf2575055876fe14cc0ec23",
+		3:  "363da18fb3bea0a45e4f34446997bb91fc9e65fbfaea70e63a5d496debb6ef0c",
+		4:  "fd0c823af0877f89a112784efea091584eb8d15c3929a8d84a1859682903b821",
+		5:  "15de276f9ae14f83f42cc3db66735da7d5fbfa873e4346fc0fef6873f8f7a6e9",
+		6:  "745eb162a70e75a4a2788588725be847539656a1d1dda1812249123fe3c800ee",
+		7:  "6bc6db1be222a43e050a0bfe7ed8348875a80a87bbb1076aa9e9940000b05b43",
+		8:  "f0afabdb3bc2bb7331ecd82cd20ce5f0222860bedbdf0c01a4451afaf3885dbf",
+		9:  "887614b4e6deb968ec6cddbfedb9f4ceec658f0cdde67d09a7abd53f604ef5c8",
+		10: "4e3919f7303cbedcc387402031c0cb7eba5bc1360c29770ce1fb6a4afdef70c0",
+		11: "1c99d9a427daf5fa5dcc7dd45e1d0de3077275d0dcd0517a8ef00bc68eac998e",
+		12: "c58f3f1346106de85aeba56d0a98a729a3b8ebad49cc36c701771bc2d207c9b5",
+		13: "9ccf66dd13b4130661045d9ea97f51a9d615cd131f41a6a8b150f509def87a95",
+		14: "48ca3fa497f1944ea733af194b334e45b96ae3c5674c5ec143dcf999c54a5720",
+		15: "92c4c8522b391288633b77a882970d73c872b2434f8138f55aabb2a971d14fdc",
+		16: "1f8fe417a42261afee49fd6ccc6350f2a11a9c45e9e9f0591f1359e7c60a82ce",
+		17: "06eff322d0e37e76506a9905973b3463c7d3626146c9ba1455784a70ecb0c292",
+		18: "292d5a54cf33d3d3be8a5f51006ec3d5999a17f095059470197b00d7ad5b6ec9",
+		19: "6c32b09777a78a66ee0ae336380fcbcdeec21ba22ef79adacd2c110964851f55",
+	},
+}
+
+func TestLoadMigrations_Released(t *testing.T) {
+	for dialect, checksums := range releasedMigrations {
+		migrations, err := loadMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) != len(checksums) {
+			t.Errorf("expected the checksums of the %d %s migrations got: %d", len(migrations), dialect, len(checksums))
+		}
+		for _, migration := range migrations {
+			if checksum := fmt.Sprintf("%x", sha256.Sum256([]byte(migration.SQL))); checksum != checksums[migration.Version] {
+				t.Errorf("the %s migration %04d_%s changed: %s", dialect, migration.Version, migration.Name, checksum)
+			}
+		}
+	}
+}
+
+func TestNew_EmptyDatabase(t *testing.T) {
+	conn := newTestConn(t)
+
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	assertSchemaVersion(t, conn, latestVersion(t))
+	if found, _ := SQLite.tableExists(conn, "login_challenges"); !found {
+		t.Error("expected the tables of the last migration to be created")
+	}
+}
+
+func TestNew_UpgradesBaselineDatabase(t *testing.T) {
+	conn := newTestConn(t)
+	if _, err := conn.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`
+		INSERT INTO users (id, username) VALUES (1, 'Maria'), (2, 'Mario');
+		INSERT INTO user_tokens (user_id, token) VALUES (1, 'abcdef0123456789abcdef0123456789abcdef01');
+		INSERT INTO follows (follower_id, following_id) VALUES (2, 1);
+		INSERT INTO photos (id, url, user_id, upload_date) VALUES (1, '/photos/a.png', 1, '2022-11-06T10:00:00+0000');
+		INSERT INTO likes (photo_id, user_id, date) VALUES (1, 2, '2022-11-06T10:05:00+0000');
+		INSERT INTO comments (photo_id, user_id, date, content) VALUES (1, 2, '2022-11-06T10:06:00+0000', 'Nice');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := PendingMigrations(conn, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != latestVersion(t)-1 {
+		t.Error("expected every migration but the baseline to be pending got:", len(pending))
+	}
+
+	// The plaintext token can't be hashed without the key, the migrations before stay applied
+	if _, err := New(conn, SQLite); !errors.Is(err, ErrTokenHashRequired) {
+		t.Fatal("expected the token hash to be required got:", err)
+	}
+	assertSchemaVersion(t, conn, 17)
+	if _, err := Migrate(conn, SQLite, MigrationConfig{TokenHash: testTokenHash}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	assertSchemaVersion(t, conn, latestVersion(t))
+
+	// The token of the baseline became a session, kept for 30 days since it has no refresh token, and it's hashed
+	var sessionUserId int
+	var expiresAt string
+	err = conn.QueryRow(
+		"SELECT user_id, expires_at FROM user_tokens WHERE token=?",
+		testTokenHash("abcdef0123456789abcdef0123456789abcdef01"),
+	).Scan(&sessionUserId, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires, err := time.Parse("2006-01-02T15:04:05.000Z", expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expires); sessionUserId != 1 || until < 719*time.Hour || until > 720*time.Hour {
+		t.Error("expected a session of user 1 expiring in 30 days got:", sessionUserId, expiresAt)
+	}
+
+	// Both users set their password at their next login
+	for table, expected := range map[string]int{
+		"users":             2,
+		"pending_passwords": 2,
+		"follows":           1,
+		"photos":            1,
+		"likes":             1,
+		"comments":          1,
+	} {
+		var count int
+		if err := conn.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Errorf("expected %d rows in %s got: %d", expected, table, count)
+		}
+	}
+
+	// The counters are backfilled from the existing rows
+	var totalLikes, totalComments, totalPhotos, totalFollowers, totalFollowing int
+	if err := conn.QueryRow("SELECT total_likes, total_comments FROM photos WHERE id=1").Scan(&totalLikes, &totalComments); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRow(
+		"SELECT total_photos, total_followers FROM users WHERE id=1",
+	).Scan(&totalPhotos, &totalFollowers); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRow("SELECT total_following FROM users WHERE id=2").Scan(&totalFollowing); err != nil {
+		t.Fatal(err)
+	}
+	if totalLikes != 1 || totalComments != 1 || totalPhotos != 1 || totalFollowers != 1 || totalFollowing != 1 {
+		t.Error("expected the counters to be backfilled got:", totalLikes, totalComments, totalPhotos, totalFollowers, totalFollowing)
+	}
+}
+
+func TestNew_RewritesDatesInUTC(t *testing.T) {
+	conn := newTestConn(t)
+	// A database with the dates stored with the offset of the server, before and after a change of daylight saving time
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(conn, SQLite, migrations[:10], MigrationConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`
+		INSERT INTO users (id, username) VALUES (1, 'Maria');
+		INSERT INTO photos (id, url, user_id, upload_date) VALUES
+			(1, '/photos/a.png', 1, '2022-10-30T02:30:00+0200'),
+			(2, '/photos/b.png', 1, '2022-10-30T02:10:00+0100');
+		INSERT INTO personal_access_tokens (user_id, name, token, scopes, created_at, expires_at) VALUES
+			(1, 'script', 'abcdef', 'photos:read', '2022-11-06T10:00:00-0530', NULL);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := conn.Query("SELECT upload_date FROM photos ORDER BY upload_date DESC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var dates []string
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			t.Fatal(err)
+		}
+		dates = append(dates, date)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// The second photo was uploaded 40 minutes after the first one, though its local time is earlier
+	if len(dates) != 2 || dates[0] != "2022-10-30T01:10:00.000Z" || dates[1] != "2022-10-30T00:30:00.000Z" {
+		t.Error("expected the upload dates in UTC, newest first, got:", dates)
+	}
+
+	var createdAt string
+	var expiresAt sql.NullString
+	if err := conn.QueryRow("SELECT created_at, expires_at FROM personal_access_tokens").Scan(&createdAt, &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if createdAt != "2022-11-06T15:30:00.000Z" || expiresAt.Valid {
+		t.Error("expected the creation date in UTC and no expiration got:", createdAt, expiresAt)
+	}
+}
+
+func TestNew_FillsUsernameKeys(t *testing.T) {
+	conn := newTestConn(t)
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(conn, SQLite, migrations[:11], MigrationConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("INSERT INTO users (id, username) VALUES (1, 'Maria'), (2, 'ＬＵＩＧＩ')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	var key string
+	if err := conn.QueryRow("SELECT username_key FROM users WHERE id=2").Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+	if key != "luigi" {
+		t.Error("expected the normalized key got:", key)
+	}
+	if _, err := conn.Exec("INSERT INTO users (username, username_key) VALUES ('MARIA', 'maria')"); err == nil {
+		t.Error("expected the key to be unique")
+	}
+}
+
+func TestMigrate_HashesLegacyTokensOnce(t *testing.T) {
+	conn := newTestConn(t)
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(conn, SQLite, migrations[:17], MigrationConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	legacyToken := "abcdef0123456789abcdef0123456789abcdef01"
+	hashedToken := testTokenHash("0123456789abcdef0123456789abcdef01234567")
+	if _, err := conn.Exec(`
+		INSERT INTO users (id, username, username_key) VALUES (1, 'Mario', 'mario');
+		INSERT INTO user_tokens (id, user_id, token, created_at, last_used_at, expires_at)
+		VALUES (1, 1, ?, '', '', ''), (2, 1, ?, '', '', '');
+		INSERT INTO refresh_tokens (session_id, token, expires_at) VALUES (1, ?, '');
+	`, legacyToken, hashedToken, "fedcba9876543210fedcba9876543210fedcba98"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Migrate(conn, SQLite, MigrationConfig{TokenHash: testTokenHash}); err != nil {
+		t.Fatal(err)
+	}
+	for query, token := range map[string]string{
+		"SELECT token FROM user_tokens WHERE id=1":    testTokenHash(legacyToken),
+		"SELECT token FROM user_tokens WHERE id=2":    hashedToken,
+		"SELECT token FROM refresh_tokens WHERE id=1": testTokenHash("fedcba9876543210fedcba9876543210fedcba98"),
+	} {
+		var stored string
+		if err := conn.QueryRow(query).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored != token {
+			t.Error("expected", token, "got:", stored, query)
+		}
+	}
+
+	// The step runs with its migration only: the tokens aren't looked at again at the next startup
+	if _, err := conn.Exec("UPDATE user_tokens SET token=? WHERE id=1", legacyToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := conn.QueryRow("SELECT token FROM user_tokens WHERE id=1").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != legacyToken {
+		t.Error("expected the token not to be hashed again got:", stored)
+	}
+}
+
+func TestNewWithPools_LegacyTokens(t *testing.T) {
+	conn := newTestConn(t)
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(conn, SQLite, migrations[:17], MigrationConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	legacyToken := "abcdef0123456789abcdef0123456789abcdef01"
+	if _, err := conn.Exec(`
+		INSERT INTO users (id, username, username_key) VALUES (1, 'Mario', 'mario');
+		INSERT INTO user_tokens (id, user_id, token, created_at, last_used_at, expires_at) VALUES (1, 1, ?, '', '', '');
+	`, legacyToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the key the database can't be opened, the tools opening it leave the migration to webapi
+	_, err = NewWithPools(conn, conn, SQLite)
+	if !errors.Is(err, ErrMigrationConfigRequired) || !errors.Is(err, ErrTokenHashRequired) {
+		t.Fatal("expected the migration config to be required got:", err)
+	}
+	if !strings.Contains(err.Error(), "Migrate") {
+		t.Error("expected the error to tell to run Migrate first got:", err)
+	}
+	assertSchemaVersion(t, conn, 17)
+
+	if _, err := Migrate(conn, SQLite, MigrationConfig{TokenHash: testTokenHash}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWithPools(conn, conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := conn.QueryRow("SELECT token FROM user_tokens WHERE id=1").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != testTokenHash(legacyToken) {
+		t.Error("expected the token to be hashed got:", stored)
+	}
+}
+
+func TestNew_PendingPasswordsOfUsersWithoutCredentials(t *testing.T) {
+	conn := newTestConn(t)
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(conn, SQLite, migrations[:18], MigrationConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`
+		INSERT INTO users (id, username, username_key) VALUES (1, 'Mario', 'mario'), (2, 'Luigi', 'luigi'), (3, 'Maria', 'maria');
+		INSERT INTO user_credentials (user_id, password_hash) VALUES (1, 'hash');
+		INSERT INTO user_identities (issuer, subject, user_id) VALUES ('https://idp.example.com', '1234', 2);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	// Only the user with neither a password nor an identity, created before passwords were introduced, sets it
+	var userIds []int
+	rows, err := conn.Query("SELECT user_id FROM pending_passwords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, userId)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(userIds) != 1 || userIds[0] != 3 {
+		t.Error("expected the pending password of Maria got:", userIds)
+	}
+}
+
+func TestNew_ReportsDuplicateUsernames(t *testing.T) {
+	conn := newTestConn(t)
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(conn, SQLite, migrations[:11], MigrationConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("INSERT INTO users (id, username) VALUES (1, 'Mario'), (2, 'Luigi'), (3, 'MARIO')"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(conn, SQLite)
+	if !errors.Is(err, ErrDuplicateUsernames) {
+		t.Fatal("expected", ErrDuplicateUsernames, "got:", err)
+	}
+	if !strings.Contains(err.Error(), `"Mario" (id 1), "MARIO" (id 3)`) {
+		t.Error("expected the duplicates to be listed got:", err)
+	}
+	// The migration is rolled back, so it's applied again once the users are renamed
+	if version, _, err := schemaVersion(conn, SQLite); err != nil || version != 11 {
+		t.Error("expected the schema to stay at version 11 got:", version, err)
+	}
+	if _, err := conn.Exec("UPDATE users SET username='Mario2' WHERE id=3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_RecordsVersionOfUnversionedDatabase(t *testing.T) {
+	conn := newTestConn(t)
+	// A database created before schema versioning, with the tables of every migration up to personal access tokens
+	migrations, err := loadMigrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations[:5] {
+		if _, err := conn.Exec(migration.SQL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending, err := PendingMigrations(conn, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) == 0 || pending[0].Version != 6 {
+		t.Fatal("expected the migrations after the fifth to be pending got:", pending)
+	}
+
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	assertSchemaVersion(t, conn, latestVersion(t))
+}
+
+func TestNew_Idempotent(t *testing.T) {
+	conn := newTestConn(t)
+	if _, err := New(conn, SQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Migrate(conn, SQLite, MigrationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Error("expected no migration to be applied twice got:", len(applied))
+	}
+	assertSchemaVersion(t, conn, latestVersion(t))
+}
+
+func TestPendingMigrations_DoesNotChangeDatabase(t *testing.T) {
+	conn := newTestConn(t)
+
+	pending, err := PendingMigrations(conn, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != latestVersion(t) {
+		t.Error("expected every migration to be pending got:", len(pending))
+	}
+	for _, table := range []string{"schema_version", "users"} {
+		if found, _ := SQLite.tableExists(conn, table); found {
+			t.Error("expected no table to be created got:", table)
+		}
+	}
+}
+
+func TestMigrate_FailedMigrationIsRolledBack(t *testing.T) {
+	conn := newTestConn(t)
+	migrations := []Migration{
+		{Version: 1, Name: "first", SQL: "CREATE TABLE first (id INTEGER);"},
+		{Version: 2, Name: "broken", SQL: "CREATE TABLE second (id INTEGER); INSERT INTO missing VALUES (1);"},
+	}
+
+	if _, err := migrate(conn, SQLite, migrations, MigrationConfig{}); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+
+	assertSchemaVersion(t, conn, 1)
+	if found, _ := SQLite.tableExists(conn, "second"); found {
+		t.Error("expected the changes of the broken migration to be rolled back")
+	}
+}
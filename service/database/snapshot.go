@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrSnapshotUnsupported is returned by Snapshot when the engine can't take one, back up PostgreSQL with pg_dump
+var ErrSnapshotUnsupported = errors.New("database snapshots are supported only by SQLite")
+
+// snapshotStepPages is how many pages a step of the online backup copies, ctx is checked between the steps
+const snapshotStepPages = 1024
+
+// snapshotBusyTimeout is how long the snapshot waits for a lock held on the database before failing
+const snapshotBusyTimeout = 5 * time.Second
+
+func (db *appdbimpl) Snapshot(ctx context.Context, path string, fn func() error) error {
+	if db.dialect != SQLite {
+		return ErrSnapshotUnsupported
+	}
+
+	// Holding the write connection keeps the rows as they are until fn returns
+	writeConn, err := db.connectionInstance.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = writeConn.Close()
+	}()
+
+	// With a read pool the copy is read through another connection, so the write lock can be taken too: it holds the
+	// writes of the other processes as well, while the readers go on. The backup can't read through a connection that
+	// is in a write transaction, so with a single pool only the writes of this one are held.
+	readConn := writeConn
+	if db.readInstance != db.connectionInstance {
+		if _, err := writeConn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return fmt.Errorf("taking the write lock: %w", err)
+		}
+		defer func() {
+			_, _ = writeConn.ExecContext(context.Background(), "ROLLBACK")
+		}()
+
+		readConn, err = db.readInstance.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = readConn.Close()
+		}()
+	}
+
+	if err := backupSQLite(ctx, readConn, path); err != nil {
+		return fmt.Errorf("copying the database: %w", err)
+	}
+	return fn()
+}
+
+func (db *txdbimpl) Snapshot(ctx context.Context, path string, fn func() error) error {
+	// The transaction holds the write connection the snapshot would wait for
+	return errors.New("a database snapshot can't be taken within a transaction")
+}
+
+// backupSQLite copies the database of src to a new SQLite file at path, with the online backup API
+func backupSQLite(ctx context.Context, src *sql.Conn, path string) error {
+	dest, err := sql.Open(string(SQLite), sqliteDSN(path, nil))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dest.Close()
+	}()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = destConn.Close()
+	}()
+
+	err = destConn.Raw(func(destDriverConn interface{}) error {
+		return src.Raw(func(srcDriverConn interface{}) error {
+			destSQLite, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("the snapshot connection isn't a SQLite one")
+			}
+			srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("the database connection isn't a SQLite one")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			// A step copies nothing while the database is locked, e.g. by a process writing in the rollback journal mode
+			busySince := time.Time{}
+			for done := false; !done; {
+				if err := ctx.Err(); err != nil {
+					_ = backup.Close()
+					return err
+				}
+				remaining := backup.Remaining()
+				if done, err = backup.Step(snapshotStepPages); err != nil {
+					_ = backup.Close()
+					return err
+				}
+				switch {
+				case done || backup.Remaining() != remaining:
+					busySince = time.Time{}
+				case busySince.IsZero():
+					busySince = time.Now()
+				case time.Since(busySince) > snapshotBusyTimeout:
+					_ = backup.Close()
+					return errors.New("the database stayed locked")
+				default:
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			return backup.Finish()
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// The copy keeps the journal mode of the database, in the rollback one it's a single self-contained file
+	_, err = destConn.ExecContext(ctx, "PRAGMA journal_mode = DELETE")
+	return err
+}
+
+// VerifySQLite checks the integrity of the SQLite database at path, e.g. a snapshot about to be restored
+func VerifySQLite(path string) error {
+	db, err := sql.Open(string(SQLite), sqliteDSN(path, url.Values{"mode": {"ro"}}))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("the SQLite database is corrupted: %s", result)
+	}
+	return nil
+}
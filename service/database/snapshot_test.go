@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func countSnapshotUsers(t *testing.T, path string) int {
+	t.Helper()
+	if err := VerifySQLite(path); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := sql.Open(string(SQLite), sqliteDSN(path, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = snapshot.Close()
+	}()
+	var count int
+	if err := snapshot.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestSnapshot_HoldsTheWrites(t *testing.T) {
+	write, read, err := OpenSQLite(filepath.Join(t.TempDir(), "wasa.db"), testSQLiteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = read.Close()
+		_ = write.Close()
+	}()
+	db, err := NewWithPools(write, read, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := db.WriteConn().Exec(ctx, "INSERT INTO users (username) VALUES ('Mario')"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	written := make(chan error, 1)
+	err = db.Snapshot(ctx, path, func() error {
+		go func() {
+			_, err := db.WriteConn().Exec(ctx, "INSERT INTO users (username) VALUES ('Luigi')")
+			written <- err
+		}()
+		select {
+		case err := <-written:
+			t.Error("expected the write to wait for the snapshot got:", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	if count := countSnapshotUsers(t, path); count != 1 {
+		t.Error("expected the snapshot to have 1 user got:", count)
+	}
+}
+
+func TestSnapshot_SinglePool(t *testing.T) {
+	conn, err := sql.Open(string(SQLite), sqliteDSN(filepath.Join(t.TempDir(), "wasa.db"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	conn.SetMaxOpenConns(1)
+	db, err := New(conn, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := db.WriteConn().Exec(ctx, "INSERT INTO users (username) VALUES ('Mario')"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := db.Snapshot(ctx, path, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if count := countSnapshotUsers(t, path); count != 1 {
+		t.Error("expected the snapshot to have 1 user got:", count)
+	}
+
+	err = db.InTx(ctx, func(tx AppDatabase) error {
+		return tx.Snapshot(ctx, filepath.Join(t.TempDir(), "tx.db"), func() error { return nil })
+	})
+	if err == nil {
+		t.Error("expected a snapshot within a transaction to fail")
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SQLiteConfig tunes the connections to a SQLite database
+type SQLiteConfig struct {
+	// JournalMode is the journal_mode of the database, WAL lets the reads run alongside a write
+	JournalMode string
+	// BusyTimeout is how long a query waits for a lock held by another connection, or process, before failing
+	BusyTimeout time.Duration
+	// MaxReadConnections is the size of the read pool
+	MaxReadConnections int
+}
+
+// sqliteJournalModes are the values of the journal_mode pragma
+var sqliteJournalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+
+// Validate checks that the configuration can be used to open a database
+func (cfg SQLiteConfig) Validate() error {
+	valid := false
+	for _, mode := range sqliteJournalModes {
+		valid = valid || strings.EqualFold(cfg.JournalMode, mode)
+	}
+	if !valid {
+		return fmt.Errorf("unsupported SQLite journal mode %q, use one of: %s", cfg.JournalMode, strings.Join(sqliteJournalModes, ", "))
+	}
+	if cfg.BusyTimeout < 0 {
+		return fmt.Errorf("the SQLite busy timeout can't be negative")
+	}
+	if cfg.MaxReadConnections < 1 {
+		return fmt.Errorf("at least one SQLite read connection is required")
+	}
+	return nil
+}
+
+// OpenSQLite opens the pools for NewWithPools on the SQLite database stored at path. The write pool has a single
+// connection, so that the writes of the app are serialized, and it begins the transactions with BEGIN IMMEDIATE, so
+// that they take the write lock upfront instead of failing when they try to upgrade it. The read pool can't write.
+func OpenSQLite(path string, cfg SQLiteConfig) (write *sql.DB, read *sql.DB, err error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	busyTimeout := fmt.Sprint(cfg.BusyTimeout.Milliseconds())
+	write, err = sql.Open(string(SQLite), sqliteDSN(path, url.Values{
+		"_foreign_keys": {"on"},
+		"_busy_timeout": {busyTimeout},
+		"_journal_mode": {strings.ToUpper(cfg.JournalMode)},
+		"_txlock":       {"immediate"},
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+	write.SetMaxOpenConns(1)
+
+	read, err = sql.Open(string(SQLite), sqliteDSN(path, url.Values{
+		"_foreign_keys": {"on"},
+		"_busy_timeout": {busyTimeout},
+		"_query_only":   {"on"},
+	}))
+	if err != nil {
+		_ = write.Close()
+		return nil, nil, err
+	}
+	read.SetMaxOpenConns(cfg.MaxReadConnections)
+	read.SetMaxIdleConns(cfg.MaxReadConnections)
+
+	return write, read, nil
+}
+
+// sqliteDSN is the data source name of the database at path for the SQLite driver. The path is escaped, SQLite reads it
+// as a URI: a ? or a # in it would start the parameters, a % would be decoded.
+func sqliteDSN(path string, params url.Values) string {
+	return (&url.URL{Scheme: "file", Opaque: (&url.URL{Path: path}).EscapedPath(), RawQuery: params.Encode()}).String()
+}
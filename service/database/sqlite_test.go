@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testSQLiteConfig = SQLiteConfig{
+	JournalMode:        "wal",
+	BusyTimeout:        time.Second,
+	MaxReadConnections: 2,
+}
+
+func TestOpenSQLite_Pools(t *testing.T) {
+	write, read, err := OpenSQLite(filepath.Join(t.TempDir(), "wasa.db"), testSQLiteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = read.Close()
+		_ = write.Close()
+	}()
+	db, err := NewWithPools(write, read, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var journalMode string
+	if err := db.Conn().QueryRow(context.Background(), "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	}
+	if journalMode != "wal" {
+		t.Error("expected the WAL journal mode got:", journalMode)
+	}
+	if stats := write.Stats(); stats.MaxOpenConnections != 1 {
+		t.Error("expected a single write connection got:", stats.MaxOpenConnections)
+	}
+
+	if _, err := db.Conn().Exec(context.Background(), "INSERT INTO users (username) VALUES ('Mario')"); err == nil {
+		t.Error("expected the read pool not to write")
+	}
+	if _, err := db.WriteConn().Exec(context.Background(), "INSERT INTO users (username) VALUES ('Mario')"); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.Conn().QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error("expected the write to be read got:", count)
+	}
+}
+
+func TestOpenSQLite_PathIsEscaped(t *testing.T) {
+	// Unescaped, the name would be read as wasa with the mode=ro parameter
+	path := filepath.Join(t.TempDir(), "wasa?mode=ro#100%25 done.db")
+	write, read, err := OpenSQLite(path, testSQLiteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = read.Close()
+		_ = write.Close()
+	}()
+	if _, err := NewWithPools(write, read, SQLite); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("expected the database at the path got:", err)
+	}
+}
+
+func TestSQLiteConfig_Validate(t *testing.T) {
+	if err := testSQLiteConfig.Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, cfg := range []SQLiteConfig{
+		{JournalMode: "fast", MaxReadConnections: 1},
+		{JournalMode: "WAL", BusyTimeout: -time.Second, MaxReadConnections: 1},
+		{JournalMode: "WAL"},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ErrTokenHashRequired is returned by the migration hashing the plaintext tokens when MigrationConfig has no TokenHash
+var ErrTokenHashRequired = fmt.Errorf("%w: the token hash key is required to hash the tokens stored in plaintext",
+	ErrMigrationConfigRequired)
+
+// legacyTokenLength is the length of the hex encoded SHA-1 tokens stored in plaintext before tokens were hashed. The
+// keyed hashes are hex encoded SHA-256 sums, they're always longer.
+const legacyTokenLength = 40
+
+// hashLegacyTokens replaces, in place, the plaintext access and refresh tokens with their hash, the step of the
+// migration to hashed tokens, so that the clients holding them keep working. It needs cfg.TokenHash only if there are
+// plaintext tokens to hash.
+func hashLegacyTokens(tx *sql.Tx, dialect Dialect, cfg MigrationConfig) error {
+	for _, table := range []string{"user_tokens", "refresh_tokens"} {
+		rows, err := tx.Query(dialect.rebind(fmt.Sprintf("SELECT id, token FROM %s WHERE length(token)=?", table)), legacyTokenLength)
+		if err != nil {
+			return err
+		}
+		legacyTokens := make(map[int]string)
+		for rows.Next() {
+			var id int
+			var token string
+			if err := rows.Scan(&id, &token); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			legacyTokens[id] = token
+		}
+		if err := rows.Err(); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		_ = rows.Close()
+
+		if len(legacyTokens) > 0 && cfg.TokenHash == nil {
+			return fmt.Errorf("%w: start webapi with its key to migrate the database", ErrTokenHashRequired)
+		}
+		for id, token := range legacyTokens {
+			if _, err := tx.Exec(
+				dialect.rebind(fmt.Sprintf("UPDATE %s SET token=? WHERE id=?", table)),
+				cfg.TokenHash(token),
+				id,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
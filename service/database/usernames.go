@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lucaronca/wasa-homework/service/usernames"
+)
+
+// ErrDuplicateUsernames is returned by the migration to unique usernames when some users have the same one
+var ErrDuplicateUsernames = errors.New("some usernames are the same once normalized")
+
+// fillUsernameKeys sets the username_key of every user, the step of the migration to unique usernames. It fails listing
+// the usernames that are the same once normalized, instead of picking which user keeps the name.
+func fillUsernameKeys(tx *sql.Tx, dialect Dialect, _ MigrationConfig) error {
+	rows, err := tx.Query("SELECT id, username FROM users WHERE username IS NOT NULL ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	type user struct {
+		id       int
+		username string
+	}
+	var users []user
+	byKey := make(map[string][]user)
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.id, &u.username); err != nil {
+			return err
+		}
+		users = append(users, u)
+		key := usernames.Key(u.username)
+		byKey[key] = append(byKey[key], u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	var duplicates []string
+	for _, same := range byKey {
+		if len(same) < 2 {
+			continue
+		}
+		names := make([]string, len(same))
+		for i, u := range same {
+			names[i] = fmt.Sprintf("%q (id %d)", u.username, u.id)
+		}
+		duplicates = append(duplicates, strings.Join(names, ", "))
+	}
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		return fmt.Errorf("%w, rename them and retry: %s", ErrDuplicateUsernames, strings.Join(duplicates, "; "))
+	}
+
+	for _, u := range users {
+		if _, err := tx.Exec(
+			dialect.rebind("UPDATE users SET username_key=? WHERE id=?"),
+			usernames.Key(u.username),
+			u.id,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// blurHashSize is the size of the side of the copy of the image the BlurHash is computed on, the details lost
+	// scaling it down wouldn't make it to the hash anyway
+	blurHashSize = 32
+
+	// blurHashComponents is the number of components along the longest side of the image, there's one less along
+	// the other side
+	blurHashComponents = 4
+
+	base83Digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// BlurHash returns the BlurHash of img (https://blurha.sh), a short string the clients decode to a blurred placeholder
+// of the image while it loads. The transparency is ignored.
+func BlurHash(img image.Image) string {
+	bounds := img.Bounds()
+	xComponents, yComponents := blurHashComponents, blurHashComponents-1
+	width, height := blurHashSize, bounds.Dy()*blurHashSize/bounds.Dx()
+	if bounds.Dy() > bounds.Dx() {
+		xComponents, yComponents = yComponents, xComponents
+		width, height = bounds.Dx()*blurHashSize/bounds.Dy(), blurHashSize
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	small := scale(img, width, height)
+
+	// The pixels are converted to linear RGB once, each component sums all of them
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pix := small.Pix[y*small.Stride+x*4:]
+			linear[y*width+x] = [3]float64{sRGBToLinear(pix[0]), sRGBToLinear(pix[1]), sRGBToLinear(pix[2])}
+		}
+	}
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					for c := range factor {
+						factor[c] += basis * linear[y*width+x][c]
+					}
+				}
+			}
+			normalization := 2.0
+			if i == 0 && j == 0 {
+				normalization = 1
+			}
+			for c := range factor {
+				factor[c] *= normalization / float64(width*height)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var hash strings.Builder
+	writeBase83(&hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	// The AC components are quantized relative to the largest of them
+	maximum := 0.0
+	for _, factor := range factors[1:] {
+		for _, v := range factor {
+			maximum = math.Max(maximum, math.Abs(v))
+		}
+	}
+	quantizedMaximum := int(math.Max(0, math.Min(82, math.Floor(maximum*166-0.5))))
+	maximum = float64(quantizedMaximum+1) / 166
+	writeBase83(&hash, quantizedMaximum, 1)
+
+	dc := factors[0]
+	writeBase83(&hash, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, factor := range factors[1:] {
+		var quantized [3]int
+		for c, v := range factor {
+			quantized[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		writeBase83(&hash, quantized[0]*19*19+quantized[1]*19+quantized[2], 2)
+	}
+	return hash.String()
+}
+
+// scale returns a copy of img scaled to width and height
+func scale(img image.Image, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func sRGBToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// writeBase83 writes value as length base 83 digits
+func writeBase83(b *strings.Builder, value int, length int) {
+	divisor := 1
+	for i := 1; i < length; i++ {
+		divisor *= 83
+	}
+	for ; divisor > 0; divisor /= 83 {
+		b.WriteByte(base83Digits[(value/divisor)%83])
+	}
+}
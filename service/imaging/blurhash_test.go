@@ -0,0 +1,39 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBlurHash(t *testing.T) {
+	// The expected hash is the one of the reference implementation, with 4x3 components
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 10), B: 128, A: 255})
+		}
+	}
+	if hash := BlurHash(img); hash != "LxH27k2swxX8mHWWjtf7gJfjfQfj" {
+		t.Error("expected LxH27k2swxX8mHWWjtf7gJfjfQfj got:", hash)
+	}
+}
+
+func TestBlurHash_Components(t *testing.T) {
+	for _, test := range []struct {
+		width, height int
+		// sizeFlag is the first character of the hash, (x components - 1) + (y components - 1) * 9
+		sizeFlag byte
+	}{
+		{300, 200, 'L'},
+		{200, 300, 'T'},
+		{2000, 1, 'L'},
+		{1, 1, 'L'},
+	} {
+		hash := BlurHash(image.NewNRGBA(image.Rect(0, 0, test.width, test.height)))
+		// The size flag, the maximum, the DC and 2 characters for each of the 11 AC components
+		if len(hash) != 28 || hash[0] != test.sizeFlag {
+			t.Error("expected a 28 characters hash starting with", string(test.sizeFlag), "for", test.width, "x", test.height, "got:", hash)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	tagOrientation        = 0x0112
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+
+	// exifDateLayout is the layout of the EXIF dates, they're in the local time of the camera
+	exifDateLayout = "2006:01:02 15:04:05"
+)
+
+// exifHeader precedes the EXIF data in the JPEG APP1 segment, and sometimes in the WebP EXIF chunk
+var exifHeader = []byte("Exif\x00\x00")
+
+var errExifNotValid = errors.New("EXIF data not valid")
+
+// Metadata is what the EXIF of an image tells about it
+type Metadata struct {
+	// Orientation is the EXIF orientation, from 1 to 8: with 1, the default, the image is stored as it's shown
+	Orientation int
+	// TakenAt is the date the photo was taken, nil if it's unknown. Without the offset of the camera, its local time is
+	// taken as UTC.
+	TakenAt *time.Time
+}
+
+// ReadMetadata reads the EXIF of the image in data, encoded in format. An image without EXIF, or with EXIF that can't
+// be parsed, has the default metadata.
+func ReadMetadata(data []byte, format string) Metadata {
+	var exif []byte
+	switch format {
+	case "jpeg":
+		exif = jpegExif(data)
+	case "png":
+		exif = pngExif(data)
+	case "webp":
+		exif = webpExif(data)
+	}
+	metadata, err := parseExif(exif)
+	if err != nil {
+		return Metadata{Orientation: 1}
+	}
+	return metadata
+}
+
+func jpegExif(data []byte) []byte {
+	var exif []byte
+	_ = walkJPEG(data, func(marker byte, segment []byte) bool {
+		if marker == 0xe1 && bytes.HasPrefix(segment[4:], exifHeader) {
+			exif = segment[4+len(exifHeader):]
+			return false
+		}
+		return marker != 0xda
+	})
+	return exif
+}
+
+func pngExif(data []byte) []byte {
+	var exif []byte
+	_ = walkPNG(data, func(chunkType string, chunk []byte) bool {
+		if chunkType == "eXIf" {
+			exif = chunk[8 : len(chunk)-4]
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+func webpExif(data []byte) []byte {
+	var exif []byte
+	_ = walkWebP(data, func(fourCC string, chunk []byte) bool {
+		if fourCC == "EXIF" {
+			exif = bytes.TrimPrefix(chunk[8:8+binary.LittleEndian.Uint32(chunk[4:8])], exifHeader)
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// tiffEntry is an entry of an IFD of the TIFF structure of the EXIF data
+type tiffEntry struct {
+	dataType uint16
+	count    uint32
+	// value is the value of the entry if it fits in 4 bytes, its offset otherwise
+	value []byte
+}
+
+// tiffReader reads the IFDs of the TIFF structure of the EXIF data
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseExif reads the orientation and the capture date from the TIFF structure of the EXIF data
+func parseExif(data []byte) (Metadata, error) {
+	metadata := Metadata{Orientation: 1}
+	if len(data) < 8 {
+		return metadata, errExifNotValid
+	}
+	r := tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return metadata, errExifNotValid
+	}
+	if r.order.Uint16(data[2:4]) != 42 {
+		return metadata, errExifNotValid
+	}
+
+	ifd0, err := r.ifd(r.order.Uint32(data[4:8]))
+	if err != nil {
+		return metadata, err
+	}
+	if entry, ok := ifd0[tagOrientation]; ok && entry.dataType == typeShort {
+		if orientation := int(r.order.Uint16(entry.value)); orientation >= 1 && orientation <= 8 {
+			metadata.Orientation = orientation
+		}
+	}
+
+	entry, ok := ifd0[tagExifIFD]
+	if !ok || entry.dataType != typeLong {
+		return metadata, nil
+	}
+	exifIFD, err := r.ifd(r.order.Uint32(entry.value))
+	if err != nil {
+		return metadata, nil
+	}
+	date, ok := r.ascii(exifIFD[tagDateTimeOriginal])
+	if !ok {
+		return metadata, nil
+	}
+	location := time.UTC
+	if offset, ok := r.ascii(exifIFD[tagOffsetTimeOriginal]); ok {
+		if zone, err := time.Parse("-07:00", offset); err == nil {
+			location = zone.Location()
+		}
+	}
+	if takenAt, err := time.ParseInLocation(exifDateLayout, date, location); err == nil {
+		takenAt = takenAt.UTC()
+		metadata.TakenAt = &takenAt
+	}
+	return metadata, nil
+}
+
+// ifd reads the entries of the IFD at offset, by tag
+func (r tiffReader) ifd(offset uint32) (map[uint16]tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return nil, errExifNotValid
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(r.data) {
+		return nil, errExifNotValid
+	}
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		entry := r.data[start+i*12 : start+(i+1)*12]
+		entries[r.order.Uint16(entry[0:2])] = tiffEntry{
+			dataType: r.order.Uint16(entry[2:4]),
+			count:    r.order.Uint32(entry[4:8]),
+			value:    entry[8:12],
+		}
+	}
+	return entries, nil
+}
+
+// ascii returns the string value of an ASCII entry, without its terminating NUL
+func (r tiffReader) ascii(entry tiffEntry) (string, bool) {
+	if entry.dataType != typeASCII || entry.count == 0 {
+		return "", false
+	}
+	value := entry.value
+	if entry.count > 4 {
+		offset := uint64(r.order.Uint32(entry.value))
+		if offset+uint64(entry.count) > uint64(len(r.data)) {
+			return "", false
+		}
+		value = r.data[offset : offset+uint64(entry.count)]
+	} else {
+		value = value[:entry.count]
+	}
+	return strings.TrimRight(string(value), "\x00 "), true
+}
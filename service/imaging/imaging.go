@@ -0,0 +1,88 @@
+/*
+Package imaging decodes the uploaded photos and resizes them, in pure Go.
+
+JPEG, PNG and WebP images are decoded. Since there's no WebP encoder in pure Go, the resized images are encoded as JPEG,
+or as PNG when the original is a PNG or when it's not opaque, so that transparency is kept.
+*/
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	// Registers the WebP format, along with JPEG and PNG registered by the encoders
+	_ "golang.org/x/image/webp"
+)
+
+// jpegQuality is the quality of the JPEG images encoded by Encode
+const jpegQuality = 85
+
+// ErrFormatNotSupported is returned by Encode for the formats it can't write
+var ErrFormatNotSupported = errors.New("image format not supported")
+
+// ErrTooManyPixels is returned by DecodeLimited for the images larger than allowed
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+// Decode decodes a JPEG, PNG or WebP image, and returns its format name: jpeg, png or webp
+func Decode(r io.Reader) (image.Image, string, error) {
+	return image.Decode(r)
+}
+
+// DecodeLimited decodes the image read from r like Decode, if it has at most maxPixels pixels. The dimensions are read
+// from the header first, so that a small file declaring huge dimensions is rejected before its pixels are allocated,
+// then r is rewound to decode the pixels.
+func DecodeLimited(r io.ReadSeeker, maxPixels int) (image.Image, string, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, "", err
+	}
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxPixels/config.Height {
+		return nil, "", ErrTooManyPixels
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return Decode(r)
+}
+
+// Resize scales img to width, keeping its aspect ratio. The height is at least a pixel.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// EncodingFormat returns the format the resized copies of img are encoded with, given the format of the original
+func EncodingFormat(img image.Image, format string) string {
+	if format == "png" {
+		return "png"
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		return "png"
+	}
+	return "jpeg"
+}
+
+// Encode writes img to w in format, jpeg or png
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		return png.Encode(w, img)
+	}
+	return ErrFormatNotSupported
+}
@@ -0,0 +1,110 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDecode_WebP(t *testing.T) {
+	file, err := os.Open("testdata/video-001.lossy.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	img, format, err := Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "webp" || img.Bounds().Dx() != 150 || img.Bounds().Dy() != 103 {
+		t.Error("expected a 150x103 webp image got:", format, img.Bounds())
+	}
+	if encoding := EncodingFormat(img, format); encoding != "jpeg" {
+		t.Error("expected an opaque webp image to be encoded as jpeg got:", encoding)
+	}
+}
+
+func TestResize_KeepsAspectRatio(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for _, test := range []struct {
+		width, height int
+	}{
+		{150, 100},
+		{100, 66},
+		{1, 1},
+	} {
+		resized := Resize(img, test.width)
+		if resized.Bounds().Dx() != test.width || resized.Bounds().Dy() != test.height {
+			t.Error("expected", test.width, "x", test.height, "got:", resized.Bounds())
+		}
+	}
+}
+
+func TestEncode_KeepsTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	format := EncodingFormat(img, "webp")
+	if format != "png" {
+		t.Fatal("expected a transparent image to be encoded as png got:", format)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, Resize(img, 2), format); err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedFormat, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodedFormat != "png" || decoded.Bounds().Dx() != 2 {
+		t.Error("expected a 2 pixels wide png got:", decodedFormat, decoded.Bounds())
+	}
+	if err := Encode(&buf, img, "webp"); err != ErrFormatNotSupported {
+		t.Error("expected", ErrFormatNotSupported, "got:", err)
+	}
+}
+
+func TestDecodeLimited_TooManyPixels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	small := buf.Bytes()
+	if _, _, err := DecodeLimited(bytes.NewReader(small), 100); err != nil {
+		t.Error("expected a 10x10 image to be decoded got:", err)
+	}
+	if _, _, err := DecodeLimited(bytes.NewReader(small), 99); err != ErrTooManyPixels {
+		t.Error("expected", ErrTooManyPixels, "got:", err)
+	}
+
+	// A few bytes declaring a 100000x100000 image, whose pixels would take 10 GB
+	bomb := append([]byte{}, small...)
+	ihdr := bomb[len(pngSignature)+8 : len(pngSignature)+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:4], 100000)
+	binary.BigEndian.PutUint32(ihdr[4:8], 100000)
+	binary.BigEndian.PutUint32(bomb[len(pngSignature)+8+13:], crc32.ChecksumIEEE(bomb[len(pngSignature)+4:len(pngSignature)+8+13]))
+	if _, _, err := DecodeLimited(bytes.NewReader(bomb), 50000000); err != ErrTooManyPixels {
+		t.Error("expected", ErrTooManyPixels, "got:", err)
+	}
+	if _, _, err := DecodeLimited(bytes.NewReader(small[:len(small)/2]), 100); err == nil {
+		t.Error("expected a truncated image not to be decoded")
+	}
+
+	// The image is decoded from where the reader is
+	r := bytes.NewReader(append([]byte("prefix"), small...))
+	if _, err := r.Seek(int64(len("prefix")), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if img, _, err := DecodeLimited(r, 100); err != nil || img.Bounds().Dx() != 10 {
+		t.Error("expected a 10x10 image to be decoded after the prefix got:", err)
+	}
+}
@@ -0,0 +1,217 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errImageNotValid = errors.New("image not valid")
+
+// pngSignature starts every PNG image
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// metadataChunks are the PNG and WebP chunks stripped from the images, they hold EXIF, XMP, text and dates
+var metadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+	"EXIF": true,
+	"XMP ": true,
+}
+
+// VP8X flags of the WebP metadata chunks
+const (
+	webpExifFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+// StripMetadata writes the image in data, encoded in format, to w without its metadata: EXIF, XMP, IPTC, comments and
+// text chunks. The pixels are copied as they are, the color profile is kept.
+func StripMetadata(w io.Writer, data []byte, format string) error {
+	var out bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = stripJPEG(&out, data)
+	case "png":
+		err = stripPNG(&out, data)
+	case "webp":
+		err = stripWebP(&out, data)
+	default:
+		err = ErrFormatNotSupported
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// walkJPEG calls fn with each marker segment of the JPEG image in data, marker included, until it returns false or
+// the image ends. The scans are passed to fn along with their header.
+func walkJPEG(data []byte, fn func(marker byte, segment []byte) bool) error {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return errImageNotValid
+	}
+	for i := 2; i < len(data); {
+		if data[i] != 0xff {
+			return errImageNotValid
+		}
+		// Fill bytes
+		if i+1 < len(data) && data[i+1] == 0xff {
+			i++
+			continue
+		}
+		if i+1 >= len(data) {
+			return errImageNotValid
+		}
+		marker := data[i+1]
+		if marker == 0xd9 {
+			fn(marker, data[i:i+2])
+			return nil
+		}
+		if i+4 > len(data) {
+			return errImageNotValid
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return errImageNotValid
+		}
+		if marker == 0xda {
+			// The entropy coded data ends at the first marker other than the restart ones, 0xff bytes in the data
+			// are followed by 0x00
+			for end < len(data) {
+				if data[end] == 0xff && end+1 < len(data) && data[end+1] != 0x00 && (data[end+1] < 0xd0 || data[end+1] > 0xd7) {
+					break
+				}
+				end++
+			}
+		}
+		if !fn(marker, data[i:end]) {
+			return nil
+		}
+		i = end
+	}
+	return errImageNotValid
+}
+
+// jpegKept reports whether a JPEG segment is kept by StripMetadata: APP1 (EXIF and XMP), APP13 (IPTC), the other
+// application segments and the comments are stripped, except for JFIF, the ICC profile and the Adobe color transform
+func jpegKept(marker byte, segment []byte) bool {
+	switch {
+	case marker == 0xe0:
+		return true
+	case marker == 0xe2:
+		return bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00"))
+	case marker == 0xee:
+		return true
+	case marker > 0xe0 && marker <= 0xef, marker == 0xfe:
+		return false
+	}
+	return true
+}
+
+func stripJPEG(w *bytes.Buffer, data []byte) error {
+	w.Write(data[:2])
+	ended := false
+	err := walkJPEG(data, func(marker byte, segment []byte) bool {
+		if jpegKept(marker, segment) {
+			w.Write(segment)
+		}
+		ended = marker == 0xd9
+		return true
+	})
+	if err == nil && !ended {
+		err = errImageNotValid
+	}
+	return err
+}
+
+// walkPNG calls fn with each chunk of the PNG image in data, length and CRC included, until it returns false or the
+// IEND chunk
+func walkPNG(data []byte, fn func(chunkType string, chunk []byte) bool) error {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return errImageNotValid
+	}
+	for i := len(pngSignature); i+12 <= len(data); {
+		end := uint64(i) + 12 + uint64(binary.BigEndian.Uint32(data[i:i+4]))
+		if end > uint64(len(data)) {
+			return errImageNotValid
+		}
+		chunkType := string(data[i+4 : i+8])
+		if !fn(chunkType, data[i:end]) || chunkType == "IEND" {
+			return nil
+		}
+		i = int(end)
+	}
+	return errImageNotValid
+}
+
+func stripPNG(w *bytes.Buffer, data []byte) error {
+	w.Write(pngSignature)
+	return walkPNG(data, func(chunkType string, chunk []byte) bool {
+		if !metadataChunks[chunkType] {
+			w.Write(chunk)
+		}
+		return true
+	})
+}
+
+// walkWebP calls fn with each chunk of the WebP image in data, header and padding included, until it returns false
+func walkWebP(data []byte, fn func(fourCC string, chunk []byte) bool) error {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return errImageNotValid
+	}
+	size := uint64(binary.LittleEndian.Uint32(data[4:8])) + 8
+	if size > uint64(len(data)) {
+		return errImageNotValid
+	}
+	for i := uint64(12); i < size; {
+		if i+8 > size {
+			return errImageNotValid
+		}
+		chunkSize := uint64(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + chunkSize + chunkSize%2
+		if end > size {
+			return errImageNotValid
+		}
+		if !fn(string(data[i:i+4]), data[i:end]) {
+			return nil
+		}
+		i = end
+	}
+	return nil
+}
+
+func stripWebP(w *bytes.Buffer, data []byte) error {
+	var chunks bytes.Buffer
+	err := walkWebP(data, func(fourCC string, chunk []byte) bool {
+		if metadataChunks[fourCC] {
+			return true
+		}
+		if fourCC == "VP8X" && len(chunk) > 8 {
+			flags := chunk[8] &^ (webpExifFlag | webpXMPFlag)
+			chunks.Write(chunk[:8])
+			chunks.WriteByte(flags)
+			chunks.Write(chunk[9:])
+			return true
+		}
+		chunks.Write(chunk)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 12)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+chunks.Len()))
+	copy(header[8:], "WEBP")
+	w.Write(header)
+	_, err = chunks.WriteTo(w)
+	return err
+}
@@ -0,0 +1,174 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+	"time"
+)
+
+// The fixtures have EXIF with GPS tags, the date they were taken at 19:32:28 +02:00, XMP with GPS coordinates and a
+// comment or a text chunk. The JPEG one has orientation 6, it has to be rotated clockwise to be shown.
+var metadataFixtures = []struct {
+	path, format string
+	orientation  int
+}{
+	{"testdata/gps.jpg", "jpeg", 6},
+	{"testdata/gps.png", "png", 1},
+	{"testdata/gps.webp", "webp", 1},
+}
+
+func TestReadMetadata_Fixtures(t *testing.T) {
+	takenAt := time.Date(2022, 7, 21, 17, 32, 28, 0, time.UTC)
+	for _, fixture := range metadataFixtures {
+		data, err := os.ReadFile(fixture.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata := ReadMetadata(data, fixture.format)
+		if metadata.Orientation != fixture.orientation {
+			t.Error("expected", fixture.path, "orientation to be", fixture.orientation, "got:", metadata.Orientation)
+		}
+		if metadata.TakenAt == nil || !metadata.TakenAt.Equal(takenAt) || metadata.TakenAt.Location() != time.UTC {
+			t.Error("expected", fixture.path, "to be taken at", takenAt, "got:", metadata.TakenAt)
+		}
+	}
+}
+
+func TestReadMetadata_NoExif(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.lossy.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata := ReadMetadata(data, "webp"); metadata.Orientation != 1 || metadata.TakenAt != nil {
+		t.Error("expected the default metadata got:", metadata)
+	}
+	// Truncated EXIF is ignored
+	if metadata := ReadMetadata([]byte("\xff\xd8\xff\xe1\x00\x0aExif\x00\x00MM"), "jpeg"); metadata.Orientation != 1 {
+		t.Error("expected the default metadata got:", metadata)
+	}
+}
+
+func TestStripMetadata_Fixtures(t *testing.T) {
+	for _, fixture := range metadataFixtures {
+		data, err := os.ReadFile(fixture.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := StripMetadata(&buf, data, fixture.format); err != nil {
+			t.Fatal(fixture.path, err)
+		}
+		stripped := buf.Bytes()
+
+		for _, leak := range []string{"Exif", "GPSLatitude", "xmpmeta", "serial", "2022:07:21"} {
+			if bytes.Contains(stripped, []byte(leak)) {
+				t.Error("expected", fixture.path, "to be stripped of", leak)
+			}
+		}
+		if metadata := ReadMetadata(stripped, fixture.format); metadata.Orientation != 1 || metadata.TakenAt != nil {
+			t.Error("expected", fixture.path, "to have the default metadata got:", metadata)
+		}
+
+		original, _, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, format, err := Decode(bytes.NewReader(stripped))
+		if err != nil {
+			t.Fatal(fixture.path, err)
+		}
+		if format != fixture.format || img.Bounds() != original.Bounds() {
+			t.Error("expected", fixture.path, "to decode to the same image got:", format, img.Bounds())
+		}
+	}
+}
+
+func TestStripMetadata_NotValid(t *testing.T) {
+	data, err := os.ReadFile("testdata/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		data   []byte
+		format string
+	}{
+		{data[:len(data)/2], "jpeg"},
+		{data, "png"},
+		{[]byte("RIFF\xff\xff\xff\xffWEBP"), "webp"},
+		{data, "gif"},
+	} {
+		if err := StripMetadata(&bytes.Buffer{}, test.data, test.format); err == nil {
+			t.Error("expected an error stripping", len(test.data), "bytes as", test.format)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image, shown as:
+	// a b c
+	// d e f
+	shown := [][]uint8{{1, 2, 3}, {4, 5, 6}}
+	// stored is how the image is stored with each orientation
+	for orientation, stored := range map[int][][]uint8{
+		1: {{1, 2, 3}, {4, 5, 6}},
+		2: {{3, 2, 1}, {6, 5, 4}},
+		3: {{6, 5, 4}, {3, 2, 1}},
+		4: {{4, 5, 6}, {1, 2, 3}},
+		5: {{1, 4}, {2, 5}, {3, 6}},
+		6: {{3, 6}, {2, 5}, {1, 4}},
+		7: {{6, 3}, {5, 2}, {4, 1}},
+		8: {{4, 1}, {5, 2}, {6, 3}},
+	} {
+		img := image.NewGray(image.Rect(0, 0, len(stored[0]), len(stored)))
+		for y, row := range stored {
+			for x, v := range row {
+				img.SetGray(x, y, color.Gray{Y: v})
+			}
+		}
+
+		oriented := Orient(img, orientation)
+		if oriented.Bounds().Dx() != 3 || oriented.Bounds().Dy() != 2 {
+			t.Error("expected orientation", orientation, "to be shown 3x2 got:", oriented.Bounds())
+			continue
+		}
+		for y, row := range shown {
+			for x, v := range row {
+				if r, _, _, _ := oriented.At(x, y).RGBA(); uint8(r>>8) != v {
+					t.Error("expected orientation", orientation, "to show", v, "at", x, y, "got:", r>>8)
+				}
+			}
+		}
+	}
+}
+
+func TestOrient_Fixture(t *testing.T) {
+	file, err := os.Open("testdata/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	img, _, err := Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The left half of the stored image is red, the right half is blue: once rotated the top half is red
+	oriented := Orient(img, 6)
+	if oriented.Bounds().Dx() != 8 || oriented.Bounds().Dy() != 16 {
+		t.Fatal("expected a 8x16 image got:", oriented.Bounds())
+	}
+	for _, test := range []struct {
+		y   int
+		red bool
+	}{{2, true}, {13, false}} {
+		r, _, b, _ := oriented.At(4, test.y).RGBA()
+		if (r > b) != test.red {
+			t.Error("expected the row", test.y, "to be red:", test.red, "got:", r>>8, b>>8)
+		}
+	}
+}
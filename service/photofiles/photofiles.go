@@ -0,0 +1,79 @@
+/*
+Package photofiles defines how the files in the photos directory are named, and which of them are served.
+
+A photo is stored as <name>.<format>, along with its variants <name>_<width>.<format>. Their files are renamed with
+Trashed when the photo is moved to the trash, and back when it's restored. The names starting with a dot, the ones of
+the photos in the trash and of the uploads being processed, aren't served by FileSystem.
+*/
+package photofiles
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// trashedPrefix is prepended to the names of the files of the photos in the trash
+const trashedPrefix = ".trashed-"
+
+// Trashed returns the name of the file named name once its photo is moved to the trash
+func Trashed(name string) string {
+	return trashedPrefix + name
+}
+
+// IsTrashed checks whether name is the one of a file of a photo in the trash
+func IsTrashed(name string) bool {
+	return len(name) > len(trashedPrefix) && strings.HasPrefix(name, trashedPrefix)
+}
+
+// FileSystem serves the files in dir, except the ones whose path has an element starting with a dot. They're left out
+// of the directory listings too.
+func FileSystem(dir string) http.FileSystem {
+	return hidingFileSystem{http.Dir(dir)}
+}
+
+type hidingFileSystem struct {
+	http.FileSystem
+}
+
+func (fs hidingFileSystem) Open(name string) (http.File, error) {
+	if hidden(name) {
+		return nil, os.ErrNotExist
+	}
+	file, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return hidingFile{file}, nil
+}
+
+// hidden checks whether an element of the slash separated path starts with a dot
+func hidden(path string) bool {
+	for _, element := range strings.Split(path, "/") {
+		if strings.HasPrefix(element, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+type hidingFile struct {
+	http.File
+}
+
+// Readdir lists the files of the directory, except the ones whose name starts with a dot
+func (f hidingFile) Readdir(count int) ([]os.FileInfo, error) {
+	var shown []os.FileInfo
+	for {
+		infos, err := f.File.Readdir(count)
+		for _, info := range infos {
+			if !strings.HasPrefix(info.Name(), ".") {
+				shown = append(shown, info)
+			}
+		}
+		// A count is honored by returning at least one file if there's any, a listing of only hidden files is skipped
+		if err != nil || count <= 0 || len(shown) > 0 {
+			return shown, err
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Package usernames defines what a valid username is, and when two usernames are the same one.
+
+Usernames are stored in their Unicode NFKC form, and compared by their Key: the case folded NFKC form, so that "Mario",
+"MARIO" and "ｍａｒｉｏ" are the same username.
+*/
+package usernames
+
+import (
+	"errors"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MinLength = 3
+	MaxLength = 16
+)
+
+// ErrNotValid is returned by Validate when a username is too short, too long or has characters not allowed
+var ErrNotValid = errors.New("Username should be between 3 and 16 letters, digits, '_', '.' or '-', starting with a letter or a digit")
+
+// reserved are the keys of the names that can't be taken, they'd be confused with the paths of the API or with the staff
+var reserved = map[string]bool{
+	"me":            true,
+	"admin":         true,
+	"administrator": true,
+	"root":          true,
+	"system":        true,
+	"support":       true,
+	"moderator":     true,
+	"staff":         true,
+	"api":           true,
+	"wasa":          true,
+}
+
+// Normalize returns the form in which a username is stored
+func Normalize(username string) string {
+	return norm.NFKC.String(username)
+}
+
+// Key returns the form in which usernames are compared: two usernames are the same one if their keys are equal
+func Key(username string) string {
+	// Case folding can undo the normalization of a few characters
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(username)))
+}
+
+// Validate checks the length and the characters of a username, in its normalized form
+func Validate(username string) error {
+	runes := []rune(Normalize(username))
+	if len(runes) < MinLength || len(runes) > MaxLength {
+		return ErrNotValid
+	}
+	for i, r := range runes {
+		if !Allowed(r) || i == 0 && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return ErrNotValid
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether a username can have the character r
+func Allowed(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// Reserved reports whether a username is one of the names that can't be taken
+func Reserved(username string) bool {
+	return reserved[Key(username)]
+}
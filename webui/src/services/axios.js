@@ -1,4 +1,6 @@
 import axios from "axios";
+import router from "../router";
+import { loggedInUser } from "../user";
 
 const instance = axios.create({
 	baseURL: __API_URL__,
@@ -14,4 +16,48 @@ instance.interceptors.request.use((config) => {
 	return config
 })
 
+// The posts of credentials, their 401s mean wrong credentials rather than an expired access token
+const credentialsUrls = ['/session', '/session/2fa', '/users', '/session/refresh']
+
+function sendsCredentials(config) {
+	return config.method === 'post' && credentialsUrls.includes(config.url)
+}
+
+// The refresh in flight: the 401s of concurrent requests wait for it instead of reusing the refresh token it rotates
+let refreshing = null
+
+function refreshTokens(refreshToken) {
+	if (!refreshing) {
+		refreshing = axios.post(`${__API_URL__}/session/refresh`, { refreshToken })
+			.then((response) => {
+				window.localStorage.setItem('$loggedInUserToken', response.data.identifier)
+				window.localStorage.setItem('$loggedInUserRefreshToken', response.data.refreshToken)
+			})
+			.finally(() => {
+				refreshing = null
+			})
+	}
+	return refreshing
+}
+
+// When the access token expires, exchange the refresh token for new tokens and retry the request once
+instance.interceptors.response.use(undefined, async (error) => {
+	const refreshToken = window.localStorage.getItem('$loggedInUserRefreshToken')
+	if (error.response?.status !== 401 || !refreshToken || error.config._retried || sendsCredentials(error.config)) {
+		throw error
+	}
+	try {
+		await refreshTokens(refreshToken)
+	} catch (e) {
+		// The session is over: log in again
+		window.localStorage.removeItem('$loggedInUserToken')
+		window.localStorage.removeItem('$loggedInUserRefreshToken')
+		loggedInUser.id = null
+		loggedInUser.username = null
+		router.replace({ name: 'Login' })
+		throw error
+	}
+	return instance({ ...error.config, _retried: true })
+})
+
 export default instance;